@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"net"
+	"syscall"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchWriter submits several datagrams at once, using sendmmsg where the
+// platform supports it. It is implemented by ipv4.PacketConn and
+// ipv6.PacketConn, whose Message types are the same.
+type batchWriter interface {
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// newBatchWriter returns a batchWriter for conn, or nil if conn is not a
+// socket.
+func newBatchWriter(conn net.PacketConn) batchWriter {
+	if _, ok := conn.(syscall.Conn); !ok {
+		return nil
+	}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn)
+	}
+	return ipv6.NewPacketConn(conn)
+}
+
+// writeBatch sends rawPackets to addr with as few calls to WriteBatch as
+// possible. ctx is only checked before the batch is submitted.
+func (c *Conn) writeBatch(ctx context.Context, rawPackets [][]byte, addr net.Addr) error {
+	select {
+	case <-ctx.Done():
+		return netError(ctx.Err())
+	default:
+	}
+
+	ms := make([]ipv4.Message, len(rawPackets))
+	for i, rawPacket := range rawPackets {
+		ms[i].Buffers = [][]byte{rawPacket}
+		ms[i].Addr = addr
+	}
+	for len(ms) > 0 {
+		n, err := c.batchConn.WriteBatch(ms, 0)
+		if err != nil {
+			return c.writeError(netWriteError(err, len(ms[0].Buffers[0])))
+		}
+		ms = ms[n:]
+	}
+	return nil
+}
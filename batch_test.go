@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package dtls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/transport/v3/dpipe"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestBatchIO(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	serverSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A small MTU splits the certificate flight into several datagrams,
+	// which are then sent as one batch.
+	type result struct {
+		c   *Conn
+		err error
+	}
+	serverCh := make(chan result)
+	go func() {
+		s, sErr := ServerWithContext(ctx, serverSock, clientSock.LocalAddr(), &Config{
+			Certificates: []tls.Certificate{serverCert},
+			MTU:          200,
+			BatchIO:      true,
+		})
+		serverCh <- result{s, sErr}
+	}()
+
+	client, err := ClientWithContext(ctx, clientSock, serverSock.LocalAddr(), &Config{
+		InsecureSkipVerify: true,
+		MTU:                200,
+		BatchIO:            true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	res := <-serverCh
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	server := res.c
+	defer func() {
+		_ = server.Close()
+	}()
+
+	if client.batchConn == nil || server.batchConn == nil {
+		t.Fatal("Expected batch IO to be enabled on UDP sockets")
+	}
+
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Unexpected application data: expected %q, got %q", "hello", buf[:n])
+	}
+}
+
+func TestBatchIONotSocket(t *testing.T) {
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+
+	if w := newBatchWriter(dtlsnet.PacketConnFromConn(ca)); w != nil {
+		t.Errorf("Expected no batch writer for a non-socket connection, got %T", w)
+	}
+}
@@ -120,3 +120,40 @@ func BenchmarkConnReadWrite(b *testing.B) {
 		benchmarkConn(b, n)
 	}
 }
+
+func BenchmarkConnWrite(b *testing.B) {
+	for _, n := range []int{16, 128, 512, 1024, 2048} {
+		n := n
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			client, server, err := pipeMemory()
+			if err != nil {
+				b.Fatal(err)
+			}
+			readDone := make(chan struct{})
+			go func() {
+				defer close(readDone)
+				buf := make([]byte, 8192)
+				for {
+					if _, rErr := server.Read(buf); rErr != nil {
+						return
+					}
+				}
+			}()
+
+			hw := make([]byte, n)
+			b.ReportAllocs()
+			b.SetBytes(int64(n))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err = client.Write(hw); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+
+			_ = client.Close()
+			_ = server.Close()
+			<-readDone
+		})
+	}
+}
@@ -7,9 +7,13 @@ import (
 	"bytes"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/asn1"
 	"fmt"
 	"strings"
 
+	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/pion/dtls/v2/pkg/protocol/handshake"
 )
 
@@ -25,6 +29,23 @@ type ClientHelloInfo struct {
 	// TLS_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256).
 	CipherSuites []CipherSuiteID
 
+	// SupportedCurves lists the elliptic curves supported by the client, as
+	// sent in the supported_groups extension.
+	SupportedCurves []elliptic.Curve
+
+	// SignatureSchemes lists the signature and hash algorithms the client is
+	// willing to verify, as sent in the signature_algorithms extension.
+	SignatureSchemes []signaturehash.Algorithm
+
+	// SupportedProtocols lists the application protocols offered by the
+	// client in the ALPN extension.
+	SupportedProtocols []string
+
+	// TrustedCAKeys lists the certificate authorities the client trusts, as
+	// sent in the trusted_ca_keys extension, so that GetCertificate can pick
+	// a chain ending at one of them.
+	TrustedCAKeys []extension.TrustedAuthority
+
 	// RandomBytes stores the client hello random bytes
 	RandomBytes [handshake.RandomBytesLength]byte
 }
@@ -69,7 +90,7 @@ func (cri *CertificateRequestInfo) SupportsCertificate(c *tls.Certificate) error
 	return errNotAcceptableCertificateChain
 }
 
-func (c *handshakeConfig) setNameToCertificateLocked() {
+func (c *handshakeConfig) setNameToCertificate() {
 	nameToCertificate := make(map[string]*tls.Certificate)
 	for i := range c.localCertificates {
 		cert := &c.localCertificates[i]
@@ -92,9 +113,6 @@ func (c *handshakeConfig) setNameToCertificateLocked() {
 }
 
 func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls.Certificate, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	if c.localGetCertificate != nil &&
 		(len(c.localCertificates) == 0 || len(clientHelloInfo.ServerName) > 0) {
 		cert, err := c.localGetCertificate(clientHelloInfo)
@@ -104,7 +122,7 @@ func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls
 	}
 
 	if c.nameToCertificate == nil {
-		c.setNameToCertificateLocked()
+		c.setNameToCertificate()
 	}
 
 	if len(c.localCertificates) == 0 {
@@ -143,8 +161,6 @@ func (c *handshakeConfig) getCertificate(clientHelloInfo *ClientHelloInfo) (*tls
 
 // NOTE: original src: https://github.com/golang/go/blob/29b9a328d268d53833d2cc063d1d8b4bf6852675/src/crypto/tls/handshake_client.go#L974
 func (c *handshakeConfig) getClientCertificate(cri *CertificateRequestInfo) (*tls.Certificate, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
 	if c.localGetClientCertificate != nil {
 		return c.localGetClientCertificate(cri)
 	}
@@ -160,3 +176,17 @@ func (c *handshakeConfig) getClientCertificate(cri *CertificateRequestInfo) (*tl
 	// No acceptable certificate found. Don't send a certificate.
 	return new(tls.Certificate), nil
 }
+
+// validateOCSPStaple checks that a non-empty staple is a single DER encoded
+// ASN.1 SEQUENCE, the outer structure of an OCSPResponse (RFC 6960).
+func validateOCSPStaple(staple []byte) error {
+	if len(staple) == 0 {
+		return nil
+	}
+	var raw asn1.RawValue
+	rest, err := asn1.Unmarshal(staple, &raw)
+	if err != nil || len(rest) != 0 || raw.Class != asn1.ClassUniversal || raw.Tag != asn1.TagSequence || !raw.IsCompound {
+		return errInvalidOCSPStaple
+	}
+	return nil
+}
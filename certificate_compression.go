@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+)
+
+// CertificateCompressionAlgorithm identifies an algorithm used to compress
+// certificate chains in the handshake.
+// https://datatracker.ietf.org/doc/html/rfc8879#section-3
+type CertificateCompressionAlgorithm = extension.CertificateCompressionAlgorithm
+
+// CertificateCompressionAlgorithm enums
+const (
+	// CertificateCompressionZlib compresses certificate chains with zlib.
+	CertificateCompressionZlib CertificateCompressionAlgorithm = extension.CertificateCompressionAlgorithmZlib
+	// CertificateCompressionBrotli compresses certificate chains with brotli.
+	CertificateCompressionBrotli CertificateCompressionAlgorithm = extension.CertificateCompressionAlgorithmBrotli
+)
+
+// maxDecompressedCertificateLength caps the size a CompressedCertificate
+// may expand to, so a small message can't make us allocate a large buffer.
+const maxDecompressedCertificateLength = 1 << 17
+
+// decompressCertificate returns the Certificate message a peer sent as a
+// CompressedCertificate, checking that the algorithm is one we offered.
+// https://datatracker.ietf.org/doc/html/rfc8879#section-4
+func decompressCertificate(m *handshake.MessageCompressedCertificate, cfg *handshakeConfig) (*handshake.MessageCertificate, *alert.Alert, error) {
+	if cfg.selectCertificateCompression([]CertificateCompressionAlgorithm{m.Algorithm}) == 0 {
+		return nil, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errUnexpectedCertificateCompression
+	}
+	certificate, err := m.Decompress(maxDecompressedCertificateLength)
+	if err != nil {
+		return nil, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+	}
+	return certificate, nil, nil
+}
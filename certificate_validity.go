@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// CertificateValidityStatus classifies the certificate chain presented by the
+// remote party. It is determined during the handshake whether or not the
+// chain is actually verified, for example with InsecureSkipVerify set.
+type CertificateValidityStatus uint8
+
+// CertificateValidityStatus enums
+const (
+	// CertificateValidityUnknown indicates that the remote party did not
+	// present a certificate.
+	CertificateValidityUnknown CertificateValidityStatus = iota
+	// CertificateValid indicates that the chain verified successfully.
+	CertificateValid
+	// CertificateExpired indicates that a certificate of the chain is past
+	// its NotAfter time.
+	CertificateExpired
+	// CertificateNotYetValid indicates that a certificate of the chain is
+	// before its NotBefore time.
+	CertificateNotYetValid
+	// CertificateUntrusted indicates that the chain is not signed by a
+	// trusted root.
+	CertificateUntrusted
+	// CertificateHostnameMismatch indicates that the certificate is not
+	// valid for the requested server name.
+	CertificateHostnameMismatch
+	// CertificateInvalid indicates that the chain failed verification for
+	// any other reason, or could not be parsed.
+	CertificateInvalid
+)
+
+func (s CertificateValidityStatus) String() string {
+	switch s {
+	case CertificateValidityUnknown:
+		return "Unknown"
+	case CertificateValid:
+		return "Valid"
+	case CertificateExpired:
+		return "Expired"
+	case CertificateNotYetValid:
+		return "NotYetValid"
+	case CertificateUntrusted:
+		return "Untrusted"
+	case CertificateHostnameMismatch:
+		return "HostnameMismatch"
+	case CertificateInvalid:
+		return "Invalid"
+	default:
+		return "Invalid CertificateValidityStatus"
+	}
+}
+
+// certificateValidity classifies the result verifyErr of verifying
+// rawCertificates at time now.
+func certificateValidity(rawCertificates [][]byte, verifyErr error, now time.Time) CertificateValidityStatus {
+	if len(rawCertificates) == 0 {
+		return CertificateValidityUnknown
+	}
+	if verifyErr == nil {
+		return CertificateValid
+	}
+
+	var invalidErr x509.CertificateInvalidError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(verifyErr, &invalidErr) && invalidErr.Reason == x509.Expired:
+		// x509 reports both ends of the validity period as Expired.
+		certs, err := loadCerts(rawCertificates)
+		if err != nil {
+			return CertificateInvalid
+		}
+		for _, cert := range certs {
+			if now.Before(cert.NotBefore) {
+				return CertificateNotYetValid
+			}
+		}
+		return CertificateExpired
+	case errors.As(verifyErr, &authorityErr):
+		return CertificateUntrusted
+	case errors.As(verifyErr, &hostnameErr):
+		return CertificateHostnameMismatch
+	default:
+		return CertificateInvalid
+	}
+}
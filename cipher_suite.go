@@ -10,6 +10,7 @@ import (
 	"crypto/tls"
 	"fmt"
 	"hash"
+	"io"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/clientcertificate"
@@ -43,6 +44,13 @@ const (
 	TLS_PSK_WITH_AES_128_CBC_SHA256 CipherSuiteID = ciphersuite.TLS_PSK_WITH_AES_128_CBC_SHA256 //nolint:revive,stylecheck
 
 	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 CipherSuiteID = ciphersuite.TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 //nolint:revive,stylecheck
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 CipherSuiteID = ciphersuite.TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 //nolint:revive,stylecheck
+
+	// Static RSA key exchange, requires Config.InsecureRSAKeyExchange
+	TLS_RSA_WITH_AES_128_GCM_SHA256 CipherSuiteID = ciphersuite.TLS_RSA_WITH_AES_128_GCM_SHA256 //nolint:revive,stylecheck
+
+	// Ephemeral finite field Diffie-Hellman key exchange, see Config.DHGroups
+	TLS_DHE_RSA_WITH_AES_128_GCM_SHA256 CipherSuiteID = ciphersuite.TLS_DHE_RSA_WITH_AES_128_GCM_SHA256 //nolint:revive,stylecheck
 )
 
 // CipherSuiteAuthenticationType controls what authentication method is using during the handshake for a CipherSuite
@@ -63,6 +71,8 @@ const (
 	CipherSuiteKeyExchangeAlgorithmNone  CipherSuiteKeyExchangeAlgorithm = ciphersuite.KeyExchangeAlgorithmNone
 	CipherSuiteKeyExchangeAlgorithmPsk   CipherSuiteKeyExchangeAlgorithm = ciphersuite.KeyExchangeAlgorithmPsk
 	CipherSuiteKeyExchangeAlgorithmEcdhe CipherSuiteKeyExchangeAlgorithm = ciphersuite.KeyExchangeAlgorithmEcdhe
+	CipherSuiteKeyExchangeAlgorithmRsa   CipherSuiteKeyExchangeAlgorithm = ciphersuite.KeyExchangeAlgorithmRsa
+	CipherSuiteKeyExchangeAlgorithmDhe   CipherSuiteKeyExchangeAlgorithm = ciphersuite.KeyExchangeAlgorithmDhe
 )
 
 var _ = allCipherSuites() // Necessary until this function isn't only used by Go 1.14
@@ -98,8 +108,49 @@ type CipherSuite interface {
 	Decrypt(h recordlayer.Header, in []byte) ([]byte, error)
 }
 
+// setCipherSuiteRand makes c draw its explicit nonces and IVs from rand if it
+// supports a custom source of randomness. It must be called before Init.
+func setCipherSuiteRand(c CipherSuite, rand io.Reader) {
+	if r, ok := c.(interface{ SetRand(io.Reader) }); ok {
+		r.SetRand(rand)
+	}
+}
+
+// supportsTruncatedMAC reports whether c can truncate its MACs with the
+// truncated_hmac extension, which only applies to CBC cipher suites.
+func supportsTruncatedMAC(c CipherSuite) bool {
+	_, ok := c.(interface{ SetTruncatedMAC(bool) })
+	return ok
+}
+
+// setCipherSuiteTruncatedMAC makes c truncate its MACs if it supports
+// truncated_hmac. It must be called before Init.
+func setCipherSuiteTruncatedMAC(c CipherSuite, truncated bool) {
+	if t, ok := c.(interface{ SetTruncatedMAC(bool) }); ok {
+		t.SetTruncatedMAC(truncated)
+	}
+}
+
+// setCipherSuiteSequenceNonce makes c use record sequence numbers as
+// explicit nonces if it supports it, which GCM cipher suites do. It must be
+// called before Init.
+func setCipherSuiteSequenceNonce(c CipherSuite, enabled bool) {
+	if s, ok := c.(interface{ SetSequenceNonce(bool) }); ok {
+		s.SetSequenceNonce(enabled)
+	}
+}
+
+// cipherSuiteSequenceNonce reports whether c uses record sequence numbers as
+// explicit nonces.
+func cipherSuiteSequenceNonce(c CipherSuite) bool {
+	s, ok := c.(interface{ SequenceNonce() bool })
+	return ok && s.SequenceNonce()
+}
+
 // CipherSuiteName provides the same functionality as tls.CipherSuiteName
-// that appeared first in Go 1.14.
+// that appeared first in Go 1.14. It returns the IANA name of the supported
+// cipher suites, such as TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, and the
+// hexadecimal ID of others.
 //
 // Our implementation differs slightly in that it takes in a CiperSuiteID,
 // like the rest of our library, instead of a uint16 like crypto/tls.
@@ -144,6 +195,12 @@ func cipherSuiteForID(id CipherSuiteID, customCiphers func() []CipherSuite) Ciph
 		return &ciphersuite.TLSEcdheRsaWithAes256GcmSha384{}
 	case TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
 		return ciphersuite.NewTLSEcdhePskWithAes128CbcSha256()
+	case TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256:
+		return &ciphersuite.TLSEcdhePskWithAes128GcmSha256{}
+	case TLS_RSA_WITH_AES_128_GCM_SHA256:
+		return &ciphersuite.TLSRsaWithAes128GcmSha256{}
+	case TLS_DHE_RSA_WITH_AES_128_GCM_SHA256:
+		return &ciphersuite.TLSDheRsaWithAes128GcmSha256{}
 	}
 
 	if customCiphers != nil {
@@ -227,6 +284,7 @@ func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites fu
 	}
 
 	var foundCertificateSuite, foundPSKSuite, foundAnonymousSuite bool
+	filtered := []CipherSuite{}
 	for _, c := range cipherSuites {
 		switch {
 		case includeCertificateSuites && c.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate:
@@ -236,6 +294,7 @@ func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites fu
 		case c.AuthenticationType() == CipherSuiteAuthenticationTypeAnonymous:
 			foundAnonymousSuite = true
 		default:
+			filtered = append(filtered, c)
 			continue
 		}
 		cipherSuites[i] = c
@@ -244,16 +303,33 @@ func parseCipherSuites(userSelectedSuites []CipherSuiteID, customCipherSuites fu
 
 	switch {
 	case includeCertificateSuites && !foundCertificateSuite && !foundAnonymousSuite:
-		return nil, errNoAvailableCertificateCipherSuite
+		err = errNoAvailableCertificateCipherSuite
 	case includePSKSuites && !foundPSKSuite:
-		return nil, errNoAvailablePSKCipherSuite
+		err = errNoAvailablePSKCipherSuite
 	case i == 0:
-		return nil, errNoAvailableCipherSuites
+		err = errNoAvailableCipherSuites
+	}
+	switch {
+	case err != nil && i == 0:
+		return nil, &noSupportedCipherSuitesError{err: err, filtered: filtered}
+	case err != nil:
+		return nil, err
 	}
 
 	return cipherSuites[:i], nil
 }
 
+// checkCipherSuitesForCertificates returns an error if none of cipherSuites
+// can be used with any of certificates, see filterCipherSuitesForCertificate.
+func checkCipherSuitesForCertificates(certificates []tls.Certificate, cipherSuites []CipherSuite) error {
+	for i := range certificates {
+		if len(filterCipherSuitesForCertificate(&certificates[i], cipherSuites)) > 0 {
+			return nil
+		}
+	}
+	return &noSupportedCipherSuitesError{err: errNoAvailableCertificateCipherSuite, filtered: cipherSuites}
+}
+
 func filterCipherSuitesForCertificate(cert *tls.Certificate, cipherSuites []CipherSuite) []CipherSuite {
 	if cert == nil || cert.PrivateKey == nil {
 		return cipherSuites
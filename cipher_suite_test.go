@@ -4,12 +4,25 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite"
+	pkgciphersuite "github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
 	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
 )
@@ -29,6 +42,13 @@ func TestCipherSuiteName(t *testing.T) {
 			t.Fatalf("Expected: %s, got %s", testCase.expected, res)
 		}
 	}
+
+	// Every supported cipher suite is named as in the IANA registry.
+	for _, cipherSuite := range allCipherSuites() {
+		if res, expected := CipherSuiteName(cipherSuite.ID()), cipherSuite.ID().String(); res != expected {
+			t.Errorf("Expected: %s, got %s", expected, res)
+		}
+	}
 }
 
 func TestAllCipherSuites(t *testing.T) {
@@ -38,6 +58,23 @@ func TestAllCipherSuites(t *testing.T) {
 	}
 }
 
+// Assert that the PRF hash of every cipher suite matches the one named in
+// its ID, e.g. SHA-384 for the *_SHA384 suites.
+func TestCipherSuitePRFHash(t *testing.T) {
+	for _, cipherSuite := range allCipherSuites() {
+		name := cipherSuite.String()
+		// All other cipher suites, including CCM and SHA-1 ones, use the
+		// default SHA-256 PRF of TLS 1.2.
+		expected := sha256.Size
+		if strings.HasSuffix(name, "_SHA384") {
+			expected = sha512.Size384
+		}
+		if size := cipherSuite.HashFunc()().Size(); size != expected {
+			t.Errorf("%s: expected a PRF hash of %d bytes, got %d", name, expected, size)
+		}
+	}
+}
+
 // CustomCipher that is just used to assert Custom IDs work
 type testCustomCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -110,3 +147,102 @@ func TestCustomCipherSuite(t *testing.T) {
 		})
 	})
 }
+
+// Assert that the AES-256-GCM cipher suites derive 256-bit keys and use
+// SHA-384 for the PRF, including the Finished verify data.
+func TestAES256GCMCipherSuites(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, id := range []CipherSuiteID{
+		TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	} {
+		id := id
+		t.Run(CipherSuiteName(id), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			serverCfg := &Config{CipherSuites: []CipherSuiteID{id}}
+			if id == TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+				key, err := rsa.GenerateKey(rand.Reader, 2048)
+				if err != nil {
+					t.Fatal(err)
+				}
+				cert, err := selfsign.SelfSign(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				serverCfg.Certificates = []tls.Certificate{cert}
+			}
+
+			client, server := pipeConnPair(t, ctx, &Config{
+				CipherSuites:       []CipherSuiteID{id},
+				InsecureSkipVerify: true,
+			}, serverCfg)
+			defer func() {
+				_ = server.Close()
+				_ = client.Close()
+			}()
+
+			if p := client.RecordProtectionParams(); p.CipherSuiteID != id || p.KeyLength != 32 {
+				t.Fatalf("Unexpected record protection parameters: %+v", p)
+			}
+
+			// The server's Finished verify data is computed with SHA-384.
+			plainText := server.handshakeCache.pullAndMerge(
+				handshakeCachePullRule{handshake.TypeClientHello, 0, true, false},
+				handshakeCachePullRule{handshake.TypeServerHello, 0, false, false},
+				handshakeCachePullRule{handshake.TypeCertificate, 0, false, false},
+				handshakeCachePullRule{handshake.TypeServerKeyExchange, 0, false, false},
+				handshakeCachePullRule{handshake.TypeServerHelloDone, 0, false, false},
+				handshakeCachePullRule{handshake.TypeClientKeyExchange, 0, true, false},
+				handshakeCachePullRule{handshake.TypeFinished, 1, true, false},
+			)
+			verifyData, err := prf.VerifyDataServer(server.state.masterSecret, plainText, sha512.New384)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(verifyData, server.state.localVerifyData) {
+				t.Error("Finished verify data was not computed with SHA-384")
+			}
+
+			// A record written by the client decrypts with 256-bit keys
+			// expanded from the master secret with SHA-384.
+			client.lock.Lock()
+			raw, err := client.processPacket(&packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Epoch:   client.state.getLocalEpoch(),
+						Version: protocol.Version1_2,
+					},
+					Content: &protocol.ApplicationData{Data: []byte("aes256")},
+				},
+				shouldEncrypt: true,
+			})
+			client.lock.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clientRandom := client.state.localRandom.MarshalFixed()
+			serverRandom := client.state.remoteRandom.MarshalFixed()
+			keys, err := prf.GenerateEncryptionKeys(client.state.masterSecret, clientRandom[:], serverRandom[:], 0, 32, 4, sha512.New384)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gcm, err := pkgciphersuite.NewGCM(keys.ServerWriteKey, keys.ServerWriteIV, keys.ClientWriteKey, keys.ClientWriteIV)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := gcm.Decrypt(recordlayer.Header{}, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data := decrypted[recordlayer.FixedHeaderSize:]; string(data) != "aes256" {
+				t.Errorf("Unexpected application data: %q", data)
+			}
+		})
+	}
+}
@@ -7,15 +7,21 @@ import (
 	"context"
 	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"io"
+	"net"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 )
 
 const keyLogLabelTLS12 = "CLIENT_RANDOM"
@@ -26,12 +32,24 @@ type Config struct {
 	// Certificates contains certificate chain to present to the other side of the connection.
 	// Server MUST set this if PSK is non-nil
 	// client SHOULD sets this so CertificateRequests can be handled if PSK is non-nil
+	//
+	// A server staples the OCSPStaple of its certificate, which must be a DER
+	// encoded OCSPResponse, if the client sent the status_request extension.
+	// Likewise, it sends the SignedCertificateTimestamps of its certificate
+	// if the client sent the signed_certificate_timestamp extension.
 	Certificates []tls.Certificate
 
 	// CipherSuites is a list of supported cipher suites.
 	// If CipherSuites is nil, a default list is used
 	CipherSuites []CipherSuiteID
 
+	// PreferServerCipherSuites controls which order a server follows when
+	// selecting a cipher suite supported by both sides. If true, it selects
+	// the first of its own CipherSuites, with CustomCipherSuites first, that
+	// the client offered. By default it selects the first suite in the order
+	// of the client's ClientHello that it supports.
+	PreferServerCipherSuites bool
+
 	// CustomCipherSuites is a list of CipherSuites that can be
 	// provided by the user. This allow users to user Ciphers that are reserved
 	// for private usage.
@@ -57,11 +75,124 @@ type Config struct {
 	// defaults to time.Second
 	FlightInterval time.Duration
 
+	// MaxFlightInterval caps the retransmission interval of a flight. The
+	// interval starts at FlightInterval and doubles with every retransmission
+	// of the same flight until it reaches MaxFlightInterval, and starts over
+	// once the peer's next flight is received. Setting it to FlightInterval
+	// disables the backoff. (default is 60 seconds)
+	// https://datatracker.ietf.org/doc/html/rfc6347#section-4.2.4.1
+	MaxFlightInterval time.Duration
+
+	// MaxRetransmissions, if greater than zero, limits how often a single
+	// flight is retransmitted while waiting for the peer's next flight. The
+	// handshake fails once the limit is exceeded instead of retransmitting
+	// until the handshake context is done. The count starts over whenever
+	// the peer advances the handshake.
+	MaxRetransmissions int
+
+	// MaxWarningAlerts, if greater than zero, limits the number of warning
+	// alerts other than close_notify accepted from the peer. Once it is
+	// exceeded the connection is closed with a fatal unexpected_message
+	// alert.
+	MaxWarningAlerts int
+
+	// MaxEarlyPacketQueue is the number of encrypted records that are held
+	// while waiting for the keys to decrypt them, such as application data
+	// that arrives before the handshake completes. Further records are
+	// dropped and counted in Stats.DroppedQueueFull. It defaults to 100 if
+	// zero, and must not be negative.
+	MaxEarlyPacketQueue int
+
+	// MaxRecordsPerDatagram is the largest number of records processed from
+	// a single datagram. Datagrams with more records are dropped whole and
+	// counted in Stats.DroppedTooManyRecords, bounding the work a peer can
+	// cause by packing many tiny records into one datagram. It defaults to
+	// 64 if zero, and must not be negative.
+	MaxRecordsPerDatagram int
+
+	// EarlyDataBuffer, if greater than zero, is the number of bytes of
+	// decrypted application data held for Read, in addition to the single
+	// record that is always held. Without it, the read loop stops reading
+	// from the network until Read takes the pending record, which also
+	// stalls the handshake and alerts. With it, records are buffered until
+	// the buffer is full; records that arrive then are dropped, as if lost
+	// by the network, and counted in Stats.DroppedBufferFull, instead of
+	// blocking the read loop. Errors for Read are always buffered.
+	EarlyDataBuffer int
+
+	// DrainOnClose keeps application data that was received and accepted,
+	// but not yet passed to Read, when the connection is closed, so that
+	// Reads after Close return it before io.EOF. Without it, Reads after
+	// Close return only the single record that was already held for Read,
+	// and a record the read loop was still waiting to hand over is dropped,
+	// although replay protection has accepted it and a retransmission by
+	// the peer would be discarded. Records buffered with EarlyDataBuffer are
+	// always kept.
+	DrainOnClose bool
+
+	// SkipCloseNotify makes Close tear the connection down without sending a
+	// close_notify alert, saving a write when the peer does not care how the
+	// connection ends. The peer then cannot tell an orderly close from a
+	// truncation attack, in which an attacker drops the final records and
+	// the connection just goes silent. CloseWrite always sends close_notify.
+	SkipCloseNotify bool
+
+	// IdleTimeout, if greater than zero, closes the connection, with a
+	// close_notify unless SkipCloseNotify is set, when no application data
+	// has been received for that long since the handshake completed or the
+	// last record arrived. Heartbeats do not keep the connection open. Read
+	// then returns io.EOF. It lets servers with many clients reap the
+	// connections of peers that went away without closing them.
+	IdleTimeout time.Duration
+
+	// KeepAlive, if greater than zero, sends a record when nothing has been
+	// written for that long after the handshake, to keep NAT and firewall
+	// mappings of long-lived connections from expiring. It is a
+	// HeartbeatRequest if the peer accepts heartbeats, and an empty
+	// application data record otherwise, which the peer's Read returns as
+	// zero bytes. Keepalives are skipped while a Ping is in progress and
+	// counted in Stats.KeepAlivesSent.
+	KeepAlive time.Duration
+
+	// SequenceNumberWarnThreshold, if greater than zero, makes the
+	// connection call OnSequenceNumberWarning when the sequence number of a
+	// record it sends reaches it. The connection fails once an epoch runs
+	// out of sequence numbers, so the warning lets applications with high
+	// record rates call Rekey or close the connection before that happens.
+	// It must not exceed the largest sequence number, 2^48-1.
+	SequenceNumberWarnThreshold uint64
+
+	// OnSequenceNumberWarning, if not nil, is called once per epoch with the
+	// epoch and the sequence number that reached SequenceNumberWarnThreshold.
+	// It is called from the goroutine sending the record, with the
+	// connection's lock held, and must not block or call methods of the
+	// connection; start a goroutine to Rekey or Close it.
+	OnSequenceNumberWarning func(epoch uint16, seq uint64)
+
+	// AcceptRekey lets a server follow a rekey started by the client with
+	// Conn.Rekey. By default the ClientHello of a rekey is answered with a
+	// no_renegotiation warning alert, and the client's Rekey fails. Clients
+	// ignore it.
+	AcceptRekey bool
+
+	// StrictHandshakeOrder makes application data received from the peer
+	// before its Finished message a fatal unexpected_message error. By
+	// default such data is delivered to Read, as a peer that has
+	// completed its side of the handshake may already be sending it when
+	// its Finished is lost or reordered.
+	StrictHandshakeOrder bool
+
 	// PSK sets the pre-shared key used by this DTLS connection
 	// If PSK is non-nil only PSK CipherSuites will be used
 	PSK             PSKCallback
 	PSKIdentityHint []byte
 
+	// GetPSKIdentityHint, if not nil, is called by a server to select the
+	// PSK identity hint sent in the ServerKeyExchange based on the
+	// ClientHello, e.g. its SNI. It takes precedence over PSKIdentityHint;
+	// returning nil omits the hint. It is ignored by clients.
+	GetPSKIdentityHint func(*ClientHelloInfo) ([]byte, error)
+
 	// InsecureSkipVerify controls whether a client verifies the
 	// server's certificate chain and host name.
 	// If InsecureSkipVerify is true, TLS accepts any certificate
@@ -74,6 +205,12 @@ type Config struct {
 	// to be vulnerable.
 	InsecureHashes bool
 
+	// InsecureRSAKeyExchange allows cipher suites using the static RSA key
+	// exchange, e.g. TLS_RSA_WITH_AES_128_GCM_SHA256, to be configured in
+	// CipherSuites. These suites lack forward secrecy and are only meant
+	// for observing legacy peers.
+	InsecureRSAKeyExchange bool
+
 	// VerifyPeerCertificate, if not nil, is called after normal
 	// certificate verification by either a client or server. It
 	// receives the certificate provided by the peer and also a flag
@@ -85,6 +222,11 @@ type Config struct {
 	// setting InsecureSkipVerify, or (for a server) when ClientAuth is
 	// RequestClientCert or RequireAnyClientCert, then this callback will
 	// be considered but the verifiedChains will always be nil.
+	//
+	// rawCerts is the DER encoded chain sent by the peer, leaf first. The
+	// callback runs before the Finished messages are exchanged, so a
+	// rejected peer never completes the handshake. dane.VerifyPeerCertificate
+	// returns a callback checking the chain against DNS TLSA records.
 	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
 
 	// VerifyConnection, if not nil, is called after normal certificate
@@ -102,6 +244,16 @@ type Config struct {
 	// If RootCAs is nil, TLS uses the host's root CA set.
 	RootCAs *x509.CertPool
 
+	// TrustedCAKeys lists certificate authorities that a client advertises
+	// in the trusted_ca_keys extension by the SHA-1 hash of their keys, so
+	// that a server with several certificate chains can send one ending at
+	// an authority the client trusts. It is meant for a large RootCAs, which
+	// cannot be enumerated, and is usually set to the certificates RootCAs
+	// was built from. The extension is not sent if TrustedCAKeys is empty.
+	// Servers read the hint from ClientHelloInfo.TrustedCAKeys.
+	// https://datatracker.ietf.org/doc/html/rfc6066#section-6
+	TrustedCAKeys []*x509.Certificate
+
 	// ClientCAs defines the set of root certificate authorities
 	// that servers use if required to verify a client certificate
 	// by the policy in ClientAuth.
@@ -111,6 +263,13 @@ type Config struct {
 	// certificates unless InsecureSkipVerify is given.
 	ServerName string
 
+	// RequireSNIMatch, if true, makes a client abort the handshake with a
+	// bad_certificate alert if the server's leaf certificate does not cover
+	// the server name sent in the SNI extension, even if InsecureSkipVerify
+	// is set. No server name is sent if ServerName is empty or an IP
+	// address, so the handshake then fails for any certificate.
+	RequireSNIMatch bool
+
 	LoggerFactory logging.LoggerFactory
 
 	// ConnectContextMaker is a function to make a context used in Dial(),
@@ -126,6 +285,46 @@ type Config struct {
 	// fit within the maximum transmission unit (default is 1200 bytes)
 	MTU int
 
+	// RetransmitMTU, if greater than zero and smaller than MTU, is the MTU
+	// used from the first retransmission of a flight onwards, in case the
+	// original datagrams were dropped for exceeding the path MTU. Once
+	// lowered, the MTU is kept for the rest of the connection.
+	RetransmitMTU int
+
+	// MinMTU, if greater than zero, enables path MTU discovery for handshake
+	// flights. From the second retransmission of a flight onwards, each
+	// retransmission lowers the MTU to the next common path MTU below the
+	// current one, but not below MinMTU, until the peer responds. The
+	// discovered MTU is kept for the rest of the connection and reported by
+	// Conn.MTU.
+	MinMTU int
+
+	// DSCP, if not zero, is the Differentiated Services Code Point (0-63)
+	// written to the IPv4 TOS or IPv6 traffic class field of outgoing
+	// datagrams. It is applied to the socket passed to Client or Server, or
+	// to the listening socket for Listen and ListenPacketConn. If the socket
+	// or platform does not support it, a warning is logged and datagrams are
+	// sent unmarked.
+	DSCP int
+
+	// DisableIPFragmentation sets the IP don't fragment (DF) bit on the
+	// socket passed to Client or Server, or on the listening socket for
+	// Listen and ListenPacketConn, where the platform supports it. Datagrams
+	// larger than the path MTU are then rejected by the network stack with
+	// EMSGSIZE instead of being fragmented. Such a rejection lowers the MTU
+	// used for handshake flights, and a flight is retransmitted in smaller
+	// fragments instead of failing the handshake. Other writes fail with a
+	// MessageTooLongError.
+	DisableIPFragmentation bool
+
+	// BatchIO submits datagrams that are sent together, such as a handshake
+	// flight spanning several datagrams, with a single WriteBatch call on the
+	// socket passed to Client or Server, which uses sendmmsg on Linux. It has
+	// no effect if the connection is not a socket, such as connections
+	// accepted from a Listener. Write deadlines are only checked before a
+	// batch is sent.
+	BatchIO bool
+
 	// ReplayProtectionWindow is the size of the replay attack protection window.
 	// Duplication of the sequence number is checked in this window size.
 	// Packet with sequence number older than this value compared to the latest
@@ -150,8 +349,37 @@ type Config struct {
 	//
 	// If an ECC ciphersuite is configured and EllipticCurves is empty
 	// it will default to X25519, P-256, P-384 in this specific order.
+	// X448 and P-521 are also implemented, but must be listed explicitly.
 	EllipticCurves []elliptic.Curve
 
+	// DHGroups lists the RFC 7919 finite field groups to use with the DHE
+	// cipher suites, in order of preference. If a DHE ciphersuite is
+	// configured and DHGroups is empty it will default to ffdhe2048,
+	// ffdhe3072, ffdhe4096 in this specific order.
+	DHGroups []ffdhe.Group
+
+	// MinDHPrimeBits is the smallest prime, in bits, a client accepts in the
+	// ServerKeyExchange of a DHE cipher suite. Smaller primes are rejected to
+	// avoid a downgrade to weak Diffie-Hellman. If zero it defaults to 2048.
+	// Primes other than those of the RFC 7919 groups must not be longer than
+	// ffdhe.MaxPrimeBits.
+	MinDHPrimeBits int
+
+	// RequiredCurve, if set, forces the server to use this curve for ECDHE
+	// key exchange regardless of the client's preference order. Clients that
+	// do not offer the curve are rejected with a handshake_failure alert.
+	// It is ignored by clients.
+	RequiredCurve elliptic.Curve
+
+	// ECDHEKeyReuse, if greater than zero, allows a server to reuse its
+	// ECDHE key-exchange key for all handshakes started within this window
+	// after the key was generated, rather than generating a fresh key for
+	// each handshake. This reduces the CPU cost of handshakes at the expense
+	// of forward secrecy: compromising a key exposes every session that used
+	// it. Keys are shared between connections using the same Config, and its
+	// copies made after a handshake. It is ignored by clients.
+	ECDHEKeyReuse time.Duration
+
 	// GetCertificate returns a Certificate based on the given
 	// ClientHelloInfo. It will only be called if the client supplies SNI
 	// information or if Certificates is empty.
@@ -161,6 +389,17 @@ type Config struct {
 	// best element of Certificates will be used.
 	GetCertificate func(*ClientHelloInfo) (*tls.Certificate, error)
 
+	// GetConfigForClient, if not nil, is called by a server after a
+	// ClientHello is received, and may return a Config to use for the rest
+	// of the handshake, for example to serve different certificates, cipher
+	// suites or client authentication policies depending on the SNI. If it
+	// returns nil, the original Config is used. Transport settings, such
+	// as the MTU and flight intervals, are always taken from the original
+	// Config, and the GetConfigForClient of the returned Config is ignored.
+	// It may be called more than once per handshake. It is ignored by
+	// clients.
+	GetConfigForClient func(*ClientHelloInfo) (*Config, error)
+
 	// GetClientCertificate, if not nil, is called when a server requests a
 	// certificate from a client. If set, the contents of Certificates will
 	// be ignored.
@@ -178,10 +417,27 @@ type Config struct {
 	// This have implication on DoS attack resistance.
 	InsecureSkipVerifyHello bool
 
+	// ShouldVerifyHello, if not nil and InsecureSkipVerifyHello is false, is
+	// called by a server with the address of each client that sends an
+	// initial ClientHello. If it returns false the hello verify phase is
+	// skipped for that client, as with InsecureSkipVerifyHello. This allows
+	// low latency connections from trusted networks, or while the rate of
+	// incoming handshakes is low, while keeping the protection against
+	// amplification attacks for other clients.
+	ShouldVerifyHello func(net.Addr) bool
+
+	// CookieGenerator, if not nil, generates and verifies the cookies sent
+	// by a server in HelloVerifyRequest messages instead of a random cookie
+	// kept for the connection. It is ignored by clients and if
+	// InsecureSkipVerifyHello is set.
+	CookieGenerator CookieGenerator
+
 	// ConnectionIDGenerator generates connection identifiers that should be
 	// sent by the remote party if it supports the DTLS Connection Identifier
 	// extension, as determined during the handshake. Generated connection
-	// identifiers must always have the same length. Returning a zero-length
+	// identifiers must always have the same length, which may be anything
+	// from 0 to 255 bytes; RandomCIDGenerator produces connection identifiers
+	// of a fixed, configurable length. Returning a zero-length
 	// connection identifier indicates that the local party supports sending
 	// connection identifiers but does not require the remote party to send
 	// them. A nil ConnectionIDGenerator indicates that connection identifiers
@@ -189,6 +445,84 @@ type Config struct {
 	// https://datatracker.ietf.org/doc/html/rfc9146
 	ConnectionIDGenerator func() []byte
 
+	// DisableConnectionIDAddressUpdate, if true, keeps the remote address of
+	// the connection fixed to the one used when it was created, even if valid
+	// connection ID records later arrive from a different source address.
+	// By default the remote address follows the most recent connection ID
+	// record, which allows peers to roam across NAT rebindings and network
+	// changes. Disabling the update gives up that mobility in exchange for
+	// preventing responses from being redirected by records replayed or
+	// forwarded from another address. Replay and decryption checks apply
+	// either way.
+	// https://datatracker.ietf.org/doc/html/rfc9146#peer-address-update
+	DisableConnectionIDAddressUpdate bool
+
+	// EnableHeartbeat advertises support for the Heartbeat extension and
+	// allows the peer to send HeartbeatRequests, which are answered
+	// automatically. If the peer advertises the extension as well, Ping can
+	// be used to check that the peer is alive and to keep NAT bindings open.
+	// https://datatracker.ietf.org/doc/html/rfc6520
+	EnableHeartbeat bool
+
+	// RequestOCSPStaple makes a client send the status_request extension,
+	// asking the server to staple an OCSP response for its certificate. The
+	// response returned is available in State.OCSPResponse.
+	// https://datatracker.ietf.org/doc/html/rfc6066#section-8
+	RequestOCSPStaple bool
+
+	// RequestSCTs makes a client offer the signed_certificate_timestamp
+	// extension, asking the server for the Signed Certificate Timestamps of
+	// its certificate. The timestamps returned are available in State.SCTs.
+	// https://datatracker.ietf.org/doc/html/rfc6962#section-3.3.1
+	RequestSCTs bool
+
+	// NonStandardCertificateCompression lists the algorithms, in order of
+	// preference, with which certificate chains may be compressed. A client
+	// offers them in the compress_certificate extension, and a server uses
+	// the first one the client offered to send its chain in a
+	// CompressedCertificate message. CertificateCompressionZlib and
+	// CertificateCompressionBrotli are supported. Compression is disabled if
+	// the list is empty.
+	//
+	// The extension is defined for TLS 1.3 only, so using it in DTLS 1.2 is
+	// not standard: only peers that opted into the same extension compress
+	// their chains, and other peers ignore the offer.
+	// https://datatracker.ietf.org/doc/html/rfc8879
+	NonStandardCertificateCompression []CertificateCompressionAlgorithm
+
+	// TruncatedHMAC enables the truncated_hmac extension. A client offers it,
+	// and a server accepts it when a CBC cipher suite is selected, in which
+	// case the MAC of every record is truncated to 80 bits. It saves
+	// bandwidth on constrained links at the cost of a weaker MAC, and has no
+	// effect on AEAD cipher suites.
+	// https://datatracker.ietf.org/doc/html/rfc6066#section-7
+	TruncatedHMAC bool
+
+	// RequireRenegotiationInfo aborts the handshake with a handshake_failure
+	// alert if the peer does not signal support for secure renegotiation,
+	// with the renegotiation_info extension or, from a client, the
+	// TLS_EMPTY_RENEGOTIATION_INFO_SCSV cipher suite. DTLS connections are
+	// never renegotiated, but the signal tells patched peers apart.
+	// https://datatracker.ietf.org/doc/html/rfc5746#section-3.6
+	RequireRenegotiationInfo bool
+
+	// SequenceNumberNonces makes GCM cipher suites send the epoch and
+	// sequence number of each record as its explicit nonce instead of
+	// random bytes, which avoids reading from Rand for every record. Peers
+	// accept either. Cipher suites from CustomCipherSuites use it if they
+	// implement SetSequenceNonce(bool). A State restored with
+	// UnmarshalBinary uses random nonces.
+	// https://datatracker.ietf.org/doc/html/rfc5288#section-3
+	SequenceNumberNonces bool
+
+	// VerifyTranscriptIntegrity enables an internal self-test that records a
+	// digest of every handshake message as it is added to the transcript
+	// and checks them all before each flight is parsed or generated. A
+	// message that changed after it was recorded, for instance because of a
+	// bug in fragment reassembly, fails the handshake with an internal error
+	// instead of surfacing later as a Finished mismatch.
+	VerifyTranscriptIntegrity bool
+
 	// PaddingLengthGenerator generates the number of padding bytes used to
 	// inflate ciphertext size in order to obscure content size from observers.
 	// The length of the content is passed to the generator such that both
@@ -201,6 +535,44 @@ type Config struct {
 	// HelloRandomBytesGenerator generates custom client hello random bytes.
 	HelloRandomBytesGenerator func() [handshake.RandomBytesLength]byte
 
+	// Rand provides the randomness of the connection: hello randoms, cookies,
+	// session IDs, ephemeral keys, signatures, pre-master secrets, explicit
+	// nonces and IVs, and heartbeat payloads. It defaults to crypto/rand.Reader.
+	// Cipher suites from CustomCipherSuites use it if they implement
+	// SetRand(io.Reader). The explicit nonces of a State restored with
+	// UnmarshalBinary are always drawn from crypto/rand.
+	Rand io.Reader
+
+	// RecordLayerVersionOverride, if not zero, replaces the version in the
+	// record layer header of every handshake record sent, independently of
+	// the version carried in the handshake messages. It is meant for testing
+	// middleboxes, e.g. sending a ClientHello for DTLS 1.2 in a record
+	// claiming DTLS 1.0.
+	RecordLayerVersionOverride protocol.Version
+
+	// MinVersion and MaxVersion bound the protocol versions a client offers
+	// in the supported_versions extension. The zero value means DTLS 1.2,
+	// the only version whose handshake is implemented, in which case the
+	// extension is not sent. MaxVersion may be DTLS 1.3 to advertise it,
+	// but the handshake fails with a protocol_version alert if the server
+	// selects it. Servers always select DTLS 1.2.
+	MinVersion, MaxVersion protocol.Version
+
+	// OnAlert, if not nil, is called whenever the connection sends or
+	// receives an alert, during the handshake as well as afterwards. sent is
+	// true for alerts written to the peer and false for alerts read from it.
+	// It is called from the connection's internal goroutines and must not
+	// block.
+	OnAlert func(level alert.Level, desc alert.Description, sent bool)
+
+	// OnHandshakeStep, if not nil, is called each time the handshake state
+	// machine enters a state, with the flight it is working on. Retransmissions
+	// repeat the Sending and Waiting steps of the current flight, and a
+	// handshake that stalls stops reporting steps at the flight it is stuck
+	// in. It is called from the handshake goroutine without holding any
+	// connection lock and must not block, as that stalls the handshake.
+	OnHandshakeStep func(step HandshakeStep)
+
 	// Handshake hooks: hooks can be used for testing invalid messages,
 	// mimicking other implementations or randomizing fields, which is valuable
 	// for applications that need censorship-resistance by making
@@ -217,6 +589,19 @@ type Config struct {
 	// CertificateRequestMessageHook, if not nil, is called when a Certificate Request
 	// message is sent from a server. The returned handshake message replaces the original message.
 	CertificateRequestMessageHook func(handshake.MessageCertificateRequest) handshake.Message
+
+	// InboundHook, if not nil, is called with every datagram read from the
+	// network before it is processed, and returns the datagrams to process
+	// in its place. It is meant for test harnesses that exercise
+	// reassembly, replay protection and retransmission: returning nil drops
+	// or holds back a datagram, and returning held datagrams along with
+	// later ones delays, reorders or duplicates them. data is only valid
+	// during the call and must be copied to be held. The hook is called
+	// from the read loop and must not block.
+	InboundHook func(addr net.Addr, data []byte) [][]byte
+
+	// ecdheKeys holds the ECDHE keys reused when ECDHEKeyReuse is set.
+	ecdheKeys *ecdheKeyCache
 }
 
 func defaultConnectContextMaker() (context.Context, func()) {
@@ -230,6 +615,13 @@ func (c *Config) connectContextMaker() (context.Context, func()) {
 	return c.ConnectContextMaker()
 }
 
+func (c *Config) randReader() io.Reader {
+	if c == nil || c.Rand == nil {
+		return rand.Reader
+	}
+	return c.Rand
+}
+
 func (c *Config) includeCertificateSuites() bool {
 	return c.PSK == nil || len(c.Certificates) > 0 || c.GetCertificate != nil || c.GetClientCertificate != nil
 }
@@ -238,6 +630,10 @@ const defaultMTU = 1200 // bytes
 
 var defaultCurves = []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384} //nolint:gochecknoglobals
 
+var defaultDHGroups = []ffdhe.Group{ffdhe.FFDHE2048, ffdhe.FFDHE3072, ffdhe.FFDHE4096} //nolint:gochecknoglobals
+
+const defaultMinDHPrimeBits = 2048
+
 // PSKCallback is called once we have the remote's PSKIdentityHint.
 // If the remote provided none it will be nil
 type PSKCallback func([]byte) ([]byte, error)
@@ -270,14 +666,32 @@ func validateConfig(config *Config) error {
 	switch {
 	case config == nil:
 		return errNoConfigProvided
-	case config.PSKIdentityHint != nil && config.PSK == nil:
+	case (config.PSKIdentityHint != nil || config.GetPSKIdentityHint != nil) && config.PSK == nil:
 		return errIdentityNoPSK
+	case config.DSCP < 0 || config.DSCP > 63:
+		return errInvalidDSCP
+	case config.MaxEarlyPacketQueue < 0:
+		return errInvalidEarlyPacketQueue
+	case config.MaxRecordsPerDatagram < 0:
+		return errInvalidMaxRecordsPerDatagram
+	case config.IdleTimeout < 0:
+		return errInvalidIdleTimeout
+	case config.KeepAlive < 0:
+		return errInvalidKeepAlive
+	case config.SequenceNumberWarnThreshold > recordlayer.MaxSequenceNumber:
+		return errInvalidSequenceWarnThreshold
+	case !config.minVersion().Equal(protocol.Version1_2),
+		!config.maxVersion().Equal(protocol.Version1_2) && !config.maxVersion().Equal(protocol.Version1_3):
+		return errInvalidVersionRange
 	}
 
 	for _, cert := range config.Certificates {
 		if cert.Certificate == nil {
 			return errInvalidCertificate
 		}
+		if err := validateOCSPStaple(cert.OCSPStaple); err != nil {
+			return err
+		}
 		if cert.PrivateKey != nil {
 			switch cert.PrivateKey.(type) {
 			case ed25519.PrivateKey:
@@ -289,6 +703,77 @@ func validateConfig(config *Config) error {
 		}
 	}
 
+	for _, group := range config.DHGroups {
+		if !ffdhe.Groups()[group] {
+			return errInvalidDHGroup
+		}
+	}
+
+	for _, algorithm := range config.NonStandardCertificateCompression {
+		if algorithm != CertificateCompressionZlib && algorithm != CertificateCompressionBrotli {
+			return errInvalidCertificateCompression
+		}
+	}
+
+	for _, id := range config.CipherSuites {
+		c := cipherSuiteForID(id, nil)
+		if c == nil {
+			continue
+		}
+		if c.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmRsa) && !config.InsecureRSAKeyExchange {
+			return errRSAKeyExchangeNotAllowed
+		}
+		// ECDHE_PSK suites mix an ephemeral ECDHE secret with the PSK and
+		// therefore need both.
+		if c.AuthenticationType() != CipherSuiteAuthenticationTypePreSharedKey || !c.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe) {
+			continue
+		}
+		switch {
+		case config.PSK == nil:
+			return errECDHEPSKNoPSK
+		case !config.hasSupportedCurve():
+			return errECDHEPSKNoCurve
+		}
+	}
+
 	_, err := parseCipherSuites(config.CipherSuites, config.CustomCipherSuites, config.includeCertificateSuites(), config.PSK != nil)
 	return err
 }
+
+func (c *Config) minVersion() protocol.Version {
+	if c.MinVersion == (protocol.Version{}) {
+		return protocol.Version1_2
+	}
+	return c.MinVersion
+}
+
+func (c *Config) maxVersion() protocol.Version {
+	if c.MaxVersion == (protocol.Version{}) {
+		return protocol.Version1_2
+	}
+	return c.MaxVersion
+}
+
+// supportedVersions returns the versions between MinVersion and MaxVersion,
+// most preferred first.
+func (c *Config) supportedVersions() []protocol.Version {
+	if c.maxVersion().Equal(protocol.Version1_3) {
+		return []protocol.Version{protocol.Version1_3, protocol.Version1_2}
+	}
+	return []protocol.Version{protocol.Version1_2}
+}
+
+// hasSupportedCurve reports whether EllipticCurves, or the default curves if
+// it is empty, contains at least one supported curve.
+func (c *Config) hasSupportedCurve() bool {
+	if len(c.EllipticCurves) == 0 {
+		return true
+	}
+	supported := elliptic.Curves()
+	for _, curve := range c.EllipticCurves {
+		if supported[curve] {
+			return true
+		}
+	}
+	return false
+}
@@ -10,8 +10,13 @@ import (
 	"crypto/tls"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 )
 
 func TestValidateConfig(t *testing.T) {
@@ -81,6 +86,38 @@ func TestValidateConfig(t *testing.T) {
 			},
 			expErr: errIdentityNoPSK,
 		},
+		"RSA key exchange not allowed": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_RSA_WITH_AES_128_GCM_SHA256},
+			},
+			expErr: errRSAKeyExchangeNotAllowed,
+		},
+		"ECDHE_PSK without PSK": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+			},
+			expErr: errECDHEPSKNoPSK,
+		},
+		"ECDHE_PSK without supported curve": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+				PSK: func([]byte) ([]byte, error) {
+					return nil, nil
+				},
+				PSKIdentityHint: []byte("Client Identity"),
+				EllipticCurves:  []elliptic.Curve{0xFFFF},
+			},
+			expErr: errECDHEPSKNoCurve,
+		},
+		"PSK identity hint callback with not PSK": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				GetPSKIdentityHint: func(*ClientHelloInfo) ([]byte, error) {
+					return nil, nil
+				},
+			},
+			expErr: errIdentityNoPSK,
+		},
 		"Invalid private key": {
 			config: &Config{
 				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
@@ -99,6 +136,86 @@ func TestValidateConfig(t *testing.T) {
 			config:     &Config{CipherSuites: []CipherSuiteID{0x0000}},
 			wantAnyErr: true,
 		},
+		"Invalid DSCP": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{cert},
+				DSCP:         64,
+			},
+			expErr: errInvalidDSCP,
+		},
+		"Negative MaxEarlyPacketQueue": {
+			config: &Config{
+				CipherSuites:        []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates:        []tls.Certificate{cert},
+				MaxEarlyPacketQueue: -1,
+			},
+			expErr: errInvalidEarlyPacketQueue,
+		},
+		"DTLS 1.3 MinVersion": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   protocol.Version1_3,
+				MaxVersion:   protocol.Version1_3,
+			},
+			expErr: errInvalidVersionRange,
+		},
+		"DTLS 1.0 MaxVersion": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{cert},
+				MaxVersion:   protocol.Version1_0,
+			},
+			expErr: errInvalidVersionRange,
+		},
+		"Negative MaxRecordsPerDatagram": {
+			config: &Config{
+				CipherSuites:          []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates:          []tls.Certificate{cert},
+				MaxRecordsPerDatagram: -1,
+			},
+			expErr: errInvalidMaxRecordsPerDatagram,
+		},
+		"Negative IdleTimeout": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{cert},
+				IdleTimeout:  -time.Second,
+			},
+			expErr: errInvalidIdleTimeout,
+		},
+		"Negative KeepAlive": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{cert},
+				KeepAlive:    -time.Second,
+			},
+			expErr: errInvalidKeepAlive,
+		},
+		"SequenceNumberWarnThreshold too large": {
+			config: &Config{
+				CipherSuites:                []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates:                []tls.Certificate{cert},
+				SequenceNumberWarnThreshold: recordlayer.MaxSequenceNumber + 1,
+			},
+			expErr: errInvalidSequenceWarnThreshold,
+		},
+		"Invalid OCSP staple": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{{Certificate: cert.Certificate, PrivateKey: cert.PrivateKey, OCSPStaple: []byte{0x01, 0x02}}},
+			},
+			expErr: errInvalidOCSPStaple,
+		},
+		"Unsupported certificate compression": {
+			config: &Config{
+				CipherSuites:                      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates:                      []tls.Certificate{cert},
+				NonStandardCertificateCompression: []CertificateCompressionAlgorithm{extension.CertificateCompressionAlgorithmZstd},
+			},
+			expErr: errInvalidCertificateCompression,
+		},
 		"Valid config": {
 			config: &Config{
 				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
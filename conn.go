@@ -6,10 +6,12 @@ package dtls
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,13 +19,14 @@ import (
 	"github.com/pion/logging"
 	"github.com/pion/transport/v3/deadline"
 	"github.com/pion/transport/v3/netctx"
-	"github.com/pion/transport/v3/replaydetector"
 	"github.com/censys-oss/dtls/v2/internal/closer"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/signaturehash"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/heartbeat"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 	"github.com/zmap/zcrypto/tls"
 )
@@ -34,11 +37,26 @@ const (
 	sessionLength         = 32
 	defaultNamedCurve     = elliptic.X25519
 	inboundBufferSize     = 8192
+	outboundBufferSize    = 8192
 	// Default replay protection window is specified by RFC 6347 Section 4.1.2.6
 	defaultReplayProtectionWindow = 64
-	// maxAppDataPacketQueueSize is the maximum number of app data packets we will
-	// enqueue before the handshake is completed
+	// maxAppDataPacketQueueSize is the default maximum number of app data
+	// packets we will enqueue before the handshake is completed
 	maxAppDataPacketQueueSize = 100
+	// defaultMaxRecordsPerDatagram is the default maximum number of records
+	// processed from a single datagram
+	defaultMaxRecordsPerDatagram = 64
+	// maxEpochHistory is the number of remote epoch transitions kept for
+	// EpochHistory
+	maxEpochHistory = 16
+	// maxRetainedEpochs is the number of epochs, counting back from the
+	// current one, for which sequence numbers and replay protection state
+	// are kept. Records of older epochs are neither sent nor received.
+	maxRetainedEpochs = 2
+	// heartbeatPayloadLength is the size of the random payload sent by Ping
+	heartbeatPayloadLength = 16
+	// Default cap of the retransmission backoff is specified by RFC 6347 Section 4.2.4.1
+	defaultMaxTickerInterval = 60 * time.Second
 )
 
 func invalidKeyingLabels() map[string]bool {
@@ -65,14 +83,18 @@ type Conn struct {
 	rAddr          net.Addr
 	state          State // Internal state
 
-	maximumTransmissionUnit int
+	maximumTransmissionUnit int32 // atomic
 	paddingLengthGenerator  func(uint) uint
 
 	handshakeCompletedSuccessfully atomic.Value
 
-	encryptedPackets []addrPkt
+	encryptedPackets    []addrPkt
+	maxEarlyPacketQueue int
+
+	maxRecordsPerDatagram int
 
 	connectionClosedByUser bool
+	writeClosed            bool
 	closeLock              sync.Mutex
 	closed                 *closer.Closer
 	handshakeLoopsFinished sync.WaitGroup
@@ -80,6 +102,13 @@ type Conn struct {
 	readDeadline  *deadline.Deadline
 	writeDeadline *deadline.Deadline
 
+	// transportFailed is closed once a write to the underlying connection
+	// fails with a fatal error, which transportErr then wraps for Read and
+	// Write.
+	transportFailed     chan struct{}
+	transportErr        error
+	transportFailedOnce sync.Once
+
 	log          logging.LeveledLogger
 	handshakeLog tls.ServerHandshake
 
@@ -88,9 +117,96 @@ type Conn struct {
 	cancelHandshaker      func()
 	cancelHandshakeReader func()
 
+	// handshakeCtx is canceled once the handshake has completed or failed.
+	handshakeCtx    context.Context
+	handshakeCancel context.CancelFunc
+
 	fsm *handshakeFSM
 
+	config *Config // Used to configure the handshake of a rekey
+
+	// handshakerDone is closed once the handshake routine has stopped.
+	// cancelFSM stops the FSM it currently runs, so that it switches to the
+	// FSM of the rekey sent on rekeys.
+	handshakerDone chan struct{}
+	cancelFSM      func()
+	rekeys         chan *rekey
+
+	// rekeying is the rekey in progress, if any, whose keys protect the
+	// epochs after the one it runs in. The epochs before cipherSuiteEpoch are
+	// protected by previousCipherSuite, the keys replaced by the last rekey.
+	// All are guarded by lock.
+	rekeying            *rekey
+	previousCipherSuite CipherSuite
+	cipherSuiteEpoch    uint16
+
 	replayProtectionWindow uint
+
+	disableConnectionIDAddressUpdate bool
+
+	onAlert func(alert.Level, alert.Description, bool)
+
+	sentAlerts     []AlertRecord
+	sentAlertsLock sync.Mutex
+
+	epochHistory     []EpochTransition // Bounded by maxEpochHistory
+	epochHistoryLock sync.Mutex
+
+	rand io.Reader // Source of heartbeat payloads and padding
+
+	pingSem     chan struct{} // Only one HeartbeatRequest may be in flight [RFC6520 Section 3]
+	pingLock    sync.Mutex
+	pingPayload []byte
+	pingDone    chan struct{}
+
+	// Counters reported by Stats, all accessed atomically.
+	droppedAfterClose     uint64
+	bytesSent             uint64
+	bytesReceived         uint64
+	recordsSent           uint64
+	recordsReceived       uint64
+	retransmittedFlights  uint64
+	decryptFailures       uint64
+	maxQueuedRecords      uint64 // Only written by the read loop
+	droppedQueueFull      uint64
+	droppedBufferFull     uint64
+	droppedTooManyRecords uint64
+	keepAlivesSent        uint64
+
+	recordLayerVersionOverride protocol.Version
+
+	batchConn batchWriter // nil unless BatchIO is enabled and supported
+
+	maxWarningAlerts int
+	warningAlerts    int // Only accessed by the read loop
+
+	// Values for Read that did not fit in the decrypted channel, if
+	// EarlyDataBuffer is set, or that were pending when the connection was
+	// closed, if DrainOnClose is set.
+	earlyDataBuffer     int
+	drainOnClose        bool
+	decryptedQueue      []interface{}
+	decryptedQueueBytes int
+	decryptedClosed     bool
+	decryptedLock       sync.Mutex
+
+	inboundHook func(net.Addr, []byte) [][]byte
+
+	skipCloseNotify      bool
+	strictHandshakeOrder bool
+
+	// idleTimer closes the connection after idleTimeout without application
+	// data. It is created stopped and started when the handshake completes.
+	idleTimeout time.Duration
+	idleTimer   *time.Timer
+
+	// keepAliveTimer sends a keepalive after keepAlive without writes. Like
+	// idleTimer, it is started when the handshake completes.
+	keepAlive      time.Duration
+	keepAliveTimer *time.Timer
+
+	sequenceNumberWarnThreshold uint64
+	onSequenceNumberWarning     func(uint16, uint64)
 }
 
 func createConn(nextConn net.PacketConn, rAddr net.Addr, config *Config, isClient bool) (*Conn, error) {
@@ -119,17 +235,34 @@ func createConn(nextConn net.PacketConn, rAddr net.Addr, config *Config, isClien
 		replayProtectionWindow = defaultReplayProtectionWindow
 	}
 
+	maxEarlyPacketQueue := config.MaxEarlyPacketQueue
+	if maxEarlyPacketQueue == 0 {
+		maxEarlyPacketQueue = maxAppDataPacketQueueSize
+	}
+
+	maxRecordsPerDatagram := config.MaxRecordsPerDatagram
+	if maxRecordsPerDatagram == 0 {
+		maxRecordsPerDatagram = defaultMaxRecordsPerDatagram
+	}
+
 	paddingLengthGenerator := config.PaddingLengthGenerator
 	if paddingLengthGenerator == nil {
 		paddingLengthGenerator = func(uint) uint { return 0 }
 	}
 
+	if config.DSCP != 0 {
+		applyDSCP(nextConn, config.DSCP, logger)
+	}
+	if config.DisableIPFragmentation {
+		applyDontFragment(nextConn, logger)
+	}
+
 	c := &Conn{
 		rAddr:                   rAddr,
 		nextConn:                netctx.NewPacketConn(nextConn),
 		fragmentBuffer:          newFragmentBuffer(),
 		handshakeCache:          newHandshakeCache(),
-		maximumTransmissionUnit: mtu,
+		maximumTransmissionUnit: int32(mtu),
 		paddingLengthGenerator:  paddingLengthGenerator,
 
 		decrypted: make(chan interface{}, 1),
@@ -142,14 +275,66 @@ func createConn(nextConn net.PacketConn, rAddr net.Addr, config *Config, isClien
 		handshakeRecv:    make(chan chan struct{}),
 		closed:           closer.NewCloser(),
 		cancelHandshaker: func() {},
+		handshakerDone:   make(chan struct{}),
+		cancelFSM:        func() {},
+		rekeys:           make(chan *rekey),
 
 		replayProtectionWindow: uint(replayProtectionWindow),
 
+		disableConnectionIDAddressUpdate: config.DisableConnectionIDAddressUpdate,
+
+		onAlert: config.OnAlert,
+
+		rand:    config.randReader(),
+		pingSem: make(chan struct{}, 1),
+
+		transportFailed: make(chan struct{}),
+
+		recordLayerVersionOverride: config.RecordLayerVersionOverride,
+
+		maxWarningAlerts: config.MaxWarningAlerts,
+
+		maxEarlyPacketQueue: maxEarlyPacketQueue,
+
+		maxRecordsPerDatagram: maxRecordsPerDatagram,
+
+		earlyDataBuffer: config.EarlyDataBuffer,
+		drainOnClose:    config.DrainOnClose,
+
+		inboundHook: config.InboundHook,
+
+		skipCloseNotify:      config.SkipCloseNotify,
+		strictHandshakeOrder: config.StrictHandshakeOrder,
+
+		idleTimeout: config.IdleTimeout,
+		keepAlive:   config.KeepAlive,
+
+		sequenceNumberWarnThreshold: config.SequenceNumberWarnThreshold,
+		onSequenceNumberWarning:     config.OnSequenceNumberWarning,
+
 		state: State{
 			isClient: isClient,
 		},
 	}
 
+	c.handshakeCtx, c.handshakeCancel = context.WithCancel(context.Background())
+	c.handshakeCache.verifyIntegrity = config.VerifyTranscriptIntegrity
+
+	if config.BatchIO {
+		if c.batchConn = newBatchWriter(nextConn); c.batchConn == nil {
+			logger.Debug("batch IO is not available, the underlying connection is not a socket")
+		}
+	}
+
+	if c.idleTimeout > 0 {
+		c.idleTimer = time.AfterFunc(c.idleTimeout, c.closeIdle)
+		c.idleTimer.Stop()
+	}
+	if c.keepAlive > 0 {
+		c.keepAliveTimer = time.AfterFunc(c.keepAlive, c.sendKeepAlive)
+		c.keepAliveTimer.Stop()
+	}
+
 	c.setRemoteEpoch(0)
 	c.setLocalEpoch(0)
 	return c, nil
@@ -159,11 +344,58 @@ func handshakeConn(ctx context.Context, conn *Conn, config *Config, isClient boo
 	if conn == nil {
 		return nil, errNilNextConn
 	}
+	defer conn.handshakeCancel()
+	conn.config = config
+
+	hsCfg, err := newHandshakeConfig(config, conn.log, isClient)
+	if err != nil {
+		return nil, err
+	}
+	conn.fragmentBuffer.statelessCookie = !isClient && hsCfg.cookieGenerator != nil && !hsCfg.insecureSkipHelloVerify
+
+	var initialFlight flightVal
+	var initialFSMState handshakeState
+
+	if initialState != nil {
+		if conn.state.isClient {
+			initialFlight = flight5
+		} else {
+			initialFlight = flight6
+		}
+		initialFSMState = handshakeFinished
 
+		conn.state = *initialState
+	} else {
+		if conn.state.isClient {
+			initialFlight = flight1
+		} else {
+			initialFlight = flight0
+		}
+		initialFSMState = handshakePreparing
+	}
+	// Do handshake
+	if err := conn.handshake(ctx, hsCfg, initialFlight, initialFSMState); err != nil {
+		return nil, err
+	}
+
+	conn.log.Trace("Handshake Completed")
+
+	return conn, nil
+}
+
+// newHandshakeConfig returns the handshake parameters described by config.
+func newHandshakeConfig(config *Config, log logging.LeveledLogger, isClient bool) (*handshakeConfig, error) {
 	cipherSuites, err := parseCipherSuites(config.CipherSuites, config.CustomCipherSuites, config.includeCertificateSuites(), config.PSK != nil)
 	if err != nil {
 		return nil, err
 	}
+	// A server can only use suites matching one of its certificates. Leave
+	// the check to the handshake if they are chosen by GetCertificate.
+	if !isClient && config.GetCertificate == nil && len(config.Certificates) > 0 {
+		if err = checkCipherSuitesForCertificates(config.Certificates, cipherSuites); err != nil {
+			return nil, err
+		}
+	}
 
 	signatureSchemes, err := signaturehash.ParseSignatureSchemes(config.SignatureSchemes, config.InsecureHashes)
 	if err != nil {
@@ -175,6 +407,11 @@ func handshakeConn(ctx context.Context, conn *Conn, config *Config, isClient boo
 		workerInterval = config.FlightInterval
 	}
 
+	maxWorkerInterval := defaultMaxTickerInterval
+	if config.MaxFlightInterval != 0 {
+		maxWorkerInterval = config.MaxFlightInterval
+	}
+
 	serverName := config.ServerName
 	// Do not allow the use of an IP address literal as an SNI value.
 	// See RFC 6066, Section 3.
@@ -187,14 +424,31 @@ func handshakeConn(ctx context.Context, conn *Conn, config *Config, isClient boo
 		curves = defaultCurves
 	}
 
+	dhGroups := config.DHGroups
+	if len(dhGroups) == 0 {
+		dhGroups = defaultDHGroups
+	}
+
+	minDHPrimeBits := config.MinDHPrimeBits
+	if minDHPrimeBits == 0 {
+		minDHPrimeBits = defaultMinDHPrimeBits
+	}
+
+	trustedCAKeys, err := trustedAuthorities(config.TrustedCAKeys)
+	if err != nil {
+		return nil, err
+	}
+
 	hsCfg := &handshakeConfig{
 		localPSKCallback:              config.PSK,
 		localPSKIdentityHint:          config.PSKIdentityHint,
 		localCipherSuites:             cipherSuites,
+		preferServerCipherSuites:      config.PreferServerCipherSuites,
 		localSignatureSchemes:         signatureSchemes,
 		extendedMasterSecret:          config.ExtendedMasterSecret,
 		localSRTPProtectionProfiles:   config.SRTPProtectionProfiles,
 		serverName:                    serverName,
+		requireSNIMatch:               config.RequireSNIMatch,
 		supportedProtocols:            config.SupportedProtocols,
 		clientAuth:                    config.ClientAuth,
 		localCertificates:             config.Certificates,
@@ -202,63 +456,53 @@ func handshakeConn(ctx context.Context, conn *Conn, config *Config, isClient boo
 		verifyPeerCertificate:         config.VerifyPeerCertificate,
 		verifyConnection:              config.VerifyConnection,
 		rootCAs:                       config.RootCAs,
+		trustedCAKeys:                 trustedCAKeys,
 		clientCAs:                     config.ClientCAs,
 		customCipherSuites:            config.CustomCipherSuites,
 		retransmitInterval:            workerInterval,
-		log:                           conn.log,
+		maxRetransmitInterval:         maxWorkerInterval,
+		maxRetransmissions:            config.MaxRetransmissions,
+		retransmitMTU:                 config.RetransmitMTU,
+		minMTU:                        config.MinMTU,
+		log:                           log,
 		initialEpoch:                  0,
 		keyLogWriter:                  config.KeyLogWriter,
 		sessionStore:                  config.SessionStore,
 		ellipticCurves:                curves,
+		dhGroups:                      dhGroups,
+		minDHPrimeBits:                minDHPrimeBits,
 		localGetCertificate:           config.GetCertificate,
 		localGetClientCertificate:     config.GetClientCertificate,
+		localGetPSKIdentityHint:       config.GetPSKIdentityHint,
 		insecureSkipHelloVerify:       config.InsecureSkipVerifyHello,
+		shouldVerifyHello:             config.ShouldVerifyHello,
+		cookieGenerator:               config.CookieGenerator,
 		connectionIDGenerator:         config.ConnectionIDGenerator,
 		helloRandomBytesGenerator:     config.HelloRandomBytesGenerator,
+		rand:                          config.randReader(),
+		heartbeat:                     config.EnableHeartbeat,
+		requestOCSPStaple:             config.RequestOCSPStaple,
+		requestSCTs:                   config.RequestSCTs,
+		certificateCompression:        config.NonStandardCertificateCompression,
+		truncatedHMAC:                 config.TruncatedHMAC,
+		requireRenegotiationInfo:      config.RequireRenegotiationInfo,
+		sequenceNonce:                 config.SequenceNumberNonces,
+		requiredCurve:                 config.RequiredCurve,
+		supportedVersions:             config.supportedVersions(),
+		onHandshakeStep:               config.OnHandshakeStep,
 		clientHelloMessageHook:        config.ClientHelloMessageHook,
 		serverHelloMessageHook:        config.ServerHelloMessageHook,
 		certificateRequestMessageHook: config.CertificateRequestMessageHook,
+		getConfigForClient:            config.GetConfigForClient,
 	}
 
-	// rfc5246#section-7.4.3
-	// In addition, the hash and signature algorithms MUST be compatible
-	// with the key in the server's end-entity certificate.
-	if !isClient {
-		cert, err := hsCfg.getCertificate(&ClientHelloInfo{})
-		if err != nil && !errors.Is(err, errNoCertificates) {
-			return nil, err
+	if config.ECDHEKeyReuse > 0 {
+		hsCfg.localGenerateKeypair = func(curve elliptic.Curve) (*elliptic.Keypair, error) {
+			return config.ecdheKeyCache().get(config.randReader(), curve, config.ECDHEKeyReuse, time.Now())
 		}
-		hsCfg.localCipherSuites = filterCipherSuitesForCertificate(cert, cipherSuites)
 	}
 
-	var initialFlight flightVal
-	var initialFSMState handshakeState
-
-	if initialState != nil {
-		if conn.state.isClient {
-			initialFlight = flight5
-		} else {
-			initialFlight = flight6
-		}
-		initialFSMState = handshakeFinished
-
-		conn.state = *initialState
-	} else {
-		if conn.state.isClient {
-			initialFlight = flight1
-		} else {
-			initialFlight = flight0
-		}
-		initialFSMState = handshakePreparing
-	}
-	// Do handshake
-	if err := conn.handshake(ctx, hsCfg, initialFlight, initialFSMState); err != nil {
-		return nil, err
-	}
-
-	conn.log.Trace("Handshake Completed")
-
-	return conn, nil
+	return hsCfg, nil
 }
 
 // Dial connects to the given network address and establishes a DTLS connection on top.
@@ -336,84 +580,529 @@ func ServerWithContext(ctx context.Context, conn net.PacketConn, rAddr net.Addr,
 
 // Read reads data from the connection.
 func (c *Conn) Read(p []byte) (n int, err error) {
+	n, _, err = c.ReadFrom(p)
+	return n, err
+}
+
+// ReadFrom reads data from the connection like Read, and returns the address
+// of the datagram that carried it. With a connection ID the peer may send
+// from a new address, which shows up here before RemoteAddr follows it.
+func (c *Conn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
 	if !c.isHandshakeCompletedSuccessfully() {
-		return 0, errHandshakeInProgress
+		return 0, nil, errHandshakeInProgress
 	}
 
 	select {
 	case <-c.readDeadline.Done():
-		return 0, errDeadlineExceeded
+		return 0, nil, errDeadlineExceeded
+	case <-c.transportFailed:
+		return 0, nil, c.transportErr
 	default:
 	}
 
 	for {
 		select {
 		case <-c.readDeadline.Done():
-			return 0, errDeadlineExceeded
+			return 0, nil, errDeadlineExceeded
+		case <-c.transportFailed:
+			return 0, nil, c.transportErr
 		case out, ok := <-c.decrypted:
 			if !ok {
-				return 0, io.EOF
+				// Records buffered with EarlyDataBuffer or DrainOnClose
+				// are read before reporting the end of the connection.
+				if out = c.popDecrypted(); out == nil {
+					return 0, nil, io.EOF
+				}
+			} else {
+				c.refillDecrypted()
 			}
 			switch val := out.(type) {
-			case ([]byte):
-				if len(p) < len(val) {
-					return 0, errBufferTooSmall
+			case addrPkt:
+				if len(p) < len(val.data) {
+					return 0, nil, errBufferTooSmall
 				}
-				copy(p, val)
-				return len(val), nil
-			case (error):
-				return 0, val
+				copy(p, val.data)
+				return len(val.data), val.rAddr, nil
+			case error:
+				return 0, nil, val
 			}
 		}
 	}
 }
 
-// Write writes len(p) bytes from p to the DTLS connection
+// deliver passes application data, as an addrPkt, or an error from the read
+// loop to Read. Unless EarlyDataBuffer is set, it blocks until Read takes
+// v. If the connection is closed first, v is dropped unless DrainOnClose is
+// set.
+func (c *Conn) deliver(ctx context.Context, v interface{}) {
+	if c.earlyDataBuffer > 0 {
+		c.bufferDecrypted(v)
+		return
+	}
+	select {
+	case c.decrypted <- v:
+	case <-c.closed.Done():
+		c.drainDecrypted(v)
+	case <-ctx.Done():
+		c.drainDecrypted(v)
+	}
+}
+
+// drainDecrypted queues v, which could not be delivered before the
+// connection was closed, for a final Read if DrainOnClose is set. The read
+// loop stops after closing, so at most one value is queued this way.
+func (c *Conn) drainDecrypted(v interface{}) {
+	if !c.drainOnClose {
+		return
+	}
+	c.decryptedLock.Lock()
+	defer c.decryptedLock.Unlock()
+
+	if pkt, ok := v.(addrPkt); ok {
+		c.decryptedQueueBytes += len(pkt.data)
+	}
+	c.decryptedQueue = append(c.decryptedQueue, v)
+}
+
+// bufferDecrypted queues v behind the decrypted channel if it is full.
+// Application data that does not fit in EarlyDataBuffer is dropped. Errors
+// are always queued, so that Read reports them in order.
+func (c *Conn) bufferDecrypted(v interface{}) {
+	c.decryptedLock.Lock()
+	defer c.decryptedLock.Unlock()
+
+	if len(c.decryptedQueue) == 0 {
+		select {
+		case c.decrypted <- v:
+			return
+		default:
+		}
+	}
+	if pkt, ok := v.(addrPkt); ok {
+		if c.decryptedQueueBytes+len(pkt.data) > c.earlyDataBuffer {
+			atomic.AddUint64(&c.droppedBufferFull, 1)
+			c.log.Debug("read buffer is full, discarding application data")
+			return
+		}
+		c.decryptedQueueBytes += len(pkt.data)
+	}
+	c.decryptedQueue = append(c.decryptedQueue, v)
+}
+
+// refillDecrypted moves the oldest queued value into the decrypted channel
+// after Read has taken a value from it.
+func (c *Conn) refillDecrypted() {
+	c.decryptedLock.Lock()
+	defer c.decryptedLock.Unlock()
+
+	if len(c.decryptedQueue) == 0 || c.decryptedClosed {
+		return
+	}
+	select {
+	case c.decrypted <- c.decryptedQueue[0]:
+		c.shiftDecrypted()
+	default:
+	}
+}
+
+// popDecrypted removes and returns the oldest queued value, or nil if the
+// queue is empty.
+func (c *Conn) popDecrypted() interface{} {
+	c.decryptedLock.Lock()
+	defer c.decryptedLock.Unlock()
+
+	if len(c.decryptedQueue) == 0 {
+		return nil
+	}
+	v := c.decryptedQueue[0]
+	c.shiftDecrypted()
+	return v
+}
+
+func (c *Conn) shiftDecrypted() {
+	if pkt, ok := c.decryptedQueue[0].(addrPkt); ok {
+		c.decryptedQueueBytes -= len(pkt.data)
+	}
+	c.decryptedQueue[0] = nil
+	c.decryptedQueue = c.decryptedQueue[1:]
+}
+
+// Write writes len(p) bytes from p to the DTLS connection as a single
+// application data record, which the peer receives with a single Read.
+// Application data is not fragmented: if the encrypted record would be
+// larger than the 8192 bytes a peer reads per datagram, Write sends nothing
+// and returns 0 and an error wrapping ErrRecordTooLarge that reports the
+// size of the record. Records larger than the MTU but within that limit are
+// sent as is and left to IP fragmentation.
 func (c *Conn) Write(p []byte) (int, error) {
+	return c.writeTo(p, nil)
+}
+
+// WriteTo writes p like Write, but sends the datagram to addr instead of the
+// remote address. It lets an application that tracks the roaming of its
+// peer itself reply to where a record came from, as reported by ReadFrom.
+// The remote address is left unchanged: WriteTo bypasses the address update
+// that connection ID records trigger, and later Writes still go to
+// RemoteAddr. addr must be of the same network as the underlying
+// connection.
+func (c *Conn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if addr == nil || addr.Network() != c.nextConn.LocalAddr().Network() {
+		return 0, errWriteAddressMismatch
+	}
+	return c.writeTo(p, addr)
+}
+
+// writeTo writes p to addr, or to the remote address if addr is nil.
+func (c *Conn) writeTo(p []byte, addr net.Addr) (int, error) {
+	if err := c.checkWrite(); err != nil {
+		return 0, err
+	}
+
+	if err := c.writePacketsTo(c.writeDeadline, []*packet{c.applicationDataPacket(p)}, addr); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// checkWrite returns the error a write of application data fails with
+// before anything is sent: the connection is closed, the write deadline has
+// passed, the handshake has not completed or CloseWrite was called.
+func (c *Conn) checkWrite() error {
 	if c.isConnectionClosed() {
-		return 0, ErrConnClosed
+		return ErrConnClosed
 	}
 
 	select {
 	case <-c.writeDeadline.Done():
-		return 0, errDeadlineExceeded
+		return errDeadlineExceeded
 	default:
 	}
 
 	if !c.isHandshakeCompletedSuccessfully() {
-		return 0, errHandshakeInProgress
+		return errHandshakeInProgress
 	}
 
-	return len(p), c.writePackets(c.writeDeadline, []*packet{
-		{
-			record: &recordlayer.RecordLayer{
-				Header: recordlayer.Header{
-					Epoch:   c.state.getLocalEpoch(),
-					Version: protocol.Version1_2,
-				},
-				Content: &protocol.ApplicationData{
-					Data: p,
-				},
+	if c.isWriteClosed() {
+		return errWriteClosed
+	}
+	return nil
+}
+
+// applicationDataPacket wraps p in an encrypted application data record of
+// the current epoch.
+func (c *Conn) applicationDataPacket(p []byte) *packet {
+	return &packet{
+		record: &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Epoch:   c.state.getLocalEpoch(),
+				Version: protocol.Version1_2,
+			},
+			Content: &protocol.ApplicationData{
+				Data: p,
 			},
-			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
-			shouldEncrypt: true,
 		},
-	})
+		shouldWrapCID: len(c.state.remoteConnectionID) > 0,
+		shouldEncrypt: true,
+	}
+}
+
+// WriteMultiple writes each payload as its own application data record, like
+// calling Write for each of them, but packs as many records into a datagram
+// as the MTU allows. This cuts the per-datagram overhead of protocols that
+// send many small messages.
+//
+// On success it returns the total length of the payloads. If an error
+// occurs it returns 0: the datagrams are written in order, so a leading
+// subset of the payloads may already have been sent, but which ones is not
+// reported. The peer receives one Read per payload either way.
+func (c *Conn) WriteMultiple(payloads [][]byte) (int, error) {
+	if err := c.checkWrite(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	pkts := make([]*packet, 0, len(payloads))
+	for _, p := range payloads {
+		n += len(p)
+		pkts = append(pkts, c.applicationDataPacket(p))
+	}
+	if err := c.writePackets(c.writeDeadline, pkts); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Flush returns once the records of writes that started before it have been
+// handed to the underlying connection. Write and WriteMultiple send their
+// records before returning, so nothing is buffered and Flush only waits for
+// writes in progress on other goroutines. It always returns nil, also on a
+// closed connection, so it is safe to call whenever records must be on the
+// wire.
+func (c *Conn) Flush() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return nil
+}
+
+// Ping sends a HeartbeatRequest to the peer and waits for the matching
+// HeartbeatResponse. The request is retransmitted until a response arrives
+// or ctx is done. Heartbeats must have been enabled with
+// Config.EnableHeartbeat and the peer must have agreed to receive them.
+// https://datatracker.ietf.org/doc/html/rfc6520
+func (c *Conn) Ping(ctx context.Context) error {
+	if c.isConnectionClosed() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	if c.state.remoteHeartbeatMode != extension.HeartbeatModePeerAllowedToSend {
+		return errHeartbeatNotAllowed
+	}
+	if c.isWriteClosed() {
+		return errWriteClosed
+	}
+
+	select {
+	case c.pingSem <- struct{}{}:
+		defer func() { <-c.pingSem }()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.closed.Done():
+		return ErrConnClosed
+	}
+
+	payload := make([]byte, heartbeatPayloadLength)
+	if _, err := io.ReadFull(c.rand, payload); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+
+	c.pingLock.Lock()
+	c.pingPayload, c.pingDone = payload, done
+	c.pingLock.Unlock()
+	defer func() {
+		c.pingLock.Lock()
+		c.pingPayload, c.pingDone = nil, nil
+		c.pingLock.Unlock()
+	}()
+
+	retransmitTicker := time.NewTicker(initialTickerInterval)
+	defer retransmitTicker.Stop()
+
+	for {
+		if err := c.writeHeartbeat(ctx, heartbeat.Request, payload); err != nil {
+			return err
+		}
+
+		select {
+		case <-done:
+			return nil
+		case <-retransmitTicker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.closed.Done():
+			return ErrConnClosed
+		}
+	}
+}
+
+// CloseWrite shuts down the writing side of the connection by sending
+// close_notify to the peer, like net.TCPConn.CloseWrite. Write returns an
+// error afterwards, while Read keeps returning the peer's records until its
+// close_notify arrives, after which Read returns io.EOF. Close must still be
+// called to release the connection.
+func (c *Conn) CloseWrite() error {
+	if c.isConnectionClosed() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+
+	c.closeLock.Lock()
+	writeClosed := c.writeClosed
+	c.writeClosed = true
+	c.closeLock.Unlock()
+
+	if writeClosed {
+		return nil
+	}
+	return c.notify(c.writeDeadline, alert.Warning, alert.CloseNotify, nil)
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.CloseWithContext(context.Background())
+}
+
+// CloseWithContext closes the connection like Close, but bounds the time spent
+// sending close_notify to the peer by ctx. If ctx is done before close_notify
+// could be written, the local state is torn down anyway so that closing a
+// connection to a dead peer or over a wedged socket does not hang.
+func (c *Conn) CloseWithContext(ctx context.Context) error {
+	err := c.close(ctx, true)
+	c.handshakeLoopsFinished.Wait()
+	return err
+}
+
+// ConnectionState returns basic DTLS details about the connection.
+// Note that this replaced the `Export` function of v1.
+func (c *Conn) ConnectionState() State {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return *c.state.clone()
+}
+
+// ClientRandom returns a copy of the random value of the ClientHello. It is
+// not secret, and helps correlate a connection with a packet capture.
+func (c *Conn) ClientRandom() []byte {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.state.isClient {
+		return marshalRandom(c.state.localRandom)
+	}
+	return marshalRandom(c.state.remoteRandom)
+}
+
+// ServerRandom returns a copy of the random value of the ServerHello.
+func (c *Conn) ServerRandom() []byte {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if c.state.isClient {
+		return marshalRandom(c.state.remoteRandom)
+	}
+	return marshalRandom(c.state.localRandom)
+}
+
+func marshalRandom(r handshake.Random) []byte {
+	raw := r.MarshalFixed()
+	return raw[:]
+}
+
+// HandshakeComplete reports, without blocking, whether the handshake has
+// completed successfully. Client, Server and their variants only return a
+// Conn once it has, so it is false only while handshaking, or after a failed
+// handshake.
+func (c *Conn) HandshakeComplete() bool {
+	return c.isHandshakeCompletedSuccessfully()
+}
+
+// HandshakeContext returns a context that is done once the handshake has
+// completed or failed, which HandshakeComplete then tells apart. It allows
+// waiting for the handshake in a select, such as in an event loop.
+func (c *Conn) HandshakeContext() context.Context {
+	return c.handshakeCtx
+}
+
+// HandshakeTranscriptSize returns the total length in bytes of the handshake
+// messages sent and received so far, including their headers. Retransmitted
+// messages are counted once. An unusually large transcript can indicate an
+// adversarial peer.
+func (c *Conn) HandshakeTranscriptSize() int {
+	return c.handshakeCache.size()
+}
+
+// AlertRecord describes an alert sent on a connection.
+type AlertRecord struct {
+	Level       alert.Level
+	Description alert.Description
+
+	// Reason is the error that caused the alert to be sent, if any. It is
+	// nil for a close_notify sent by Close or CloseWrite.
+	Reason error
+}
+
+// SentAlerts returns the alerts sent on the connection so far, oldest
+// first, to help explain why a connection was closed.
+func (c *Conn) SentAlerts() []AlertRecord {
+	c.sentAlertsLock.Lock()
+	defer c.sentAlertsLock.Unlock()
+	return append([]AlertRecord{}, c.sentAlerts...)
+}
+
+// EpochTransition describes a change of the epoch of the records received
+// from the peer.
+type EpochTransition struct {
+	From, To uint16
+	Time     time.Time
 }
 
-// Close closes the connection.
-func (c *Conn) Close() error {
-	err := c.close(true) //nolint:contextcheck
-	c.handshakeLoopsFinished.Wait()
-	return err
+// EpochHistory returns the most recent changes of the epoch of the records
+// received from the peer, oldest first. This helps diagnose peers that
+// change epochs unexpectedly. Only the last 16 transitions are kept.
+func (c *Conn) EpochHistory() []EpochTransition {
+	c.epochHistoryLock.Lock()
+	defer c.epochHistoryLock.Unlock()
+	return append([]EpochTransition{}, c.epochHistory...)
 }
 
-// ConnectionState returns basic DTLS details about the connection.
-// Note that this replaced the `Export` function of v1.
-func (c *Conn) ConnectionState() State {
+// TranscriptHash returns the hash of the handshake messages exchanged so far,
+// computed with the hash function of the negotiated cipher suite, for use as
+// a channel binding or when debugging a handshake. It returns an error until
+// a cipher suite has been negotiated.
+func (c *Conn) TranscriptHash() ([]byte, error) {
 	c.lock.RLock()
-	defer c.lock.RUnlock()
-	return *c.state.clone()
+	cipherSuite := c.state.cipherSuite
+	c.lock.RUnlock()
+	if cipherSuite == nil {
+		return nil, errCipherSuiteNotNegotiated
+	}
+
+	hash := cipherSuite.HashFunc()()
+	if _, err := hash.Write(c.handshakeCache.transcript(0)); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
+
+// MTU returns the length at which handshake messages are currently
+// fragmented, which is lowered by Config.RetransmitMTU and path MTU
+// discovery.
+func (c *Conn) MTU() int {
+	return int(atomic.LoadInt32(&c.maximumTransmissionUnit))
+}
+
+// SetMTU changes the length at which handshake messages are fragmented. It
+// applies to every flight sent from then on, including retransmissions of a
+// flight already in progress, so a flight that was dropped for exceeding the
+// path MTU is resent in smaller datagrams rather than repeated as is.
+// Values less than or equal to zero are ignored.
+func (c *Conn) SetMTU(mtu int) {
+	if mtu > 0 {
+		atomic.StoreInt32(&c.maximumTransmissionUnit, int32(mtu))
+	}
+}
+
+// pathMTUPlateaus are common path MTUs, less the IP and UDP headers, tried
+// in turn by path MTU discovery.
+var pathMTUPlateaus = []int{1452, 1232, 1000, 800, 548, 256} //nolint:gochecknoglobals
+
+// nextPathMTU returns the next MTU below mtu to try, which is never less than
+// minMTU.
+func nextPathMTU(mtu, minMTU int) int {
+	for _, plateau := range pathMTUPlateaus {
+		if plateau < mtu {
+			if plateau < minMTU {
+				return minMTU
+			}
+			return plateau
+		}
+	}
+	return minMTU
+}
+
+// lowerMTU reduces the MTU to mtu if it is currently larger.
+func (c *Conn) lowerMTU(mtu int) {
+	for {
+		current := atomic.LoadInt32(&c.maximumTransmissionUnit)
+		if mtu <= 0 || int32(mtu) >= current {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.maximumTransmissionUnit, current, int32(mtu)) {
+			return
+		}
+	}
 }
 
 // SelectedSRTPProtectionProfile returns the selected SRTPProtectionProfile
@@ -427,12 +1116,37 @@ func (c *Conn) SelectedSRTPProtectionProfile() (SRTPProtectionProfile, bool) {
 }
 
 func (c *Conn) writePackets(ctx context.Context, pkts []*packet) error {
+	return c.writePacketsTo(ctx, pkts, nil)
+}
+
+// writePacketsTo writes pkts to addr, or to the remote address if addr is
+// nil.
+func (c *Conn) writePacketsTo(ctx context.Context, pkts []*packet, addr net.Addr) error {
+	select {
+	case <-c.transportFailed:
+		return c.transportErr
+	default:
+	}
+
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	if addr == nil {
+		addr = c.rAddr
+	}
+
 	var rawPackets [][]byte
 
 	for _, p := range pkts {
+		// Protected records carry the connection ID of the peer, including
+		// the handshake messages of a rekey. As on receipt, a
+		// ChangeCipherSpec is only protected inside a connection ID record.
+		if p.shouldEncrypt {
+			p.shouldWrapCID = len(c.state.remoteConnectionID) > 0
+			if _, ok := p.record.Content.(*protocol.ChangeCipherSpec); ok && !p.shouldWrapCID {
+				p.shouldEncrypt = false
+			}
+		}
 		if h, ok := p.record.Content.(*handshake.Handshake); ok {
 			handshakeRaw, err := p.record.Marshal()
 			if err != nil {
@@ -451,10 +1165,20 @@ func (c *Conn) writePackets(ctx context.Context, pkts []*packet) error {
 			}
 			rawPackets = append(rawPackets, rawHandshakePackets...)
 		} else {
-			rawPacket, err := c.processPacket(p)
+			bufptr, ok := poolWriteBuffer.Get().(*[]byte)
+			if !ok {
+				return errFailedToAccessPoolWriteBuffer
+			}
+			defer poolWriteBuffer.Put(bufptr)
+
+			rawPacket, err := c.appendPacket((*bufptr)[:0], p)
 			if err != nil {
 				return err
 			}
+			if len(rawPacket) > inboundBufferSize {
+				return fmt.Errorf("%w: %d byte record exceeds the %d byte limit",
+					ErrRecordTooLarge, len(rawPacket), inboundBufferSize)
+			}
 			rawPackets = append(rawPackets, rawPacket)
 		}
 	}
@@ -463,15 +1187,85 @@ func (c *Conn) writePackets(ctx context.Context, pkts []*packet) error {
 	}
 	compactedRawPackets := c.compactRawPackets(rawPackets)
 
-	for _, compactedRawPackets := range compactedRawPackets {
-		if _, err := c.nextConn.WriteToContext(ctx, compactedRawPackets, c.rAddr); err != nil {
-			return netError(err)
+	if c.batchConn != nil && len(compactedRawPackets) > 1 {
+		if err := c.writeBatch(ctx, compactedRawPackets, addr); err != nil {
+			return c.latchTransportError(err)
+		}
+	} else {
+		for _, compactedRawPackets := range compactedRawPackets {
+			if _, err := c.nextConn.WriteToContext(ctx, compactedRawPackets, addr); err != nil {
+				return c.latchTransportError(c.writeError(netWriteError(err, len(compactedRawPackets))))
+			}
 		}
 	}
 
+	atomic.AddUint64(&c.recordsSent, uint64(len(rawPackets)))
+	for _, compactedRawPackets := range compactedRawPackets {
+		atomic.AddUint64(&c.bytesSent, uint64(len(compactedRawPackets)))
+	}
+	if c.isHandshakeCompletedSuccessfully() {
+		c.resetKeepAliveTimer()
+	}
 	return nil
 }
 
+// latchTransportError makes Read and Write fail from now on if err, from a
+// write to the underlying connection, is fatal, and returns err. Writes fail
+// anyway once the connection has been closed, which is not latched so that
+// Read still returns what is pending.
+func (c *Conn) latchTransportError(err error) error {
+	var e *FatalError
+	if errors.As(err, &e) && !c.isConnectionClosed() {
+		c.transportFailedOnce.Do(func() {
+			c.log.Debugf("%s: underlying connection failed: %s", srvCliStr(c.state.isClient), err)
+			c.transportErr = fmt.Errorf("%w: %v", errTransportFailed, err) //nolint:errorlint
+			close(c.transportFailed)
+		})
+	}
+	return err
+}
+
+// writeError lowers the MTU if err reports a datagram exceeding the path MTU,
+// so that later handshake flights are sent in smaller fragments, and returns
+// err.
+func (c *Conn) writeError(err error) error {
+	var e *MessageTooLongError
+	if errors.As(err, &e) && e.Size > 0 {
+		// Datagrams carry record headers in addition to the fragments that
+		// the MTU limits, so a datagram may exceed the MTU slightly.
+		mtu := c.MTU()
+		if e.Size < mtu {
+			mtu = e.Size
+		}
+		c.lowerMTU(nextPathMTU(mtu, 0))
+	}
+	return err
+}
+
+func (c *Conn) writeHeartbeat(ctx context.Context, typ heartbeat.MessageType, payload []byte) error {
+	padding := make([]byte, heartbeat.MinPaddingLength)
+	if _, err := io.ReadFull(c.rand, padding); err != nil {
+		return err
+	}
+	return c.writePackets(ctx, []*packet{
+		{
+			record: &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Epoch:   c.state.getLocalEpoch(),
+					Version: protocol.Version1_2,
+				},
+				Content: &heartbeat.Heartbeat{
+					Type:    typ,
+					Payload: payload,
+					Padding: padding,
+				},
+			},
+			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
+			shouldEncrypt: true,
+		},
+	})
+}
+
 func (c *Conn) compactRawPackets(rawPackets [][]byte) [][]byte {
 	// avoid a useless copy in the common case
 	if len(rawPackets) == 1 {
@@ -479,12 +1273,13 @@ func (c *Conn) compactRawPackets(rawPackets [][]byte) [][]byte {
 	}
 
 	combinedRawPackets := make([][]byte, 0)
-	currentCombinedRawPacket := make([]byte, 0)
+	mtu := c.MTU()
+	currentCombinedRawPacket := make([]byte, 0, mtu)
 
 	for _, rawPacket := range rawPackets {
-		if len(currentCombinedRawPacket) > 0 && len(currentCombinedRawPacket)+len(rawPacket) >= c.maximumTransmissionUnit {
+		if len(currentCombinedRawPacket) > 0 && len(currentCombinedRawPacket)+len(rawPacket) >= mtu {
 			combinedRawPackets = append(combinedRawPackets, currentCombinedRawPacket)
-			currentCombinedRawPacket = []byte{}
+			currentCombinedRawPacket = make([]byte, 0, mtu)
 		}
 		currentCombinedRawPacket = append(currentCombinedRawPacket, rawPacket...)
 	}
@@ -495,16 +1290,16 @@ func (c *Conn) compactRawPackets(rawPackets [][]byte) [][]byte {
 }
 
 func (c *Conn) processPacket(p *packet) ([]byte, error) {
+	return c.appendPacket(nil, p)
+}
+
+// appendPacket encodes and, if requested, encrypts p into b, which is reused
+// for the result if its capacity allows.
+func (c *Conn) appendPacket(b []byte, p *packet) ([]byte, error) {
 	epoch := p.record.Header.Epoch
-	for len(c.state.localSequenceNumber) <= int(epoch) {
-		c.state.localSequenceNumber = append(c.state.localSequenceNumber, uint64(0))
-	}
-	seq := atomic.AddUint64(&c.state.localSequenceNumber[epoch], 1) - 1
-	if seq > recordlayer.MaxSequenceNumber {
-		// RFC 6347 Section 4.1.0
-		// The implementation must either abandon an association or rehandshake
-		// prior to allowing the sequence number to wrap.
-		return nil, errSequenceNumberOverflow
+	seq, err := c.nextSequenceNumber(epoch)
+	if err != nil {
+		return nil, err
 	}
 	p.record.Header.SequenceNumber = seq
 
@@ -534,7 +1329,7 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 			ConnectionID:   c.state.remoteConnectionID,
 			SequenceNumber: p.record.Header.SequenceNumber,
 		}
-		rawPacket, err = cidHeader.Marshal()
+		rawPacket, err = cidHeader.AppendMarshal(b)
 		if err != nil {
 			return nil, err
 		}
@@ -542,7 +1337,7 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 		rawPacket = append(rawPacket, rawInner...)
 	} else {
 		var err error
-		rawPacket, err = p.record.Marshal()
+		rawPacket, err = p.record.AppendMarshal(b)
 		if err != nil {
 			return nil, err
 		}
@@ -550,7 +1345,7 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 
 	if p.shouldEncrypt {
 		var err error
-		rawPacket, err = c.state.cipherSuite.Encrypt(p.record, rawPacket)
+		rawPacket, err = c.cipherSuiteLocked(p.record.Header.Epoch).Encrypt(p.record, rawPacket)
 		if err != nil {
 			return nil, err
 		}
@@ -559,6 +1354,28 @@ func (c *Conn) processPacket(p *packet) ([]byte, error) {
 	return rawPacket, nil
 }
 
+// nextSequenceNumber takes the next local sequence number of epoch, calling
+// onSequenceNumberWarning if it reaches sequenceNumberWarnThreshold.
+func (c *Conn) nextSequenceNumber(epoch uint16) (uint64, error) {
+	sequenceNumber := c.state.localSequenceNumberOf(epoch)
+	if sequenceNumber == nil {
+		return 0, errEpochNotRetained
+	}
+	seq := atomic.AddUint64(sequenceNumber, 1) - 1
+	if seq > recordlayer.MaxSequenceNumber {
+		// RFC 6347 Section 4.1.0
+		// The implementation must either abandon an association or rehandshake
+		// prior to allowing the sequence number to wrap.
+		return 0, errSequenceNumberOverflow
+	}
+	// Sequence numbers are taken atomically, so only one record gets the
+	// threshold and the callback runs once per epoch.
+	if seq == c.sequenceNumberWarnThreshold && seq > 0 && c.onSequenceNumberWarning != nil {
+		c.onSequenceNumberWarning(epoch, seq)
+	}
+	return seq, nil
+}
+
 func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]byte, error) {
 	rawPackets := make([][]byte, 0)
 
@@ -567,14 +1384,15 @@ func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]by
 		return nil, err
 	}
 	epoch := p.record.Header.Epoch
-	for len(c.state.localSequenceNumber) <= int(epoch) {
-		c.state.localSequenceNumber = append(c.state.localSequenceNumber, uint64(0))
+
+	if c.recordLayerVersionOverride != (protocol.Version{}) {
+		p.record.Header.Version = c.recordLayerVersionOverride
 	}
 
 	for _, handshakeFragment := range handshakeFragments {
-		seq := atomic.AddUint64(&c.state.localSequenceNumber[epoch], 1) - 1
-		if seq > recordlayer.MaxSequenceNumber {
-			return nil, errSequenceNumberOverflow
+		seq, err := c.nextSequenceNumber(epoch)
+		if err != nil {
+			return nil, err
 		}
 
 		var rawPacket []byte
@@ -594,7 +1412,7 @@ func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]by
 				Epoch:          p.record.Header.Epoch,
 				ContentLen:     uint16(len(rawInner)),
 				ConnectionID:   c.state.remoteConnectionID,
-				SequenceNumber: p.record.Header.SequenceNumber,
+				SequenceNumber: seq,
 			}
 			rawPacket, err = cidHeader.Marshal()
 			if err != nil {
@@ -622,7 +1440,7 @@ func (c *Conn) processHandshakePacket(p *packet, h *handshake.Handshake) ([][]by
 
 		if p.shouldEncrypt {
 			var err error
-			rawPacket, err = c.state.cipherSuite.Encrypt(p.record, rawPacket)
+			rawPacket, err = c.cipherSuiteLocked(p.record.Header.Epoch).Encrypt(p.record, rawPacket)
 			if err != nil {
 				return nil, err
 			}
@@ -642,7 +1460,7 @@ func (c *Conn) fragmentHandshake(h *handshake.Handshake) ([][]byte, error) {
 
 	fragmentedHandshakes := make([][]byte, 0)
 
-	contentFragments := splitBytes(content, c.maximumTransmissionUnit)
+	contentFragments := splitBytes(content, c.MTU())
 	if len(contentFragments) == 0 {
 		contentFragments = [][]byte{
 			{},
@@ -675,6 +1493,9 @@ func (c *Conn) fragmentHandshake(h *handshake.Handshake) ([][]byte, error) {
 	return fragmentedHandshakes, nil
 }
 
+// poolReadBuffer holds the buffers datagrams are read into. A buffer is
+// returned to the pool as soon as readAndBuffer has handled its records, so
+// nothing derived from it may be retained beyond that point without a copy.
 var poolReadBuffer = sync.Pool{ //nolint:gochecknoglobals
 	New: func() interface{} {
 		b := make([]byte, inboundBufferSize)
@@ -682,6 +1503,15 @@ var poolReadBuffer = sync.Pool{ //nolint:gochecknoglobals
 	},
 }
 
+// poolWriteBuffer holds scratch buffers in which outgoing records are
+// encoded and encrypted, so that Write does not allocate per record.
+var poolWriteBuffer = sync.Pool{ //nolint:gochecknoglobals
+	New: func() interface{} {
+		b := make([]byte, 0, outboundBufferSize)
+		return &b
+	},
+}
+
 func (c *Conn) readAndBuffer(ctx context.Context) error {
 	bufptr, ok := poolReadBuffer.Get().(*[]byte)
 	if !ok {
@@ -695,31 +1525,46 @@ func (c *Conn) readAndBuffer(ctx context.Context) error {
 		return netError(err)
 	}
 
-	pkts, err := recordlayer.ContentAwareUnpackDatagram(b[:i], len(c.state.localConnectionID))
-	if err != nil {
-		return err
+	atomic.AddUint64(&c.bytesReceived, uint64(i))
+
+	datagrams := [][]byte{b[:i]}
+	if c.inboundHook != nil {
+		datagrams = c.inboundHook(rAddr, b[:i])
 	}
 
 	var hasHandshake bool
-	for _, p := range pkts {
-		hs, alert, err := c.handleIncomingPacket(ctx, p, rAddr, true)
-		if alert != nil {
-			if alertErr := c.notify(ctx, alert.Level, alert.Description); alertErr != nil {
-				if err == nil {
-					err = alertErr
-				}
-			}
-		}
-
-		var e *alertError
-		if errors.As(err, &e) && e.IsFatalOrCloseNotify() {
-			return e
-		}
+	for _, datagram := range datagrams {
+		pkts, err := recordlayer.ContentAwareUnpackDatagram(datagram, len(c.state.localConnectionID))
 		if err != nil {
 			return err
 		}
-		if hs {
-			hasHandshake = true
+		atomic.AddUint64(&c.recordsReceived, uint64(len(pkts)))
+		if len(pkts) > c.maxRecordsPerDatagram {
+			atomic.AddUint64(&c.droppedTooManyRecords, 1)
+			c.log.Debugf("discarded datagram with %d records", len(pkts))
+			continue
+		}
+
+		for _, p := range pkts {
+			hs, alert, err := c.handleIncomingPacket(ctx, p, rAddr, true)
+			if alert != nil {
+				if alertErr := c.notify(ctx, alert.Level, alert.Description, err); alertErr != nil {
+					if err == nil {
+						err = alertErr
+					}
+				}
+			}
+
+			var e *alertError
+			if errors.As(err, &e) && e.IsFatalOrCloseNotify() {
+				return e
+			}
+			if err != nil {
+				return err
+			}
+			if hs {
+				hasHandshake = true
+			}
 		}
 	}
 	if hasHandshake {
@@ -729,7 +1574,7 @@ func (c *Conn) readAndBuffer(ctx context.Context) error {
 			// If the other party may retransmit the flight,
 			// we should respond even if it not a new message.
 			<-done
-		case <-c.fsm.Done():
+		case <-c.handshakerDone:
 		}
 	}
 	return nil
@@ -739,10 +1584,16 @@ func (c *Conn) handleQueuedPackets(ctx context.Context) error {
 	pkts := c.encryptedPackets
 	c.encryptedPackets = nil
 
+	// Records may have been queued out of order. Handle them by epoch, so that
+	// a ChangeCipherSpec is applied before the records of the epoch it starts.
+	sort.SliceStable(pkts, func(i, j int) bool {
+		return recordEpoch(pkts[i].data) < recordEpoch(pkts[j].data)
+	})
+
 	for _, p := range pkts {
 		_, alert, err := c.handleIncomingPacket(ctx, p.data, p.rAddr, false) // don't re-enqueue
 		if alert != nil {
-			if alertErr := c.notify(ctx, alert.Level, alert.Description); alertErr != nil {
+			if alertErr := c.notify(ctx, alert.Level, alert.Description, err); alertErr != nil {
 				if err == nil {
 					err = alertErr
 				}
@@ -759,15 +1610,69 @@ func (c *Conn) handleQueuedPackets(ctx context.Context) error {
 	return nil
 }
 
+// recordEpoch returns the epoch of the record in buf, which has already been
+// unpacked from its datagram.
+func recordEpoch(buf []byte) uint16 {
+	if len(buf) < recordlayer.FixedHeaderSize {
+		return 0
+	}
+	return binary.BigEndian.Uint16(buf[3:])
+}
+
 func (c *Conn) enqueueEncryptedPackets(packet addrPkt) bool {
-	if len(c.encryptedPackets) < maxAppDataPacketQueueSize {
+	if len(c.encryptedPackets) < c.maxEarlyPacketQueue {
+		// The packet is a slice into a pooled read buffer, which is reused by
+		// the next read before the queue is handled.
+		packet.data = append([]byte{}, packet.data...)
 		c.encryptedPackets = append(c.encryptedPackets, packet)
+		if n := uint64(len(c.encryptedPackets)); n > atomic.LoadUint64(&c.maxQueuedRecords) {
+			atomic.StoreUint64(&c.maxQueuedRecords, n)
+		}
 		return true
 	}
+	atomic.AddUint64(&c.droppedQueueFull, 1)
+	c.log.Debug("packet queue is full, discarding packet")
 	return false
 }
 
+// replayDetector returns the replay detector of epoch, creating it if
+// needed, or nil if epoch is too far behind remoteEpoch to be retained. The
+// detectors of expired epochs are released so that a long-lived connection
+// does not accumulate state for every epoch it has used.
+func (c *Conn) replayDetector(epoch, remoteEpoch uint16) *countingReplayDetector {
+	if epoch > remoteEpoch || remoteEpoch-epoch >= maxRetainedEpochs {
+		return nil
+	}
+	slot := &c.state.replayDetector[epoch%maxRetainedEpochs]
+
+	c.lock.RLock()
+	if d := *slot; d != nil && d.epoch == epoch {
+		c.lock.RUnlock()
+		return d
+	}
+	c.lock.RUnlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	switch d := *slot; {
+	case d == nil || d.epoch < epoch:
+		// The slot is free or holds the detector of an expired epoch.
+		*slot = newCountingReplayDetector(epoch, c.replayProtectionWindow, recordlayer.MaxSequenceNumber)
+	case d.epoch > epoch:
+		return nil
+	}
+	return *slot
+}
+
 func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.Addr, enqueue bool) (bool, *alert.Alert, error) { //nolint:gocognit
+	// Records arriving after Close, such as retransmissions of the peer,
+	// are dropped regardless of whether the read loop is still running.
+	if c.isConnectionClosed() {
+		atomic.AddUint64(&c.droppedAfterClose, 1)
+		c.log.Debug("discarded packet received after close")
+		return false, nil, nil
+	}
+
 	h := &recordlayer.Header{}
 	// Set connection ID size so that records of content type tls12_cid will
 	// be parsed correctly.
@@ -798,12 +1703,14 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	}
 
 	// Anti-replay protection
-	for len(c.state.replayDetector) <= int(h.Epoch) {
-		c.state.replayDetector = append(c.state.replayDetector,
-			replaydetector.New(c.replayProtectionWindow, recordlayer.MaxSequenceNumber),
+	replayDetector := c.replayDetector(h.Epoch, remoteEpoch)
+	if replayDetector == nil {
+		c.log.Debugf("discarded packet of expired epoch (epoch: %d, seq: %d)",
+			h.Epoch, h.SequenceNumber,
 		)
+		return false, nil, nil
 	}
-	markPacketAsValid, ok := c.state.replayDetector[int(h.Epoch)].Check(h.SequenceNumber)
+	markPacketAsValid, ok := replayDetector.Check(h.SequenceNumber)
 	if !ok {
 		c.log.Debugf("discarded duplicated packet (epoch: %d, seq: %d)",
 			h.Epoch, h.SequenceNumber,
@@ -814,10 +1721,15 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	// originalCID indicates whether the original record had content type
 	// Connection ID.
 	originalCID := false
+	// record is what is queued if the record has to wait for the keys of the
+	// next epoch. Decryption is in place, so the ChangeCipherSpec of a rekey,
+	// which is protected inside a connection ID record, is copied first.
+	record := buf
 
 	// Decrypt
 	if h.Epoch != 0 {
-		if c.state.cipherSuite == nil || !c.state.cipherSuite.IsInitialized() {
+		cipherSuite := c.cipherSuite(h.Epoch)
+		if cipherSuite == nil || !cipherSuite.IsInitialized() {
 			if enqueue {
 				if ok := c.enqueueEncryptedPackets(addrPkt{rAddr, buf}); ok {
 					c.log.Debug("handshake not finished, queuing packet")
@@ -833,14 +1745,26 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 			return false, nil, nil
 		}
 
+		// If connection ID does not match discard the packet. The header has
+		// already been parsed, so check it before spending any work on
+		// decryption.
+		if !bytes.Equal(c.state.localConnectionID, h.ConnectionID) {
+			c.log.Debug("unexpected connection ID")
+			return false, nil, nil
+		}
+
 		var err error
 		var hdr recordlayer.Header
 		if h.ContentType == protocol.ContentTypeConnectionID {
 			hdr.ConnectionID = make([]byte, len(c.state.localConnectionID))
+			if next := c.cipherSuite(h.Epoch + 1); next != cipherSuite && (next == nil || !next.IsInitialized()) {
+				record = append([]byte{}, buf...)
+			}
 		}
-		buf, err = c.state.cipherSuite.Decrypt(hdr, buf)
+		buf, err = cipherSuite.Decrypt(hdr, buf)
 		if err != nil {
 			c.log.Debugf("%s: decrypt failed: %s", srvCliStr(c.state.isClient), err)
+			atomic.AddUint64(&c.decryptFailures, 1)
 			return false, nil, nil
 		}
 		// If this is a connection ID record, make it look like a normal record for
@@ -866,12 +1790,6 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 			}
 			buf = append(buf, ip.Content...)
 		}
-
-		// If connection ID does not match discard the packet.
-		if !bytes.Equal(c.state.localConnectionID, h.ConnectionID) {
-			c.log.Debug("unexpected connection ID")
-			return false, nil, nil
-		}
 	}
 
 	isHandshake, err := c.fragmentBuffer.push(append([]byte{}, buf...))
@@ -882,6 +1800,7 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 		return false, nil, nil
 	} else if isHandshake {
 		markPacketAsValid()
+		var a *alert.Alert
 		for out, epoch := c.fragmentBuffer.pop(); out != nil; out, epoch = c.fragmentBuffer.pop() {
 			header := &handshake.Header{}
 			if err := header.Unmarshal(out); err != nil {
@@ -889,13 +1808,24 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 				continue
 			}
 			c.handshakeCache.push(out, epoch, header.MessageSequence, header.Type, !c.state.isClient)
+			if header.Type == handshake.TypeClientHello && epoch > 0 && !c.state.isClient {
+				if refusal := c.acceptRekey(epoch); refusal != nil {
+					a = refusal
+				}
+			}
 		}
 
-		return true, nil, nil
+		return true, a, nil
 	}
 
 	r := &recordlayer.RecordLayer{}
 	if err := r.Unmarshal(buf); err != nil {
+		if r.Header.ContentType == protocol.ContentTypeHeartbeat {
+			// Heartbeat messages with a payload_length that does not fit the
+			// record must be silently discarded [RFC6520 Section 4]
+			c.log.Debugf("discarded broken heartbeat: %s", err)
+			return false, nil, nil
+		}
 		return false, &alert.Alert{Level: alert.Fatal, Description: alert.DecodeError}, err
 	}
 
@@ -903,17 +1833,30 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	switch content := r.Content.(type) {
 	case *alert.Alert:
 		c.log.Tracef("%s: <- %s", srvCliStr(c.state.isClient), content.String())
+		if c.onAlert != nil {
+			c.onAlert(content.Level, content.Description, false)
+		}
+		if content.Level == alert.Warning && content.Description != alert.CloseNotify && c.maxWarningAlerts > 0 {
+			c.warningAlerts++
+			if c.warningAlerts > c.maxWarningAlerts {
+				c.log.Debugf("%s: closing after %d warning alerts", srvCliStr(c.state.isClient), c.warningAlerts)
+				return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errTooManyWarningAlerts
+			}
+		}
+		if content.Description == alert.NoRenegotiation && c.state.isClient {
+			c.refuseRekey()
+		}
 		var a *alert.Alert
-		if content.Description == alert.CloseNotify {
+		if content.Description == alert.CloseNotify && !c.isWriteClosed() {
 			// Respond with a close_notify [RFC5246 Section 7.2.1]
 			a = &alert.Alert{Level: alert.Warning, Description: alert.CloseNotify}
 		}
 		_ = markPacketAsValid()
 		return false, a, &alertError{content}
 	case *protocol.ChangeCipherSpec:
-		if c.state.cipherSuite == nil || !c.state.cipherSuite.IsInitialized() {
+		if cipherSuite := c.cipherSuite(h.Epoch + 1); cipherSuite == nil || !cipherSuite.IsInitialized() {
 			if enqueue {
-				if ok := c.enqueueEncryptedPackets(addrPkt{rAddr, buf}); ok {
+				if ok := c.enqueueEncryptedPackets(addrPkt{rAddr, record}); ok {
 					c.log.Debugf("CipherSuite not initialized, queuing packet")
 				}
 			}
@@ -923,21 +1866,67 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 		newRemoteEpoch := h.Epoch + 1
 		c.log.Tracef("%s: <- ChangeCipherSpec (epoch: %d)", srvCliStr(c.state.isClient), newRemoteEpoch)
 
-		if c.state.getRemoteEpoch()+1 == newRemoteEpoch {
+		switch remoteEpoch := c.state.getRemoteEpoch(); {
+		case remoteEpoch+1 == newRemoteEpoch:
 			c.setRemoteEpoch(newRemoteEpoch)
 			isLatestSeqNum = markPacketAsValid()
+			if enqueue && len(c.encryptedPackets) > 0 {
+				// Records of the new epoch that overtook the
+				// ChangeCipherSpec were queued; handle them now and let
+				// the handshake look at any it completes.
+				if err := c.handleQueuedPackets(ctx); err != nil {
+					return false, nil, err
+				}
+				return true, nil, nil
+			}
+		case newRemoteEpoch <= remoteEpoch:
+			c.log.Debugf("%s: discarded retransmitted ChangeCipherSpec (epoch: %d)", srvCliStr(c.state.isClient), h.Epoch)
+		default:
+			c.log.Debugf("%s: discarded ChangeCipherSpec skipping from epoch %d to %d", srvCliStr(c.state.isClient), remoteEpoch, newRemoteEpoch)
 		}
 	case *protocol.ApplicationData:
 		if h.Epoch == 0 {
 			return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errApplicationDataEpochZero
 		}
+		if c.strictHandshakeOrder && !c.isPeerFinishedReceived() {
+			return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errApplicationDataBeforeFinished
+		}
 
 		isLatestSeqNum = markPacketAsValid()
+		c.resetIdleTimer()
 
-		select {
-		case c.decrypted <- content.Data:
-		case <-c.closed.Done():
-		case <-ctx.Done():
+		// content.Data is a copy made by Unmarshal, so it remains valid
+		// after the read buffer is returned to the pool.
+		c.deliver(ctx, addrPkt{rAddr, content.Data})
+
+	case *heartbeat.Heartbeat:
+		if c.state.remoteHeartbeatMode == 0 {
+			return false, &alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}, errHeartbeatNotNegotiated
+		}
+		if h.Epoch == 0 {
+			// Heartbeats must not be sent during handshakes [RFC6520 Section 3]
+			c.log.Debug("discarded heartbeat with epoch of 0")
+			return false, nil, nil
+		}
+
+		c.log.Tracef("%s: <- %s", srvCliStr(c.state.isClient), content.String())
+		isLatestSeqNum = markPacketAsValid()
+
+		switch content.Type {
+		case heartbeat.Request:
+			if c.isWriteClosed() {
+				break
+			}
+			if err := c.writeHeartbeat(ctx, heartbeat.Response, content.Payload); err != nil {
+				c.log.Debugf("%s: failed to send HeartbeatResponse: %s", srvCliStr(c.state.isClient), err)
+			}
+		case heartbeat.Response:
+			c.pingLock.Lock()
+			if c.pingDone != nil && bytes.Equal(c.pingPayload, content.Payload) {
+				close(c.pingDone)
+				c.pingPayload, c.pingDone = nil, nil
+			}
+			c.pingLock.Unlock()
 		}
 
 	default:
@@ -947,7 +1936,7 @@ func (c *Conn) handleIncomingPacket(ctx context.Context, buf []byte, rAddr net.A
 	// Any valid connection ID record is a candidate for updating the remote
 	// address if it is the latest record received.
 	// https://datatracker.ietf.org/doc/html/rfc9146#peer-address-update
-	if originalCID && isLatestSeqNum {
+	if originalCID && isLatestSeqNum && !c.disableConnectionIDAddressUpdate {
 		if rAddr != c.RemoteAddr() {
 			c.lock.Lock()
 			c.rAddr = rAddr
@@ -962,7 +1951,7 @@ func (c *Conn) recvHandshake() <-chan chan struct{} {
 	return c.handshakeRecv
 }
 
-func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Description) error {
+func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Description, reason error) error {
 	if level == alert.Fatal && len(c.state.SessionID) > 0 {
 		// According to the RFC, we need to delete the stored session.
 		// https://datatracker.ietf.org/doc/html/rfc5246#section-7.2
@@ -973,7 +1962,7 @@ func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Descrip
 			}
 		}
 	}
-	return c.writePackets(ctx, []*packet{
+	if err := c.writePackets(ctx, []*packet{
 		{
 			record: &recordlayer.RecordLayer{
 				Header: recordlayer.Header{
@@ -988,13 +1977,90 @@ func (c *Conn) notify(ctx context.Context, level alert.Level, desc alert.Descrip
 			shouldWrapCID: len(c.state.remoteConnectionID) > 0,
 			shouldEncrypt: c.isHandshakeCompletedSuccessfully(),
 		},
-	})
+	}); err != nil {
+		return err
+	}
+
+	c.sentAlertsLock.Lock()
+	c.sentAlerts = append(c.sentAlerts, AlertRecord{Level: level, Description: desc, Reason: reason})
+	c.sentAlertsLock.Unlock()
+
+	if c.onAlert != nil {
+		c.onAlert(level, desc, true)
+	}
+	return nil
+}
+
+// resetIdleTimer restarts the IdleTimeout window, if one is set.
+func (c *Conn) resetIdleTimer() {
+	if c.idleTimer != nil {
+		c.idleTimer.Reset(c.idleTimeout)
+	}
+}
+
+// closeIdle closes the connection once IdleTimeout has passed without
+// application data.
+func (c *Conn) closeIdle() {
+	if c.isConnectionClosed() {
+		return
+	}
+	c.log.Debugf("%s: closing after %v without application data", srvCliStr(c.state.isClient), c.idleTimeout)
+	if !c.isWriteClosed() && !c.skipCloseNotify {
+		_ = c.notify(c.writeDeadline, alert.Warning, alert.CloseNotify, nil)
+	}
+	_ = c.close(context.Background(), false)
+}
+
+// resetKeepAliveTimer restarts the KeepAlive interval, if one is set.
+func (c *Conn) resetKeepAliveTimer() {
+	if c.keepAliveTimer != nil && !c.isConnectionClosed() {
+		c.keepAliveTimer.Reset(c.keepAlive)
+	}
+}
+
+// sendKeepAlive sends a HeartbeatRequest, or an empty application data
+// record if the peer does not accept heartbeats, once KeepAlive has passed
+// without writes. Writing restarts the timer.
+func (c *Conn) sendKeepAlive() {
+	if c.isConnectionClosed() || c.isWriteClosed() {
+		return
+	}
+
+	var err error
+	if c.state.remoteHeartbeatMode == extension.HeartbeatModePeerAllowedToSend {
+		select {
+		case c.pingSem <- struct{}{}:
+		default:
+			// A Ping is in progress, its requests keep the mappings alive.
+			c.resetKeepAliveTimer()
+			return
+		}
+		payload := make([]byte, heartbeatPayloadLength)
+		if _, err = io.ReadFull(c.rand, payload); err == nil {
+			err = c.writeHeartbeat(c.writeDeadline, heartbeat.Request, payload)
+		}
+		<-c.pingSem
+	} else {
+		_, err = c.Write(nil)
+	}
+	if err != nil {
+		c.log.Debugf("%s: failed to send keepalive: %s", srvCliStr(c.state.isClient), err)
+		c.resetKeepAliveTimer()
+		return
+	}
+	atomic.AddUint64(&c.keepAlivesSent, 1)
 }
 
 func (c *Conn) setHandshakeCompletedSuccessfully() {
 	c.handshakeCompletedSuccessfully.Store(struct{ bool }{true})
 }
 
+// isPeerFinishedReceived reports whether the Finished message of the peer has
+// been received.
+func (c *Conn) isPeerFinishedReceived() bool {
+	return c.handshakeCache.pull(handshakeCachePullRule{handshake.TypeFinished, 1, !c.state.isClient, false})[0] != nil
+}
+
 func (c *Conn) isHandshakeCompletedSuccessfully() bool {
 	boolean, _ := c.handshakeCompletedSuccessfully.Load().(struct{ bool })
 	return boolean.bool
@@ -1009,9 +2075,15 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 	cfg.onFlightState = func(_ flightVal, s handshakeState) {
 		if s == handshakeFinished && !c.isHandshakeCompletedSuccessfully() {
 			c.setHandshakeCompletedSuccessfully()
+			c.resetIdleTimer()
+			c.resetKeepAliveTimer()
+			c.handshakeCancel()
 			close(done)
 		}
 	}
+	cfg.onRetransmit = func() {
+		atomic.AddUint64(&c.retransmittedFlights, 1)
+	}
 
 	ctxHs, cancel := context.WithCancel(context.Background())
 	c.cancelHandshaker = cancel
@@ -1024,7 +2096,8 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 	// The other party may request retransmission of the last flight to cope with packet drop.
 	go func() {
 		defer c.handshakeLoopsFinished.Done()
-		err := c.fsm.Run(ctxHs, c, initialState)
+		defer close(c.handshakerDone)
+		err := c.runHandshaker(ctxHs, initialState)
 		if !errors.Is(err, context.Canceled) {
 			select {
 			case firstErr <- err:
@@ -1036,7 +2109,10 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 		defer func() {
 			// Escaping read loop.
 			// It's safe to close decrypted channnel now.
+			c.decryptedLock.Lock()
+			c.decryptedClosed = true
 			close(c.decrypted)
+			c.decryptedLock.Unlock()
 
 			// Force stop handshaker when the underlying connection is closed.
 			cancel()
@@ -1049,17 +2125,15 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 					if !e.IsFatalOrCloseNotify() {
 						if c.isHandshakeCompletedSuccessfully() {
 							// Pass the error to Read()
-							select {
-							case c.decrypted <- err:
-							case <-c.closed.Done():
-							case <-ctxRead.Done():
-							}
+							c.deliver(ctxRead, err)
 						}
 						continue // non-fatal alert must not stop read loop
 					}
 				} else {
 					switch {
 					case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled), errors.Is(err, io.EOF), errors.Is(err, net.ErrClosed):
+					case errors.Is(err, errTooManyWarningAlerts):
+						// The connection is closed below
 					case errors.Is(err, recordlayer.ErrInvalidPacketLength):
 						// Decode error must be silently discarded
 						// [RFC6347 Section-4.1.2.7]
@@ -1067,11 +2141,7 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 					default:
 						if c.isHandshakeCompletedSuccessfully() {
 							// Keep read loop and pass the read error to Read()
-							select {
-							case c.decrypted <- err:
-							case <-c.closed.Done():
-							case <-ctxRead.Done():
-							}
+							c.deliver(ctxRead, err)
 							continue // non-fatal alert must not stop read loop
 						}
 					}
@@ -1084,12 +2154,15 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 
 				if e != nil {
 					if e.IsFatalOrCloseNotify() {
-						_ = c.close(false) //nolint:contextcheck
+						_ = c.close(context.Background(), false) //nolint:contextcheck
 					}
 				}
+				if errors.Is(err, errTooManyWarningAlerts) {
+					_ = c.close(context.Background(), false) //nolint:contextcheck
+				}
 				if !c.isConnectionClosed() && errors.Is(err, context.Canceled) {
 					c.log.Trace("handshake timeouts - closing underline connection")
-					_ = c.close(false) //nolint:contextcheck
+					_ = c.close(context.Background(), false) //nolint:contextcheck
 				}
 				return
 			}
@@ -1101,17 +2174,35 @@ func (c *Conn) handshake(ctx context.Context, cfg *handshakeConfig, initialFligh
 		cancelRead()
 		cancel()
 		c.handshakeLoopsFinished.Wait()
-		return c.translateHandshakeCtxError(err)
+		return c.translateHandshakeCtxError(c.checkIncompleteServerFlight(err))
 	case <-ctx.Done():
 		cancelRead()
 		cancel()
 		c.handshakeLoopsFinished.Wait()
-		return c.translateHandshakeCtxError(ctx.Err())
+		return c.translateHandshakeCtxError(c.checkIncompleteServerFlight(ctx.Err()))
 	case <-done:
 		return nil
 	}
 }
 
+// checkIncompleteServerFlight replaces a handshake timeout with
+// ErrIncompleteServerFlight if the server started its flight with a
+// ServerHello but never completed it. It must only be called once the
+// handshake loops have finished.
+func (c *Conn) checkIncompleteServerFlight(err error) error {
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, errMaxRetransmitsExceeded) {
+		return err
+	}
+	missing := c.fsm.incompleteServerFlight()
+	if len(missing) == 0 {
+		return err
+	}
+	c.log.Warnf("server flight incomplete, missing %v: %v", missing, err)
+	return ErrIncompleteServerFlight
+}
+
+// translateHandshakeCtxError wraps a handshake failure in a HandshakeError.
+// It must only be called once the handshake loops have finished.
 func (c *Conn) translateHandshakeCtxError(err error) error {
 	if err == nil {
 		return nil
@@ -1119,17 +2210,39 @@ func (c *Conn) translateHandshakeCtxError(err error) error {
 	if errors.Is(err, context.Canceled) && c.isHandshakeCompletedSuccessfully() {
 		return nil
 	}
-	return &HandshakeError{Err: err}
+	hsErr := &HandshakeError{Err: err}
+	if c.fsm.currentFlight != 0 {
+		hsErr.LastFlight = c.fsm.currentFlight.String()
+	}
+	var e *alertError
+	if errors.As(err, &e) {
+		hsErr.Alert = e.Alert
+	}
+	return hsErr
 }
 
-func (c *Conn) close(byUser bool) error {
+func (c *Conn) close(ctx context.Context, byUser bool) error {
 	c.cancelHandshaker()
 	c.cancelHandshakeReader()
 
-	if c.isHandshakeCompletedSuccessfully() && byUser {
-		// Discard error from notify() to return non-error on the first user call of Close()
-		// even if the underlying connection is already closed.
-		_ = c.notify(context.Background(), alert.Warning, alert.CloseNotify)
+	if c.isHandshakeCompletedSuccessfully() && byUser && !c.isWriteClosed() && !c.skipCloseNotify {
+		notifyDone := make(chan struct{})
+		go func() {
+			defer close(notifyDone)
+			// Discard error from notify() to return non-error on the first user call of Close()
+			// even if the underlying connection is already closed.
+			_ = c.notify(ctx, alert.Warning, alert.CloseNotify, nil)
+		}()
+		select {
+		case <-notifyDone:
+		case <-ctx.Done():
+			c.log.Debug("close_notify was not sent before the close context finished")
+		}
+		// A write still blocked on the underlying connection is released once
+		// it has been closed below.
+		defer func() {
+			<-notifyDone
+		}()
 	}
 
 	c.closeLock.Lock()
@@ -1142,6 +2255,15 @@ func (c *Conn) close(byUser bool) error {
 	c.closed.Close()
 	c.closeLock.Unlock()
 
+	// Stop the timers after the close_notify, whose write restarts the
+	// keepalive timer.
+	if c.idleTimer != nil {
+		c.idleTimer.Stop()
+	}
+	if c.keepAliveTimer != nil {
+		c.keepAliveTimer.Stop()
+	}
+
 	if closedByUser {
 		return ErrConnClosed
 	}
@@ -1153,6 +2275,12 @@ func (c *Conn) close(byUser bool) error {
 	return c.nextConn.Close()
 }
 
+func (c *Conn) isWriteClosed() bool {
+	c.closeLock.Lock()
+	defer c.closeLock.Unlock()
+	return c.writeClosed
+}
+
 func (c *Conn) isConnectionClosed() bool {
 	select {
 	case <-c.closed.Done():
@@ -1163,11 +2291,27 @@ func (c *Conn) isConnectionClosed() bool {
 }
 
 func (c *Conn) setLocalEpoch(epoch uint16) {
+	if epoch != c.state.getLocalEpoch() {
+		// The sequence numbers of the new epoch take the place of those of
+		// an expired one.
+		atomic.StoreUint64(&c.state.localSequenceNumber[epoch%maxRetainedEpochs], 0)
+	}
 	c.state.localEpoch.Store(epoch)
 }
 
 func (c *Conn) setRemoteEpoch(epoch uint16) {
+	previous, ok := c.state.remoteEpoch.Load().(uint16)
 	c.state.remoteEpoch.Store(epoch)
+	if !ok || previous == epoch {
+		return
+	}
+
+	c.epochHistoryLock.Lock()
+	defer c.epochHistoryLock.Unlock()
+	if len(c.epochHistory) == maxEpochHistory {
+		c.epochHistory = append(c.epochHistory[:0], c.epochHistory[1:]...)
+	}
+	c.epochHistory = append(c.epochHistory, EpochTransition{From: previous, To: epoch, Time: time.Now()})
 }
 
 // LocalAddr implements net.Conn.LocalAddr
@@ -1182,6 +2326,10 @@ func (c *Conn) RemoteAddr() net.Addr {
 	return c.rAddr
 }
 
+func (c *Conn) remoteAddr() net.Addr {
+	return c.RemoteAddr()
+}
+
 func (c *Conn) sessionKey() []byte {
 	if c.state.isClient {
 		// As ServerName can be like 0.example.com, it's better to add
@@ -1219,6 +2367,7 @@ func (c *Conn) GetHandshakeLog() *tls.ServerHandshake {
 	_, serverMsgs, ok := s.cache.fullPullMap(1, s.state.cipherSuite,
 		handshakeCachePullRule{handshake.TypeServerHello, s.cfg.initialEpoch, false, true},
 		handshakeCachePullRule{handshake.TypeCertificate, s.cfg.initialEpoch, false, true},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, s.cfg.initialEpoch, false, true},
 		handshakeCachePullRule{handshake.TypeServerKeyExchange, s.cfg.initialEpoch, false, true},
 		handshakeCachePullRule{handshake.TypeCertificateRequest, s.cfg.initialEpoch, false, true},
 		handshakeCachePullRule{handshake.TypeServerHelloDone, s.cfg.initialEpoch, false, true},
@@ -1258,6 +2407,10 @@ func (c *Conn) GetHandshakeLog() *tls.ServerHandshake {
 			hsLog.ServerHello = m.MakeLog()
 		case *handshake.MessageCertificate:
 			hsLog.ServerCertificates = m.MakeLog()
+		case *handshake.MessageCompressedCertificate:
+			if certificate, err := m.Decompress(maxDecompressedCertificateLength); err == nil {
+				hsLog.ServerCertificates = certificate.MakeLog()
+			}
 		case *handshake.MessageServerKeyExchange:
 			hsLog.ServerKeyExchange = m.MakeLog()
 		case *handshake.MessageFinished:
@@ -9,26 +9,36 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	cryptoElliptic "crypto/elliptic"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
+	"reflect"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/dane"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/hash"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/signature"
@@ -47,6 +57,7 @@ var (
 	errNotExpectedChain       = errors.New("not expected chain")
 	errExpecedChain           = errors.New("expected chain")
 	errWrongCert              = errors.New("wrong cert")
+	errUnknownServerName      = errors.New("unknown server name")
 )
 
 func TestStressDuplex(t *testing.T) {
@@ -177,7 +188,7 @@ func TestReadWriteDeadline(t *testing.T) {
 	}
 }
 
-func TestSequenceNumberOverflow(t *testing.T) {
+func TestCloseWithContextBlockedSocket(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(5 * time.Second)
 	defer lim.Stop()
@@ -186,159 +197,105 @@ func TestSequenceNumberOverflow(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	t.Run("ApplicationData", func(t *testing.T) {
-		ca, cb, err := pipeMemory()
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		atomic.StoreUint64(&ca.state.localSequenceNumber[1], recordlayer.MaxSequenceNumber)
-		if _, werr := ca.Write(make([]byte, 100)); werr != nil {
-			t.Errorf("Write must send message with maximum sequence number, but errord: %v", werr)
-		}
-		if _, werr := ca.Write(make([]byte, 100)); !errors.Is(werr, errSequenceNumberOverflow) {
-			t.Errorf("Write must abandonsend message with maximum sequence number, but errord: %v", werr)
-		}
-
-		if err := ca.Close(); err != nil {
-			t.Error(err)
-		}
-		if err := cb.Close(); err != nil {
-			t.Error(err)
-		}
-	})
-	t.Run("Handshake", func(t *testing.T) {
-		ca, cb, err := pipeMemory()
-		if err != nil {
-			t.Fatal(err)
-		}
+	ca, cb := dpipe.Pipe()
+	wedged := &wedgedConn{Conn: ca, closed: make(chan struct{})}
+	client, server, err := pipeConn(wedged, cb)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+	wedged.wedged.Store(true)
 
-		atomic.StoreUint64(&ca.state.localSequenceNumber[0], recordlayer.MaxSequenceNumber+1)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
 
-		// Try to send handshake packet.
-		if werr := ca.writePackets(ctx, []*packet{
-			{
-				record: &recordlayer.RecordLayer{
-					Header: recordlayer.Header{
-						Version: protocol.Version1_2,
-					},
-					Content: &handshake.Handshake{
-						Message: &handshake.MessageClientHello{
-							Version:            protocol.Version1_2,
-							Cookie:             make([]byte, 64),
-							CipherSuiteIDs:     cipherSuiteIDs(defaultCipherSuites()),
-							CompressionMethods: defaultCompressionMethods(),
-						},
-					},
-				},
-			},
-		}); !errors.Is(werr, errSequenceNumberOverflow) {
-			t.Errorf("Connection must fail on handshake packet reaches maximum sequence number")
-		}
+	start := time.Now()
+	if err := client.CloseWithContext(ctx); err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CloseWithContext took %v on a blocked socket", elapsed)
+	}
 
-		if err := ca.Close(); err != nil {
-			t.Error(err)
-		}
-		if err := cb.Close(); err != nil {
-			t.Error(err)
-		}
-	})
+	if _, err := client.Write([]byte("hello")); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Write must return %v after close, got %v", ErrConnClosed, err)
+	}
+	if err := server.Close(); err != nil {
+		t.Error(err)
+	}
 }
 
-func pipeMemory() (*Conn, *Conn, error) {
-	// In memory pipe
-	ca, cb := dpipe.Pipe()
-	return pipeConn(ca, cb)
+// wedgedConn blocks all writes once wedged until it is closed.
+type wedgedConn struct {
+	net.Conn
+	wedged    atomic.Bool
+	closed    chan struct{}
+	closeOnce sync.Once
 }
 
-func pipeConn(ca, cb net.Conn) (*Conn, *Conn, error) {
-	type result struct {
-		c   *Conn
-		err error
+func (c *wedgedConn) Write(b []byte) (int, error) {
+	if c.wedged.Load() {
+		<-c.closed
+		return 0, net.ErrClosed
 	}
+	return c.Conn.Write(b)
+}
 
-	c := make(chan result)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+func (c *wedgedConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.Conn.Close()
+}
 
-	// Setup client
-	go func() {
-		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}}, true)
-		c <- result{client, err}
-	}()
+func TestHandshakeTranscriptSize(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-	// Setup server
-	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}}, true)
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
 	if err != nil {
-		return nil, nil, err
+		t.Fatal(err)
 	}
 
-	// Receive client
-	res := <-c
-	if res.err != nil {
-		_ = server.Close()
-		return nil, nil, res.err
-	}
+	transcriptSize := func(cert tls.Certificate) int {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	return res.c, server, nil
-}
+		client, server := pipeConnPair(t, ctx, &Config{}, &Config{
+			Certificates: []tls.Certificate{cert},
+		})
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
 
-func testClient(ctx context.Context, c net.PacketConn, rAddr net.Addr, cfg *Config, generateCertificate bool) (*Conn, error) {
-	if generateCertificate {
-		clientCert, err := selfsign.GenerateSelfSigned()
-		if err != nil {
-			return nil, err
+		size := client.HandshakeTranscriptSize()
+		if serverSize := server.HandshakeTranscriptSize(); serverSize != size {
+			t.Errorf("Client and server transcript sizes differ: %d != %d", size, serverSize)
 		}
-		cfg.Certificates = []tls.Certificate{clientCert}
+		return size
 	}
-	cfg.InsecureSkipVerify = true
-	return ClientWithContext(ctx, c, rAddr, cfg)
-}
 
-func testServer(ctx context.Context, c net.PacketConn, rAddr net.Addr, cfg *Config, generateCertificate bool) (*Conn, error) {
-	if generateCertificate {
-		serverCert, err := selfsign.GenerateSelfSigned()
-		if err != nil {
-			return nil, err
-		}
-		cfg.Certificates = []tls.Certificate{serverCert}
+	size := transcriptSize(serverCert)
+	if size < 500 || size > 2000 {
+		t.Errorf("Transcript size of a standard handshake out of range: %d", size)
 	}
-	return ServerWithContext(ctx, c, rAddr, cfg)
-}
 
-func sendClientHello(cookie []byte, ca net.Conn, sequenceNumber uint64, extensions []extension.Extension) error {
-	packet, err := (&recordlayer.RecordLayer{
-		Header: recordlayer.Header{
-			Version:        protocol.Version1_2,
-			SequenceNumber: sequenceNumber,
-		},
-		Content: &handshake.Handshake{
-			Header: handshake.Header{
-				MessageSequence: uint16(sequenceNumber),
-			},
-			Message: &handshake.MessageClientHello{
-				Version:            protocol.Version1_2,
-				Cookie:             cookie,
-				CipherSuiteIDs:     cipherSuiteIDs(defaultCipherSuites()),
-				CompressionMethods: defaultCompressionMethods(),
-				Extensions:         extensions,
-			},
-		},
-	}).Marshal()
-	if err != nil {
-		return err
+	largeCert := serverCert
+	for i := 0; i < 4; i++ {
+		largeCert.Certificate = append(largeCert.Certificate, serverCert.Certificate[0])
 	}
-
-	if _, err = ca.Write(packet); err != nil {
-		return err
+	if largeSize := transcriptSize(largeCert); largeSize < size+4*len(serverCert.Certificate[0]) {
+		t.Errorf("Transcript size did not grow with the certificate chain: %d, standard %d", largeSize, size)
 	}
-	return nil
 }
 
-func TestHandshakeWithAlert(t *testing.T) {
+func TestTranscriptHash(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -347,62 +304,77 @@ func TestHandshakeWithAlert(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
+	if _, err := (&Conn{handshakeCache: newHandshakeCache()}).TranscriptHash(); !errors.Is(err, errCipherSuiteNotNegotiated) {
+		t.Errorf("TranscriptHash before negotiation: expected %v, got %v", errCipherSuiteNotNegotiated, err)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cases := map[string]struct {
-		configServer, configClient *Config
-		errServer, errClient       error
-	}{
-		"CipherSuiteNoIntersection": {
-			configServer: &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			},
-			configClient: &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
-			},
-			errServer: errCipherSuiteNoIntersection,
-			errClient: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-		},
-		"SignatureSchemesNoIntersection": {
-			configServer: &Config{
-				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-				SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
-			},
-			configClient: &Config{
-				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-				SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
-			},
-			errServer: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			errClient: errNoAvailableSignatureSchemes,
-		},
+	client, server := pipeConnPair(t, ctx, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	}, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	clientHash, err := client.TranscriptHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverHash, err := server.TranscriptHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientHash, serverHash) {
+		t.Errorf("Client and server transcript hashes differ: %x != %x", clientHash, serverHash)
 	}
+	if len(clientHash) != sha512.Size384 {
+		t.Errorf("Expected a SHA-384 transcript hash, got %d bytes", len(clientHash))
+	}
+}
 
-	for name, testCase := range cases {
-		testCase := testCase
-		t.Run(name, func(t *testing.T) {
-			clientErr := make(chan error, 1)
+func TestVerifyTranscriptIntegrity(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-			ca, cb := dpipe.Pipe()
-			go func() {
-				_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), testCase.configClient, true)
-				clientErr <- err
-			}()
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-			_, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), testCase.configServer, true)
-			if !errors.Is(errServer, testCase.errServer) {
-				t.Fatalf("Server error exp(%v) failed(%v)", testCase.errServer, errServer)
-			}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-			errClient := <-clientErr
-			if !errors.Is(errClient, testCase.errClient) {
-				t.Fatalf("Client error exp(%v) failed(%v)", testCase.errClient, errClient)
-			}
-		})
+	// A small MTU makes the server's flights arrive in fragments.
+	client, server := pipeConnPair(t, ctx, &Config{
+		VerifyTranscriptIntegrity: true,
+	}, &Config{
+		MTU:                       100,
+		VerifyTranscriptIntegrity: true,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	for _, conn := range []*Conn{client, server} {
+		if err := conn.handshakeCache.checkIntegrity(); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// Corrupt the Certificate the client received from the server.
+	client.handshakeCache.pull(handshakeCachePullRule{handshake.TypeCertificate, 0, false, false})[0].data[20] ^= 0xff
+	if err := client.handshakeCache.checkIntegrity(); !errors.Is(err, errTranscriptCorrupted) {
+		t.Errorf("Expected error %v, got %v", errTranscriptCorrupted, err)
 	}
 }
 
-func TestHandshakeWithInvalidRecord(t *testing.T) {
+func TestRetransmitMTU(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -414,1450 +386,6063 @@ func TestHandshakeWithInvalidRecord(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	type result struct {
-		c   *Conn
-		err error
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
 	}
-	clientErr := make(chan result, 1)
+	for i := 0; i < 2; i++ {
+		serverCert.Certificate = append(serverCert.Certificate, serverCert.Certificate[0])
+	}
+
+	const retransmitMTU = 300
+
 	ca, cb := dpipe.Pipe()
-	caWithInvalidRecord := &connWithCallback{Conn: ca}
+	recorder := &certificateDroppingConn{Conn: cb}
+	client, server := connPair(t, ctx, ca, recorder, &Config{
+		FlightInterval: 5 * time.Second,
+	}, &Config{
+		Certificates:   []tls.Certificate{serverCert},
+		FlightInterval: 100 * time.Millisecond,
+		RetransmitMTU:  retransmitMTU,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-	var msgSeq atomic.Int32
-	// Send invalid record after first message
-	caWithInvalidRecord.onWrite = func([]byte) {
-		if msgSeq.Add(1) == 2 {
-			if _, err := ca.Write([]byte{0x01, 0x02}); err != nil {
-				t.Fatal(err)
-			}
+	if server.MTU() != retransmitMTU {
+		t.Errorf("Server MTU not lowered after retransmission: %d", server.MTU())
+	}
+	if server.Stats().RetransmittedFlights == 0 {
+		t.Error("Server retransmission not counted")
+	}
+
+	transmissions := recorder.transmissions()
+	if len(transmissions) < 2 {
+		t.Fatalf("Expected the Certificate to be retransmitted, sent %d times", len(transmissions))
+	}
+	if first := transmissions[0]; first[0] <= retransmitMTU {
+		t.Errorf("Expected the original Certificate fragments to exceed %d bytes: %v", retransmitMTU, first)
+	}
+	last := transmissions[len(transmissions)-1]
+	if len(last) <= len(transmissions[0]) {
+		t.Errorf("Expected the retransmitted Certificate in more fragments: %v, originally %v", last, transmissions[0])
+	}
+	for _, length := range last {
+		if length > retransmitMTU {
+			t.Errorf("Retransmitted Certificate fragment of %d bytes exceeds %d", length, retransmitMTU)
 		}
 	}
-	go func() {
-		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(caWithInvalidRecord), caWithInvalidRecord.RemoteAddr(), &Config{
-			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		}, true)
-		clientErr <- result{client, err}
-	}()
+}
 
-	server, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-	}, true)
+func TestPathMTUDiscovery(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-	errClient := <-clientErr
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		serverCert.Certificate = append(serverCert.Certificate, serverCert.Certificate[0])
+	}
 
+	// Datagrams larger than the path MTU are lost.
+	ca, cb := dpipe.Pipe()
+	client, server := connPair(t, ctx, ca, &oversizedDroppingConn{Conn: cb, mtu: 700}, &Config{
+		FlightInterval: 5 * time.Second,
+	}, &Config{
+		Certificates:   []tls.Certificate{serverCert},
+		FlightInterval: 20 * time.Millisecond,
+		MinMTU:         256,
+	})
 	defer func() {
-		if server != nil {
-			if err := server.Close(); err != nil {
-				t.Fatal(err)
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	if mtu := server.MTU(); mtu != 548 {
+		t.Errorf("Expected the server to discover an MTU of 548, got %d", mtu)
+	}
+	if mtu := client.MTU(); mtu != defaultMTU {
+		t.Errorf("Expected the client MTU to be unchanged, got %d", mtu)
+	}
+}
+
+func TestNextPathMTU(t *testing.T) {
+	for _, test := range []struct {
+		mtu, minMTU, expected int
+	}{
+		{1200, 256, 1000},
+		{1000, 256, 800},
+		{548, 256, 256},
+		{256, 256, 256},
+		{1000, 900, 900},
+		{1500, 0, 1452},
+	} {
+		if actual := nextPathMTU(test.mtu, test.minMTU); actual != test.expected {
+			t.Errorf("nextPathMTU(%d, %d): expected %d, got %d", test.mtu, test.minMTU, test.expected, actual)
+		}
+	}
+}
+
+// oversizedDroppingConn silently drops written datagrams larger than mtu.
+type oversizedDroppingConn struct {
+	net.Conn
+	mtu int
+}
+
+func (c *oversizedDroppingConn) Write(b []byte) (int, error) {
+	if len(b) > c.mtu {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// certificateDroppingConn drops the first datagram it writes that carries a
+// Certificate, and records the fragment lengths of every Certificate
+// transmission.
+type certificateDroppingConn struct {
+	net.Conn
+
+	mu        sync.Mutex
+	dropped   bool
+	fragments [][]int
+}
+
+func (c *certificateDroppingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	hasCertificate := false
+	for offset := 0; offset+recordlayer.FixedHeaderSize < len(b); {
+		end := offset + recordlayer.FixedHeaderSize + int(binary.BigEndian.Uint16(b[offset+11:]))
+		if end > len(b) {
+			break
+		}
+		body := b[offset+recordlayer.FixedHeaderSize : end]
+		if b[offset] == byte(protocol.ContentTypeHandshake) && len(body) >= handshake.HeaderLength &&
+			body[0] == byte(handshake.TypeCertificate) {
+			hasCertificate = true
+			fragmentOffset := int(body[6])<<16 | int(body[7])<<8 | int(body[8])
+			fragmentLength := int(body[9])<<16 | int(body[10])<<8 | int(body[11])
+			if fragmentOffset == 0 {
+				c.fragments = append(c.fragments, nil)
+			}
+			if n := len(c.fragments); n > 0 {
+				c.fragments[n-1] = append(c.fragments[n-1], fragmentLength)
 			}
 		}
+		offset = end
+	}
+	drop := hasCertificate && !c.dropped
+	if drop {
+		c.dropped = true
+	}
+	c.mu.Unlock()
 
-		if errClient.c != nil {
-			if err := errClient.c.Close(); err != nil {
+	if drop {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+func (c *certificateDroppingConn) transmissions() [][]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([][]int{}, c.fragments...)
+}
+
+func TestSkipCloseNotify(t *testing.T) {
+	for name, skip := range map[string]bool{
+		"Skip": true,
+		"Send": false,
+	} {
+		skip := skip
+		t.Run(name, func(t *testing.T) {
+			// Limit runtime in case of deadlocks
+			lim := test.TimeOut(5 * time.Second)
+			defer lim.Stop()
+
+			// Check for leaking routines
+			report := test.CheckRoutines(t)
+			defer report()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			counting := &writeCountingConn{Conn: ca}
+			client, server := connPair(t, ctx, counting, cb, &Config{
+				SkipCloseNotify: skip,
+			}, &Config{})
+
+			before := counting.writes.Load()
+			if err := client.Close(); err != nil {
 				t.Fatal(err)
 			}
-		}
+			if sent := counting.writes.Load() != before; sent == skip {
+				t.Errorf("close_notify sent: %v, expected %v", sent, !skip)
+			}
+			if err := server.Close(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// writeCountingConn counts the datagrams written to the wrapped net.Conn.
+type writeCountingConn struct {
+	net.Conn
+	writes atomic.Int32
+}
+
+func (c *writeCountingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+func TestWriteMultiple(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	counting := &writeCountingConn{Conn: ca}
+	client, server := connPair(t, ctx, counting, cb, &Config{}, &Config{})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
 	}()
 
-	if errServer != nil {
-		t.Fatalf("Server failed(%v)", errServer)
+	var payloads [][]byte
+	total := 0
+	for i := 0; i < 10; i++ {
+		payload := bytes.Repeat([]byte{byte(i)}, 10+i)
+		payloads = append(payloads, payload)
+		total += len(payload)
 	}
 
-	if errClient.err != nil {
-		t.Fatalf("Client failed(%v)", errClient.err)
+	counting.writes.Store(0)
+	n, err := client.WriteMultiple(payloads)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != total {
+		t.Errorf("WriteMultiple returned %d, expected %d", n, total)
+	}
+	if writes := counting.writes.Load(); writes != 1 {
+		t.Errorf("Expected the records to be coalesced into one datagram, sent %d", writes)
+	}
+	if err = client.Flush(); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	if writes := counting.writes.Load(); writes != 1 {
+		t.Errorf("Expected Flush to find nothing buffered, sent %d datagrams", writes)
+	}
+
+	buf := make([]byte, 1024)
+	for i, payload := range payloads {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Errorf("Payload %d mismatch: got %v, expected %v", i, buf[:n], payload)
+		}
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.WriteMultiple(payloads); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected WriteMultiple after Close to fail with %v, got %v", ErrConnClosed, err)
+	}
+	if err := client.Flush(); err != nil {
+		t.Errorf("Expected Flush after Close to succeed, got %v", err)
 	}
 }
 
-func TestExportKeyingMaterial(t *testing.T) {
+func TestWriteRecordTooLarge(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	var rand [28]byte
-	exportLabel := "EXTRACTOR-dtls_srtp"
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	expectedServerKey := []byte{0x61, 0x09, 0x9d, 0x7d, 0xcb, 0x08, 0x52, 0x2c, 0xe7, 0x7b}
-	expectedClientKey := []byte{0x87, 0xf0, 0x40, 0x02, 0xf6, 0x1c, 0xf1, 0xfe, 0x8c, 0x77}
+	ca, cb := dpipe.Pipe()
+	counting := &writeCountingConn{Conn: ca}
+	client, server := connPair(t, ctx, counting, cb, &Config{}, &Config{})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-	c := &Conn{
-		state: State{
-			localRandom:         handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand},
-			remoteRandom:        handshake.Random{GMTUnixTime: time.Unix(1000, 0), RandomBytes: rand},
-			localSequenceNumber: []uint64{0, 0},
-			cipherSuite:         &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
-		},
+	counting.writes.Store(0)
+	n, err := client.Write(make([]byte, 20*1024))
+	if !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Expected %v, got %v", ErrRecordTooLarge, err)
+	}
+	if n != 0 {
+		t.Errorf("Write returned %d, expected 0", n)
+	}
+	if writes := counting.writes.Load(); writes != 0 {
+		t.Errorf("Expected nothing to be sent, sent %d datagrams", writes)
 	}
-	c.setLocalEpoch(0)
-	c.setRemoteEpoch(0)
 
-	state := c.ConnectionState()
-	_, err := state.ExportKeyingMaterial(exportLabel, nil, 0)
-	if !errors.Is(err, errHandshakeInProgress) {
-		t.Errorf("ExportKeyingMaterial when epoch == 0: expected '%s' actual '%s'", errHandshakeInProgress, err)
+	// The connection stays usable, and a payload larger than the MTU but
+	// within the record limit is still delivered in one piece.
+	payload := bytes.Repeat([]byte{0x01}, 4096)
+	if n, err = client.Write(payload); err != nil {
+		t.Fatal(err)
+	} else if n != len(payload) {
+		t.Errorf("Write returned %d, expected %d", n, len(payload))
+	}
+	buf := make([]byte, 8192)
+	n, err = server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
 	}
+	if !bytes.Equal(buf[:n], payload) {
+		t.Error("Payload mismatch")
+	}
+}
 
-	c.setLocalEpoch(1)
-	state = c.ConnectionState()
-	_, err = state.ExportKeyingMaterial(exportLabel, []byte{0x00}, 0)
-	if !errors.Is(err, errContextUnsupported) {
-		t.Errorf("ExportKeyingMaterial with context: expected '%s' actual '%s'", errContextUnsupported, err)
+func TestCloseWrite(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	for k := range invalidKeyingLabels() {
-		state = c.ConnectionState()
-		_, err = state.ExportKeyingMaterial(k, nil, 0)
-		if !errors.Is(err, errReservedExportKeyingMaterial) {
-			t.Errorf("ExportKeyingMaterial reserved label: expected '%s' actual '%s'", errReservedExportKeyingMaterial, err)
-		}
+	if _, err = server.Write([]byte("bye")); err != nil {
+		t.Fatal(err)
+	}
+	if err = client.CloseWrite(); err != nil {
+		t.Fatal(err)
 	}
 
-	state = c.ConnectionState()
-	keyingMaterial, err := state.ExportKeyingMaterial(exportLabel, nil, 10)
-	if err != nil {
-		t.Errorf("ExportKeyingMaterial as server: unexpected error '%s'", err)
-	} else if !bytes.Equal(keyingMaterial, expectedServerKey) {
-		t.Errorf("ExportKeyingMaterial client export: expected (% 02x) actual (% 02x)", expectedServerKey, keyingMaterial)
+	if _, err = client.Write([]byte("hello")); !errors.Is(err, errWriteClosed) {
+		t.Errorf("Write must return %v after CloseWrite, got %v", errWriteClosed, err)
 	}
 
-	c.state.isClient = true
-	state = c.ConnectionState()
-	keyingMaterial, err = state.ExportKeyingMaterial(exportLabel, nil, 10)
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
 	if err != nil {
-		t.Errorf("ExportKeyingMaterial as server: unexpected error '%s'", err)
-	} else if !bytes.Equal(keyingMaterial, expectedClientKey) {
-		t.Errorf("ExportKeyingMaterial client export: expected (% 02x) actual (% 02x)", expectedClientKey, keyingMaterial)
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "bye" {
+		t.Errorf("Unexpected application data: %q", buf[:n])
+	}
+
+	// The server answers close_notify and closes, which ends the client's
+	// read side as well.
+	if _, err = server.Read(buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Server Read must return %v after close_notify, got %v", io.EOF, err)
+	}
+	if _, err = client.Read(buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Client Read must return %v after close_notify, got %v", io.EOF, err)
+	}
+
+	if err = client.Close(); err != nil {
+		t.Error(err)
+	}
+	if err = server.Close(); err != nil {
+		t.Error(err)
 	}
 }
 
-func TestPSK(t *testing.T) {
+func TestRecordAfterClose(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
+	lim := test.TimeOut(5 * time.Second)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name                   string
-		ClientIdentity         []byte
-		ServerIdentity         []byte
-		CipherSuites           []CipherSuiteID
-		ClientVerifyConnection func(*State) error
-		ServerVerifyConnection func(*State) error
-		WantFail               bool
-		ExpectedServerErr      string
-		ExpectedClientErr      string
-	}{
-		{
-			Name:           "Server identity specified",
-			ServerIdentity: []byte("Test Identity"),
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		},
-		{
-			Name:           "Server identity specified - Server verify connection fails",
-			ServerIdentity: []byte("Test Identity"),
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-			ServerVerifyConnection: func(*State) error {
-				return errExample
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.lock.Lock()
+	raw, err := client.processPacket(&packet{
+		record: &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Epoch:   client.state.getLocalEpoch(),
+				Version: protocol.Version1_2,
+			},
+			Content: &protocol.ApplicationData{
+				Data: []byte("late"),
 			},
-			WantFail:          true,
-			ExpectedServerErr: errExample.Error(),
-			ExpectedClientErr: alert.BadCertificate.String(),
 		},
-		{
-			Name:           "Server identity specified - Client verify connection fails",
-			ServerIdentity: []byte("Test Identity"),
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-			ClientVerifyConnection: func(*State) error {
-				return errExample
-			},
-			WantFail:          true,
-			ExpectedServerErr: alert.BadCertificate.String(),
-			ExpectedClientErr: errExample.Error(),
-		},
-		{
-			Name:           "Server identity nil",
-			ServerIdentity: nil,
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		},
-		{
-			Name:           "TLS_PSK_WITH_AES_128_CBC_SHA256",
-			ServerIdentity: nil,
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CBC_SHA256},
-		},
-		{
-			Name:           "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256",
-			ServerIdentity: nil,
-			ClientIdentity: []byte("Client Identity"),
-			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256},
-		},
-		{
-			Name:           "Client identity empty",
-			ServerIdentity: nil,
-			ClientIdentity: []byte{},
-			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		},
-	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
-			type result struct {
-				c   *Conn
-				err error
-			}
-			clientRes := make(chan result, 1)
-
-			ca, cb := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					PSK: func(hint []byte) ([]byte, error) {
-						if !bytes.Equal(test.ServerIdentity, hint) {
-							return nil, fmt.Errorf("TestPSK: Client got invalid identity expected(% 02x) actual(% 02x)", test.ServerIdentity, hint) //nolint:goerr113
-						}
+		shouldEncrypt: true,
+	})
+	client.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
 
-						return []byte{0xAB, 0xC1, 0x23}, nil
-					},
-					PSKIdentityHint:  test.ClientIdentity,
-					CipherSuites:     test.CipherSuites,
-					VerifyConnection: test.ClientVerifyConnection,
-				}
+	if err = server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// The read loop has exited once Close returns, but it may have dropped
+	// the client's reply to close_notify already.
+	dropped := server.Stats().DroppedAfterClose
 
-				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
-				clientRes <- result{c, err}
-			}()
+	hs, a, err := server.handleIncomingPacket(context.Background(), raw, server.RemoteAddr(), true)
+	if hs || a != nil || err != nil {
+		t.Errorf("Record after Close must be silently dropped, got (%v, %v, %v)", hs, a, err)
+	}
+	if n := server.Stats().DroppedAfterClose - dropped; n != 1 {
+		t.Errorf("Expected 1 record dropped after close, got %d", n)
+	}
+	select {
+	case v, ok := <-server.decrypted:
+		if ok {
+			t.Errorf("Record after Close must not reach Read, got %v", v)
+		}
+	default:
+	}
 
-			config := &Config{
-				PSK: func(hint []byte) ([]byte, error) {
-					fmt.Println(hint)
-					if !bytes.Equal(test.ClientIdentity, hint) {
-						return nil, fmt.Errorf("%w: expected(% 02x) actual(% 02x)", errTestPSKInvalidIdentity, test.ClientIdentity, hint)
-					}
-					return []byte{0xAB, 0xC1, 0x23}, nil
-				},
-				PSKIdentityHint:  test.ServerIdentity,
-				CipherSuites:     test.CipherSuites,
-				VerifyConnection: test.ServerVerifyConnection,
-			}
+	if err = client.Close(); err != nil {
+		t.Error(err)
+	}
+}
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false)
-			if test.WantFail {
-				res := <-clientRes
-				if err == nil || !strings.Contains(err.Error(), test.ExpectedServerErr) {
-					t.Fatalf("TestPSK: Server expected(%v) actual(%v)", test.ExpectedServerErr, err)
-				}
-				if res.err == nil || !strings.Contains(res.err.Error(), test.ExpectedClientErr) {
-					t.Fatalf("TestPSK: Client expected(%v) actual(%v)", test.ExpectedClientErr, res.err)
-				}
-				return
-			}
-			if err != nil {
-				t.Fatalf("TestPSK: Server failed(%v)", err)
-			}
+// addrRecordingConn records the destination of every datagram written.
+type addrRecordingConn struct {
+	net.PacketConn
 
-			actualPSKIdentityHint := server.ConnectionState().IdentityHint
-			if !bytes.Equal(actualPSKIdentityHint, test.ClientIdentity) {
-				t.Errorf("TestPSK: Server ClientPSKIdentity Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ClientIdentity, actualPSKIdentityHint)
-			}
+	mu    sync.Mutex
+	addrs []net.Addr
+}
 
-			defer func() {
-				_ = server.Close()
-			}()
+func (c *addrRecordingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	c.addrs = append(c.addrs, addr)
+	c.mu.Unlock()
+	return c.PacketConn.WriteTo(p, addr)
+}
 
-			res := <-clientRes
-			if res.err != nil {
-				t.Fatal(res.err)
-			}
-			_ = res.c.Close()
-		})
-	}
+func (c *addrRecordingConn) lastAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addrs[len(c.addrs)-1]
 }
 
-func TestPSKHintFail(t *testing.T) {
+func TestWriteTo(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	serverAlertError := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InternalError}}
-	pskRejected := errPSKRejected
-
-	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
-	defer lim.Stop()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientErr := make(chan error, 1)
-
 	ca, cb := dpipe.Pipe()
+	serverConn := &addrRecordingConn{PacketConn: dtlsnet.PacketConnFromConn(cb)}
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result)
 	go func() {
-		conf := &Config{
-			PSK: func([]byte) ([]byte, error) {
-				return nil, pskRejected
-			},
-			PSKIdentityHint: []byte{},
-			CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
-		}
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, true)
+		c <- result{client, err}
+	}()
 
-		_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
-		clientErr <- err
+	server, err := testServer(ctx, serverConn, cb.RemoteAddr(), &Config{}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = server.Close()
+		_ = res.c.Close()
 	}()
 
-	config := &Config{
-		PSK: func([]byte) ([]byte, error) {
-			return nil, pskRejected
-		},
-		PSKIdentityHint: []byte{},
-		CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+	// The pipe delivers the datagram to the client wherever it is sent.
+	roamed := dtlsnet.PipeAddr(1)
+	if _, err = server.WriteTo([]byte("roamed"), roamed); err != nil {
+		t.Fatal(err)
+	}
+	if actual := serverConn.lastAddr(); actual != net.Addr(roamed) {
+		t.Errorf("Expected the datagram to be sent to %v, got %v", roamed, actual)
+	}
+	if actual := server.RemoteAddr(); actual != cb.RemoteAddr() {
+		t.Errorf("WriteTo must not change the remote address, got %v", actual)
+	}
+	buf := make([]byte, 16)
+	n, err := res.c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "roamed" {
+		t.Errorf("Unexpected application data: %q", buf[:n])
 	}
 
-	if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false); !errors.Is(err, serverAlertError) {
-		t.Fatalf("TestPSK: Server error exp(%v) failed(%v)", serverAlertError, err)
+	if _, err = server.Write([]byte("remote")); err != nil {
+		t.Fatal(err)
+	}
+	if actual := serverConn.lastAddr(); actual != cb.RemoteAddr() {
+		t.Errorf("Expected Write to send to the remote address %v, got %v", cb.RemoteAddr(), actual)
 	}
 
-	if err := <-clientErr; !errors.Is(err, pskRejected) {
-		t.Fatalf("TestPSK: Client error exp(%v) failed(%v)", pskRejected, err)
+	if _, err = server.WriteTo([]byte("udp"), &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5684}); !errors.Is(err, errWriteAddressMismatch) {
+		t.Errorf("Expected %v for an address of another network, got %v", errWriteAddressMismatch, err)
 	}
 }
 
-// Assert that ServerKeyExchange is only sent if Identity is set on server side
-func TestPSKServerKeyExchange(t *testing.T) {
+func TestIdleTimeout(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
+	lim := test.TimeOut(5 * time.Second)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name        string
-		SetIdentity bool
-	}{
-		{
-			Name:        "Server Identity Set",
-			SetIdentity: true,
-		},
-		{
-			Name:        "Server Not Identity Set",
-			SetIdentity: false,
-		},
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const idleTimeout = 100 * time.Millisecond
+
+	client, server := pipeConnPair(t, ctx, &Config{}, &Config{
+		IdleTimeout: idleTimeout,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	// Application data keeps the connection open past the timeout.
+	buf := make([]byte, 16)
+	for i := 0; i < 5; i++ {
+		time.Sleep(idleTimeout / 2)
+		if _, err := client.Write([]byte("keepalive")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := server.Read(buf); err != nil {
+			t.Fatalf("Connection closed while application data was received: %v", err)
+		}
+	}
+
+	// Once idle, the server closes the connection and tells the client.
+	lastData := time.Now()
+	if _, err := server.Read(buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected %v from the idle server, got %v", io.EOF, err)
+	}
+	if idle := time.Since(lastData); idle < idleTimeout {
+		t.Errorf("Connection closed after %v, before the idle timeout of %v", idle, idleTimeout)
+	}
+	if _, err := client.Read(buf); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected %v after close_notify, got %v", io.EOF, err)
+	}
+	if err := server.Close(); err != nil {
+		t.Errorf("Close after the idle timeout: %v", err)
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	for name, enableHeartbeat := range map[string]bool{
+		"Heartbeat":       true,
+		"ApplicationData": false,
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
+		enableHeartbeat := enableHeartbeat
+		t.Run(name, func(t *testing.T) {
+			// Limit runtime in case of deadlocks
+			lim := test.TimeOut(5 * time.Second)
+			defer lim.Stop()
+
+			// Check for leaking routines
+			report := test.CheckRoutines(t)
+			defer report()
+
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
-			gotServerKeyExchange := false
 
-			clientErr := make(chan error, 1)
-			ca, cb := dpipe.Pipe()
-			cbAnalyzer := &connWithCallback{Conn: cb}
-			cbAnalyzer.onWrite = func(in []byte) {
-				messages, err := recordlayer.UnpackDatagram(in)
-				if err != nil {
-					t.Fatal(err)
-				}
+			const keepAlive = 40 * time.Millisecond
 
-				for i := range messages {
-					h := &handshake.Handshake{}
-					_ = h.Unmarshal(messages[i][recordlayer.FixedHeaderSize:])
+			client, server := pipeConnPair(t, ctx, &Config{
+				EnableHeartbeat: enableHeartbeat,
+				KeepAlive:       keepAlive,
+			}, &Config{
+				EnableHeartbeat: enableHeartbeat,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
 
-					if h.Header.Type == handshake.TypeServerKeyExchange {
-						gotServerKeyExchange = true
+			serverRead := make(chan int, 16)
+			go func() {
+				buf := make([]byte, 16)
+				for {
+					n, err := server.Read(buf)
+					if err != nil {
+						close(serverRead)
+						return
 					}
+					serverRead <- n
 				}
-			}
+			}()
 
-			go func() {
-				conf := &Config{
-					PSK: func([]byte) ([]byte, error) {
-						return []byte{0xAB, 0xC1, 0x23}, nil
-					},
-					PSKIdentityHint: []byte{0xAB, 0xC1, 0x23},
-					CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			// Application writes make keepalives unnecessary.
+			for i := 0; i < 5; i++ {
+				if _, err := client.Write([]byte("data")); err != nil {
+					t.Fatal(err)
 				}
-
-				if client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false); err != nil {
-					clientErr <- err
-				} else {
-					clientErr <- client.Close() //nolint
+				if n := <-serverRead; n != 4 {
+					t.Errorf("Expected 4 bytes of application data, got %d", n)
 				}
-			}()
-
-			config := &Config{
-				PSK: func([]byte) ([]byte, error) {
-					return []byte{0xAB, 0xC1, 0x23}, nil
-				},
-				CipherSuites: []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+				time.Sleep(keepAlive / 4)
 			}
-			if test.SetIdentity {
-				config.PSKIdentityHint = []byte{0xAB, 0xC1, 0x23}
+			if n := client.Stats().KeepAlivesSent; n != 0 {
+				t.Errorf("Expected no keepalives while writing, got %d", n)
 			}
 
-			if server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cbAnalyzer), cbAnalyzer.RemoteAddr(), config, false); err != nil {
-				t.Fatalf("TestPSK: Server error %v", err)
+			time.Sleep(keepAlive * 4)
+			sent := client.Stats().KeepAlivesSent
+			if sent < 2 {
+				t.Fatalf("Expected periodic keepalives while idle, got %d", sent)
+			}
+			if enableHeartbeat {
+				// The server answers the HeartbeatRequests and Read sees nothing.
+				select {
+				case n := <-serverRead:
+					t.Errorf("Unexpected read of %d bytes", n)
+				default:
+				}
 			} else {
-				if err = server.Close(); err != nil {
-					t.Fatal(err)
+				for i := uint64(0); i < sent; i++ {
+					if n := <-serverRead; n != 0 {
+						t.Errorf("Expected an empty record, got %d bytes", n)
+					}
 				}
 			}
 
-			if err := <-clientErr; err != nil {
-				t.Fatalf("TestPSK: Client error %v", err)
+			// Closing stops the keepalives.
+			if err := client.Close(); err != nil {
+				t.Fatal(err)
 			}
-
-			if gotServerKeyExchange != test.SetIdentity {
-				t.Fatalf("Mismatch between setting Identity and getting a ServerKeyExchange exp(%t) actual(%t)", test.SetIdentity, gotServerKeyExchange)
+			sent = client.Stats().KeepAlivesSent
+			time.Sleep(keepAlive * 2)
+			if n := client.Stats().KeepAlivesSent; n != sent {
+				t.Errorf("Expected no keepalives after Close, got %d", n-sent)
 			}
 		})
 	}
 }
 
-func TestClientTimeout(t *testing.T) {
+func TestSequenceNumberWarning(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
+	lim := test.TimeOut(5 * time.Second)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	clientErr := make(chan error, 1)
+	const threshold = 8
 
-	ca, _ := dpipe.Pipe()
-	go func() {
-		conf := &Config{}
+	type warning struct {
+		epoch uint16
+		seq   uint64
+	}
+	var warningsLock sync.Mutex
+	var warnings []warning
+
+	client, server := pipeConnPair(t, ctx, &Config{
+		SequenceNumberWarnThreshold: threshold,
+		OnSequenceNumberWarning: func(epoch uint16, seq uint64) {
+			warningsLock.Lock()
+			defer warningsLock.Unlock()
+			if epoch == 1 {
+				warnings = append(warnings, warning{epoch, seq})
+			}
+		},
+	}, &Config{})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-		c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, true)
-		if err == nil {
-			_ = c.Close() //nolint:contextcheck
+	buf := make([]byte, 16)
+	for i := 0; i < 2*threshold; i++ {
+		if _, err := client.Write([]byte("data")); err != nil {
+			t.Fatal(err)
 		}
-		clientErr <- err
+		if _, err := server.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	warningsLock.Lock()
+	defer warningsLock.Unlock()
+	if len(warnings) != 1 || warnings[0] != (warning{1, threshold}) {
+		t.Errorf("Expected one warning for sequence number %d of epoch 1, got %v", threshold, warnings)
+	}
+}
+
+// failingWriteConn fails every write with a fatal error once fail is set.
+type failingWriteConn struct {
+	net.Conn
+	fail atomic.Value // bool
+}
+
+var errLinkDown = errors.New("link down")
+
+func (c *failingWriteConn) Write(p []byte) (int, error) {
+	if fail, _ := c.fail.Load().(bool); fail {
+		return 0, errLinkDown
+	}
+	return c.Conn.Write(p)
+}
+
+func TestTransportWriteErrorLatched(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	serverConn := &failingWriteConn{Conn: cb}
+
+	// The failure is first hit by a keepalive, not by the application.
+	client, server := connPair(t, ctx, ca, serverConn, &Config{}, &Config{
+		KeepAlive: 20 * time.Millisecond,
+	})
+	defer func() {
+		_ = server.Close()
+		_ = client.Close()
 	}()
 
-	// no server!
-	err := <-clientErr
-	var netErr net.Error
-	if !errors.As(err, &netErr) || !netErr.Timeout() {
-		t.Fatalf("Client error exp(Temporary network error) failed(%v)", err)
+	readErr := make(chan error)
+	go func() {
+		_, err := server.Read(make([]byte, 16))
+		readErr <- err
+	}()
+	serverConn.fail.Store(true)
+
+	// The blocked Read learns of the failure without a deadline.
+	select {
+	case err := <-readErr:
+		if !errors.Is(err, errTransportFailed) || !strings.Contains(err.Error(), errLinkDown.Error()) {
+			t.Errorf("Expected %v wrapping %v from Read, got %v", errTransportFailed, errLinkDown, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read was not unblocked by the failed write")
+	}
+
+	if _, err := server.Write([]byte("data")); !errors.Is(err, errTransportFailed) {
+		t.Errorf("Expected %v from Write, got %v", errTransportFailed, err)
+	}
+	if _, err := server.Read(make([]byte, 16)); !errors.Is(err, errTransportFailed) {
+		t.Errorf("Expected %v from a later Read, got %v", errTransportFailed, err)
 	}
 }
 
-func TestSRTPConfiguration(t *testing.T) {
+func TestHandshakeContext(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name            string
-		ClientSRTP      []SRTPProtectionProfile
-		ServerSRTP      []SRTPProtectionProfile
-		ExpectedProfile SRTPProtectionProfile
-		WantClientError error
-		WantServerError error
-	}{
-		{
-			Name:            "No SRTP in use",
-			ClientSRTP:      nil,
-			ServerSRTP:      nil,
-			ExpectedProfile: 0,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "SRTP both ends",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "SRTP client only",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ServerSRTP:      nil,
-			ExpectedProfile: 0,
-			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			WantServerError: errServerNoMatchingSRTPProfile,
-		},
-		{
-			Name:            "SRTP server only",
-			ClientSRTP:      nil,
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: 0,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "Multiple Suites",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-		{
-			Name:            "Multiple Suites, Client Chooses",
-			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
-			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_32, SRTP_AES128_CM_HMAC_SHA1_80},
-			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
-			WantClientError: nil,
-			WantServerError: nil,
-		},
-	} {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
+	t.Run("Completed", func(t *testing.T) {
 		ca, cb := dpipe.Pipe()
-		type result struct {
-			c   *Conn
-			err error
+		config := &Config{InsecureSkipVerify: true}
+		client, err := createConn(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if client.HandshakeComplete() {
+			t.Error("Handshake complete before it started")
+		}
+		select {
+		case <-client.HandshakeContext().Done():
+			t.Error("Handshake context done before the handshake started")
+		default:
 		}
-		c := make(chan result)
 
+		clientErr := make(chan error)
 		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ClientSRTP}, true)
-			c <- result{client, err}
+			_, err := handshakeConn(ctx, client, config, true, nil)
+			clientErr <- err
 		}()
 
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ServerSRTP}, true)
-		if !errors.Is(err, test.WantServerError) {
-			t.Errorf("TestSRTPConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true)
+		if err != nil {
+			t.Fatal(err)
 		}
-		if err == nil {
-			defer func() {
-				_ = server.Close()
-			}()
+		defer func() {
+			_ = server.Close()
+		}()
+		if err = <-clientErr; err != nil {
+			t.Fatal(err)
 		}
+		defer func() {
+			_ = client.Close()
+		}()
 
-		res := <-c
-		if res.err == nil {
-			defer func() {
-				_ = res.c.Close()
-			}()
-		}
-		if !errors.Is(res.err, test.WantClientError) {
-			t.Fatalf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
-		}
-		if res.c == nil {
-			return
+		<-client.HandshakeContext().Done()
+		if !client.HandshakeComplete() {
+			t.Error("Handshake context done, but the handshake is not complete")
 		}
+	})
 
-		actualClientSRTP, _ := res.c.SelectedSRTPProtectionProfile()
-		if actualClientSRTP != test.ExpectedProfile {
-			t.Errorf("TestSRTPConfiguration: Client SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualClientSRTP)
+	t.Run("Failed", func(t *testing.T) {
+		ca, cb := dpipe.Pipe()
+		defer func() {
+			_ = cb.Close()
+		}()
+		config := &Config{InsecureSkipVerify: true}
+		client, err := createConn(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatal(err)
 		}
 
-		actualServerSRTP, _ := server.SelectedSRTPProtectionProfile()
-		if actualServerSRTP != test.ExpectedProfile {
-			t.Errorf("TestSRTPConfiguration: Server SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualServerSRTP)
+		// Nobody answers, so the handshake times out.
+		failCtx, failCancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer failCancel()
+		if _, err = handshakeConn(failCtx, client, config, true, nil); err == nil {
+			t.Fatal("Expected the handshake to fail")
 		}
-	}
+		select {
+		case <-client.HandshakeContext().Done():
+		default:
+			t.Error("Handshake context not done after the handshake failed")
+		}
+		if client.HandshakeComplete() {
+			t.Error("Failed handshake reported as complete")
+		}
+	})
 }
 
-func TestClientCertificate(t *testing.T) {
+func TestRekey(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	srvCert, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
-	}
-	srvCAPool := x509.NewCertPool()
-	srvCertificate, err := x509.ParseCertificate(srvCert.Certificate[0])
-	if err != nil {
-		t.Fatal(err)
-	}
-	srvCAPool.AddCert(srvCertificate)
+	for name, cidGenerator := range map[string]func() []byte{
+		"NoConnectionID": nil,
+		"ConnectionID":   RandomCIDGenerator(8),
+	} {
+		cidGenerator := cidGenerator
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
 
-	cert, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
-	}
-	certificate, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		t.Fatal(err)
+			client, server := pipeConnPair(t, ctx, &Config{
+				InsecureSkipVerify:    true,
+				ConnectionIDGenerator: cidGenerator,
+			}, &Config{
+				ConnectionIDGenerator: cidGenerator,
+				AcceptRekey:           true,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			if err := server.Rekey(ctx); !errors.Is(err, errRekeyNotClient) {
+				t.Fatalf("Expected %v from the server, got %v", errRekeyNotClient, err)
+			}
+
+			exchange := func() {
+				t.Helper()
+				buf := make([]byte, 16)
+				for _, conns := range [][2]*Conn{{client, server}, {server, client}} {
+					if _, err := conns[0].Write([]byte("ping")); err != nil {
+						t.Fatal(err)
+					}
+					n, err := conns[1].Read(buf)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if string(buf[:n]) != "ping" {
+						t.Fatalf("Expected ping, got %q", buf[:n])
+					}
+				}
+			}
+			exchange()
+
+			for epoch := uint16(2); epoch <= 3; epoch++ {
+				before := client.ConnectionState()
+				if err := client.Rekey(ctx); err != nil {
+					t.Fatal(err)
+				}
+				after := client.ConnectionState()
+				if before.localRandom == after.localRandom || bytes.Equal(before.masterSecret, after.masterSecret) {
+					t.Error("Rekey did not negotiate new keys")
+				}
+				if !bytes.Equal(after.PeerCertificates[0], before.PeerCertificates[0]) {
+					t.Error("Peer certificates changed by the rekey")
+				}
+				exchange()
+
+				for _, conn := range []*Conn{client, server} {
+					if local, remote := conn.state.getLocalEpoch(), conn.state.getRemoteEpoch(); local != epoch || remote != epoch {
+						t.Errorf("Expected epoch %d after the rekey, got local %d and remote %d", epoch, local, remote)
+					}
+				}
+				if !bytes.Equal(server.ConnectionState().masterSecret, after.masterSecret) {
+					t.Error("Client and server disagree on the master secret")
+				}
+			}
+		})
 	}
-	caPool := x509.NewCertPool()
-	caPool.AddCert(certificate)
+}
 
-	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
-		tests := map[string]struct {
-			clientCfg *Config
-			serverCfg *Config
-			wantErr   bool
-		}{
-			"NoClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-				},
-			},
-			"NoClientCert_ServerVerifyConnectionFails": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-					VerifyConnection: func(*State) error {
-						return errExample
-					},
-				},
-				wantErr: true,
-			},
-			"NoClientCert_ClientVerifyConnectionFails": {
-				clientCfg: &Config{RootCAs: srvCAPool, VerifyConnection: func(*State) error {
-					return errExample
-				}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   NoClientCert,
-					ClientCAs:    caPool,
-				},
-				wantErr: true,
-			},
-			"NoClientCert_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
-				},
-			},
-			"RequestClientCert_cert_sigscheme": { // specify signature algorithm
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
-					Certificates:     []tls.Certificate{srvCert},
-					ClientAuth:       RequestClientCert,
-				},
-			},
-			"RequestClientCert_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequestClientCert,
-				},
-			},
-			"RequestClientCert_no_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequestClientCert,
-					ClientCAs:    caPool,
-				},
+func TestRekeyRefused(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, server := pipeConnPair(t, ctx, &Config{
+		InsecureSkipVerify: true,
+	}, &Config{})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	if err := client.Rekey(ctx); !errors.Is(err, errRekeyRefused) {
+		t.Fatalf("Expected %v, got %v", errRekeyRefused, err)
+	}
+	if local := server.state.getLocalEpoch(); local != 1 {
+		t.Errorf("Expected the server to stay in epoch 1, got %d", local)
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	clientBefore, serverBefore := client.Stats(), server.Stats()
+	if clientBefore.RecordsSent == 0 || serverBefore.RecordsReceived == 0 {
+		t.Errorf("Expected the handshake to be counted, got %+v and %+v", clientBefore, serverBefore)
+	}
+
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+	clientAfter, serverAfter := client.Stats(), server.Stats()
+	if n := clientAfter.RecordsSent - clientBefore.RecordsSent; n != 1 {
+		t.Errorf("Expected 1 record sent, got %d", n)
+	}
+	if n := serverAfter.RecordsReceived - serverBefore.RecordsReceived; n != 1 {
+		t.Errorf("Expected 1 record received, got %d", n)
+	}
+	sent := clientAfter.BytesSent - clientBefore.BytesSent
+	if received := serverAfter.BytesReceived - serverBefore.BytesReceived; sent == 0 || sent != received {
+		t.Errorf("Expected the bytes sent and received to match, got %d and %d", sent, received)
+	}
+
+	// A record that fails authentication is counted as a decrypt failure.
+	client.lock.Lock()
+	raw, err := client.processPacket(&packet{
+		record: &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Epoch:   client.state.getLocalEpoch(),
+				Version: protocol.Version1_2,
 			},
-			"RequireAnyClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
-				},
+			Content: &protocol.ApplicationData{
+				Data: []byte("tampered"),
 			},
-			"RequireAnyClientCert_error": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAnyClientCert,
+		},
+		shouldEncrypt: true,
+	})
+	client.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw[len(raw)-1] ^= 0xff
+	if _, _, err = server.handleIncomingPacket(context.Background(), raw, server.RemoteAddr(), true); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Stats().DecryptFailures - serverAfter.DecryptFailures; n != 1 {
+		t.Errorf("Expected 1 decrypt failure, got %d", n)
+	}
+}
+
+func TestEpochHistory(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	start := time.Now()
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	for name, c := range map[string]*Conn{"Client": client, "Server": server} {
+		history := c.EpochHistory()
+		if len(history) != 1 {
+			t.Fatalf("%s: expected 1 epoch transition, got %v", name, history)
+		}
+		if history[0].From != 0 || history[0].To != 1 {
+			t.Errorf("%s: expected a transition from epoch 0 to 1, got %d to %d", name, history[0].From, history[0].To)
+		}
+		if history[0].Time.Before(start) || history[0].Time.After(time.Now()) {
+			t.Errorf("%s: unexpected transition time %v", name, history[0].Time)
+		}
+	}
+
+	// Setting the same epoch again is not a transition, and only the most
+	// recent transitions are kept.
+	client.setRemoteEpoch(1)
+	for epoch := uint16(2); epoch < 2+maxEpochHistory; epoch++ {
+		client.setRemoteEpoch(epoch)
+	}
+	history := client.EpochHistory()
+	if len(history) != maxEpochHistory {
+		t.Fatalf("Expected %d epoch transitions, got %d", maxEpochHistory, len(history))
+	}
+	if first := history[0]; first.From != 1 || first.To != 2 {
+		t.Errorf("Expected the oldest transition to be from epoch 1 to 2, got %d to %d", first.From, first.To)
+	}
+}
+
+func TestRetainedEpochs(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	const epochs = 1000
+	for epoch := uint16(2); epoch < epochs; epoch++ {
+		client.setRemoteEpoch(epoch)
+		if client.replayDetector(epoch, epoch) == nil {
+			t.Fatalf("Expected a replay detector for the current epoch %d", epoch)
+		}
+		if client.replayDetector(epoch-maxRetainedEpochs, epoch) != nil {
+			t.Fatalf("Expected epoch %d to be expired at epoch %d", epoch-maxRetainedEpochs, epoch)
+		}
+	}
+
+	retained := 0
+	for _, d := range client.state.replayDetector {
+		if d != nil {
+			retained++
+		}
+	}
+	if retained > maxRetainedEpochs {
+		t.Errorf("Expected at most %d retained replay detectors, got %d", maxRetainedEpochs, retained)
+	}
+	if stats := client.Stats(); len(stats.Replay) != retained {
+		t.Errorf("Expected replay stats for %d epochs, got %d", retained, len(stats.Replay))
+	}
+
+	// Records of expired epochs are discarded before decryption.
+	raw, err := (&recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version: protocol.Version1_2,
+			Epoch:   1,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("expired")},
+	}).Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failures := client.Stats().DecryptFailures
+	if _, _, err := client.handleIncomingPacket(context.Background(), raw, nil, false); err != nil {
+		t.Fatal(err)
+	}
+	if client.Stats().DecryptFailures != failures {
+		t.Error("Expected the record of an expired epoch to be discarded before decryption")
+	}
+
+	// Only the sequence numbers of the local epoch and the one before it
+	// are kept, and those of a new epoch start at zero.
+	for epoch := uint16(2); epoch < epochs; epoch++ {
+		client.setLocalEpoch(epoch)
+		if seq, err := client.nextSequenceNumber(epoch); err != nil || seq != 0 {
+			t.Fatalf("Expected sequence number 0 in epoch %d, got %d (%v)", epoch, seq, err)
+		}
+		if _, err := client.nextSequenceNumber(epoch - 1); err != nil {
+			t.Fatalf("Expected the sequence numbers of epoch %d to be kept at epoch %d: %v", epoch-1, epoch, err)
+		}
+		if _, err := client.nextSequenceNumber(epoch - maxRetainedEpochs); !errors.Is(err, errEpochNotRetained) {
+			t.Fatalf("Expected %v for epoch %d at epoch %d, got %v", errEpochNotRetained, epoch-maxRetainedEpochs, epoch, err)
+		}
+		if _, err := client.nextSequenceNumber(epoch + 1); !errors.Is(err, errEpochNotRetained) {
+			t.Fatalf("Expected %v for epoch %d at epoch %d, got %v", errEpochNotRetained, epoch+1, epoch, err)
+		}
+	}
+}
+
+func TestSequenceNumberOverflow(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("ApplicationData", func(t *testing.T) {
+		ca, cb, err := pipeMemory()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		atomic.StoreUint64(&ca.state.localSequenceNumber[1], recordlayer.MaxSequenceNumber)
+		if _, werr := ca.Write(make([]byte, 100)); werr != nil {
+			t.Errorf("Write must send message with maximum sequence number, but errord: %v", werr)
+		}
+		if _, werr := ca.Write(make([]byte, 100)); !errors.Is(werr, errSequenceNumberOverflow) {
+			t.Errorf("Write must abandonsend message with maximum sequence number, but errord: %v", werr)
+		}
+
+		if err := ca.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := cb.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Handshake", func(t *testing.T) {
+		ca, cb, err := pipeMemory()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		atomic.StoreUint64(&ca.state.localSequenceNumber[0], recordlayer.MaxSequenceNumber+1)
+
+		// Try to send handshake packet.
+		if werr := ca.writePackets(ctx, []*packet{
+			{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Version: protocol.Version1_2,
+					},
+					Content: &handshake.Handshake{
+						Message: &handshake.MessageClientHello{
+							Version:            protocol.Version1_2,
+							Cookie:             make([]byte, 64),
+							CipherSuiteIDs:     cipherSuiteIDs(defaultCipherSuites()),
+							CompressionMethods: defaultCompressionMethods(),
+						},
+					},
 				},
-				wantErr: true,
 			},
-			"VerifyClientCertIfGiven_no_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-					ClientCAs:    caPool,
-				},
+		}); !errors.Is(werr, errSequenceNumberOverflow) {
+			t.Errorf("Connection must fail on handshake packet reaches maximum sequence number")
+		}
+
+		if err := ca.Close(); err != nil {
+			t.Error(err)
+		}
+		if err := cb.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func pipeMemory() (*Conn, *Conn, error) {
+	// In memory pipe
+	ca, cb := dpipe.Pipe()
+	return pipeConn(ca, cb)
+}
+
+func pipeConn(ca, cb net.Conn) (*Conn, *Conn, error) {
+	type result struct {
+		c   *Conn
+		err error
+	}
+
+	c := make(chan result)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Setup client
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}}, true)
+		c <- result{client, err}
+	}()
+
+	// Setup server
+	server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80}}, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Receive client
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		return nil, nil, res.err
+	}
+
+	return res.c, server, nil
+}
+
+// pipeConnPair connects a client and a server configured with clientCfg and
+// serverCfg over an in-memory pipe, and fails the test unless both
+// handshakes succeed. The caller closes both connections.
+func pipeConnPair(t *testing.T, ctx context.Context, clientCfg, serverCfg *Config) (*Conn, *Conn) { //nolint:revive
+	t.Helper()
+
+	ca, cb := dpipe.Pipe()
+	return connPair(t, ctx, ca, cb, clientCfg, serverCfg)
+}
+
+// connPair is like pipeConnPair over ca and cb, the two ends of a pipe that
+// the caller may wrap.
+func connPair(t *testing.T, ctx context.Context, ca, cb net.Conn, clientCfg, serverCfg *Config) (*Conn, *Conn) { //nolint:revive
+	t.Helper()
+
+	client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, clientCfg, serverCfg)
+	if clientErr != nil || serverErr != nil {
+		if server != nil {
+			_ = server.Close()
+		}
+		if client != nil {
+			_ = client.Close()
+		}
+		t.Fatalf("Handshake failed: server: %v, client: %v", serverErr, clientErr)
+	}
+	return client, server
+}
+
+// handshakePair runs the handshakes of a client configured with clientCfg
+// over ca and of a server configured with serverCfg over cb, the two ends of
+// a pipe, and returns each connection or the error its handshake failed
+// with. A side whose Config has neither certificates nor a PSK gets a
+// self-signed certificate. The caller closes the connections.
+func handshakePair(ctx context.Context, ca, cb net.Conn, clientCfg, serverCfg *Config) (client, server *Conn, clientErr, serverErr error) {
+	type result struct {
+		c   *Conn
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), clientCfg, needsTestCertificate(clientCfg))
+		c <- result{client, err}
+	}()
+
+	server, serverErr = testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), serverCfg, needsTestCertificate(serverCfg))
+	res := <-c
+	return res.c, server, res.err, serverErr
+}
+
+func needsTestCertificate(cfg *Config) bool {
+	return len(cfg.Certificates) == 0 && cfg.GetCertificate == nil && cfg.GetClientCertificate == nil && cfg.PSK == nil
+}
+
+func testClient(ctx context.Context, c net.PacketConn, rAddr net.Addr, cfg *Config, generateCertificate bool) (*Conn, error) {
+	if generateCertificate {
+		clientCert, err := selfsign.GenerateSelfSigned()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{clientCert}
+	}
+	cfg.InsecureSkipVerify = true
+	return ClientWithContext(ctx, c, rAddr, cfg)
+}
+
+func testServer(ctx context.Context, c net.PacketConn, rAddr net.Addr, cfg *Config, generateCertificate bool) (*Conn, error) {
+	if generateCertificate {
+		serverCert, err := selfsign.GenerateSelfSigned()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{serverCert}
+	}
+	return ServerWithContext(ctx, c, rAddr, cfg)
+}
+
+func sendClientHello(cookie []byte, ca net.Conn, sequenceNumber uint64, extensions []extension.Extension) error {
+	packet, err := (&recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version:        protocol.Version1_2,
+			SequenceNumber: sequenceNumber,
+		},
+		Content: &handshake.Handshake{
+			Header: handshake.Header{
+				MessageSequence: uint16(sequenceNumber),
 			},
-			"VerifyClientCertIfGiven_cert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-					ClientCAs:    caPool,
-				},
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				Cookie:             cookie,
+				CipherSuiteIDs:     cipherSuiteIDs(defaultCipherSuites()),
+				CompressionMethods: defaultCompressionMethods(),
+				Extensions:         extensions,
 			},
-			"VerifyClientCertIfGiven_error": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   VerifyClientCertIfGiven,
-				},
-				wantErr: true,
+		},
+	}).Marshal()
+	if err != nil {
+		return err
+	}
+
+	if _, err = ca.Write(packet); err != nil {
+		return err
+	}
+	return nil
+}
+
+func TestHandshakeWithAlert(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cases := map[string]struct {
+		configServer, configClient *Config
+		errServer, errClient       error
+	}{
+		"CipherSuiteNoIntersection": {
+			configServer: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
 			},
-			"RequireAndVerifyClientCert": {
-				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}, VerifyConnection: func(s *State) error {
-					if ok := bytes.Equal(s.PeerCertificates[0], srvCertificate.Raw); !ok {
-						return errExample
+			configClient: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			},
+			errServer: errCipherSuiteNoIntersection,
+			errClient: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+		},
+		"SignatureSchemesNoIntersection": {
+			configServer: &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			},
+			configClient: &Config{
+				CipherSuites:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
+			},
+			errServer: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			errClient: errNoAvailableSignatureSchemes,
+		},
+	}
+
+	for name, testCase := range cases {
+		testCase := testCase
+		t.Run(name, func(t *testing.T) {
+			clientErr := make(chan error, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), testCase.configClient, true)
+				clientErr <- err
+			}()
+
+			_, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), testCase.configServer, true)
+			if !errors.Is(errServer, testCase.errServer) {
+				t.Fatalf("Server error exp(%v) failed(%v)", testCase.errServer, errServer)
+			}
+
+			errClient := <-clientErr
+			if !errors.Is(errClient, testCase.errClient) {
+				t.Fatalf("Client error exp(%v) failed(%v)", testCase.errClient, errClient)
+			}
+
+			// The side that received the alert must expose it on the
+			// HandshakeError.
+			for _, err := range []error{errServer, errClient} {
+				var hsErr *HandshakeError
+				if !errors.As(err, &hsErr) {
+					continue
+				}
+				var expected *alertError
+				if !errors.As(err, &expected) {
+					if hsErr.Alert != nil {
+						t.Errorf("Unexpected alert on HandshakeError: %v", hsErr.Alert)
 					}
-					return nil
-				}},
-				serverCfg: &Config{
-					Certificates: []tls.Certificate{srvCert},
-					ClientAuth:   RequireAndVerifyClientCert,
-					ClientCAs:    caPool,
-					VerifyConnection: func(s *State) error {
-						if ok := bytes.Equal(s.PeerCertificates[0], certificate.Raw); !ok {
-							return errExample
+					continue
+				}
+				if hsErr.Alert == nil || *hsErr.Alert != *expected.Alert {
+					t.Errorf("HandshakeError alert exp(%v) failed(%v)", expected.Alert, hsErr.Alert)
+				}
+			}
+		})
+	}
+}
+
+func TestOnAlert(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type observedAlert struct {
+		level alert.Level
+		desc  alert.Description
+		sent  bool
+	}
+	observer := func(observed chan observedAlert) func(alert.Level, alert.Description, bool) {
+		return func(level alert.Level, desc alert.Description, sent bool) {
+			observed <- observedAlert{level, desc, sent}
+		}
+	}
+	clientAlerts := make(chan observedAlert, 8)
+	serverAlerts := make(chan observedAlert, 8)
+
+	ca, cb := dpipe.Pipe()
+	_, _, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		OnAlert:      observer(clientAlerts),
+	}, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		OnAlert:      observer(serverAlerts),
+	})
+	if !errors.Is(serverErr, errCipherSuiteNoIntersection) {
+		t.Fatalf("Server error exp(%v) failed(%v)", errCipherSuiteNoIntersection, serverErr)
+	}
+	if clientErr == nil {
+		t.Fatal("Client handshake must fail")
+	}
+
+	expectedServer := observedAlert{alert.Fatal, alert.InsufficientSecurity, true}
+	if actual := <-serverAlerts; actual != expectedServer {
+		t.Errorf("Server alert mismatch\nwant: %+v\ngot: %+v", expectedServer, actual)
+	}
+	expectedClient := observedAlert{alert.Fatal, alert.InsufficientSecurity, false}
+	if actual := <-clientAlerts; actual != expectedClient {
+		t.Errorf("Client alert mismatch\nwant: %+v\ngot: %+v", expectedClient, actual)
+	}
+}
+
+func TestMaxWarningAlerts(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	const maxWarningAlerts = 2
+	clientAlerts := make(chan alert.Alert, 8)
+
+	client, server := pipeConnPair(t, ctx, &Config{
+		OnAlert: func(level alert.Level, desc alert.Description, sent bool) {
+			if !sent {
+				clientAlerts <- alert.Alert{Level: level, Description: desc}
+			}
+		},
+	}, &Config{
+		MaxWarningAlerts: maxWarningAlerts,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	for i := 0; i <= maxWarningAlerts; i++ {
+		if err := client.notify(ctx, alert.Warning, alert.UserCanceled, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	warning := &alertError{&alert.Alert{Level: alert.Warning, Description: alert.UserCanceled}}
+	buf := make([]byte, 1024)
+	for i := 0; i < maxWarningAlerts; i++ {
+		if _, err := server.Read(buf); !errors.Is(err, warning) {
+			t.Fatalf("Expected warning alert %d to reach Read, got %v", i+1, err)
+		}
+	}
+	if _, err := server.Read(buf); !errors.Is(err, io.EOF) {
+		t.Fatalf("Expected the server to close after %d warning alerts, got %v", maxWarningAlerts, err)
+	}
+	if _, err := server.Write([]byte("data")); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("Expected Write after closing to fail with %v, got %v", ErrConnClosed, err)
+	}
+
+	expected := alert.Alert{Level: alert.Fatal, Description: alert.UnexpectedMessage}
+	if actual := <-clientAlerts; actual != expected {
+		t.Errorf("Client alert mismatch\nwant: %+v\ngot: %+v", expected, actual)
+	}
+}
+
+func TestSentAlerts(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	t.Run("CloseNotify", func(t *testing.T) {
+		client, server := pipeConnPair(t, ctx, &Config{}, &Config{})
+		defer func() {
+			_ = server.Close()
+		}()
+
+		if sent := client.SentAlerts(); len(sent) != 0 {
+			t.Errorf("Expected no alerts after the handshake, got %+v", sent)
+		}
+		if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+		expected := []AlertRecord{{Level: alert.Warning, Description: alert.CloseNotify}}
+		if actual := client.SentAlerts(); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Sent alerts mismatch\nwant: %+v\ngot: %+v", expected, actual)
+		}
+	})
+
+	t.Run("Fatal", func(t *testing.T) {
+		client, server := pipeConnPair(t, ctx, &Config{}, &Config{MaxWarningAlerts: 1})
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
+
+		// The second warning makes the server close with a fatal alert.
+		for i := 0; i < 2; i++ {
+			if err := client.notify(ctx, alert.Warning, alert.UserCanceled, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		buf := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buf); errors.Is(err, io.EOF) {
+				break
+			}
+		}
+
+		sent := server.SentAlerts()
+		if len(sent) != 1 {
+			t.Fatalf("Expected the server to send one alert, got %+v", sent)
+		}
+		if a := sent[0]; a.Level != alert.Fatal || a.Description != alert.UnexpectedMessage || !errors.Is(a.Reason, errTooManyWarningAlerts) {
+			t.Errorf("Unexpected fatal alert record: %+v", a)
+		}
+	})
+}
+
+func TestOnHandshakeStep(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	var mu sync.Mutex
+	var clientSteps, serverSteps []HandshakeStep
+	recorder := func(steps *[]HandshakeStep) func(HandshakeStep) {
+		return func(s HandshakeStep) {
+			mu.Lock()
+			defer mu.Unlock()
+			*steps = append(*steps, s)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConnPair(t, ctx, &Config{
+		OnHandshakeStep: recorder(&clientSteps),
+	}, &Config{
+		OnHandshakeStep: recorder(&serverSteps),
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for name, tt := range map[string]struct {
+		steps       []HandshakeStep
+		first, last HandshakeStep
+		via         string
+	}{
+		"Client": {clientSteps, HandshakeStep{"Flight 1", "Preparing"}, HandshakeStep{"Flight 5", "Finished"}, "Flight 3"},
+		"Server": {serverSteps, HandshakeStep{"Flight 0", "Preparing"}, HandshakeStep{"Flight 6", "Finished"}, "Flight 4"},
+	} {
+		if len(tt.steps) == 0 {
+			t.Errorf("%s: no handshake steps observed", name)
+			continue
+		}
+		if tt.steps[0] != tt.first {
+			t.Errorf("%s: first step mismatch\nwant: %v %v\ngot: %v %v", name, tt.first.Flight, tt.first.State, tt.steps[0].Flight, tt.steps[0].State)
+		}
+		if actual := tt.steps[len(tt.steps)-1]; actual != tt.last {
+			t.Errorf("%s: last step mismatch\nwant: %v %v\ngot: %v %v", name, tt.last.Flight, tt.last.State, actual.Flight, actual.State)
+		}
+		var passed bool
+		for _, s := range tt.steps {
+			if s == (HandshakeStep{tt.via, "Waiting"}) {
+				passed = true
+			}
+		}
+		if !passed {
+			t.Errorf("%s: handshake did not wait in %v", name, tt.via)
+		}
+	}
+}
+
+func TestHandshakeWithInvalidRecord(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	type result struct {
+		c   *Conn
+		err error
+	}
+	clientErr := make(chan result, 1)
+	ca, cb := dpipe.Pipe()
+	caWithInvalidRecord := &connWithCallback{Conn: ca}
+
+	var msgSeq atomic.Int32
+	// Send invalid record after first message
+	caWithInvalidRecord.onWrite = func([]byte) {
+		if msgSeq.Add(1) == 2 {
+			if _, err := ca.Write([]byte{0x01, 0x02}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	go func() {
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(caWithInvalidRecord), caWithInvalidRecord.RemoteAddr(), &Config{
+			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}, true)
+		clientErr <- result{client, err}
+	}()
+
+	server, errServer := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}, true)
+
+	errClient := <-clientErr
+
+	defer func() {
+		if server != nil {
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if errClient.c != nil {
+			if err := errClient.c.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}()
+
+	if errServer != nil {
+		t.Fatalf("Server failed(%v)", errServer)
+	}
+
+	if errClient.err != nil {
+		t.Fatalf("Client failed(%v)", errClient.err)
+	}
+}
+
+func TestExportKeyingMaterial(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	var rand [28]byte
+	exportLabel := "EXTRACTOR-dtls_srtp"
+
+	expectedServerKey := []byte{0x61, 0x09, 0x9d, 0x7d, 0xcb, 0x08, 0x52, 0x2c, 0xe7, 0x7b}
+	expectedClientKey := []byte{0x87, 0xf0, 0x40, 0x02, 0xf6, 0x1c, 0xf1, 0xfe, 0x8c, 0x77}
+
+	c := &Conn{
+		state: State{
+			handshakeResult: handshakeResult{
+				localRandom:  handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand},
+				remoteRandom: handshake.Random{GMTUnixTime: time.Unix(1000, 0), RandomBytes: rand},
+				cipherSuite:  &ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+			},
+		},
+	}
+	c.setLocalEpoch(0)
+	c.setRemoteEpoch(0)
+
+	state := c.ConnectionState()
+	_, err := state.ExportKeyingMaterial(exportLabel, nil, 0)
+	if !errors.Is(err, errHandshakeInProgress) {
+		t.Errorf("ExportKeyingMaterial when epoch == 0: expected '%s' actual '%s'", errHandshakeInProgress, err)
+	}
+
+	c.setLocalEpoch(1)
+	state = c.ConnectionState()
+	_, err = state.ExportKeyingMaterial(exportLabel, []byte{0x00}, 0)
+	if !errors.Is(err, errContextUnsupported) {
+		t.Errorf("ExportKeyingMaterial with context: expected '%s' actual '%s'", errContextUnsupported, err)
+	}
+
+	for k := range invalidKeyingLabels() {
+		state = c.ConnectionState()
+		_, err = state.ExportKeyingMaterial(k, nil, 0)
+		if !errors.Is(err, errReservedExportKeyingMaterial) {
+			t.Errorf("ExportKeyingMaterial reserved label: expected '%s' actual '%s'", errReservedExportKeyingMaterial, err)
+		}
+	}
+
+	state = c.ConnectionState()
+	keyingMaterial, err := state.ExportKeyingMaterial(exportLabel, nil, 10)
+	if err != nil {
+		t.Errorf("ExportKeyingMaterial as server: unexpected error '%s'", err)
+	} else if !bytes.Equal(keyingMaterial, expectedServerKey) {
+		t.Errorf("ExportKeyingMaterial client export: expected (% 02x) actual (% 02x)", expectedServerKey, keyingMaterial)
+	}
+
+	c.state.isClient = true
+	state = c.ConnectionState()
+	keyingMaterial, err = state.ExportKeyingMaterial(exportLabel, nil, 10)
+	if err != nil {
+		t.Errorf("ExportKeyingMaterial as server: unexpected error '%s'", err)
+	} else if !bytes.Equal(keyingMaterial, expectedClientKey) {
+		t.Errorf("ExportKeyingMaterial client export: expected (% 02x) actual (% 02x)", expectedClientKey, keyingMaterial)
+	}
+}
+
+func TestPSK(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                   string
+		ClientIdentity         []byte
+		ServerIdentity         []byte
+		CipherSuites           []CipherSuiteID
+		ClientVerifyConnection func(*State) error
+		ServerVerifyConnection func(*State) error
+		WantFail               bool
+		ExpectedServerErr      string
+		ExpectedClientErr      string
+	}{
+		{
+			Name:           "Server identity specified",
+			ServerIdentity: []byte("Test Identity"),
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		},
+		{
+			Name:           "Server identity specified - Server verify connection fails",
+			ServerIdentity: []byte("Test Identity"),
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			ServerVerifyConnection: func(*State) error {
+				return errExample
+			},
+			WantFail:          true,
+			ExpectedServerErr: errExample.Error(),
+			ExpectedClientErr: alert.BadCertificate.String(),
+		},
+		{
+			Name:           "Server identity specified - Client verify connection fails",
+			ServerIdentity: []byte("Test Identity"),
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			ClientVerifyConnection: func(*State) error {
+				return errExample
+			},
+			WantFail:          true,
+			ExpectedServerErr: alert.BadCertificate.String(),
+			ExpectedClientErr: errExample.Error(),
+		},
+		{
+			Name:           "Server identity nil",
+			ServerIdentity: nil,
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		},
+		{
+			Name:           "TLS_PSK_WITH_AES_128_CBC_SHA256",
+			ServerIdentity: nil,
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CBC_SHA256},
+		},
+		{
+			Name:           "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256",
+			ServerIdentity: nil,
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256},
+		},
+		{
+			Name:           "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256",
+			ServerIdentity: nil,
+			ClientIdentity: []byte("Client Identity"),
+			CipherSuites:   []CipherSuiteID{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		},
+		{
+			Name:           "Client identity empty",
+			ServerIdentity: nil,
+			ClientIdentity: []byte{},
+			CipherSuites:   []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			type result struct {
+				c   *Conn
+				err error
+			}
+			clientRes := make(chan result, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					PSK: func(hint []byte) ([]byte, error) {
+						if !bytes.Equal(test.ServerIdentity, hint) {
+							return nil, fmt.Errorf("TestPSK: Client got invalid identity expected(% 02x) actual(% 02x)", test.ServerIdentity, hint) //nolint:goerr113
+						}
+
+						return []byte{0xAB, 0xC1, 0x23}, nil
+					},
+					PSKIdentityHint:  test.ClientIdentity,
+					CipherSuites:     test.CipherSuites,
+					VerifyConnection: test.ClientVerifyConnection,
+				}
+
+				c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+				clientRes <- result{c, err}
+			}()
+
+			config := &Config{
+				PSK: func(hint []byte) ([]byte, error) {
+					fmt.Println(hint)
+					if !bytes.Equal(test.ClientIdentity, hint) {
+						return nil, fmt.Errorf("%w: expected(% 02x) actual(% 02x)", errTestPSKInvalidIdentity, test.ClientIdentity, hint)
+					}
+					return []byte{0xAB, 0xC1, 0x23}, nil
+				},
+				PSKIdentityHint:  test.ServerIdentity,
+				CipherSuites:     test.CipherSuites,
+				VerifyConnection: test.ServerVerifyConnection,
+			}
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false)
+			if test.WantFail {
+				res := <-clientRes
+				if err == nil || !strings.Contains(err.Error(), test.ExpectedServerErr) {
+					t.Fatalf("TestPSK: Server expected(%v) actual(%v)", test.ExpectedServerErr, err)
+				}
+				if res.err == nil || !strings.Contains(res.err.Error(), test.ExpectedClientErr) {
+					t.Fatalf("TestPSK: Client expected(%v) actual(%v)", test.ExpectedClientErr, res.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TestPSK: Server failed(%v)", err)
+			}
+
+			actualPSKIdentityHint := server.ConnectionState().IdentityHint
+			if !bytes.Equal(actualPSKIdentityHint, test.ClientIdentity) {
+				t.Errorf("TestPSK: Server ClientPSKIdentity Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ClientIdentity, actualPSKIdentityHint)
+			}
+
+			defer func() {
+				_ = server.Close()
+			}()
+
+			res := <-clientRes
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			_ = res.c.Close()
+		})
+	}
+}
+
+func TestPSKHintFail(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverAlertError := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InternalError}}
+	pskRejected := errPSKRejected
+
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	clientErr := make(chan error, 1)
+
+	ca, cb := dpipe.Pipe()
+	go func() {
+		conf := &Config{
+			PSK: func([]byte) ([]byte, error) {
+				return nil, pskRejected
+			},
+			PSKIdentityHint: []byte{},
+			CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+		}
+
+		_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+		clientErr <- err
+	}()
+
+	config := &Config{
+		PSK: func([]byte) ([]byte, error) {
+			return nil, pskRejected
+		},
+		PSKIdentityHint: []byte{},
+		CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+	}
+
+	if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, false); !errors.Is(err, serverAlertError) {
+		t.Fatalf("TestPSK: Server error exp(%v) failed(%v)", serverAlertError, err)
+	}
+
+	if err := <-clientErr; !errors.Is(err, pskRejected) {
+		t.Fatalf("TestPSK: Client error exp(%v) failed(%v)", pskRejected, err)
+	}
+}
+
+func TestPSKGetIdentityHint(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	hints := map[string][]byte{
+		"a.example.com": []byte("Hint A"),
+		"b.example.com": []byte("Hint B"),
+	}
+
+	for serverName, expectedHint := range hints {
+		serverName, expectedHint := serverName, expectedHint
+		t.Run(serverName, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var hint []byte
+			client, server := pipeConnPair(t, ctx, &Config{
+				PSK: func(h []byte) ([]byte, error) {
+					hint = h
+					return []byte{0xAB, 0xC1, 0x23}, nil
+				},
+				PSKIdentityHint: []byte("Client Identity"),
+				CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+				ServerName:      serverName,
+			}, &Config{
+				PSK: func([]byte) ([]byte, error) {
+					return []byte{0xAB, 0xC1, 0x23}, nil
+				},
+				PSKIdentityHint: []byte("Static Hint"),
+				GetPSKIdentityHint: func(info *ClientHelloInfo) ([]byte, error) {
+					return hints[info.ServerName], nil
+				},
+				CipherSuites: []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			if !bytes.Equal(hint, expectedHint) {
+				t.Errorf("TestPSKGetIdentityHint: Client got hint %q, expected %q", hint, expectedHint)
+			}
+		})
+	}
+}
+
+// Assert that ServerKeyExchange is only sent if Identity is set on server side
+func TestPSKServerKeyExchange(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name        string
+		SetIdentity bool
+	}{
+		{
+			Name:        "Server Identity Set",
+			SetIdentity: true,
+		},
+		{
+			Name:        "Server Not Identity Set",
+			SetIdentity: false,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			gotServerKeyExchange := false
+
+			clientErr := make(chan error, 1)
+			ca, cb := dpipe.Pipe()
+			cbAnalyzer := &connWithCallback{Conn: cb}
+			cbAnalyzer.onWrite = func(in []byte) {
+				messages, err := recordlayer.UnpackDatagram(in)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				for i := range messages {
+					h := &handshake.Handshake{}
+					_ = h.Unmarshal(messages[i][recordlayer.FixedHeaderSize:])
+
+					if h.Header.Type == handshake.TypeServerKeyExchange {
+						gotServerKeyExchange = true
+					}
+				}
+			}
+
+			go func() {
+				conf := &Config{
+					PSK: func([]byte) ([]byte, error) {
+						return []byte{0xAB, 0xC1, 0x23}, nil
+					},
+					PSKIdentityHint: []byte{0xAB, 0xC1, 0x23},
+					CipherSuites:    []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+				}
+
+				if client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false); err != nil {
+					clientErr <- err
+				} else {
+					clientErr <- client.Close() //nolint
+				}
+			}()
+
+			config := &Config{
+				PSK: func([]byte) ([]byte, error) {
+					return []byte{0xAB, 0xC1, 0x23}, nil
+				},
+				CipherSuites: []CipherSuiteID{TLS_PSK_WITH_AES_128_CCM_8},
+			}
+			if test.SetIdentity {
+				config.PSKIdentityHint = []byte{0xAB, 0xC1, 0x23}
+			}
+
+			if server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cbAnalyzer), cbAnalyzer.RemoteAddr(), config, false); err != nil {
+				t.Fatalf("TestPSK: Server error %v", err)
+			} else {
+				if err = server.Close(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := <-clientErr; err != nil {
+				t.Fatalf("TestPSK: Client error %v", err)
+			}
+
+			if gotServerKeyExchange != test.SetIdentity {
+				t.Fatalf("Mismatch between setting Identity and getting a ServerKeyExchange exp(%t) actual(%t)", test.SetIdentity, gotServerKeyExchange)
+			}
+		})
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	clientErr := make(chan error, 1)
+
+	ca, _ := dpipe.Pipe()
+	go func() {
+		conf := &Config{}
+
+		c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, true)
+		if err == nil {
+			_ = c.Close() //nolint:contextcheck
+		}
+		clientErr <- err
+	}()
+
+	// no server!
+	err := <-clientErr
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Client error exp(Temporary network error) failed(%v)", err)
+	}
+}
+
+func TestSRTPConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name            string
+		ClientSRTP      []SRTPProtectionProfile
+		ServerSRTP      []SRTPProtectionProfile
+		ExpectedProfile SRTPProtectionProfile
+		WantClientError error
+		WantServerError error
+	}{
+		{
+			Name:            "No SRTP in use",
+			ClientSRTP:      nil,
+			ServerSRTP:      nil,
+			ExpectedProfile: 0,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "SRTP both ends",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "SRTP client only",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ServerSRTP:      nil,
+			ExpectedProfile: 0,
+			WantClientError: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			WantServerError: errServerNoMatchingSRTPProfile,
+		},
+		{
+			Name:            "SRTP server only",
+			ClientSRTP:      nil,
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: 0,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "Multiple Suites",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+		{
+			Name:            "Multiple Suites, Client Chooses",
+			ClientSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_80, SRTP_AES128_CM_HMAC_SHA1_32},
+			ServerSRTP:      []SRTPProtectionProfile{SRTP_AES128_CM_HMAC_SHA1_32, SRTP_AES128_CM_HMAC_SHA1_80},
+			ExpectedProfile: SRTP_AES128_CM_HMAC_SHA1_80,
+			WantClientError: nil,
+			WantServerError: nil,
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ClientSRTP}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{SRTPProtectionProfiles: test.ServerSRTP}, true)
+		if !errors.Is(err, test.WantServerError) {
+			t.Errorf("TestSRTPConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+		}
+		if err == nil {
+			defer func() {
+				_ = server.Close()
+			}()
+		}
+
+		res := <-c
+		if res.err == nil {
+			defer func() {
+				_ = res.c.Close()
+			}()
+		}
+		if !errors.Is(res.err, test.WantClientError) {
+			t.Fatalf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+		}
+		if res.c == nil {
+			return
+		}
+
+		actualClientSRTP, _ := res.c.SelectedSRTPProtectionProfile()
+		if actualClientSRTP != test.ExpectedProfile {
+			t.Errorf("TestSRTPConfiguration: Client SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualClientSRTP)
+		}
+
+		actualServerSRTP, _ := server.SelectedSRTPProtectionProfile()
+		if actualServerSRTP != test.ExpectedProfile {
+			t.Errorf("TestSRTPConfiguration: Server SRTPProtectionProfile Mismatch '%s': expected(%v) actual(%v)", test.Name, test.ExpectedProfile, actualServerSRTP)
+		}
+	}
+}
+
+func TestClientCertificate(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	srvCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvCAPool := x509.NewCertPool()
+	srvCertificate, err := x509.ParseCertificate(srvCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	srvCAPool.AddCert(srvCertificate)
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
+		tests := map[string]struct {
+			clientCfg *Config
+			serverCfg *Config
+			wantErr   bool
+		}{
+			"NoClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+				},
+			},
+			"NoClientCert_ServerVerifyConnectionFails": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+					VerifyConnection: func(*State) error {
+						return errExample
+					},
+				},
+				wantErr: true,
+			},
+			"NoClientCert_ClientVerifyConnectionFails": {
+				clientCfg: &Config{RootCAs: srvCAPool, VerifyConnection: func(*State) error {
+					return errExample
+				}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   NoClientCert,
+					ClientCAs:    caPool,
+				},
+				wantErr: true,
+			},
+			"NoClientCert_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+			},
+			"RequestClientCert_cert_sigscheme": { // specify signature algorithm
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP521AndSHA512},
+					Certificates:     []tls.Certificate{srvCert},
+					ClientAuth:       RequestClientCert,
+				},
+			},
+			"RequestClientCert_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequestClientCert,
+				},
+			},
+			"RequestClientCert_no_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequestClientCert,
+					ClientCAs:    caPool,
+				},
+			},
+			"RequireAnyClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+			},
+			"RequireAnyClientCert_error": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAnyClientCert,
+				},
+				wantErr: true,
+			},
+			"VerifyClientCertIfGiven_no_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+					ClientCAs:    caPool,
+				},
+			},
+			"VerifyClientCertIfGiven_cert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+					ClientCAs:    caPool,
+				},
+			},
+			"VerifyClientCertIfGiven_error": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   VerifyClientCertIfGiven,
+				},
+				wantErr: true,
+			},
+			"RequireAndVerifyClientCert": {
+				clientCfg: &Config{RootCAs: srvCAPool, Certificates: []tls.Certificate{cert}, VerifyConnection: func(s *State) error {
+					if ok := bytes.Equal(s.PeerCertificates[0], srvCertificate.Raw); !ok {
+						return errExample
+					}
+					return nil
+				}},
+				serverCfg: &Config{
+					Certificates: []tls.Certificate{srvCert},
+					ClientAuth:   RequireAndVerifyClientCert,
+					ClientCAs:    caPool,
+					VerifyConnection: func(s *State) error {
+						if ok := bytes.Equal(s.PeerCertificates[0], certificate.Raw); !ok {
+							return errExample
+						}
+						return nil
+					},
+				},
+			},
+			"RequireAndVerifyClientCert_callbacks": {
+				clientCfg: &Config{
+					RootCAs: srvCAPool,
+					// Certificates:   []tls.Certificate{cert},
+					GetClientCertificate: func(*CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil },
+				},
+				serverCfg: &Config{
+					GetCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) { return &srvCert, nil },
+					// Certificates:   []tls.Certificate{srvCert},
+					ClientAuth: RequireAndVerifyClientCert,
+					ClientCAs:  caPool,
+				},
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				type result struct {
+					c   *Conn
+					err error
+				}
+				c := make(chan result)
+
+				go func() {
+					client, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+					c <- result{client, err}
+				}()
+
+				server, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+				res := <-c
+				defer func() {
+					if err == nil {
+						_ = server.Close()
+					}
+					if res.err == nil {
+						_ = res.c.Close()
+					}
+				}()
+
+				if tt.wantErr {
+					if err != nil {
+						// Error expected, test succeeded
+						return
+					}
+					t.Error("Error expected")
+				}
+				if err != nil {
+					t.Errorf("Server failed(%v)", err)
+				}
+
+				if res.err != nil {
+					t.Errorf("Client failed(%v)", res.err)
+				}
+
+				actualClientCert := server.ConnectionState().PeerCertificates
+				if tt.serverCfg.ClientAuth == RequireAnyClientCert || tt.serverCfg.ClientAuth == RequireAndVerifyClientCert {
+					if actualClientCert == nil {
+						t.Errorf("Client did not provide a certificate")
+					}
+
+					var cfgCert [][]byte
+					if len(tt.clientCfg.Certificates) > 0 {
+						cfgCert = tt.clientCfg.Certificates[0].Certificate
+					}
+					if tt.clientCfg.GetClientCertificate != nil {
+						crt, err := tt.clientCfg.GetClientCertificate(&CertificateRequestInfo{})
+						if err != nil {
+							t.Errorf("Server configuration did not provide a certificate")
+						}
+						cfgCert = crt.Certificate
+					}
+					if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualClientCert[0]) {
+						t.Errorf("Client certificate was not communicated correctly")
+					}
+				}
+				if tt.serverCfg.ClientAuth == NoClientCert {
+					if actualClientCert != nil {
+						t.Errorf("Client certificate wasn't expected")
+					}
+				}
+
+				actualServerCert := res.c.ConnectionState().PeerCertificates
+				if actualServerCert == nil {
+					t.Errorf("Server did not provide a certificate")
+				}
+				var cfgCert [][]byte
+				if len(tt.serverCfg.Certificates) > 0 {
+					cfgCert = tt.serverCfg.Certificates[0].Certificate
+				}
+				if tt.serverCfg.GetCertificate != nil {
+					crt, err := tt.serverCfg.GetCertificate(&ClientHelloInfo{})
+					if err != nil {
+						t.Errorf("Server configuration did not provide a certificate")
+					}
+					cfgCert = crt.Certificate
+				}
+				if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualServerCert[0]) {
+					t.Errorf("Server certificate was not communicated correctly")
+				}
+			})
+		}
+	})
+}
+
+func TestSignatureScheme(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Both sides only accept SHA-384 instead of the default SHA-256, for
+	// the ServerKeyExchange as well as the CertificateVerify.
+	client, server := pipeConnPair(t, ctx, &Config{
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384},
+	}, &Config{
+		SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP384AndSHA384},
+		ClientAuth:       RequireAnyClientCert,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	expected := signaturehash.Algorithm{Hash: hash.SHA384, Signature: signature.ECDSA}
+	if actual := client.ConnectionState().SignatureScheme; actual != expected {
+		t.Errorf("Server signature scheme mismatch: expected %v, got %v", expected, actual)
+	}
+	if actual := server.ConnectionState().SignatureScheme; actual != expected {
+		t.Errorf("Client signature scheme mismatch: expected %v, got %v", expected, actual)
+	}
+}
+
+func TestConnectionID(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	clientCID := []byte{5, 77, 33, 24, 93, 27, 45, 81}
+	serverCID := []byte{64, 24, 73, 2, 17, 96, 38, 59}
+	cidEcho := func(echo []byte) func() []byte {
+		return func() []byte {
+			return echo
+		}
+	}
+	tests := map[string]struct {
+		clientCfg          *Config
+		serverCfg          *Config
+		clientConnectionID []byte
+		serverConnectionID []byte
+	}{
+		"BidirectionalConnectionIDs": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+			clientConnectionID: clientCID,
+			serverConnectionID: serverCID,
+		},
+		"BothSupportOnlyClientSends": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(nil),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+			serverConnectionID: serverCID,
+		},
+		"BothSupportOnlyServerSends": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(nil),
+			},
+			clientConnectionID: clientCID,
+		},
+		"ClientDoesNotSupport": {
+			clientCfg: &Config{},
+			serverCfg: &Config{
+				ConnectionIDGenerator: cidEcho(serverCID),
+			},
+		},
+		"ServerDoesNotSupport": {
+			clientCfg: &Config{
+				ConnectionIDGenerator: cidEcho(clientCID),
+			},
+			serverCfg: &Config{},
+		},
+		"NeitherSupport": {
+			clientCfg: &Config{},
+			serverCfg: &Config{},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
+			if err != nil {
+				t.Fatalf("Unexpected server error: %v", err)
+			}
+			res := <-c
+			if res.err != nil {
+				t.Fatalf("Unexpected client error: %v", res.err)
+			}
+			defer func() {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+			}()
+
+			if !bytes.Equal(res.c.state.localConnectionID, tt.clientConnectionID) {
+				t.Errorf("Unexpected client local connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, res.c.state.localConnectionID)
+			}
+			if !bytes.Equal(res.c.state.remoteConnectionID, tt.serverConnectionID) {
+				t.Errorf("Unexpected client remote connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, res.c.state.remoteConnectionID)
+			}
+			if !bytes.Equal(server.state.localConnectionID, tt.serverConnectionID) {
+				t.Errorf("Unexpected server local connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, server.state.localConnectionID)
+			}
+			if !bytes.Equal(server.state.remoteConnectionID, tt.clientConnectionID) {
+				t.Errorf("Unexpected server remote connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, server.state.remoteConnectionID)
+			}
+		})
+	}
+}
+
+func TestConnectionIDAddressUpdate(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for name, disable := range map[string]bool{
+		"UpdateEnabled":  false,
+		"UpdateDisabled": true,
+	} {
+		disable := disable
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			client, server := pipeConnPair(t, ctx, &Config{
+				ConnectionIDGenerator: OnlySendCIDGenerator(),
+			}, &Config{
+				ConnectionIDGenerator:            RandomCIDGenerator(8),
+				DisableConnectionIDAddressUpdate: disable,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			// Build a connection ID record on the client and deliver it to the
+			// server as if it arrived from a different source address.
+			client.lock.Lock()
+			raw, err := client.processPacket(&packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Epoch:   client.state.getLocalEpoch(),
+						Version: protocol.Version1_2,
+					},
+					Content: &protocol.ApplicationData{
+						Data: []byte("roaming"),
+					},
+				},
+				shouldWrapCID: true,
+				shouldEncrypt: true,
+			})
+			client.lock.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			originalAddr := server.RemoteAddr()
+			newAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5684}
+			if _, _, err := server.handleIncomingPacket(ctx, raw, newAddr, false); err != nil {
+				t.Fatal(err)
+			}
+
+			// ReadFrom reports the source of the record, even if the remote
+			// address is not updated.
+			buf := make([]byte, 16)
+			n, addr, err := server.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "roaming" {
+				t.Errorf("Unexpected application data: %q", buf[:n])
+			}
+			if addr != net.Addr(newAddr) {
+				t.Errorf("Unexpected source address\nwant: %v\ngot: %v", newAddr, addr)
+			}
+
+			expected := net.Addr(newAddr)
+			if disable {
+				expected = originalAddr
+			}
+			if actual := server.RemoteAddr(); actual != expected {
+				t.Errorf("Unexpected remote address\nwant: %v\ngot: %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestConnectionIDMismatchSkipsDecrypt(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConnPair(t, ctx, &Config{
+		ConnectionIDGenerator: OnlySendCIDGenerator(),
+	}, &Config{
+		ConnectionIDGenerator: RandomCIDGenerator(8),
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	cidRecord := func(data string) []byte {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+		raw, err := client.processPacket(&packet{
+			record: &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Epoch:   client.state.getLocalEpoch(),
+					Version: protocol.Version1_2,
+				},
+				Content: &protocol.ApplicationData{
+					Data: []byte(data),
+				},
+			},
+			shouldWrapCID: true,
+			shouldEncrypt: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	// A record with an unknown connection ID is discarded before it is
+	// decrypted, so it is not counted as a decrypt failure.
+	before := server.Stats()
+	mismatched := cidRecord("mismatched")
+	mismatched[recordlayer.FixedHeaderSize] ^= 0xff
+	if _, _, err := server.handleIncomingPacket(ctx, mismatched, server.RemoteAddr(), false); err != nil {
+		t.Fatal(err)
+	}
+	if n := server.Stats().DecryptFailures - before.DecryptFailures; n != 0 {
+		t.Errorf("Expected no decrypt failures, got %d", n)
+	}
+
+	// A record with the negotiated connection ID still decrypts.
+	if _, _, err := server.handleIncomingPacket(ctx, cidRecord("valid"), server.RemoteAddr(), false); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "valid" {
+		t.Errorf("Unexpected application data: %q", buf[:n])
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name            string
+		ClientHeartbeat bool
+		ServerHeartbeat bool
+		ExpectedErr     error
+	}{
+		{
+			Name:            "Both enabled",
+			ClientHeartbeat: true,
+			ServerHeartbeat: true,
+		},
+		{
+			Name:            "Server disabled",
+			ClientHeartbeat: true,
+			ExpectedErr:     errHeartbeatNotAllowed,
+		},
+		{
+			Name:            "Client disabled",
+			ServerHeartbeat: true,
+			ExpectedErr:     errHeartbeatNotAllowed,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			client, server := pipeConnPair(t, ctx, &Config{
+				EnableHeartbeat: test.ClientHeartbeat,
+			}, &Config{
+				EnableHeartbeat: test.ServerHeartbeat,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			if err := client.Ping(ctx); !errors.Is(err, test.ExpectedErr) {
+				t.Errorf("Client ping: expected(%v) actual(%v)", test.ExpectedErr, err)
+			}
+			if err := server.Ping(ctx); !errors.Is(err, test.ExpectedErr) {
+				t.Errorf("Server ping: expected(%v) actual(%v)", test.ExpectedErr, err)
+			}
+		})
+	}
+}
+
+func TestExtendedMasterSecret(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	tests := map[string]struct {
+		clientCfg         *Config
+		serverCfg         *Config
+		expectedClientErr error
+		expectedServerErr error
+	}{
+		"Request_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Request_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Request_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Require_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: errClientRequiredButNoServerEMS,
+			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+		},
+		"Disable_Request_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequestExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+		"Disable_Require_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: RequireExtendedMasterSecret,
+			},
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			expectedServerErr: errServerRequiredButNoClientEMS,
+		},
+		"Disable_Disable_ExtendedMasterSecret": {
+			clientCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			serverCfg: &Config{
+				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			},
+			expectedClientErr: nil,
+			expectedServerErr: nil,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
+			res := <-c
+			defer func() {
+				if err == nil {
+					_ = server.Close()
+				}
+				if res.err == nil {
+					_ = res.c.Close()
+				}
+			}()
+
+			if !errors.Is(res.err, tt.expectedClientErr) {
+				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, res.err)
+			}
+
+			if !errors.Is(err, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, err)
+			}
+		})
+	}
+}
+
+func TestServerCertificate(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(certificate)
+
+	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
+		tests := map[string]struct {
+			clientCfg *Config
+			serverCfg *Config
+			wantErr   bool
+		}{
+			"no_ca": {
+				clientCfg: &Config{},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+			"good_ca": {
+				clientCfg: &Config{RootCAs: caPool},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"no_ca_skip_verify": {
+				clientCfg: &Config{InsecureSkipVerify: true},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"good_ca_skip_verify_custom_verify_peer": {
+				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: RequireAnyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
+					if len(chain) != 0 {
+						return errNotExpectedChain
+					}
+					return nil
+				}},
+			},
+			"good_ca_verify_custom_verify_peer": {
+				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
+				serverCfg: &Config{ClientCAs: caPool, Certificates: []tls.Certificate{cert}, ClientAuth: RequireAndVerifyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
+					if len(chain) == 0 {
+						return errExpecedChain
+					}
+					return nil
+				}},
+			},
+			"good_ca_custom_verify_peer": {
+				clientCfg: &Config{
+					RootCAs: caPool,
+					VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
+						return errWrongCert
+					},
+				},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+			"server_name": {
+				clientCfg: &Config{RootCAs: caPool, ServerName: certificate.Subject.CommonName},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+			},
+			"server_name_error": {
+				clientCfg: &Config{RootCAs: caPool, ServerName: "barfoo"},
+				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
+				wantErr:   true,
+			},
+		}
+		for name, tt := range tests {
+			tt := tt
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+
+				type result struct {
+					c   *Conn
+					err error
+				}
+				srvCh := make(chan result)
+				go func() {
+					s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
+					srvCh <- result{s, err}
+				}()
+
+				cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+				if err == nil {
+					_ = cli.Close()
+				}
+				if !tt.wantErr && err != nil {
+					t.Errorf("Client failed(%v)", err)
+				}
+				if tt.wantErr && err == nil {
+					t.Fatal("Error expected")
+				}
+
+				srv := <-srvCh
+				if srv.err == nil {
+					_ = srv.c.Close()
+				}
+			})
+		}
+	})
+}
+
+func TestCertificateValidityStatus(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	generate := func(notBefore, notAfter time.Time) tls.Certificate {
+		priv, err := ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "localhost"},
+			DNSNames:              []string{"localhost"},
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+			ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			BasicConstraintsValid: true,
+			IsCA:                  true,
+		}
+		raw, err := x509.CreateCertificate(rand.Reader, template, template, priv.Public(), priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{raw}, PrivateKey: priv}
+	}
+	now := time.Now()
+	current := generate(now.Add(-time.Hour), now.Add(time.Hour))
+	currentCert, err := x509.ParseCertificate(current.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPool := x509.NewCertPool()
+	caPool.AddCert(currentCert)
+
+	for name, tt := range map[string]struct {
+		cert      tls.Certificate
+		clientCfg *Config
+		expected  CertificateValidityStatus
+	}{
+		"Valid": {
+			cert:      current,
+			clientCfg: &Config{RootCAs: caPool, ServerName: "localhost"},
+			expected:  CertificateValid,
+		},
+		"Expired": {
+			cert:      generate(now.Add(-2*time.Hour), now.Add(-time.Hour)),
+			clientCfg: &Config{InsecureSkipVerify: true},
+			expected:  CertificateExpired,
+		},
+		"NotYetValid": {
+			cert:      generate(now.Add(time.Hour), now.Add(2*time.Hour)),
+			clientCfg: &Config{InsecureSkipVerify: true},
+			expected:  CertificateNotYetValid,
+		},
+		"Untrusted": {
+			cert:      current,
+			clientCfg: &Config{InsecureSkipVerify: true},
+			expected:  CertificateUntrusted,
+		},
+		"HostnameMismatch": {
+			cert:      current,
+			clientCfg: &Config{RootCAs: caPool, ServerName: "example.com", InsecureSkipVerify: true},
+			expected:  CertificateHostnameMismatch,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			srvCh := make(chan result)
+			go func() {
+				s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+					Certificates: []tls.Certificate{tt.cert},
+				})
+				srvCh <- result{s, err}
+			}()
+
+			client, err := ClientWithContext(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = client.Close()
+			}()
+			res := <-srvCh
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = res.c.Close()
+			}()
+
+			if actual := client.ConnectionState().CertificateValidityStatus; actual != tt.expected {
+				t.Errorf("Certificate validity mismatch\nwant: %s\ngot: %s", tt.expected, actual)
+			}
+			if actual := res.c.ConnectionState().CertificateValidityStatus; actual != CertificateValidityUnknown {
+				t.Errorf("Server must not classify an absent client certificate, got %s", actual)
+			}
+		})
+	}
+}
+
+func TestCipherSuiteConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                     string
+		ClientCipherSuites       []CipherSuiteID
+		ServerCipherSuites       []CipherSuiteID
+		WantClientError          error
+		WantServerError          error
+		WantSelectedCipherSuite  CipherSuiteID
+		PreferServerCipherSuites bool
+	}{
+		{
+			Name:               "No CipherSuites specified",
+			ClientCipherSuites: nil,
+			ServerCipherSuites: nil,
+			WantClientError:    nil,
+			WantServerError:    nil,
+		},
+		{
+			Name:               "Invalid CipherSuite",
+			ClientCipherSuites: []CipherSuiteID{0x00},
+			ServerCipherSuites: []CipherSuiteID{0x00},
+			WantClientError:    &invalidCipherSuiteError{0x00},
+			WantServerError:    &invalidCipherSuiteError{0x00},
+		},
+		{
+			Name:                    "Valid CipherSuites specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:               "CipherSuites mismatch",
+			ClientCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			WantClientError:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
+			WantServerError:    errCipherSuiteNoIntersection,
+		},
+		{
+			Name:                    "Valid CipherSuites CCM specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+		},
+		{
+			Name:                    "Valid CipherSuites CCM-8 specified",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+		},
+		{
+			Name:                    "Server supports subset of client suites",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		},
+		{
+			Name:                    "Client preference order",
+			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			WantClientError:         nil,
+			WantServerError:         nil,
+			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:                     "Server preference order",
+			ClientCipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+			ServerCipherSuites:       []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			WantClientError:          nil,
+			WantServerError:          nil,
+			WantSelectedCipherSuite:  TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			PreferServerCipherSuites: true,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.ClientCipherSuites}, true)
+				c <- result{client, err}
+			}()
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: test.ServerCipherSuites, PreferServerCipherSuites: test.PreferServerCipherSuites}, true)
+			if err == nil {
+				defer func() {
+					_ = server.Close()
+				}()
+			}
+			if !errors.Is(err, test.WantServerError) {
+				t.Errorf("TestCipherSuiteConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			}
+
+			res := <-c
+			if res.err == nil {
+				_ = server.Close()
+				_ = res.c.Close()
+			}
+			if !errors.Is(res.err, test.WantClientError) {
+				t.Errorf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			}
+			if test.WantSelectedCipherSuite != 0x00 && res.c.state.cipherSuite.ID() != test.WantSelectedCipherSuite {
+				t.Errorf("TestCipherSuiteConfiguration: Server Selected Bad Cipher Suite '%s': expected(%v) actual(%v)", test.Name, test.WantSelectedCipherSuite, res.c.state.cipherSuite.ID())
+			}
+		})
+	}
+}
+
+func TestRSAKeyExchange(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverCerts := make([]tls.Certificate, 2)
+	for i := range serverCerts {
+		priv, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if serverCerts[i], err = selfsign.SelfSign(priv); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, serverCfg := range map[string]*Config{
+		"Certificates": {
+			Certificates: serverCerts[:1],
+		},
+		// The premaster secret must be decrypted with the key of the
+		// certificate that was sent, even if GetCertificate returns another
+		// one when called again.
+		"GetCertificate": {
+			GetCertificate: func() func(*ClientHelloInfo) (*tls.Certificate, error) {
+				calls := 0
+				return func(*ClientHelloInfo) (*tls.Certificate, error) {
+					calls++
+					return &serverCerts[calls%len(serverCerts)], nil
+				}
+			}(),
+		},
+	} {
+		serverCfg := serverCfg
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			serverCfg.CipherSuites = []CipherSuiteID{TLS_RSA_WITH_AES_128_GCM_SHA256}
+			serverCfg.InsecureRSAKeyExchange = true
+			client, server := pipeConnPair(t, ctx, &Config{
+				CipherSuites:           []CipherSuiteID{TLS_RSA_WITH_AES_128_GCM_SHA256},
+				InsecureRSAKeyExchange: true,
+			}, serverCfg)
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			if actual := client.ConnectionState().CipherSuiteID; actual != TLS_RSA_WITH_AES_128_GCM_SHA256 {
+				t.Errorf("Cipher suite mismatch: expected %v, got %v", TLS_RSA_WITH_AES_128_GCM_SHA256, actual)
+			}
+			if _, err := client.Write([]byte("legacy")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 16)
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "legacy" {
+				t.Errorf("Unexpected application data: %q", buf[:n])
+			}
+		})
+	}
+}
+
+func TestCertificateAndPSKServer(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name      string
+		ClientPSK bool
+	}{
+		{
+			Name:      "Client uses PKI",
+			ClientPSK: false,
+		},
+		{
+			Name:      "Client uses PSK",
+			ClientPSK: true,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
+			}
+			c := make(chan result)
+
+			go func() {
+				config := &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
+				if test.ClientPSK {
+					config.PSK = func([]byte) ([]byte, error) {
+						return []byte{0x00, 0x01, 0x02}, nil
+					}
+					config.PSKIdentityHint = []byte{0x00}
+					config.CipherSuites = []CipherSuiteID{TLS_PSK_WITH_AES_128_GCM_SHA256}
+				}
+
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+				c <- result{client, err}
+			}()
+
+			config := &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256},
+				PSK: func([]byte) ([]byte, error) {
+					return []byte{0x00, 0x01, 0x02}, nil
+				},
+			}
+
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+			if err == nil {
+				defer func() {
+					_ = server.Close()
+				}()
+			} else {
+				t.Errorf("TestCertificateAndPSKServer: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, err)
+			}
+
+			res := <-c
+			if res.err == nil {
+				_ = server.Close()
+				_ = res.c.Close()
+			} else {
+				t.Errorf("TestCertificateAndPSKServer: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, res.err)
+			}
+		})
+	}
+}
+
+func TestPSKConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                 string
+		ClientHasCertificate bool
+		ServerHasCertificate bool
+		ClientPSK            PSKCallback
+		ServerPSK            PSKCallback
+		ClientPSKIdentity    []byte
+		ServerPSKIdentity    []byte
+		WantClientError      error
+		WantServerError      error
+	}{
+		{
+			Name:                 "PSK and no certificate specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      &noSupportedCipherSuitesError{err: errNoAvailablePSKCipherSuite, filtered: defaultCipherSuites()},
+			WantServerError:      &noSupportedCipherSuitesError{err: errNoAvailablePSKCipherSuite, filtered: defaultCipherSuites()},
+		},
+		{
+			Name:                 "PSK and certificate specified",
+			ClientHasCertificate: true,
+			ServerHasCertificate: true,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      errNoAvailablePSKCipherSuite,
+			WantServerError:      errNoAvailablePSKCipherSuite,
+		},
+		{
+			Name:                 "PSK and no identity specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+			ClientPSKIdentity:    nil,
+			ServerPSKIdentity:    nil,
+			WantClientError:      errPSKAndIdentityMustBeSetForClient,
+			WantServerError:      &noSupportedCipherSuitesError{err: errNoAvailablePSKCipherSuite, filtered: defaultCipherSuites()},
+		},
+		{
+			Name:                 "No PSK and identity specified",
+			ClientHasCertificate: false,
+			ServerHasCertificate: false,
+			ClientPSK:            nil,
+			ServerPSK:            nil,
+			ClientPSKIdentity:    []byte{0x00},
+			ServerPSKIdentity:    []byte{0x00},
+			WantClientError:      errIdentityNoPSK,
+			WantServerError:      errIdentityNoPSK,
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
+		}
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{PSK: test.ClientPSK, PSKIdentityHint: test.ClientPSKIdentity}, test.ClientHasCertificate)
+			c <- result{client, err}
+		}()
+
+		_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{PSK: test.ServerPSK, PSKIdentityHint: test.ServerPSKIdentity}, test.ServerHasCertificate)
+		if err != nil || test.WantServerError != nil {
+			if !(err != nil && test.WantServerError != nil && err.Error() == test.WantServerError.Error()) {
+				t.Fatalf("TestPSKConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			}
+		}
+
+		res := <-c
+		if res.err != nil || test.WantClientError != nil {
+			if !(res.err != nil && test.WantClientError != nil && res.err.Error() == test.WantClientError.Error()) {
+				t.Fatalf("TestPSKConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			}
+		}
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookie := make([]byte, 20)
+	_, err := rand.Read(cookie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rand [28]byte
+	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
+
+	cipherSuites := []CipherSuite{
+		&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
+		&ciphersuite.TLSEcdheRsaWithAes128GcmSha256{},
+	}
+
+	extensions := []extension.Extension{
+		&extension.SupportedSignatureAlgorithms{
+			SignatureHashAlgorithms: []signaturehash.Algorithm{
+				{Hash: hash.SHA256, Signature: signature.ECDSA},
+				{Hash: hash.SHA384, Signature: signature.ECDSA},
+				{Hash: hash.SHA512, Signature: signature.ECDSA},
+				{Hash: hash.SHA256, Signature: signature.RSA},
+				{Hash: hash.SHA384, Signature: signature.RSA},
+				{Hash: hash.SHA512, Signature: signature.RSA},
+			},
+		},
+		&extension.SupportedEllipticCurves{
+			EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+		},
+		&extension.SupportedPointFormats{
+			PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+		},
+	}
+
+	record := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			SequenceNumber: 0,
+			Version:        protocol.Version1_2,
+		},
+		Content: &handshake.Handshake{
+			// sequenceNumber and messageSequence line up, may need to be re-evaluated
+			Header: handshake.Header{
+				MessageSequence: 0,
+			},
+			Message: &handshake.MessageClientHello{
+				Version:            protocol.Version1_2,
+				Cookie:             cookie,
+				Random:             random,
+				CipherSuiteIDs:     cipherSuiteIDs(cipherSuites),
+				CompressionMethods: defaultCompressionMethods(),
+				Extensions:         extensions,
+			},
+		},
+	}
+
+	packet, err := record.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		err := ca.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Client reader
+	caReadChan := make(chan []byte, 1000)
+	go func() {
+		for {
+			data := make([]byte, 8192)
+			n, err := ca.Read(data)
+			if err != nil {
+				return
+			}
+
+			caReadChan <- data[:n]
+		}
+	}()
+
+	// Start sending ClientHello packets until server responds with first packet
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				_, err := ca.Write(packet)
+				if err != nil {
+					return
+				}
+			case <-caReadChan:
+				// Once we receive the first reply from the server, stop
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	config := &Config{
+		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		FlightInterval: 100 * time.Millisecond,
+	}
+
+	_, serverErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+	var netErr net.Error
+	if !errors.As(serverErr, &netErr) || !netErr.Timeout() {
+		t.Fatalf("Client error exp(Temporary network error) failed(%v)", serverErr)
+	}
+
+	// Wait a little longer to ensure no additional messages have been sent by the server
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case msg := <-caReadChan:
+		t.Fatalf("Expected no additional messages from server, got: %+v", msg)
+	default:
+	}
+}
+
+func TestProtocolVersionValidation(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookie := make([]byte, 20)
+	if _, err := rand.Read(cookie); err != nil {
+		t.Fatal(err)
+	}
+
+	var rand [28]byte
+	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
+
+	config := &Config{
+		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		FlightInterval: 100 * time.Millisecond,
+	}
+
+	t.Run("Server", func(t *testing.T) {
+		serverCases := map[string]struct {
+			records []*recordlayer.RecordLayer
+		}{
+			"ClientHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+				},
+			},
+			"SecondsClientHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version1_2,
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+					{
+						Header: recordlayer.Header{
+							Version:        protocol.Version1_2,
+							SequenceNumber: 1,
+						},
+						Content: &handshake.Handshake{
+							Header: handshake.Header{
+								MessageSequence: 1,
+							},
+							Message: &handshake.MessageClientHello{
+								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Cookie:             cookie,
+								Random:             random,
+								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
+								CompressionMethods: defaultCompressionMethods(),
+							},
+						},
+					},
+				},
+			},
+		}
+		for name, c := range serverCases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				defer func() {
+					err := ca.Close()
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+
+				var wg sync.WaitGroup
+				wg.Add(1)
+				defer wg.Wait()
+				go func() {
+					defer wg.Done()
+					if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
+						t.Errorf("Client error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
+					}
+				}()
+
+				time.Sleep(50 * time.Millisecond)
+
+				resp := make([]byte, 1024)
+				for _, record := range c.records {
+					packet, err := record.Marshal()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, werr := ca.Write(packet); werr != nil {
+						t.Fatal(werr)
+					}
+					n, rerr := ca.Read(resp[:cap(resp)])
+					if rerr != nil {
+						t.Fatal(rerr)
+					}
+					resp = resp[:n]
+				}
+
+				h := &recordlayer.Header{}
+				if err := h.Unmarshal(resp); err != nil {
+					t.Fatal("Failed to unmarshal response")
+				}
+				if h.ContentType != protocol.ContentTypeAlert {
+					t.Errorf("Peer must return alert to unsupported protocol version")
+				}
+			})
+		}
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		clientCases := map[string]struct {
+			records []*recordlayer.RecordLayer
+		}{
+			"ServerHelloVersion": {
+				records: []*recordlayer.RecordLayer{
+					{
+						Header: recordlayer.Header{
+							Version: protocol.Version1_2,
+						},
+						Content: &handshake.Handshake{
+							Message: &handshake.MessageHelloVerifyRequest{
+								Version: protocol.Version1_2,
+								Cookie:  cookie,
+							},
+						},
+					},
+					{
+						Header: recordlayer.Header{
+							Version:        protocol.Version1_2,
+							SequenceNumber: 1,
+						},
+						Content: &handshake.Handshake{
+							Header: handshake.Header{
+								MessageSequence: 1,
+							},
+							Message: &handshake.MessageServerHello{
+								Version:           protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
+								Random:            random,
+								CipherSuiteID:     func() *uint16 { id := uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); return &id }(),
+								CompressionMethod: defaultCompressionMethods()[0],
+							},
+						},
+					},
+				},
+			},
+		}
+		for name, c := range clientCases {
+			c := c
+			t.Run(name, func(t *testing.T) {
+				ca, cb := dpipe.Pipe()
+				defer func() {
+					err := ca.Close()
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				defer cancel()
+
+				var wg sync.WaitGroup
+				wg.Add(1)
+				defer wg.Wait()
+				go func() {
+					defer wg.Done()
+					if _, err := testClient(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
+						t.Errorf("Server error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
+					}
+				}()
+
+				time.Sleep(50 * time.Millisecond)
+
+				for _, record := range c.records {
+					if _, err := ca.Read(make([]byte, 1024)); err != nil {
+						t.Fatal(err)
+					}
+
+					packet, err := record.Marshal()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err := ca.Write(packet); err != nil {
+						t.Fatal(err)
+					}
+				}
+				resp := make([]byte, 1024)
+				n, err := ca.Read(resp)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp = resp[:n]
+
+				h := &recordlayer.Header{}
+				if err := h.Unmarshal(resp); err != nil {
+					t.Fatal("Failed to unmarshal response")
+				}
+				if h.ContentType != protocol.ContentTypeAlert {
+					t.Errorf("Peer must return alert to unsupported protocol version")
+				}
+			})
+		}
+	})
+}
+
+func TestMultipleHelloVerifyRequest(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	cookies := [][]byte{
+		// first clientHello contains an empty cookie
+		{},
+	}
+	var packets [][]byte
+	for i := 0; i < 2; i++ {
+		cookie := make([]byte, 20)
+		if _, err := rand.Read(cookie); err != nil {
+			t.Fatal(err)
+		}
+		cookies = append(cookies, cookie)
+
+		record := &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				SequenceNumber: uint64(i),
+				Version:        protocol.Version1_2,
+			},
+			Content: &handshake.Handshake{
+				Header: handshake.Header{
+					MessageSequence: uint16(i),
+				},
+				Message: &handshake.MessageHelloVerifyRequest{
+					Version: protocol.Version1_2,
+					Cookie:  cookie,
+				},
+			},
+		}
+		packet, err := record.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, packet)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		err := ca.Close()
+		if err != nil {
+			t.Error(err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Wait()
+	go func() {
+		defer wg.Done()
+		_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
+	}()
+
+	for i, cookie := range cookies {
+		// read client hello
+		resp := make([]byte, 1024)
+		n, err := cb.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := &recordlayer.RecordLayer{}
+		if err := record.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+		clientHello, ok := record.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
+		if !ok {
+			t.Fatal("Failed to cast MessageClientHello")
+		}
+
+		if !bytes.Equal(clientHello.Cookie, cookie) {
+			t.Fatalf("Wrong cookie, expected: %x, got: %x", clientHello.Cookie, cookie)
+		}
+		if len(packets) <= i {
+			break
+		}
+		// write hello verify request
+		if _, err := cb.Write(packets[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+}
+
+// Assert that a DTLS Server always responds with RenegotiationInfo if
+// a ClientHello contained that extension or not
+func TestRenegotationInfo(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	resp := make([]byte, 1024)
+
+	for _, testCase := range []struct {
+		Name                  string
+		SendRenegotiationInfo bool
+	}{
+		{
+			"Include RenegotiationInfo",
+			true,
+		},
+		{
+			"No RenegotiationInfo",
+			false,
+		},
+	} {
+		test := testCase
+		t.Run(test.Name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			defer func() {
+				if err := ca.Close(); err != nil {
+					t.Error(err)
+				}
+			}()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			go func() {
+				if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
+					t.Error(err)
+				}
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+
+			extensions := []extension.Extension{}
+			if test.SendRenegotiationInfo {
+				extensions = append(extensions, &extension.RenegotiationInfo{
+					RenegotiatedConnection: 0,
+				})
+			}
+			err := sendClientHello([]byte{}, ca, 0, extensions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, err := ca.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &recordlayer.RecordLayer{}
+			if err = r.Unmarshal(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
+			if !ok {
+				t.Fatal("Failed to cast MessageHelloVerifyRequest")
+			}
+
+			err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n, err = ca.Read(resp); err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := recordlayer.UnpackDatagram(resp[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := r.Unmarshal(messages[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+			if !ok {
+				t.Fatal("Failed to cast MessageServerHello")
+			}
+
+			gotNegotationInfo := false
+			for _, v := range serverHello.Extensions {
+				if _, ok := v.(*extension.RenegotiationInfo); ok {
+					gotNegotationInfo = true
+				}
+			}
+
+			if !gotNegotationInfo {
+				t.Fatalf("Received ServerHello without RenegotiationInfo")
+			}
+		})
+	}
+}
+
+func TestServerNameIndicationExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name       string
+		ServerName string
+		Expected   []byte
+		IncludeSNI bool
+	}{
+		{
+			Name:       "Server name is a valid hostname",
+			ServerName: "example.com",
+			Expected:   []byte("example.com"),
+			IncludeSNI: true,
+		},
+		{
+			Name:       "Server name is an IP literal",
+			ServerName: "1.2.3.4",
+			Expected:   []byte(""),
+			IncludeSNI: false,
+		},
+		{
+			Name:       "Server name is empty",
+			ServerName: "",
+			Expected:   []byte(""),
+			IncludeSNI: false,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					ServerName: test.ServerName,
+				}
+
+				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+			}()
+
+			// Receive ClientHello
+			resp := make([]byte, 1024)
+			n, err := cb.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &recordlayer.RecordLayer{}
+			if err = r.Unmarshal(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			clientHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
+			if !ok {
+				t.Fatal("Failed to cast MessageClientHello")
+			}
+
+			gotSNI := false
+			var actualServerName string
+			for _, v := range clientHello.Extensions {
+				if _, ok := v.(*extension.ServerName); ok {
+					gotSNI = true
+					extensionServerName, ok := v.(*extension.ServerName)
+					if !ok {
+						t.Fatal("Failed to cast extension.ServerName")
+					}
+
+					actualServerName = extensionServerName.ServerName
+				}
+			}
+
+			if gotSNI != test.IncludeSNI {
+				t.Errorf("TestSNI: unexpected SNI inclusion '%s': expected(%v) actual(%v)", test.Name, test.IncludeSNI, gotSNI)
+			}
+
+			if !bytes.Equal([]byte(actualServerName), test.Expected) {
+				t.Errorf("TestSNI: server name mismatch '%s': expected(%v) actual(%v)", test.Name, test.Expected, actualServerName)
+			}
+		})
+	}
+}
+
+func TestALPNExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name                   string
+		ClientProtocolNameList []string
+		ServerProtocolNameList []string
+		ExpectedProtocol       string
+		ExpectAlertFromClient  bool
+		ExpectAlertFromServer  bool
+		Alert                  alert.Description
+	}{
+		{
+			Name:                   "Negotiate a protocol",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
+			ServerProtocolNameList: []string{"spd/1"},
+			ExpectedProtocol:       "spd/1",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Server doesn't support any",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
+			ServerProtocolNameList: []string{},
+			ExpectedProtocol:       "",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Negotiate with higher server precedence",
+			ClientProtocolNameList: []string{"http/1.1", "spd/1", "http/3"},
+			ServerProtocolNameList: []string{"ssh/2", "http/3", "spd/1"},
+			ExpectedProtocol:       "http/3",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  false,
+			Alert:                  0,
+		},
+		{
+			Name:                   "Empty intersection",
+			ClientProtocolNameList: []string{"http/1.1", "http/3"},
+			ServerProtocolNameList: []string{"ssh/2", "spd/1"},
+			ExpectedProtocol:       "",
+			ExpectAlertFromClient:  false,
+			ExpectAlertFromServer:  true,
+			Alert:                  alert.NoApplicationProtocol,
+		},
+		{
+			Name:                   "Multiple protocols in ServerHello",
+			ClientProtocolNameList: []string{"http/1.1"},
+			ServerProtocolNameList: []string{"http/1.1"},
+			ExpectedProtocol:       "http/1.1",
+			ExpectAlertFromClient:  true,
+			ExpectAlertFromServer:  false,
+			Alert:                  alert.InternalError,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					SupportedProtocols: test.ClientProtocolNameList,
+				}
+				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
+			}()
+
+			// Receive ClientHello
+			resp := make([]byte, 1024)
+			n, err := cb.Read(resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel2()
+
+			ca2, cb2 := dpipe.Pipe()
+			go func() {
+				conf := &Config{
+					SupportedProtocols: test.ServerProtocolNameList,
+				}
+				if _, err2 := testServer(ctx2, dtlsnet.PacketConnFromConn(cb2), cb2.RemoteAddr(), conf, true); !errors.Is(err2, context.Canceled) {
+					if test.ExpectAlertFromServer { //nolint
+						// Assert the error type?
+					} else {
+						t.Error(err2)
+					}
+				}
+			}()
+
+			time.Sleep(50 * time.Millisecond)
+
+			// Forward ClientHello
+			if _, err = ca2.Write(resp[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive HelloVerify
+			resp2 := make([]byte, 1024)
+			n, err = ca2.Read(resp2)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Forward HelloVerify
+			if _, err = cb.Write(resp2[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive ClientHello
+			resp3 := make([]byte, 1024)
+			n, err = cb.Read(resp3)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Forward ClientHello
+			if _, err = ca2.Write(resp3[:n]); err != nil {
+				t.Fatal(err)
+			}
+
+			// Receive ServerHello
+			resp4 := make([]byte, 1024)
+			n, err = ca2.Read(resp4)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			messages, err := recordlayer.UnpackDatagram(resp4[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := &recordlayer.RecordLayer{}
+			if err := r.Unmarshal(messages[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			if test.ExpectAlertFromServer {
+				a, ok := r.Content.(*alert.Alert)
+				if !ok {
+					t.Fatal("Failed to cast alert.Alert")
+				}
+
+				if a.Description != test.Alert {
+					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
+				}
+			} else {
+				serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+				if !ok {
+					t.Fatal("Failed to cast handshake.MessageServerHello")
+				}
+
+				var negotiatedProtocol string
+				for _, v := range serverHello.Extensions {
+					if _, ok := v.(*extension.ALPN); ok {
+						e, ok := v.(*extension.ALPN)
+						if !ok {
+							t.Fatal("Failed to cast extension.ALPN")
+						}
+
+						negotiatedProtocol = e.ProtocolNameList[0]
+
+						// Manipulate ServerHello
+						if test.ExpectAlertFromClient {
+							e.ProtocolNameList = append(e.ProtocolNameList, "oops")
+						}
+					}
+				}
+
+				if negotiatedProtocol != test.ExpectedProtocol {
+					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.ExpectedProtocol, negotiatedProtocol)
+				}
+
+				s, err := r.Marshal()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				// Forward ServerHello
+				if _, err = cb.Write(s); err != nil {
+					t.Fatal(err)
+				}
+
+				if test.ExpectAlertFromClient {
+					resp5 := make([]byte, 1024)
+					n, err = cb.Read(resp5)
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					r2 := &recordlayer.RecordLayer{}
+					if err := r2.Unmarshal(resp5[:n]); err != nil {
+						t.Fatal(err)
+					}
+
+					a, ok := r2.Content.(*alert.Alert)
+					if !ok {
+						t.Fatal("Failed to cast alert.Alert")
+					}
+
+					if a.Description != test.Alert {
+						t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
+					}
+				}
+			}
+
+			time.Sleep(50 * time.Millisecond) // Give some time for returned errors
+		})
+	}
+}
+
+// Make sure the supported_groups extension is not included in the ServerHello
+func TestSupportedGroupsExtension(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("ServerHello Supported Groups", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
+				t.Error(err)
+			}
+		}()
+		extensions := []extension.Extension{
+			&extension.SupportedEllipticCurves{
+				EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+			},
+			&extension.SupportedPointFormats{
+				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+			},
+		}
+
+		time.Sleep(50 * time.Millisecond)
+
+		resp := make([]byte, 1024)
+		err := sendClientHello([]byte{}, ca, 0, extensions)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Receive ServerHello
+		n, err := ca.Read(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &recordlayer.RecordLayer{}
+		if err = r.Unmarshal(resp[:n]); err != nil {
+			t.Fatal(err)
+		}
+
+		helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
+		if !ok {
+			t.Fatal("Failed to cast MessageHelloVerifyRequest")
+		}
+
+		err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err = ca.Read(resp); err != nil {
+			t.Fatal(err)
+		}
+
+		messages, err := recordlayer.UnpackDatagram(resp[:n])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := r.Unmarshal(messages[0]); err != nil {
+			t.Fatal(err)
+		}
+
+		serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
+		if !ok {
+			t.Fatal("Failed to cast MessageServerHello")
+		}
+
+		gotGroups := false
+		for _, v := range serverHello.Extensions {
+			if _, ok := v.(*extension.SupportedEllipticCurves); ok {
+				gotGroups = true
+			}
+		}
+
+		if gotGroups {
+			t.Errorf("TestSupportedGroups: supported_groups extension was sent in ServerHello")
+		}
+	})
+}
+
+func TestSessionResume(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	t.Run("resumed", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
+
+		ss := &memSessStore{}
+
+		id, _ := hex.DecodeString("9b9fc92255634d9fb109febed42166717bb8ded8c738ba71bc7f2a0d9dae0306")
+		secret, _ := hex.DecodeString("2e942a37aca5241deb2295b5fcedac221c7078d2503d2b62aeb48c880d7da73c001238b708559686b9da6e829c05ead7")
+
+		s := Session{ID: id, Secret: secret}
+
+		ca, cb := dpipe.Pipe()
+
+		_ = ss.Set(id, s)
+		_ = ss.Set([]byte(ca.RemoteAddr().String()+"_example.com"), s)
+
+		go func() {
+			config := &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				ServerName:   "example.com",
+				SessionStore: ss,
+				MTU:          100,
+			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
+
+		config := &Config{
+			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			ServerName:   "example.com",
+			SessionStore: ss,
+			MTU:          100,
+		}
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatalf("TestSessionResume: Server failed(%v)", err)
+		}
+
+		actualSessionID := server.ConnectionState().SessionID
+		actualMasterSecret := server.ConnectionState().masterSecret
+		if !bytes.Equal(actualSessionID, id) {
+			t.Errorf("TestSessionResumetion: SessionID Mismatch: expected(%v) actual(%v)", id, actualSessionID)
+		}
+		if !bytes.Equal(actualMasterSecret, secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", secret, actualMasterSecret)
+		}
+
+		defer func() {
+			_ = server.Close()
+		}()
+
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		_ = res.c.Close()
+	})
+
+	t.Run("new session", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		type result struct {
+			c   *Conn
+			err error
+		}
+		clientRes := make(chan result, 1)
+
+		s1 := &memSessStore{}
+		s2 := &memSessStore{}
+
+		ca, cb := dpipe.Pipe()
+		go func() {
+			config := &Config{
+				ServerName:   "example.com",
+				SessionStore: s1,
+			}
+			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
+			clientRes <- result{c, err}
+		}()
+
+		config := &Config{
+			SessionStore: s2,
+		}
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
+		if err != nil {
+			t.Fatalf("TestSessionResumetion: Server failed(%v)", err)
+		}
+
+		actualSessionID := server.ConnectionState().SessionID
+		actualMasterSecret := server.ConnectionState().masterSecret
+		ss, _ := s2.Get(actualSessionID)
+		if !bytes.Equal(actualMasterSecret, ss.Secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		}
+
+		defer func() {
+			_ = server.Close()
+		}()
+
+		res := <-clientRes
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		cs, _ := s1.Get([]byte(ca.RemoteAddr().String() + "_example.com"))
+		if !bytes.Equal(actualMasterSecret, cs.Secret) {
+			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		}
+		_ = res.c.Close()
+	})
+}
+
+type memSessStore struct {
+	sync.Map
+}
+
+func (ms *memSessStore) Set(key []byte, s Session) error {
+	k := hex.EncodeToString(key)
+	ms.Store(k, s)
+
+	return nil
+}
+
+func (ms *memSessStore) Get(key []byte) (Session, error) {
+	k := hex.EncodeToString(key)
+
+	v, ok := ms.Load(k)
+	if !ok {
+		return Session{}, nil
+	}
+
+	s, ok := v.(Session)
+	if !ok {
+		return Session{}, nil
+	}
+
+	return s, nil
+}
+
+func (ms *memSessStore) Del(key []byte) error {
+	k := hex.EncodeToString(key)
+	ms.Delete(k)
+
+	return nil
+}
+
+// Assert that the server only uses CipherSuites with a hash+signature that matches
+// the certificate. As specified in rfc5246#section-7.4.3
+func TestCipherSuiteMatchesCertificateType(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name           string
+		cipherList     []CipherSuiteID
+		expectedCipher CipherSuiteID
+		generateRSA    bool
+	}{
+		{
+			Name:           "ECDSA Certificate with RSA CipherSuite first",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+			expectedCipher: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+		{
+			Name:           "RSA Certificate with ECDSA CipherSuite first",
+			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			expectedCipher: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			generateRSA:    true,
+		},
+	} {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			clientErr := make(chan error, 1)
+			client := make(chan *Conn, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.cipherList}, false)
+				clientErr <- err
+				client <- c
+			}()
+
+			var (
+				priv crypto.PrivateKey
+				err  error
+			)
+
+			if test.generateRSA {
+				if priv, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if priv, err = ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			serverCert, err := selfsign.SelfSign(priv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+				CipherSuites: test.cipherList,
+				Certificates: []tls.Certificate{serverCert},
+			}, false); err != nil {
+				t.Fatal(err)
+			} else if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c, err := <-client, <-clientErr; err != nil {
+				t.Fatal(err)
+			} else if err := c.Close(); err != nil {
+				t.Fatal(err)
+			} else if c.ConnectionState().cipherSuite.ID() != test.expectedCipher {
+				t.Fatalf("Expected(%s) and Actual(%s) CipherSuite do not match", test.expectedCipher, c.ConnectionState().cipherSuite.ID())
+			}
+		})
+	}
+}
+
+// Test that we return the proper certificate if we are serving multiple ServerNames on a single Server
+func TestMultipleServerCertificates(t *testing.T) {
+	fooCert, err := selfsign.GenerateSelfSignedWithDNS("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	barCert, err := selfsign.GenerateSelfSignedWithDNS("bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPool := x509.NewCertPool()
+	for _, cert := range []tls.Certificate{fooCert, barCert} {
+		certificate, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		caPool.AddCert(certificate)
+	}
+
+	for _, test := range []struct {
+		RequestServerName string
+		ExpectedDNSName   string
+	}{
+		{
+			"foo",
+			"foo",
+		},
+		{
+			"bar",
+			"bar",
+		},
+		{
+			"invalid",
+			"foo",
+		},
+	} {
+		test := test
+		t.Run(test.RequestServerName, func(t *testing.T) {
+			clientErr := make(chan error, 2)
+			client := make(chan *Conn, 1)
+
+			ca, cb := dpipe.Pipe()
+			go func() {
+				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					RootCAs:    caPool,
+					ServerName: test.RequestServerName,
+					VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+						certificate, err := x509.ParseCertificate(rawCerts[0])
+						if err != nil {
+							return err
+						}
+
+						if certificate.DNSNames[0] != test.ExpectedDNSName {
+							return errWrongCert
 						}
+
 						return nil
 					},
+				}, false)
+				clientErr <- err
+				client <- c
+			}()
+
+			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{Certificates: []tls.Certificate{fooCert, barCert}}, false); err != nil {
+				t.Fatal(err)
+			} else if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if c, err := <-client, <-clientErr; err != nil {
+				t.Fatal(err)
+			} else if err := c.Close(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// Test that GetCertificate receives the ClientHello and can select between
+// ECDSA and RSA certificates by ServerName.
+func TestGetCertificateClientHelloInfo(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ecdsaCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, err := selfsign.SelfSign(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientCipherSuites := []CipherSuiteID{
+		TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	}
+	clientCurves := []elliptic.Curve{elliptic.P256, elliptic.X25519}
+	clientProtocols := []string{"h2", "http/1.1"}
+
+	for serverName, expectedCipherSuite := range map[string]CipherSuiteID{
+		"ecdsa.example.com": TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"rsa.example.com":   TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	} {
+		serverName, expectedCipherSuite := serverName, expectedCipherSuite
+		t.Run(serverName, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var info *ClientHelloInfo
+			client, server := pipeConnPair(t, ctx, &Config{
+				CipherSuites:       clientCipherSuites,
+				EllipticCurves:     clientCurves,
+				SupportedProtocols: clientProtocols,
+				ServerName:         serverName,
+			}, &Config{
+				CipherSuites:       clientCipherSuites,
+				SupportedProtocols: clientProtocols,
+				GetCertificate: func(chi *ClientHelloInfo) (*tls.Certificate, error) {
+					info = chi
+					if chi.ServerName == "rsa.example.com" {
+						return &rsaCert, nil
+					}
+					return &ecdsaCert, nil
 				},
-			},
-			"RequireAndVerifyClientCert_callbacks": {
-				clientCfg: &Config{
-					RootCAs: srvCAPool,
-					// Certificates:   []tls.Certificate{cert},
-					GetClientCertificate: func(*CertificateRequestInfo) (*tls.Certificate, error) { return &cert, nil },
-				},
-				serverCfg: &Config{
-					GetCertificate: func(*ClientHelloInfo) (*tls.Certificate, error) { return &srvCert, nil },
-					// Certificates:   []tls.Certificate{srvCert},
-					ClientAuth: RequireAndVerifyClientCert,
-					ClientCAs:  caPool,
-				},
-			},
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
+
+			if actual := server.ConnectionState().cipherSuite.ID(); actual != expectedCipherSuite {
+				t.Errorf("CipherSuite mismatch: expected %s, got %s", expectedCipherSuite, actual)
+			}
+			if info.ServerName != serverName {
+				t.Errorf("ServerName mismatch: expected %q, got %q", serverName, info.ServerName)
+			}
+			if !reflect.DeepEqual(info.CipherSuites, clientCipherSuites) {
+				t.Errorf("CipherSuites mismatch: expected %v, got %v", clientCipherSuites, info.CipherSuites)
+			}
+			if !reflect.DeepEqual(info.SupportedCurves, clientCurves) {
+				t.Errorf("SupportedCurves mismatch: expected %v, got %v", clientCurves, info.SupportedCurves)
+			}
+			if len(info.SignatureSchemes) == 0 {
+				t.Error("SignatureSchemes must not be empty")
+			}
+			if !reflect.DeepEqual(info.SupportedProtocols, clientProtocols) {
+				t.Errorf("SupportedProtocols mismatch: expected %v, got %v", clientProtocols, info.SupportedProtocols)
+			}
+		})
+	}
+}
+
+func TestEllipticCurveConfiguration(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, test := range []struct {
+		Name            string
+		ConfigCurves    []elliptic.Curve
+		HandshakeCurves []elliptic.Curve
+	}{
+		{
+			Name:            "Curve defaulting",
+			ConfigCurves:    nil,
+			HandshakeCurves: defaultCurves,
+		},
+		{
+			Name:            "Single curve",
+			ConfigCurves:    []elliptic.Curve{elliptic.X25519},
+			HandshakeCurves: []elliptic.Curve{elliptic.X25519},
+		},
+		{
+			Name:            "Multiple curves",
+			ConfigCurves:    []elliptic.Curve{elliptic.P384, elliptic.X25519},
+			HandshakeCurves: []elliptic.Curve{elliptic.P384, elliptic.X25519},
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		ca, cb := dpipe.Pipe()
+		type result struct {
+			c   *Conn
+			err error
 		}
-		for name, tt := range tests {
-			tt := tt
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-				type result struct {
-					c   *Conn
-					err error
+		c := make(chan result)
+
+		go func() {
+			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
+			c <- result{client, err}
+		}()
+
+		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
+		if err != nil {
+			t.Fatalf("Server error: %v", err)
+		}
+
+		if len(test.ConfigCurves) == 0 && len(test.HandshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
+			t.Fatalf("Failed to default Elliptic curves, expected %d, got: %d", len(test.HandshakeCurves), len(server.fsm.cfg.ellipticCurves))
+		}
+
+		if len(test.ConfigCurves) != 0 {
+			if len(test.HandshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
+				t.Fatalf("Failed to configure Elliptic curves, expect %d, got %d", len(test.HandshakeCurves), len(server.fsm.cfg.ellipticCurves))
+			}
+			for i, c := range test.ConfigCurves {
+				if c != server.fsm.cfg.ellipticCurves[i] {
+					t.Fatalf("Failed to maintain Elliptic curve order, expected %s, got %s", c, server.fsm.cfg.ellipticCurves[i])
 				}
-				c := make(chan result)
+			}
+		}
 
-				go func() {
-					client, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
-					c <- result{client, err}
-				}()
+		res := <-c
+		if res.err != nil {
+			t.Fatalf("Client error; %v", err)
+		}
 
-				server, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
-				res := <-c
-				defer func() {
-					if err == nil {
-						_ = server.Close()
-					}
-					if res.err == nil {
-						_ = res.c.Close()
-					}
-				}()
+		defer func() {
+			err = server.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = res.c.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}()
+	}
+}
+
+func TestRequiredCurve(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	tests := map[string]struct {
+		clientCurves      []elliptic.Curve
+		expectedClientErr error
+		expectedServerErr error
+	}{
+		"OfferedOnlyX25519": {
+			clientCurves:      []elliptic.Curve{elliptic.X25519},
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+			expectedServerErr: errRequiredCurveNotOffered,
+		},
+		"OfferedP384": {
+			clientCurves: []elliptic.Curve{elliptic.X25519, elliptic.P384},
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				EllipticCurves: tt.clientCurves,
+			}, &Config{
+				CipherSuites:  []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				RequiredCurve: elliptic.P384,
+			})
+			defer func() {
+				if serverErr == nil {
+					_ = server.Close()
+				}
+				if clientErr == nil {
+					_ = client.Close()
+				}
+			}()
+
+			if !errors.Is(clientErr, tt.expectedClientErr) {
+				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, clientErr)
+			}
+
+			if !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, serverErr)
+			}
+
+			if serverErr == nil {
+				if curve := server.state.namedCurve; curve != elliptic.P384 {
+					t.Errorf("Server negotiated curve expected: %s but got %s", elliptic.P384, curve)
+				}
+			}
+		})
+	}
+}
+
+func TestCurveNegotiation(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	tests := map[string]struct {
+		clientCurves      []elliptic.Curve
+		serverCurves      []elliptic.Curve
+		expectedCurve     elliptic.Curve
+		expectedClientErr error
+		expectedServerErr error
+	}{
+		"X448": {
+			clientCurves:  []elliptic.Curve{elliptic.X448},
+			serverCurves:  []elliptic.Curve{elliptic.X25519, elliptic.X448},
+			expectedCurve: elliptic.X448,
+		},
+		"P521": {
+			clientCurves:  []elliptic.Curve{elliptic.P521, elliptic.X25519},
+			serverCurves:  []elliptic.Curve{elliptic.X25519, elliptic.P521},
+			expectedCurve: elliptic.P521,
+		},
+		"SkipUnconfigured": {
+			clientCurves:  []elliptic.Curve{elliptic.X448, elliptic.P256},
+			expectedCurve: elliptic.P256,
+		},
+		"NoSharedCurve": {
+			clientCurves:      []elliptic.Curve{elliptic.X448},
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+			expectedServerErr: errNoSupportedEllipticCurves,
+		},
+	}
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-				if tt.wantErr {
-					if err != nil {
-						// Error expected, test succeeded
-						return
-					}
-					t.Error("Error expected")
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				EllipticCurves: tt.clientCurves,
+			}, &Config{
+				CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				EllipticCurves: tt.serverCurves,
+			})
+			defer func() {
+				if serverErr == nil {
+					_ = server.Close()
 				}
-				if err != nil {
-					t.Errorf("Server failed(%v)", err)
+				if clientErr == nil {
+					_ = client.Close()
 				}
+			}()
 
-				if res.err != nil {
-					t.Errorf("Client failed(%v)", res.err)
-				}
+			if !errors.Is(clientErr, tt.expectedClientErr) {
+				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, clientErr)
+			}
 
-				actualClientCert := server.ConnectionState().PeerCertificates
-				if tt.serverCfg.ClientAuth == RequireAnyClientCert || tt.serverCfg.ClientAuth == RequireAndVerifyClientCert {
-					if actualClientCert == nil {
-						t.Errorf("Client did not provide a certificate")
-					}
+			if !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, serverErr)
+			}
 
-					var cfgCert [][]byte
-					if len(tt.clientCfg.Certificates) > 0 {
-						cfgCert = tt.clientCfg.Certificates[0].Certificate
-					}
-					if tt.clientCfg.GetClientCertificate != nil {
-						crt, err := tt.clientCfg.GetClientCertificate(&CertificateRequestInfo{})
-						if err != nil {
-							t.Errorf("Server configuration did not provide a certificate")
-						}
-						cfgCert = crt.Certificate
-					}
-					if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualClientCert[0]) {
-						t.Errorf("Client certificate was not communicated correctly")
-					}
-				}
-				if tt.serverCfg.ClientAuth == NoClientCert {
-					if actualClientCert != nil {
-						t.Errorf("Client certificate wasn't expected")
-					}
+			if serverErr == nil {
+				if curve := server.state.namedCurve; curve != tt.expectedCurve {
+					t.Errorf("Server negotiated curve expected: %s but got %s", tt.expectedCurve, curve)
 				}
+			}
+		})
+	}
+}
 
-				actualServerCert := res.c.ConnectionState().PeerCertificates
-				if actualServerCert == nil {
-					t.Errorf("Server did not provide a certificate")
-				}
-				var cfgCert [][]byte
-				if len(tt.serverCfg.Certificates) > 0 {
-					cfgCert = tt.serverCfg.Certificates[0].Certificate
-				}
-				if tt.serverCfg.GetCertificate != nil {
-					crt, err := tt.serverCfg.GetCertificate(&ClientHelloInfo{})
-					if err != nil {
-						t.Errorf("Server configuration did not provide a certificate")
-					}
-					cfgCert = crt.Certificate
+func TestDANEVerifyPeerCertificate(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(serverCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	spki := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	otherSPKI := sha256.Sum256([]byte("another key"))
+
+	for name, tt := range map[string]struct {
+		tlsa              []dane.TLSARecord
+		expectedServerErr error
+	}{
+		"Match": {
+			tlsa: []dane.TLSARecord{{Usage: dane.UsageDANEEE, Selector: dane.SelectorSPKI, MatchingType: dane.MatchingTypeSHA256, Certificate: spki[:]}},
+		},
+		"Mismatch": {
+			tlsa:              []dane.TLSARecord{{Usage: dane.UsageDANEEE, Selector: dane.SelectorSPKI, MatchingType: dane.MatchingTypeSHA256, Certificate: otherSPKI[:]}},
+			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			var rawCerts [][]byte
+			verify := dane.VerifyPeerCertificate(tt.tlsa)
+			clientConfig := &Config{
+				VerifyPeerCertificate: func(raw [][]byte, chains [][]*x509.Certificate) error {
+					rawCerts = raw
+					return verify(raw, chains)
+				},
+			}
+
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, clientConfig, &Config{
+				Certificates: []tls.Certificate{serverCert},
+			})
+			defer func() {
+				if serverErr == nil {
+					_ = server.Close()
 				}
-				if len(cfgCert) == 0 || !bytes.Equal(cfgCert[0], actualServerCert[0]) {
-					t.Errorf("Server certificate was not communicated correctly")
+				if clientErr == nil {
+					_ = client.Close()
 				}
-			})
-		}
-	})
+			}()
+
+			if !reflect.DeepEqual(rawCerts, serverCert.Certificate) {
+				t.Error("VerifyPeerCertificate was not called with the server's DER chain")
+			}
+			if (clientErr == nil) != (tt.expectedServerErr == nil) {
+				t.Errorf("Unexpected client error: %v", clientErr)
+			}
+			// The server must not accept a Finished from a client that
+			// rejected its certificate.
+			if !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, serverErr)
+			}
+		})
+	}
 }
 
-func TestConnectionID(t *testing.T) {
+func TestTamperedServerKeyExchange(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	clientCID := []byte{5, 77, 33, 24, 93, 27, 45, 81}
-	serverCID := []byte{64, 24, 73, 2, 17, 96, 38, 59}
-	cidEcho := func(echo []byte) func() []byte {
-		return func() []byte {
-			return echo
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	client, server, clientErr, serverErr := handshakePair(ctx, ca, &tamperingConn{cb}, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	defer func() {
+		if serverErr == nil {
+			_ = server.Close()
 		}
+		if clientErr == nil {
+			_ = client.Close()
+		}
+	}()
+
+	if !errors.Is(clientErr, errKeySignatureMismatch) {
+		t.Errorf("Client error expected: \"%v\" but got \"%v\"", errKeySignatureMismatch, clientErr)
+	}
+
+	expectedServerErr := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}}
+	if !errors.Is(serverErr, expectedServerErr) {
+		t.Errorf("Server error expected: \"%v\" but got \"%v\"", expectedServerErr, serverErr)
+	}
+}
+
+// tamperingConn flips the last byte of every ServerKeyExchange record it
+// writes, which lies in the signature over the key exchange parameters.
+type tamperingConn struct {
+	net.Conn
+}
+
+func (c *tamperingConn) Write(b []byte) (int, error) {
+	out := append([]byte{}, b...)
+	for offset := 0; offset+recordlayer.FixedHeaderSize < len(out); {
+		end := offset + recordlayer.FixedHeaderSize + int(binary.BigEndian.Uint16(out[offset+11:]))
+		if end > len(out) {
+			break
+		}
+		if out[offset] == byte(protocol.ContentTypeHandshake) && out[offset+recordlayer.FixedHeaderSize] == byte(handshake.TypeServerKeyExchange) {
+			out[end-1] ^= 0xff
+		}
+		offset = end
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func TestDHEKeyExchange(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
 	}
+	serverCert, err := selfsign.SelfSign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	tests := map[string]struct {
-		clientCfg          *Config
-		serverCfg          *Config
-		clientConnectionID []byte
-		serverConnectionID []byte
+		clientGroups      []ffdhe.Group
+		clientMinBits     int
+		serverGroups      []ffdhe.Group
+		expectedGroup     ffdhe.Group
+		expectedClientErr error
+		expectedServerErr error
 	}{
-		"BidirectionalConnectionIDs": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
-			clientConnectionID: clientCID,
-			serverConnectionID: serverCID,
-		},
-		"BothSupportOnlyClientSends": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(nil),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
-			serverConnectionID: serverCID,
-		},
-		"BothSupportOnlyServerSends": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(nil),
-			},
-			clientConnectionID: clientCID,
+		"Default": {
+			expectedGroup: ffdhe.FFDHE2048,
 		},
-		"ClientDoesNotSupport": {
-			clientCfg: &Config{},
-			serverCfg: &Config{
-				ConnectionIDGenerator: cidEcho(serverCID),
-			},
+		"ClientPreference": {
+			clientGroups:  []ffdhe.Group{ffdhe.FFDHE3072, ffdhe.FFDHE2048},
+			expectedGroup: ffdhe.FFDHE3072,
 		},
-		"ServerDoesNotSupport": {
-			clientCfg: &Config{
-				ConnectionIDGenerator: cidEcho(clientCID),
-			},
-			serverCfg: &Config{},
+		"NoSharedGroup": {
+			clientGroups:      []ffdhe.Group{ffdhe.FFDHE4096},
+			serverGroups:      []ffdhe.Group{ffdhe.FFDHE2048},
+			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}},
+			expectedServerErr: errNoSupportedDHGroups,
 		},
-		"NeitherSupport": {
-			clientCfg: &Config{},
-			serverCfg: &Config{},
+		"PrimeTooSmall": {
+			clientMinBits:     3072,
+			serverGroups:      []ffdhe.Group{ffdhe.FFDHE2048},
+			expectedClientErr: errDHPrimeTooSmall,
+			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
 		},
 	}
-	for name, tt := range tests {
-		tt := tt
+	for name, tt := range tests {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				CipherSuites:       []CipherSuiteID{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256},
+				DHGroups:           tt.clientGroups,
+				MinDHPrimeBits:     tt.clientMinBits,
+				InsecureSkipVerify: true,
+			}, &Config{
+				Certificates: []tls.Certificate{serverCert},
+				CipherSuites: []CipherSuiteID{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256},
+				DHGroups:     tt.serverGroups,
+			})
+			defer func() {
+				if serverErr == nil {
+					_ = server.Close()
+				}
+				if clientErr == nil {
+					_ = client.Close()
+				}
+			}()
+
+			if !errors.Is(clientErr, tt.expectedClientErr) {
+				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, clientErr)
+			}
+
+			if !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, serverErr)
+			}
+
+			if serverErr == nil && clientErr == nil {
+				if group := server.state.dhGroup; group != tt.expectedGroup {
+					t.Errorf("Server negotiated group expected: %s but got %s", tt.expectedGroup, group)
+				}
+				if _, err := client.Write([]byte("dhe")); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 16)
+				n, err := server.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				} else if string(buf[:n]) != "dhe" {
+					t.Errorf("Server read expected: dhe but got %q", buf[:n])
+				}
+			}
+		})
+	}
+}
+
+func TestSkipHelloVerify(t *testing.T) {
+	report := test.CheckRoutines(t)
+	defer report()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHello := make(chan struct{})
+
+	go func() {
+		server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			Certificates:            []tls.Certificate{certificate},
+			LoggerFactory:           logging.NewDefaultLoggerFactory(),
+			InsecureSkipVerifyHello: true,
+		}, false)
+		if sErr != nil {
+			t.Error(sErr)
+			return
+		}
+		buf := make([]byte, 1024)
+		if _, sErr = server.Read(buf); sErr != nil {
+			t.Error(sErr)
+		}
+		gotHello <- struct{}{}
+		if sErr = server.Close(); sErr != nil { //nolint:contextcheck
+			t.Error(sErr)
+		}
+	}()
+
+	client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+		LoggerFactory:      logging.NewDefaultLoggerFactory(),
+		InsecureSkipVerify: true,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-gotHello:
+		// OK
+	case <-time.After(time.Second * 5):
+		t.Error("timeout")
+	}
+
+	if err = client.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShouldVerifyHello(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for name, verify := range map[string]bool{
+		"Verify": true,
+		"Skip":   false,
+	} {
+		verify := verify
 		t.Run(name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
-
-			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
-				c <- result{client, err}
-			}()
-
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
-			if err != nil {
-				t.Fatalf("Unexpected server error: %v", err)
-			}
-			res := <-c
-			if res.err != nil {
-				t.Fatalf("Unexpected client error: %v", res.err)
-			}
+			var calledWith net.Addr
+			client, server := pipeConnPair(t, ctx, &Config{
+				InsecureSkipVerify: true,
+			}, &Config{
+				ShouldVerifyHello: func(addr net.Addr) bool {
+					calledWith = addr
+					return verify
+				},
+			})
 			defer func() {
-				if err == nil {
-					_ = server.Close()
-				}
-				if res.err == nil {
-					_ = res.c.Close()
-				}
+				_ = client.Close()
+				_ = server.Close()
 			}()
 
-			if !bytes.Equal(res.c.state.localConnectionID, tt.clientConnectionID) {
-				t.Errorf("Unexpected client local connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, res.c.state.localConnectionID)
-			}
-			if !bytes.Equal(res.c.state.remoteConnectionID, tt.serverConnectionID) {
-				t.Errorf("Unexpected client remote connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, res.c.state.remoteConnectionID)
-			}
-			if !bytes.Equal(server.state.localConnectionID, tt.serverConnectionID) {
-				t.Errorf("Unexpected server local connection ID\nwant: %v\ngot:%v", tt.serverConnectionID, server.state.localConnectionID)
+			if calledWith == nil || calledWith.String() != server.RemoteAddr().String() {
+				t.Errorf("Expected ShouldVerifyHello to be called with %v, got %v", server.RemoteAddr(), calledWith)
 			}
-			if !bytes.Equal(server.state.remoteConnectionID, tt.clientConnectionID) {
-				t.Errorf("Unexpected server remote connection ID\nwant: %v\ngot:%v", tt.clientConnectionID, server.state.remoteConnectionID)
+			// The client only has a cookie if it received a HelloVerifyRequest.
+			if verified := len(client.state.cookie) > 0; verified != verify {
+				t.Errorf("Expected hello verification %v, got %v", verify, verified)
 			}
 		})
 	}
 }
 
-func TestExtendedMasterSecret(t *testing.T) {
+type connWithCallback struct {
+	net.Conn
+	onWrite func([]byte)
+}
+
+func (c *connWithCallback) Write(b []byte) (int, error) {
+	if c.onWrite != nil {
+		c.onWrite(b)
+	}
+	return c.Conn.Write(b)
+}
+
+func TestApplicationDataQueueLimited(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	tests := map[string]struct {
-		clientCfg         *Config
-		serverCfg         *Config
-		expectedClientErr error
-		expectedServerErr error
-	}{
-		"Request_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Request_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Request_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Require_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			expectedClientErr: errClientRequiredButNoServerEMS,
-			expectedServerErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-		},
-		"Disable_Request_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequestExtendedMasterSecret,
-			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
-		"Disable_Require_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
-			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: RequireExtendedMasterSecret,
-			},
-			expectedClientErr: &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			expectedServerErr: errServerRequiredButNoClientEMS,
-		},
-		"Disable_Disable_ExtendedMasterSecret": {
-			clientCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	defer ca.Close() //nolint:errcheck
+	defer cb.Close() //nolint:errcheck
+
+	done := make(chan struct{})
+	go func() {
+		serverCert, err := selfsign.GenerateSelfSigned()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		cfg := &Config{}
+		cfg.Certificates = []tls.Certificate{serverCert}
+
+		dconn, err := createConn(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), cfg, false)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			for i := 0; i < 5; i++ {
+				dconn.lock.RLock()
+				qlen := len(dconn.encryptedPackets)
+				dconn.lock.RUnlock()
+				if qlen > maxAppDataPacketQueueSize {
+					t.Error("too many encrypted packets enqueued", len(dconn.encryptedPackets))
+				}
+				t.Log(qlen)
+				time.Sleep(1 * time.Second)
+			}
+		}()
+		if _, err := handshakeConn(ctx, dconn, cfg, false, nil); err == nil {
+			t.Error("expected handshake to fail")
+		}
+		close(done)
+	}()
+	extensions := []extension.Extension{}
+
+	time.Sleep(50 * time.Millisecond)
+
+	err := sendClientHello([]byte{}, ca, 0, extensions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	for i := 0; i < 1000; i++ {
+		// Send an application data packet
+		packet, err := (&recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Version:        protocol.Version1_2,
+				SequenceNumber: uint64(3),
+				Epoch:          1, // use an epoch greater than 0
 			},
-			serverCfg: &Config{
-				ExtendedMasterSecret: DisableExtendedMasterSecret,
+			Content: &protocol.ApplicationData{
+				Data: []byte{1, 2, 3, 4},
 			},
-			expectedClientErr: nil,
-			expectedServerErr: nil,
-		},
+		}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ca.Write(packet) // nolint
+		if i%100 == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
 	}
-	for name, tt := range tests {
-		tt := tt
-		t.Run(name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
-
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
-
-			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg, true)
-				c <- result{client, err}
-			}()
+	time.Sleep(1 * time.Second)
+	ca.Close() // nolint
+	<-done
+}
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg, true)
-			res := <-c
-			defer func() {
-				if err == nil {
-					_ = server.Close()
-				}
-				if res.err == nil {
-					_ = res.c.Close()
-				}
-			}()
+func TestMaxEarlyPacketQueue(t *testing.T) {
+	ca, cb := dpipe.Pipe()
+	defer ca.Close() //nolint:errcheck
+	defer cb.Close() //nolint:errcheck
 
-			if !errors.Is(res.err, tt.expectedClientErr) {
-				t.Errorf("Client error expected: \"%v\" but got \"%v\"", tt.expectedClientErr, res.err)
-			}
+	dconn, err := createConn(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{MaxEarlyPacketQueue: 3}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			if !errors.Is(err, tt.expectedServerErr) {
-				t.Errorf("Server error expected: \"%v\" but got \"%v\"", tt.expectedServerErr, err)
-			}
-		})
+	for i := 0; i < 5; i++ {
+		if ok := dconn.enqueueEncryptedPackets(addrPkt{cb.RemoteAddr(), []byte{byte(i)}}); ok != (i < 3) {
+			t.Errorf("Packet %d enqueued: %v, expected %v", i, ok, i < 3)
+		}
+	}
+	if len(dconn.encryptedPackets) != 3 {
+		t.Errorf("Expected 3 queued packets, got %d", len(dconn.encryptedPackets))
+	}
+	stats := dconn.Stats()
+	if stats.MaxQueuedRecords != 3 || stats.DroppedQueueFull != 2 {
+		t.Errorf("Expected 3 queued and 2 dropped records, got %d and %d", stats.MaxQueuedRecords, stats.DroppedQueueFull)
 	}
 }
 
-func TestServerCertificate(t *testing.T) {
+func TestMaxRecordsPerDatagram(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cert, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
-	}
-	certificate, err := x509.ParseCertificate(cert.Certificate[0])
-	if err != nil {
-		t.Fatal(err)
-	}
-	caPool := x509.NewCertPool()
-	caPool.AddCert(certificate)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	t.Run("parallel", func(t *testing.T) { // sync routines to check routine leak
-		tests := map[string]struct {
-			clientCfg *Config
-			serverCfg *Config
-			wantErr   bool
-		}{
-			"no_ca": {
-				clientCfg: &Config{},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
-			"good_ca": {
-				clientCfg: &Config{RootCAs: caPool},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"no_ca_skip_verify": {
-				clientCfg: &Config{InsecureSkipVerify: true},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"good_ca_skip_verify_custom_verify_peer": {
-				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: RequireAnyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
-					if len(chain) != 0 {
-						return errNotExpectedChain
-					}
-					return nil
-				}},
-			},
-			"good_ca_verify_custom_verify_peer": {
-				clientCfg: &Config{RootCAs: caPool, Certificates: []tls.Certificate{cert}},
-				serverCfg: &Config{ClientCAs: caPool, Certificates: []tls.Certificate{cert}, ClientAuth: RequireAndVerifyClientCert, VerifyPeerCertificate: func(_ [][]byte, chain [][]*x509.Certificate) error {
-					if len(chain) == 0 {
-						return errExpecedChain
-					}
-					return nil
-				}},
-			},
-			"good_ca_custom_verify_peer": {
-				clientCfg: &Config{
-					RootCAs: caPool,
-					VerifyPeerCertificate: func([][]byte, [][]*x509.Certificate) error {
-						return errWrongCert
-					},
+	ca, cb := dpipe.Pipe()
+	client, server := connPair(t, ctx, ca, cb, &Config{}, &Config{MaxRecordsPerDatagram: 4})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	// Records of epoch 1 that fail to decrypt show whether the datagram
+	// was processed.
+	seq := uint64(1 << 20)
+	datagram := func(records int) []byte {
+		var out []byte
+		for i := 0; i < records; i++ {
+			raw, err := (&recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Version:        protocol.Version1_2,
+					Epoch:          1,
+					SequenceNumber: seq,
 				},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
-			"server_name": {
-				clientCfg: &Config{RootCAs: caPool, ServerName: certificate.Subject.CommonName},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-			},
-			"server_name_error": {
-				clientCfg: &Config{RootCAs: caPool, ServerName: "barfoo"},
-				serverCfg: &Config{Certificates: []tls.Certificate{cert}, ClientAuth: NoClientCert},
-				wantErr:   true,
-			},
+				Content: &protocol.ApplicationData{Data: make([]byte, 32)},
+			}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			seq++
+			out = append(out, raw...)
 		}
-		for name, tt := range tests {
-			tt := tt
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-
-				type result struct {
-					c   *Conn
-					err error
-				}
-				srvCh := make(chan result)
-				go func() {
-					s, err := Server(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), tt.serverCfg)
-					srvCh <- result{s, err}
-				}()
+		return out
+	}
+	waitStats := func(done func(Stats) bool) Stats {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stats := server.Stats()
+			if done(stats) || time.Now().After(deadline) {
+				return stats
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
 
-				cli, err := Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.clientCfg)
-				if err == nil {
-					_ = cli.Close()
-				}
-				if !tt.wantErr && err != nil {
-					t.Errorf("Client failed(%v)", err)
-				}
-				if tt.wantErr && err == nil {
-					t.Fatal("Error expected")
-				}
+	if _, err := ca.Write(datagram(5)); err != nil {
+		t.Fatal(err)
+	}
+	stats := waitStats(func(s Stats) bool { return s.DroppedTooManyRecords > 0 })
+	if stats.DroppedTooManyRecords != 1 || stats.DecryptFailures != 0 {
+		t.Fatalf("Expected the datagram to be dropped before decryption, got %d dropped and %d decrypt failures",
+			stats.DroppedTooManyRecords, stats.DecryptFailures)
+	}
 
-				srv := <-srvCh
-				if srv.err == nil {
-					_ = srv.c.Close()
-				}
-			})
-		}
-	})
+	if _, err := ca.Write(datagram(4)); err != nil {
+		t.Fatal(err)
+	}
+	stats = waitStats(func(s Stats) bool { return s.DecryptFailures >= 4 })
+	if stats.DroppedTooManyRecords != 1 || stats.DecryptFailures != 4 {
+		t.Errorf("Expected the records to be processed, got %d dropped and %d decrypt failures",
+			stats.DroppedTooManyRecords, stats.DecryptFailures)
+	}
 }
 
-func TestCipherSuiteConfiguration(t *testing.T) {
+func TestEarlyDataBuffer(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name                    string
-		ClientCipherSuites      []CipherSuiteID
-		ServerCipherSuites      []CipherSuiteID
-		WantClientError         error
-		WantServerError         error
-		WantSelectedCipherSuite CipherSuiteID
-	}{
-		{
-			Name:               "No CipherSuites specified",
-			ClientCipherSuites: nil,
-			ServerCipherSuites: nil,
-			WantClientError:    nil,
-			WantServerError:    nil,
-		},
-		{
-			Name:               "Invalid CipherSuite",
-			ClientCipherSuites: []CipherSuiteID{0x00},
-			ServerCipherSuites: []CipherSuiteID{0x00},
-			WantClientError:    &invalidCipherSuiteError{0x00},
-			WantServerError:    &invalidCipherSuiteError{0x00},
-		},
-		{
-			Name:                    "Valid CipherSuites specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
-		},
-		{
-			Name:               "CipherSuites mismatch",
-			ClientCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerCipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			WantClientError:    &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}},
-			WantServerError:    errCipherSuiteNoIntersection,
-		},
-		{
-			Name:                    "Valid CipherSuites CCM specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
-		},
-		{
-			Name:                    "Valid CipherSuites CCM-8 specified",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
-		},
-		{
-			Name:                    "Server supports subset of client suites",
-			ClientCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			ServerCipherSuites:      []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
-			WantClientError:         nil,
-			WantServerError:         nil,
-			WantSelectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
-		},
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, server := pipeConnPair(t, ctx, &Config{EnableHeartbeat: true}, &Config{
+		EnableHeartbeat: true,
+		EarlyDataBuffer: 64,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	// One record is held by the channel and six more fit in the buffer.
+	var payloads [][]byte
+	for i := 0; i < 10; i++ {
+		payload := bytes.Repeat([]byte{byte(i)}, 10)
+		payloads = append(payloads, payload)
+		if _, err := client.Write(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The server answers the HeartbeatRequest only if its read loop is not
+	// blocked on the unread records.
+	if err := client.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if dropped := server.Stats().DroppedBufferFull; dropped != 3 {
+		t.Errorf("Expected 3 records to be dropped, got %d", dropped)
+	}
+
+	buf := make([]byte, 64)
+	for i, payload := range payloads[:7] {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], payload) {
+			t.Errorf("Payload %d mismatch: got %v, expected %v", i, buf[:n], payload)
+		}
+	}
+
+	// The buffer accepts new records once it has been drained.
+	if _, err := client.Write(payloads[9]); err != nil {
+		t.Fatal(err)
+	}
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], payloads[9]) {
+		t.Errorf("Payload mismatch after draining: got %v, expected %v", buf[:n], payloads[9])
+	}
+}
+
+func TestDrainOnClose(t *testing.T) {
+	for name, tt := range map[string]struct {
+		drainOnClose bool
+		expected     []string
+	}{
+		// The record held for Read survives Close, the one the read loop
+		// is waiting to hand over is dropped.
+		"Default":      {false, []string{"a"}},
+		"DrainOnClose": {true, []string{"a", "b"}},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			// Limit runtime in case of deadlocks
+			lim := test.TimeOut(time.Second * 20)
+			defer lim.Stop()
+
+			// Check for leaking routines
+			report := test.CheckRoutines(t)
+			defer report()
+
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
-
-			go func() {
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.ClientCipherSuites}, true)
-				c <- result{client, err}
+			client, server := pipeConnPair(t, ctx, &Config{}, &Config{
+				DrainOnClose: tt.drainOnClose,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
 			}()
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: test.ServerCipherSuites}, true)
-			if err == nil {
-				defer func() {
-					_ = server.Close()
-				}()
-			}
-			if !errors.Is(err, test.WantServerError) {
-				t.Errorf("TestCipherSuiteConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
+			for _, payload := range []string{"a", "b"} {
+				if _, err := client.Write([]byte(payload)); err != nil {
+					t.Fatal(err)
+				}
 			}
 
-			res := <-c
-			if res.err == nil {
-				_ = server.Close()
-				_ = res.c.Close()
+			// Wait until both records and the Finished of the client have
+			// passed replay protection, then close without reading.
+			for accepted := uint64(0); accepted < 3; time.Sleep(time.Millisecond) {
+				for _, r := range server.Stats().Replay {
+					if r.Epoch == 1 {
+						accepted = r.Accepted
+					}
+				}
 			}
-			if !errors.Is(res.err, test.WantClientError) {
-				t.Errorf("TestSRTPConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
+			if err := server.Close(); err != nil {
+				t.Fatal(err)
 			}
-			if test.WantSelectedCipherSuite != 0x00 && res.c.state.cipherSuite.ID() != test.WantSelectedCipherSuite {
-				t.Errorf("TestCipherSuiteConfiguration: Server Selected Bad Cipher Suite '%s': expected(%v) actual(%v)", test.Name, test.WantSelectedCipherSuite, res.c.state.cipherSuite.ID())
+
+			buf := make([]byte, 8)
+			for _, expected := range tt.expected {
+				n, err := server.Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(buf[:n]) != expected {
+					t.Errorf("Expected %q, got %q", expected, buf[:n])
+				}
+			}
+			if _, err := server.Read(buf); !errors.Is(err, io.EOF) {
+				t.Errorf("Expected %v after the pending records, got %v", io.EOF, err)
 			}
 		})
 	}
 }
 
-func TestCertificateAndPSKServer(t *testing.T) {
+func TestInboundHookReorder(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name      string
-		ClientPSK bool
-	}{
-		{
-			Name:      "Client uses PKI",
-			ClientPSK: false,
-		},
-		{
-			Name:      "Client uses PSK",
-			ClientPSK: true,
-		},
-	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-			ca, cb := dpipe.Pipe()
-			type result struct {
-				c   *Conn
-				err error
-			}
-			c := make(chan result)
+	// Swap every pair of datagrams received by the client. A held datagram
+	// is released after the next one, which reorders the fragments of the
+	// server's flights and the flights themselves when a retransmission
+	// releases the last datagram of the previous one.
+	var (
+		held    []byte
+		swapped uint64
+	)
+	hook := func(_ net.Addr, data []byte) [][]byte {
+		if held == nil {
+			held = append([]byte{}, data...)
+			return nil
+		}
+		out := [][]byte{data, held}
+		held = nil
+		atomic.AddUint64(&swapped, 1)
+		return out
+	}
 
-			go func() {
-				config := &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}}
-				if test.ClientPSK {
-					config.PSK = func([]byte) ([]byte, error) {
-						return []byte{0x00, 0x01, 0x02}, nil
-					}
-					config.PSKIdentityHint = []byte{0x00}
-					config.CipherSuites = []CipherSuiteID{TLS_PSK_WITH_AES_128_GCM_SHA256}
-				}
+	client, server := pipeConnPair(t, ctx, &Config{
+		FlightInterval: 50 * time.Millisecond,
+		InboundHook:    hook,
+	}, &Config{
+		FlightInterval: 50 * time.Millisecond,
+		MTU:            300,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
-				c <- result{client, err}
-			}()
+	if atomic.LoadUint64(&swapped) < 2 {
+		t.Errorf("Expected at least two pairs of datagrams to be swapped, got %d", atomic.LoadUint64(&swapped))
+	}
+}
 
-			config := &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_PSK_WITH_AES_128_GCM_SHA256},
-				PSK: func([]byte) ([]byte, error) {
-					return []byte{0x00, 0x01, 0x02}, nil
-				},
-			}
+func TestChangeCipherSpecReordered(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-			if err == nil {
-				defer func() {
-					_ = server.Close()
-				}()
-			} else {
-				t.Errorf("TestCertificateAndPSKServer: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, err)
-			}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-			res := <-c
-			if res.err == nil {
-				_ = server.Close()
-				_ = res.c.Close()
-			} else {
-				t.Errorf("TestCertificateAndPSKServer: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, nil, res.err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Deliver every ChangeCipherSpec after the records that follow it in its
+	// datagram, so that the Finished of the next epoch overtakes it.
+	var reordered uint64
+	hook := func(_ net.Addr, data []byte) [][]byte {
+		pkts, err := recordlayer.UnpackDatagram(data)
+		if err != nil {
+			return [][]byte{data}
+		}
+		var out, ccs [][]byte
+		for _, p := range pkts {
+			if protocol.ContentType(p[0]) == protocol.ContentTypeChangeCipherSpec {
+				ccs = append(ccs, p)
+				continue
 			}
-		})
+			out = append(out, p)
+		}
+		if len(ccs) > 0 && len(out) > 0 {
+			atomic.AddUint64(&reordered, 1)
+		}
+		return append(out, ccs...)
+	}
+
+	// The flight interval is long enough for any retransmission to fail
+	// the test.
+	client, server := pipeConnPair(t, ctx, &Config{
+		FlightInterval: time.Minute,
+		InboundHook:    hook,
+	}, &Config{
+		FlightInterval: time.Minute,
+		InboundHook:    hook,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	if n := atomic.LoadUint64(&reordered); n != 2 {
+		t.Errorf("Expected the ChangeCipherSpec of both flights to be reordered, got %d", n)
+	}
+	for _, conn := range []*Conn{client, server} {
+		if n := conn.Stats().RetransmittedFlights; n != 0 {
+			t.Errorf("Expected no retransmissions, got %d", n)
+		}
+	}
+	if server.state.getRemoteEpoch() != 1 || client.state.getRemoteEpoch() != 1 {
+		t.Errorf("Expected a remote epoch of 1")
 	}
 }
 
-func TestPSKConfiguration(t *testing.T) {
-	// Check for leaking routines
+func TestHelloRandom(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	for _, test := range []struct {
-		Name                 string
-		ClientHasCertificate bool
-		ServerHasCertificate bool
-		ClientPSK            PSKCallback
-		ServerPSK            PSKCallback
-		ClientPSKIdentity    []byte
-		ServerPSKIdentity    []byte
-		WantClientError      error
-		WantServerError      error
-	}{
-		{
-			Name:                 "PSK and no certificate specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errNoAvailablePSKCipherSuite,
-			WantServerError:      errNoAvailablePSKCipherSuite,
-		},
-		{
-			Name:                 "PSK and certificate specified",
-			ClientHasCertificate: true,
-			ServerHasCertificate: true,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errNoAvailablePSKCipherSuite,
-			WantServerError:      errNoAvailablePSKCipherSuite,
-		},
-		{
-			Name:                 "PSK and no identity specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ServerPSK:            func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
-			ClientPSKIdentity:    nil,
-			ServerPSKIdentity:    nil,
-			WantClientError:      errPSKAndIdentityMustBeSetForClient,
-			WantServerError:      errNoAvailablePSKCipherSuite,
-		},
-		{
-			Name:                 "No PSK and identity specified",
-			ClientHasCertificate: false,
-			ServerHasCertificate: false,
-			ClientPSK:            nil,
-			ServerPSK:            nil,
-			ClientPSKIdentity:    []byte{0x00},
-			ServerPSKIdentity:    []byte{0x00},
-			WantClientError:      errIdentityNoPSK,
-			WantServerError:      errIdentityNoPSK,
-		},
-	} {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	ca, cb := dpipe.Pipe()
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotHello := make(chan struct{})
 
-		ca, cb := dpipe.Pipe()
-		type result struct {
-			c   *Conn
-			err error
-		}
-		c := make(chan result)
+	chRandom := [handshake.RandomBytesLength]byte{}
+	_, err = rand.Read(chRandom[:])
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{PSK: test.ClientPSK, PSKIdentityHint: test.ClientPSKIdentity}, test.ClientHasCertificate)
-			c <- result{client, err}
-		}()
+	go func() {
+		server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
+			GetCertificate: func(chi *ClientHelloInfo) (*tls.Certificate, error) {
+				if len(chi.CipherSuites) == 0 {
+					return &certificate, nil
+				}
 
-		_, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{PSK: test.ServerPSK, PSKIdentityHint: test.ServerPSKIdentity}, test.ServerHasCertificate)
-		if err != nil || test.WantServerError != nil {
-			if !(err != nil && test.WantServerError != nil && err.Error() == test.WantServerError.Error()) {
-				t.Fatalf("TestPSKConfiguration: Server Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantServerError, err)
-			}
+				if !bytes.Equal(chi.RandomBytes[:], chRandom[:]) {
+					t.Error("client hello random differs")
+				}
+
+				return &certificate, nil
+			},
+			LoggerFactory: logging.NewDefaultLoggerFactory(),
+		}, false)
+		if sErr != nil {
+			t.Error(sErr)
+			return
 		}
-
-		res := <-c
-		if res.err != nil || test.WantClientError != nil {
-			if !(res.err != nil && test.WantClientError != nil && res.err.Error() == test.WantClientError.Error()) {
-				t.Fatalf("TestPSKConfiguration: Client Error Mismatch '%s': expected(%v) actual(%v)", test.Name, test.WantClientError, res.err)
-			}
+		buf := make([]byte, 1024)
+		if _, sErr = server.Read(buf); sErr != nil {
+			t.Error(sErr)
+		}
+		gotHello <- struct{}{}
+		if sErr = server.Close(); sErr != nil { //nolint:contextcheck
+			t.Error(sErr)
 		}
+	}()
+
+	client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+		LoggerFactory: logging.NewDefaultLoggerFactory(),
+		HelloRandomBytesGenerator: func() [handshake.RandomBytesLength]byte {
+			return chRandom
+		},
+		InsecureSkipVerify: true,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-gotHello:
+		// OK
+	case <-time.After(time.Second * 5):
+		t.Error("timeout")
+	}
+
+	if err = client.Close(); err != nil {
+		t.Error(err)
 	}
 }
 
-func TestServerTimeout(t *testing.T) {
+func TestClientServerRandom(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -1866,554 +6451,531 @@ func TestServerTimeout(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cookie := make([]byte, 20)
-	_, err := rand.Read(cookie)
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	var rand [28]byte
-	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
-
-	cipherSuites := []CipherSuite{
-		&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{},
-		&ciphersuite.TLSEcdheRsaWithAes128GcmSha256{},
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	extensions := []extension.Extension{
-		&extension.SupportedSignatureAlgorithms{
-			SignatureHashAlgorithms: []signaturehash.Algorithm{
-				{Hash: hash.SHA256, Signature: signature.ECDSA},
-				{Hash: hash.SHA384, Signature: signature.ECDSA},
-				{Hash: hash.SHA512, Signature: signature.ECDSA},
-				{Hash: hash.SHA256, Signature: signature.RSA},
-				{Hash: hash.SHA384, Signature: signature.RSA},
-				{Hash: hash.SHA512, Signature: signature.RSA},
-			},
-		},
-		&extension.SupportedEllipticCurves{
-			EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
+	var clientHelloRandom, serverHelloRandom [handshake.RandomLength]byte
+	client, server := pipeConnPair(t, ctx, &Config{
+		InsecureSkipVerify: true,
+		ClientHelloMessageHook: func(ch handshake.MessageClientHello) handshake.Message {
+			clientHelloRandom = ch.Random.MarshalFixed()
+			return &ch
 		},
-		&extension.SupportedPointFormats{
-			PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
+	}, &Config{
+		ServerHelloMessageHook: func(sh handshake.MessageServerHello) handshake.Message {
+			serverHelloRandom = sh.Random.MarshalFixed()
+			return &sh
 		},
-	}
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-	record := &recordlayer.RecordLayer{
-		Header: recordlayer.Header{
-			SequenceNumber: 0,
-			Version:        protocol.Version1_2,
-		},
-		Content: &handshake.Handshake{
-			// sequenceNumber and messageSequence line up, may need to be re-evaluated
-			Header: handshake.Header{
-				MessageSequence: 0,
-			},
-			Message: &handshake.MessageClientHello{
-				Version:            protocol.Version1_2,
-				Cookie:             cookie,
-				Random:             random,
-				CipherSuiteIDs:     cipherSuiteIDs(cipherSuites),
-				CompressionMethods: defaultCompressionMethods(),
-				Extensions:         extensions,
-			},
-		},
-	}
+	for name, c := range map[string]*Conn{"Client": client, "Server": server} {
+		clientRandom, serverRandom := c.ClientRandom(), c.ServerRandom()
+		if len(clientRandom) != 32 || len(serverRandom) != 32 {
+			t.Fatalf("%s: expected 32 byte randoms, got %d and %d bytes", name, len(clientRandom), len(serverRandom))
+		}
+		if !bytes.Equal(clientRandom, clientHelloRandom[:]) {
+			t.Errorf("%s: ClientRandom %x does not match the ClientHello %x", name, clientRandom, clientHelloRandom)
+		}
+		if !bytes.Equal(serverRandom, serverHelloRandom[:]) {
+			t.Errorf("%s: ServerRandom %x does not match the ServerHello %x", name, serverRandom, serverHelloRandom)
+		}
 
-	packet, err := record.Marshal()
-	if err != nil {
-		t.Fatal(err)
+		// The values returned are copies.
+		clientRandom[0]++
+		if bytes.Equal(c.ClientRandom(), clientRandom) {
+			t.Errorf("%s: ClientRandom returned the connection state", name)
+		}
 	}
+}
 
-	ca, cb := dpipe.Pipe()
-	defer func() {
-		err := ca.Close()
-		if err != nil {
-			t.Fatal(err)
-		}
-	}()
+func TestRecordLayerVersionOverride(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
 
-	// Client reader
-	caReadChan := make(chan []byte, 1000)
-	go func() {
-		for {
-			data := make([]byte, 8192)
-			n, err := ca.Read(data)
-			if err != nil {
-				return
-			}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-			caReadChan <- data[:n]
-		}
-	}()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	// Start sending ClientHello packets until server responds with first packet
+	ca, cb := dpipe.Pipe()
+	clientErr := make(chan error, 1)
 	go func() {
-		for {
-			select {
-			case <-time.After(10 * time.Millisecond):
-				_, err := ca.Write(packet)
-				if err != nil {
-					return
-				}
-			case <-caReadChan:
-				// Once we receive the first reply from the server, stop
-				return
-			}
-		}
+		_, err := ClientWithContext(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			InsecureSkipVerify:         true,
+			RecordLayerVersionOverride: protocol.Version1_0,
+		})
+		clientErr <- err
 	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
-	defer cancel()
-
-	config := &Config{
-		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		FlightInterval: 100 * time.Millisecond,
+	buf := make([]byte, 8192)
+	n, err := cb.Read(buf)
+	if err != nil {
+		t.Fatal(err)
 	}
-
-	_, serverErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-	var netErr net.Error
-	if !errors.As(serverErr, &netErr) || !netErr.Timeout() {
-		t.Fatalf("Client error exp(Temporary network error) failed(%v)", serverErr)
+	cancel()
+	if err = <-clientErr; err == nil {
+		t.Error("Client handshake must not complete without a server")
 	}
+	_ = cb.Close()
 
-	// Wait a little longer to ensure no additional messages have been sent by the server
-	time.Sleep(300 * time.Millisecond)
-	select {
-	case msg := <-caReadChan:
-		t.Fatalf("Expected no additional messages from server, got: %+v", msg)
-	default:
+	r := &recordlayer.RecordLayer{}
+	if err = r.Unmarshal(buf[:n]); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Version != protocol.Version1_0 {
+		t.Errorf("Record layer version mismatch: expected %v, got %v", protocol.Version1_0, r.Header.Version)
+	}
+	h, ok := r.Content.(*handshake.Handshake)
+	if !ok {
+		t.Fatalf("Expected a handshake record, got %T", r.Content)
+	}
+	clientHello, ok := h.Message.(*handshake.MessageClientHello)
+	if !ok {
+		t.Fatalf("Expected a ClientHello, got %T", h.Message)
+	}
+	if clientHello.Version != protocol.Version1_2 {
+		t.Errorf("ClientHello version mismatch: expected %v, got %v", protocol.Version1_2, clientHello.Version)
 	}
 }
 
-func TestProtocolVersionValidation(t *testing.T) {
+func TestIncompleteServerFlight(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
-	defer report()
-
-	cookie := make([]byte, 20)
-	if _, err := rand.Read(cookie); err != nil {
-		t.Fatal(err)
-	}
-
-	var rand [28]byte
-	random := handshake.Random{GMTUnixTime: time.Unix(500, 0), RandomBytes: rand}
-
-	config := &Config{
-		CipherSuites:   []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-		FlightInterval: 100 * time.Millisecond,
-	}
-
-	t.Run("Server", func(t *testing.T) {
-		serverCases := map[string]struct {
-			records []*recordlayer.RecordLayer
-		}{
-			"ClientHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-				},
-			},
-			"SecondsClientHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version1_2,
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-					{
-						Header: recordlayer.Header{
-							Version:        protocol.Version1_2,
-							SequenceNumber: 1,
-						},
-						Content: &handshake.Handshake{
-							Header: handshake.Header{
-								MessageSequence: 1,
-							},
-							Message: &handshake.MessageClientHello{
-								Version:            protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Cookie:             cookie,
-								Random:             random,
-								CipherSuiteIDs:     []uint16{uint16((&ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256{}).ID())},
-								CompressionMethods: defaultCompressionMethods(),
-							},
-						},
-					},
-				},
-			},
-		}
-		for name, c := range serverCases {
-			c := c
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-				defer func() {
-					err := ca.Close()
-					if err != nil {
-						t.Error(err)
-					}
-				}()
+	defer report()
 
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
+	ca, cb := dpipe.Pipe()
+	sa, sb := dpipe.Pipe()
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+		_ = sa.Close()
+		_ = sb.Close()
+	}()
 
-				var wg sync.WaitGroup
-				wg.Add(1)
-				defer wg.Wait()
-				go func() {
-					defer wg.Done()
-					if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
-						t.Errorf("Client error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
-					}
-				}()
+	// Relay between client and server, dropping every ServerHelloDone.
+	relay := func(dst, src net.Conn, filter func([]byte) []byte) {
+		buf := make([]byte, 8192)
+		for {
+			n, err := src.Read(buf)
+			if err != nil {
+				return
+			}
+			if _, err := dst.Write(filter(buf[:n])); err != nil {
+				return
+			}
+		}
+	}
+	dropServerHelloDone := func(datagram []byte) []byte {
+		records, err := recordlayer.UnpackDatagram(datagram)
+		if err != nil {
+			return datagram
+		}
+		out := []byte{}
+		for _, r := range records {
+			if protocol.ContentType(r[0]) == protocol.ContentTypeHandshake &&
+				r[3] == 0 && r[4] == 0 && len(r) > recordlayer.FixedHeaderSize &&
+				handshake.Type(r[recordlayer.FixedHeaderSize]) == handshake.TypeServerHelloDone {
+				continue
+			}
+			out = append(out, r...)
+		}
+		return out
+	}
+	go relay(sa, cb, func(b []byte) []byte { return b })
+	go relay(cb, sa, dropServerHelloDone)
 
-				time.Sleep(50 * time.Millisecond)
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverCtx, serverCancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() {
+		_, err := ServerWithContext(serverCtx, dtlsnet.PacketConnFromConn(sb), sb.RemoteAddr(), &Config{
+			Certificates: []tls.Certificate{serverCert},
+		})
+		serverErr <- err
+	}()
+	defer func() {
+		serverCancel()
+		<-serverErr
+	}()
 
-				resp := make([]byte, 1024)
-				for _, record := range c.records {
-					packet, err := record.Marshal()
-					if err != nil {
-						t.Fatal(err)
-					}
-					if _, werr := ca.Write(packet); werr != nil {
-						t.Fatal(werr)
-					}
-					n, rerr := ca.Read(resp[:cap(resp)])
-					if rerr != nil {
-						t.Fatal(rerr)
-					}
-					resp = resp[:n]
-				}
+	const dialTimeout = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
 
-				h := &recordlayer.Header{}
-				if err := h.Unmarshal(resp); err != nil {
-					t.Fatal("Failed to unmarshal response")
-				}
-				if h.ContentType != protocol.ContentTypeAlert {
-					t.Errorf("Peer must return alert to unsupported protocol version")
-				}
-			})
-		}
+	start := time.Now()
+	_, err = ClientWithContext(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+		InsecureSkipVerify: true,
+		FlightInterval:     100 * time.Millisecond,
+		MaxRetransmissions: 1,
 	})
+	if !errors.Is(err, ErrIncompleteServerFlight) {
+		t.Fatalf("Expected error %v, got %v", ErrIncompleteServerFlight, err)
+	}
+	if elapsed := time.Since(start); elapsed >= dialTimeout/2 {
+		t.Errorf("Expected the handshake to fail well before the dial timeout, took %v", elapsed)
+	}
+}
 
-	t.Run("Client", func(t *testing.T) {
-		clientCases := map[string]struct {
-			records []*recordlayer.RecordLayer
-		}{
-			"ServerHelloVersion": {
-				records: []*recordlayer.RecordLayer{
-					{
-						Header: recordlayer.Header{
-							Version: protocol.Version1_2,
-						},
-						Content: &handshake.Handshake{
-							Message: &handshake.MessageHelloVerifyRequest{
-								Version: protocol.Version1_2,
-								Cookie:  cookie,
-							},
-						},
-					},
-					{
-						Header: recordlayer.Header{
-							Version:        protocol.Version1_2,
-							SequenceNumber: 1,
-						},
-						Content: &handshake.Handshake{
-							Header: handshake.Header{
-								MessageSequence: 1,
-							},
-							Message: &handshake.MessageServerHello{
-								Version:           protocol.Version{Major: 0xfe, Minor: 0xff}, // try to downgrade
-								Random:            random,
-								CipherSuiteID:     func() *uint16 { id := uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); return &id }(),
-								CompressionMethod: defaultCompressionMethods()[0],
-							},
-						},
-					},
-				},
-			},
-		}
-		for name, c := range clientCases {
-			c := c
-			t.Run(name, func(t *testing.T) {
-				ca, cb := dpipe.Pipe()
-				defer func() {
-					err := ca.Close()
-					if err != nil {
-						t.Error(err)
-					}
-				}()
+func TestCompressionMethod(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
 
-				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-				defer cancel()
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-				var wg sync.WaitGroup
-				wg.Add(1)
-				defer wg.Wait()
-				go func() {
-					defer wg.Done()
-					if _, err := testClient(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true); !errors.Is(err, errUnsupportedProtocolVersion) {
-						t.Errorf("Server error exp(%v) failed(%v)", errUnsupportedProtocolVersion, err)
-					}
-				}()
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-				time.Sleep(50 * time.Millisecond)
+	expected := defaultCompressionMethods()[0].ID
+	if actual := client.ConnectionState().CompressionMethod; actual != expected {
+		t.Errorf("Client compression method mismatch: expected %d, got %d", expected, actual)
+	}
+	if actual := server.ConnectionState().CompressionMethod; actual != expected {
+		t.Errorf("Server compression method mismatch: expected %d, got %d", expected, actual)
+	}
+}
 
-				for _, record := range c.records {
-					if _, err := ca.Read(make([]byte, 1024)); err != nil {
-						t.Fatal(err)
-					}
+func TestCompressionMethodNotNull(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 10)
+	defer lim.Stop()
 
-					packet, err := record.Marshal()
-					if err != nil {
-						t.Fatal(err)
-					}
-					if _, err := ca.Write(packet); err != nil {
-						t.Fatal(err)
-					}
-				}
-				resp := make([]byte, 1024)
-				n, err := ca.Read(resp)
-				if err != nil {
-					t.Fatal(err)
-				}
-				resp = resp[:n]
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-				h := &recordlayer.Header{}
-				if err := h.Unmarshal(resp); err != nil {
-					t.Fatal("Failed to unmarshal response")
-				}
-				if h.ContentType != protocol.ContentTypeAlert {
-					t.Errorf("Peer must return alert to unsupported protocol version")
-				}
-			})
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// DEFLATE, which clients never offer.
+	const deflate protocol.CompressionMethodID = 1
+
+	ca, cb := dpipe.Pipe()
+	client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{}, &Config{
+		ServerHelloMessageHook: func(sh handshake.MessageServerHello) handshake.Message {
+			sh.CompressionMethod = &protocol.CompressionMethod{ID: deflate}
+			return &sh
+		},
 	})
+	defer func() {
+		if serverErr == nil {
+			_ = server.Close()
+		}
+		if clientErr == nil {
+			_ = client.Close()
+		}
+	}()
+
+	if !errors.Is(clientErr, ErrUnsupportedCompressionMethod) {
+		t.Fatalf("Client error expected: \"%v\" but got \"%v\"", ErrUnsupportedCompressionMethod, clientErr)
+	}
+	var compressionErr *CompressionMethodError
+	if !errors.As(clientErr, &compressionErr) || compressionErr.Method != deflate {
+		t.Errorf("Expected a CompressionMethodError for method %d, got %v", deflate, clientErr)
+	}
+	expected := &alertError{&alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}}
+	if !errors.Is(serverErr, expected) {
+		t.Errorf("Server error expected: \"%v\" but got \"%v\"", expected, serverErr)
+	}
 }
 
-func TestMultipleHelloVerifyRequest(t *testing.T) {
+func TestApplicationDataNotAliased(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(time.Second * 20)
+	lim := test.TimeOut(time.Second * 10)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	cookies := [][]byte{
-		// first clientHello contains an empty cookie
-		{},
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
 	}
-	var packets [][]byte
-	for i := 0; i < 2; i++ {
-		cookie := make([]byte, 20)
-		if _, err := rand.Read(cookie); err != nil {
-			t.Fatal(err)
-		}
-		cookies = append(cookies, cookie)
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-		record := &recordlayer.RecordLayer{
-			Header: recordlayer.Header{
-				SequenceNumber: uint64(i),
-				Version:        protocol.Version1_2,
-			},
-			Content: &handshake.Handshake{
-				Header: handshake.Header{
-					MessageSequence: uint16(i),
-				},
-				Message: &handshake.MessageHelloVerifyRequest{
+	appData := func(data []byte) *packet {
+		return &packet{
+			record: &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Epoch:   client.state.getLocalEpoch(),
 					Version: protocol.Version1_2,
-					Cookie:  cookie,
+				},
+				Content: &protocol.ApplicationData{
+					Data: data,
 				},
 			},
+			shouldEncrypt: true,
 		}
-		packet, err := record.Marshal()
-		if err != nil {
-			t.Fatal(err)
-		}
-		packets = append(packets, packet)
 	}
 
-	ca, cb := dpipe.Pipe()
-	defer func() {
-		err := ca.Close()
-		if err != nil {
-			t.Error(err)
+	// Both records are compacted into a single datagram, so they are
+	// decrypted from the same pooled read buffer.
+	first := bytes.Repeat([]byte{0x01}, 64)
+	second := bytes.Repeat([]byte{0x02}, 64)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err = client.writePackets(ctx, []*packet{appData(first), appData(second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deliver a further datagram to make sure the read buffer was reused.
+	if _, err = client.Write(bytes.Repeat([]byte{0x03}, 64)); err != nil {
+		t.Fatal(err)
+	}
+
+	var received [3][]byte
+	for i := range received {
+		buf := make([]byte, 128)
+		n, rErr := server.Read(buf)
+		if rErr != nil {
+			t.Fatal(rErr)
 		}
-	}()
+		received[i] = buf[:n]
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+	if !bytes.Equal(received[0], first) {
+		t.Errorf("First record was modified: expected %x, got %x", first, received[0])
+	}
+	if !bytes.Equal(received[1], second) {
+		t.Errorf("Second record was modified: expected %x, got %x", second, received[1])
+	}
+}
 
-	var wg sync.WaitGroup
-	wg.Add(1)
-	defer wg.Wait()
-	go func() {
-		defer wg.Done()
-		_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{}, false)
-	}()
+func TestECDHEKeyReuse(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverKey := func(config *Config) []byte {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
 
-	for i, cookie := range cookies {
-		// read client hello
-		resp := make([]byte, 1024)
-		n, err := cb.Read(resp)
-		if err != nil {
-			t.Fatal(err)
-		}
-		record := &recordlayer.RecordLayer{}
-		if err := record.Unmarshal(resp[:n]); err != nil {
-			t.Fatal(err)
-		}
-		clientHello, ok := record.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
-		if !ok {
-			t.Fatal("Failed to cast MessageClientHello")
-		}
+		client, server := pipeConnPair(t, ctx, &Config{}, config)
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
 
-		if !bytes.Equal(clientHello.Cookie, cookie) {
-			t.Fatalf("Wrong cookie, expected: %x, got: %x", clientHello.Cookie, cookie)
-		}
-		if len(packets) <= i {
-			break
+		return server.state.localKeypair.PublicKey
+	}
+
+	t.Run("Fresh", func(t *testing.T) {
+		config := &Config{}
+		if bytes.Equal(serverKey(config), serverKey(config)) {
+			t.Error("Server must generate a fresh key for each handshake")
 		}
-		// write hello verify request
-		if _, err := cb.Write(packets[i]); err != nil {
-			t.Fatal(err)
+	})
+
+	t.Run("Reused", func(t *testing.T) {
+		config := &Config{ECDHEKeyReuse: time.Hour}
+		if !bytes.Equal(serverKey(config), serverKey(config)) {
+			t.Error("Server must reuse its key within the window")
 		}
-	}
-	cancel()
+	})
 }
 
-// Assert that a DTLS Server always responds with RenegotiationInfo if
-// a ClientHello contained that extension or not
-func TestRenegotationInfo(t *testing.T) {
+func TestHandshakeErrorLastFlight(t *testing.T) {
 	// Limit runtime in case of deadlocks
-	lim := test.TimeOut(10 * time.Second)
+	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
 
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	resp := make([]byte, 1024)
+	isServerHello := func(r []byte) bool {
+		return protocol.ContentType(r[0]) == protocol.ContentTypeHandshake &&
+			len(r) > recordlayer.FixedHeaderSize &&
+			handshake.Type(r[recordlayer.FixedHeaderSize]) == handshake.TypeServerHello
+	}
 
-	for _, testCase := range []struct {
-		Name                  string
-		SendRenegotiationInfo bool
+	for name, tt := range map[string]struct {
+		// drop reports whether a record sent by the server is dropped, or
+		// nil if the server does not respond at all.
+		drop     func([]byte) bool
+		expected flightVal
 	}{
-		{
-			"Include RenegotiationInfo",
-			true,
+		"NoServer": {
+			expected: flight1,
 		},
-		{
-			"No RenegotiationInfo",
-			false,
+		"NoServerHello": {
+			drop:     isServerHello,
+			expected: flight3,
 		},
 	} {
-		test := testCase
-		t.Run(test.Name, func(t *testing.T) {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ca, cb := dpipe.Pipe()
+			sa, sb := dpipe.Pipe()
 			defer func() {
-				if err := ca.Close(); err != nil {
-					t.Error(err)
-				}
+				_ = ca.Close()
+				_ = cb.Close()
+				_ = sa.Close()
+				_ = sb.Close()
 			}()
 
-			ctx, cancel := context.WithCancel(context.Background())
-			defer cancel()
-
-			go func() {
-				if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
-					t.Error(err)
+			if tt.drop != nil {
+				relay := func(dst, src net.Conn, drop func([]byte) bool) {
+					buf := make([]byte, 8192)
+					for {
+						n, err := src.Read(buf)
+						if err != nil {
+							return
+						}
+						records, err := recordlayer.UnpackDatagram(buf[:n])
+						if err != nil {
+							continue
+						}
+						out := []byte{}
+						for _, r := range records {
+							if !drop(r) {
+								out = append(out, r...)
+							}
+						}
+						if _, err := dst.Write(out); err != nil {
+							return
+						}
+					}
 				}
-			}()
-
-			time.Sleep(50 * time.Millisecond)
+				go relay(sa, cb, func([]byte) bool { return false })
+				go relay(cb, sa, tt.drop)
 
-			extensions := []extension.Extension{}
-			if test.SendRenegotiationInfo {
-				extensions = append(extensions, &extension.RenegotiationInfo{
-					RenegotiatedConnection: 0,
-				})
-			}
-			err := sendClientHello([]byte{}, ca, 0, extensions)
-			if err != nil {
-				t.Fatal(err)
+				serverCtx, serverCancel := context.WithCancel(context.Background())
+				serverErr := make(chan error, 1)
+				go func() {
+					_, err := testServer(serverCtx, dtlsnet.PacketConnFromConn(sb), sb.RemoteAddr(), &Config{}, true)
+					serverErr <- err
+				}()
+				defer func() {
+					serverCancel()
+					<-serverErr
+				}()
 			}
-			n, err := ca.Read(resp)
-			if err != nil {
-				t.Fatal(err)
+
+			_, err := testClient(context.Background(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+				InsecureSkipVerify: true,
+				FlightInterval:     100 * time.Millisecond,
+				MaxRetransmissions: 1,
+			}, false)
+			var hsErr *HandshakeError
+			if !errors.As(err, &hsErr) {
+				t.Fatalf("Expected HandshakeError, got %v", err)
 			}
-			r := &recordlayer.RecordLayer{}
-			if err = r.Unmarshal(resp[:n]); err != nil {
-				t.Fatal(err)
+			if hsErr.LastFlight != tt.expected.String() {
+				t.Errorf("LastFlight mismatch: expected %v, got %v", tt.expected, hsErr.LastFlight)
 			}
+		})
+	}
+}
 
-			helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
-			if !ok {
-				t.Fatal("Failed to cast MessageHelloVerifyRequest")
-			}
+func TestSNIMatchesCertificate(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-			err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if n, err = ca.Read(resp); err != nil {
-				t.Fatal(err)
-			}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-			messages, err := recordlayer.UnpackDatagram(resp[:n])
-			if err != nil {
-				t.Fatal(err)
-			}
+	serverCert, err := selfsign.GenerateSelfSignedWithDNS("foo.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
 
-			if err := r.Unmarshal(messages[0]); err != nil {
-				t.Fatal(err)
-			}
+	for name, tt := range map[string]struct {
+		serverName      string
+		requireSNIMatch bool
+		expectedMatch   bool
+		expectedErr     error
+	}{
+		"Match": {
+			serverName:    "foo.example.com",
+			expectedMatch: true,
+		},
+		"Mismatch": {
+			serverName: "bar.example.com",
+		},
+		"NoSNI": {},
+		"RequireMatch": {
+			serverName:      "foo.example.com",
+			requireSNIMatch: true,
+			expectedMatch:   true,
+		},
+		"RequireMismatch": {
+			serverName:      "bar.example.com",
+			requireSNIMatch: true,
+			expectedErr:     errSNICertificateMismatch,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-			serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-			if !ok {
-				t.Fatal("Failed to cast MessageServerHello")
-			}
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				ServerName:         tt.serverName,
+				InsecureSkipVerify: true,
+				RequireSNIMatch:    tt.requireSNIMatch,
+			}, &Config{
+				Certificates: []tls.Certificate{serverCert},
+			})
+			defer func() {
+				if clientErr == nil {
+					_ = client.Close()
+				}
+				if serverErr == nil {
+					_ = server.Close()
+				}
+			}()
 
-			gotNegotationInfo := false
-			for _, v := range serverHello.Extensions {
-				if _, ok := v.(*extension.RenegotiationInfo); ok {
-					gotNegotationInfo = true
+			if tt.expectedErr != nil {
+				if !errors.Is(clientErr, tt.expectedErr) {
+					t.Fatalf("Expected error %v, got %v", tt.expectedErr, clientErr)
 				}
+				return
 			}
-
-			if !gotNegotationInfo {
-				t.Fatalf("Received ServerHello without RenegotiationInfo")
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			if actual := client.ConnectionState().SNIMatchesCertificate; actual != tt.expectedMatch {
+				t.Errorf("SNIMatchesCertificate mismatch: expected %v, got %v", tt.expectedMatch, actual)
 			}
 		})
 	}
 }
 
-func TestServerNameIndicationExtension(t *testing.T) {
+func TestGetConfigForClient(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2422,87 +6984,239 @@ func TestServerNameIndicationExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name       string
-		ServerName string
-		Expected   []byte
-		IncludeSNI bool
+	fooCert, err := selfsign.GenerateSelfSignedWithDNS("foo.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	barCert, err := selfsign.GenerateSelfSignedWithDNS("bar.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	getConfigForClient := func(info *ClientHelloInfo) (*Config, error) {
+		switch info.ServerName {
+		case "foo.example.com":
+			return nil, nil
+		case "bar.example.com":
+			return &Config{
+				Certificates:          []tls.Certificate{barCert},
+				CipherSuites:          []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+				ConnectionIDGenerator: RandomCIDGenerator(8),
+			}, nil
+		default:
+			return nil, errUnknownServerName
+		}
+	}
+
+	for name, tt := range map[string]struct {
+		serverName          string
+		expectedCert        tls.Certificate
+		expectedCipherSuite CipherSuiteID
+		expectedCID         bool
+		expectedErr         error
 	}{
-		{
-			Name:       "Server name is a valid hostname",
-			ServerName: "example.com",
-			Expected:   []byte("example.com"),
-			IncludeSNI: true,
+		"Base": {
+			serverName:          "foo.example.com",
+			expectedCert:        fooCert,
+			expectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 		},
-		{
-			Name:       "Server name is an IP literal",
-			ServerName: "1.2.3.4",
-			Expected:   []byte(""),
-			IncludeSNI: false,
+		"Swapped": {
+			serverName:          "bar.example.com",
+			expectedCert:        barCert,
+			expectedCipherSuite: TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			expectedCID:         true,
 		},
-		{
-			Name:       "Server name is empty",
-			ServerName: "",
-			Expected:   []byte(""),
-			IncludeSNI: false,
+		"Error": {
+			serverName:  "baz.example.com",
+			expectedErr: errUnknownServerName,
 		},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
+			cookies := &testCookieGenerator{key: []byte("secret")}
+
 			ca, cb := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					ServerName: test.ServerName,
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				ServerName:            tt.serverName,
+				InsecureSkipVerify:    true,
+				ConnectionIDGenerator: OnlySendCIDGenerator(),
+			}, &Config{
+				Certificates:    []tls.Certificate{fooCert},
+				CookieGenerator: cookies,
+				GetConfigForClient: func(info *ClientHelloInfo) (*Config, error) {
+					cookies.mu.Lock()
+					verified := cookies.verified
+					cookies.mu.Unlock()
+					if verified == 0 {
+						t.Error("GetConfigForClient called before the cookie exchange")
+					}
+					return getConfigForClient(info)
+				},
+			})
+			defer func() {
+				if clientErr == nil {
+					_ = client.Close()
+				}
+				if serverErr == nil {
+					_ = server.Close()
 				}
-
-				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
 			}()
 
-			// Receive ClientHello
-			resp := make([]byte, 1024)
-			n, err := cb.Read(resp)
-			if err != nil {
-				t.Fatal(err)
+			if tt.expectedErr != nil {
+				if !errors.Is(serverErr, tt.expectedErr) {
+					t.Fatalf("Expected server error %v, got %v", tt.expectedErr, serverErr)
+				}
+				if clientErr == nil {
+					t.Fatal("Expected the client handshake to fail")
+				}
+				return
 			}
-			r := &recordlayer.RecordLayer{}
-			if err = r.Unmarshal(resp[:n]); err != nil {
-				t.Fatal(err)
+			if clientErr != nil {
+				t.Fatal(clientErr)
+			}
+			state := client.ConnectionState()
+			if !bytes.Equal(state.PeerCertificates[0], tt.expectedCert.Certificate[0]) {
+				t.Error("Client received an unexpected certificate")
 			}
+			if id := state.cipherSuite.ID(); id != tt.expectedCipherSuite {
+				t.Errorf("Expected cipher suite %s, got %s", tt.expectedCipherSuite, id)
+			}
+			if cid := state.remoteConnectionID != nil; cid != tt.expectedCID {
+				t.Errorf("Expected a connection ID to be negotiated: %v, got %v", tt.expectedCID, cid)
+			}
+		})
+	}
+}
 
-			clientHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageClientHello)
-			if !ok {
-				t.Fatal("Failed to cast MessageClientHello")
+func TestSupportedVersions(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	dtls13 := []protocol.Version{protocol.Version1_3, protocol.Version1_2}
+
+	for name, tt := range map[string]struct {
+		maxVersion        protocol.Version
+		offerOnly         []protocol.Version
+		serverSelects     protocol.Version
+		expectedOffer     []protocol.Version
+		expectedClientErr error
+		expectedServerErr error
+	}{
+		"Default": {},
+		"OfferDTLS13": {
+			maxVersion:    protocol.Version1_3,
+			expectedOffer: dtls13,
+		},
+		"ServerSelectsDTLS13": {
+			maxVersion:        protocol.Version1_3,
+			serverSelects:     protocol.Version1_3,
+			expectedOffer:     dtls13,
+			expectedClientErr: errUnsupportedProtocolVersion,
+		},
+		"OnlyDTLS13": {
+			offerOnly:         []protocol.Version{protocol.Version1_3},
+			expectedOffer:     []protocol.Version{protocol.Version1_3},
+			expectedServerErr: errUnsupportedProtocolVersion,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			serverConfig := &Config{}
+			if tt.serverSelects != (protocol.Version{}) {
+				serverConfig.ServerHelloMessageHook = func(sh handshake.MessageServerHello) handshake.Message {
+					sh.Extensions = append(sh.Extensions, &extension.SupportedVersions{SelectedVersion: tt.serverSelects})
+					return &sh
+				}
 			}
 
-			gotSNI := false
-			var actualServerName string
-			for _, v := range clientHello.Extensions {
-				if _, ok := v.(*extension.ServerName); ok {
-					gotSNI = true
-					extensionServerName, ok := v.(*extension.ServerName)
-					if !ok {
-						t.Fatal("Failed to cast extension.ServerName")
+			var offered []protocol.Version
+			clientConfig := &Config{
+				InsecureSkipVerify: true,
+				MaxVersion:         tt.maxVersion,
+				ClientHelloMessageHook: func(ch handshake.MessageClientHello) handshake.Message {
+					if tt.offerOnly != nil {
+						ch.Extensions = append(ch.Extensions, &extension.SupportedVersions{Versions: tt.offerOnly})
+					}
+					for _, e := range ch.Extensions {
+						if s, ok := e.(*extension.SupportedVersions); ok {
+							offered = s.Versions
+						}
 					}
+					return &ch
+				},
+			}
 
-					actualServerName = extensionServerName.ServerName
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, clientConfig, serverConfig)
+			defer func() {
+				if clientErr == nil {
+					_ = client.Close()
 				}
-			}
+				if serverErr == nil {
+					_ = server.Close()
+				}
+			}()
 
-			if gotSNI != test.IncludeSNI {
-				t.Errorf("TestSNI: unexpected SNI inclusion '%s': expected(%v) actual(%v)", test.Name, test.IncludeSNI, gotSNI)
+			if !reflect.DeepEqual(offered, tt.expectedOffer) {
+				t.Errorf("Expected the client to offer %v, got %v", tt.expectedOffer, offered)
 			}
-
-			if !bytes.Equal([]byte(actualServerName), test.Expected) {
-				t.Errorf("TestSNI: server name mismatch '%s': expected(%v) actual(%v)", test.Name, test.Expected, actualServerName)
+			if tt.expectedClientErr != nil && !errors.Is(clientErr, tt.expectedClientErr) {
+				t.Errorf("Expected client error %v, got %v", tt.expectedClientErr, clientErr)
+			}
+			if tt.expectedServerErr != nil && !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Expected server error %v, got %v", tt.expectedServerErr, serverErr)
+			}
+			if tt.expectedClientErr == nil && tt.expectedServerErr == nil && (clientErr != nil || serverErr != nil) {
+				t.Errorf("Unexpected handshake errors: client %v, server %v", clientErr, serverErr)
 			}
 		})
 	}
 }
 
-func TestALPNExtension(t *testing.T) {
+type testCookieGenerator struct {
+	key    []byte
+	reject bool
+
+	mu                      sync.Mutex
+	generated, verified     int
+	generatedFor, verifyFor []byte
+}
+
+func (g *testCookieGenerator) mac(clientAddr net.Addr, clientHello []byte) []byte {
+	mac := hmac.New(sha256.New, g.key)
+	_, _ = mac.Write([]byte(clientAddr.String()))
+	_, _ = mac.Write(clientHello)
+	return mac.Sum(nil)
+}
+
+func (g *testCookieGenerator) Generate(clientAddr net.Addr, clientHello []byte) ([]byte, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.generated++
+	g.generatedFor = append([]byte{}, clientHello...)
+	return g.mac(clientAddr, clientHello), nil
+}
+
+func (g *testCookieGenerator) Verify(clientAddr net.Addr, clientHello, cookie []byte) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.verified++
+	g.verifyFor = append([]byte{}, clientHello...)
+	return !g.reject && hmac.Equal(cookie, g.mac(clientAddr, clientHello))
+}
+
+func TestCookieGenerator(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2511,222 +7225,207 @@ func TestALPNExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name                   string
-		ClientProtocolNameList []string
-		ServerProtocolNameList []string
-		ExpectedProtocol       string
-		ExpectAlertFromClient  bool
-		ExpectAlertFromServer  bool
-		Alert                  alert.Description
+	for name, tt := range map[string]struct {
+		reject      bool
+		expectedErr error
 	}{
-		{
-			Name:                   "Negotiate a protocol",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
-			ServerProtocolNameList: []string{"spd/1"},
-			ExpectedProtocol:       "spd/1",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
-		},
-		{
-			Name:                   "Server doesn't support any",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1"},
-			ServerProtocolNameList: []string{},
-			ExpectedProtocol:       "",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
-		},
-		{
-			Name:                   "Negotiate with higher server precedence",
-			ClientProtocolNameList: []string{"http/1.1", "spd/1", "http/3"},
-			ServerProtocolNameList: []string{"ssh/2", "http/3", "spd/1"},
-			ExpectedProtocol:       "http/3",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  false,
-			Alert:                  0,
-		},
-		{
-			Name:                   "Empty intersection",
-			ClientProtocolNameList: []string{"http/1.1", "http/3"},
-			ServerProtocolNameList: []string{"ssh/2", "spd/1"},
-			ExpectedProtocol:       "",
-			ExpectAlertFromClient:  false,
-			ExpectAlertFromServer:  true,
-			Alert:                  alert.NoApplicationProtocol,
-		},
-		{
-			Name:                   "Multiple protocols in ServerHello",
-			ClientProtocolNameList: []string{"http/1.1"},
-			ServerProtocolNameList: []string{"http/1.1"},
-			ExpectedProtocol:       "http/1.1",
-			ExpectAlertFromClient:  true,
-			ExpectAlertFromServer:  false,
-			Alert:                  alert.InternalError,
+		"Accepted": {},
+		"Rejected": {
+			reject:      true,
+			expectedErr: errCookieMismatch,
 		},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
 			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 			defer cancel()
 
+			generator := &testCookieGenerator{key: []byte("cluster secret"), reject: tt.reject}
+
 			ca, cb := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					SupportedProtocols: test.ClientProtocolNameList,
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, &Config{
+				InsecureSkipVerify: true,
+			}, &Config{
+				CookieGenerator: generator,
+			})
+			defer func() {
+				if clientErr == nil {
+					_ = client.Close()
+				}
+				if serverErr == nil {
+					_ = server.Close()
 				}
-				_, _ = testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), conf, false)
 			}()
 
-			// Receive ClientHello
-			resp := make([]byte, 1024)
-			n, err := cb.Read(resp)
-			if err != nil {
-				t.Fatal(err)
+			generator.mu.Lock()
+			defer generator.mu.Unlock()
+			if generator.generated == 0 || generator.verified == 0 {
+				t.Fatalf("Expected the cookie to be generated and verified, got %d and %d calls", generator.generated, generator.verified)
+			}
+			if !bytes.Equal(generator.generatedFor, generator.verifyFor) {
+				t.Error("Expected the same ClientHello to be passed to Generate and Verify")
 			}
 
-			ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Second)
-			defer cancel2()
-
-			ca2, cb2 := dpipe.Pipe()
-			go func() {
-				conf := &Config{
-					SupportedProtocols: test.ServerProtocolNameList,
-				}
-				if _, err2 := testServer(ctx2, dtlsnet.PacketConnFromConn(cb2), cb2.RemoteAddr(), conf, true); !errors.Is(err2, context.Canceled) {
-					if test.ExpectAlertFromServer { //nolint
-						// Assert the error type?
-					} else {
-						t.Error(err2)
-					}
+			if tt.expectedErr != nil {
+				if !errors.Is(serverErr, tt.expectedErr) {
+					t.Fatalf("Expected server error %v, got %v", tt.expectedErr, serverErr)
 				}
-			}()
-
-			time.Sleep(50 * time.Millisecond)
-
-			// Forward ClientHello
-			if _, err = ca2.Write(resp[:n]); err != nil {
-				t.Fatal(err)
+				return
 			}
-
-			// Receive HelloVerify
-			resp2 := make([]byte, 1024)
-			n, err = ca2.Read(resp2)
-			if err != nil {
-				t.Fatal(err)
+			if clientErr != nil || serverErr != nil {
+				t.Fatalf("Unexpected handshake errors: client %v, server %v", clientErr, serverErr)
 			}
+		})
+	}
+}
 
-			// Forward HelloVerify
-			if _, err = cb.Write(resp2[:n]); err != nil {
-				t.Fatal(err)
-			}
+// redirectConn sends the first datagram written, and reads the first
+// reply, through first, and all the others through then.
+type redirectConn struct {
+	net.Conn
+	then net.Conn
 
-			// Receive ClientHello
-			resp3 := make([]byte, 1024)
-			n, err = cb.Read(resp3)
-			if err != nil {
-				t.Fatal(err)
-			}
+	mu          sync.Mutex
+	wrote, read bool
+}
 
-			// Forward ClientHello
-			if _, err = ca2.Write(resp3[:n]); err != nil {
-				t.Fatal(err)
-			}
+func (c *redirectConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	conn := c.then
+	if !c.wrote {
+		conn, c.wrote = c.Conn, true
+	}
+	c.mu.Unlock()
+	return conn.Write(b)
+}
 
-			// Receive ServerHello
-			resp4 := make([]byte, 1024)
-			n, err = ca2.Read(resp4)
-			if err != nil {
-				t.Fatal(err)
-			}
+func (c *redirectConn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	conn := c.then
+	if !c.read {
+		conn, c.read = c.Conn, true
+	}
+	c.mu.Unlock()
+	return conn.Read(b)
+}
 
-			messages, err := recordlayer.UnpackDatagram(resp4[:n])
-			if err != nil {
-				t.Fatal(err)
-			}
+func (c *redirectConn) SetReadDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.then.SetReadDeadline(t)
+}
 
-			r := &recordlayer.RecordLayer{}
-			if err := r.Unmarshal(messages[0]); err != nil {
-				t.Fatal(err)
-			}
+func (c *redirectConn) Close() error {
+	err := c.Conn.Close()
+	if thenErr := c.then.Close(); err == nil {
+		err = thenErr
+	}
+	return err
+}
 
-			if test.ExpectAlertFromServer {
-				a, ok := r.Content.(*alert.Alert)
-				if !ok {
-					t.Fatal("Failed to cast alert.Alert")
-				}
+func TestCookieGeneratorStateless(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-				if a.Description != test.Alert {
-					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
-				}
-			} else {
-				serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-				if !ok {
-					t.Fatal("Failed to cast handshake.MessageServerHello")
-				}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-				var negotiatedProtocol string
-				for _, v := range serverHello.Extensions {
-					if _, ok := v.(*extension.ALPN); ok {
-						e, ok := v.(*extension.ALPN)
-						if !ok {
-							t.Fatal("Failed to cast extension.ALPN")
-						}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-						negotiatedProtocol = e.ProtocolNameList[0]
+	// The HelloVerifyRequest is sent by issuer, and the ClientHello carrying
+	// its cookie is received by verifier, which shares the cookie key.
+	issuer := &testCookieGenerator{key: []byte("cluster secret")}
+	verifier := &testCookieGenerator{key: []byte("cluster secret")}
 
-						// Manipulate ServerHello
-						if test.ExpectAlertFromClient {
-							e.ProtocolNameList = append(e.ProtocolNameList, "oops")
-						}
-					}
-				}
+	ca1, cb1 := dpipe.Pipe()
+	ca2, cb2 := dpipe.Pipe()
 
-				if negotiatedProtocol != test.ExpectedProtocol {
-					t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.ExpectedProtocol, negotiatedProtocol)
-				}
+	issuerCtx, issuerCancel := context.WithCancel(ctx)
+	issuerDone := make(chan struct{})
+	go func() {
+		defer close(issuerDone)
+		if s, err := testServer(issuerCtx, dtlsnet.PacketConnFromConn(cb1), cb1.RemoteAddr(), &Config{
+			CookieGenerator: issuer,
+		}, true); err == nil {
+			_ = s.Close()
+		}
+	}()
+	defer func() {
+		issuerCancel()
+		<-issuerDone
+		_ = cb1.Close()
+	}()
 
-				s, err := r.Marshal()
-				if err != nil {
-					t.Fatal(err)
-				}
+	client, server := connPair(t, ctx, &redirectConn{Conn: ca1, then: ca2}, cb2, &Config{
+		InsecureSkipVerify: true,
+	}, &Config{
+		CookieGenerator: verifier,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
 
-				// Forward ServerHello
-				if _, err = cb.Write(s); err != nil {
-					t.Fatal(err)
-				}
+	issuer.mu.Lock()
+	defer issuer.mu.Unlock()
+	verifier.mu.Lock()
+	defer verifier.mu.Unlock()
+	if issuer.generated != 1 || issuer.verified != 0 {
+		t.Errorf("Expected the issuer to only generate the cookie, got %d and %d calls", issuer.generated, issuer.verified)
+	}
+	if verifier.generated != 0 || verifier.verified != 1 {
+		t.Errorf("Expected the verifier to only verify the cookie, got %d and %d calls", verifier.generated, verifier.verified)
+	}
+}
 
-				if test.ExpectAlertFromClient {
-					resp5 := make([]byte, 1024)
-					n, err = cb.Read(resp5)
-					if err != nil {
-						t.Fatal(err)
-					}
+func TestOCSPResponseNotStapled(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-					r2 := &recordlayer.RecordLayer{}
-					if err := r2.Unmarshal(resp5[:n]); err != nil {
-						t.Fatal(err)
-					}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-					a, ok := r2.Content.(*alert.Alert)
-					if !ok {
-						t.Fatal("Failed to cast alert.Alert")
-					}
+	for _, requestOCSPStaple := range []bool{false, true} {
+		requestOCSPStaple := requestOCSPStaple
+		t.Run(fmt.Sprintf("RequestOCSPStaple=%v", requestOCSPStaple), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-					if a.Description != test.Alert {
-						t.Errorf("ALPN %v: expected(%v) actual(%v)", test.Name, test.Alert, a.Description)
+			var statusRequested bool
+			client, server := pipeConnPair(t, ctx, &Config{
+				InsecureSkipVerify: true,
+				RequestOCSPStaple:  requestOCSPStaple,
+				ClientHelloMessageHook: func(ch handshake.MessageClientHello) handshake.Message {
+					for _, e := range ch.Extensions {
+						if s, ok := e.(*extension.StatusRequest); ok && s.StatusType == extension.CertificateStatusTypeOCSP {
+							statusRequested = true
+						}
 					}
-				}
-			}
+					return &ch
+				},
+			}, &Config{})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
 
-			time.Sleep(50 * time.Millisecond) // Give some time for returned errors
+			if statusRequested != requestOCSPStaple {
+				t.Errorf("Expected a status_request extension in the ClientHello: %v, got %v", requestOCSPStaple, statusRequested)
+			}
+			if actual := client.ConnectionState().OCSPResponse; actual != nil {
+				t.Errorf("Expected no OCSP response, got %v", actual)
+			}
 		})
 	}
 }
 
-// Make sure the supported_groups extension is not included in the ServerHello
-func TestSupportedGroupsExtension(t *testing.T) {
+func TestOCSPStapling(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2735,84 +7434,129 @@ func TestSupportedGroupsExtension(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	t.Run("ServerHello Supported Groups", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// OCSPResponse with responseStatus tryLater and no responseBytes.
+	staple := []byte{0x30, 0x03, 0x0a, 0x01, 0x03}
 
-		ca, cb := dpipe.Pipe()
-		go func() {
-			if _, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{}, true); !errors.Is(err, context.Canceled) {
-				t.Error(err)
-			}
-		}()
-		extensions := []extension.Extension{
-			&extension.SupportedEllipticCurves{
-				EllipticCurves: []elliptic.Curve{elliptic.X25519, elliptic.P256, elliptic.P384},
-			},
-			&extension.SupportedPointFormats{
-				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
-			},
-		}
+	for name, tt := range map[string]struct {
+		staple         []byte
+		notRequested   bool
+		expectedStaple []byte
+	}{
+		"Stapled": {
+			staple:         staple,
+			expectedStaple: staple,
+		},
+		"NoStaple": {},
+		"NotRequested": {
+			staple:       staple,
+			notRequested: true,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-		time.Sleep(50 * time.Millisecond)
+			cert := serverCert
+			cert.OCSPStaple = tt.staple
 
-		resp := make([]byte, 1024)
-		err := sendClientHello([]byte{}, ca, 0, extensions)
-		if err != nil {
-			t.Fatal(err)
-		}
+			clientConfig := &Config{
+				InsecureSkipVerify: true,
+				RequestOCSPStaple:  !tt.notRequested,
+			}
 
-		// Receive ServerHello
-		n, err := ca.Read(resp)
-		if err != nil {
-			t.Fatal(err)
-		}
-		r := &recordlayer.RecordLayer{}
-		if err = r.Unmarshal(resp[:n]); err != nil {
-			t.Fatal(err)
-		}
+			client, server := pipeConnPair(t, ctx, clientConfig, &Config{
+				Certificates: []tls.Certificate{cert},
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
 
-		helloVerifyRequest, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageHelloVerifyRequest)
-		if !ok {
-			t.Fatal("Failed to cast MessageHelloVerifyRequest")
-		}
+			if actual := client.ConnectionState().OCSPResponse; !bytes.Equal(actual, tt.expectedStaple) {
+				t.Errorf("OCSPResponse mismatch: expected %v, got %v", tt.expectedStaple, actual)
+			}
+		})
+	}
+}
 
-		err = sendClientHello(helloVerifyRequest.Cookie, ca, 1, extensions)
-		if err != nil {
-			t.Fatal(err)
-		}
-		if n, err = ca.Read(resp); err != nil {
-			t.Fatal(err)
-		}
+func TestNoSupportedCipherSuites(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-		messages, err := recordlayer.UnpackDatagram(resp[:n])
-		if err != nil {
-			t.Fatal(err)
-		}
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
 
-		if err := r.Unmarshal(messages[0]); err != nil {
-			t.Fatal(err)
-		}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert, err := selfsign.SelfSign(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
 
-		serverHello, ok := r.Content.(*handshake.Handshake).Message.(*handshake.MessageServerHello)
-		if !ok {
-			t.Fatal("Failed to cast MessageServerHello")
-		}
+	for name, tt := range map[string]struct {
+		isClient bool
+		config   *Config
+	}{
+		"Client": {
+			isClient: true,
+			config: &Config{
+				CipherSuites:    []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				PSK:             func([]byte) ([]byte, error) { return []byte{0x00, 0x01, 0x02}, nil },
+				PSKIdentityHint: []byte{0x00},
+			},
+		},
+		"Server": {
+			config: &Config{
+				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+				Certificates: []tls.Certificate{rsaCert},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dpipe.Pipe()
+			defer func() {
+				_ = ca.Close()
+				_ = cb.Close()
+			}()
 
-		gotGroups := false
-		for _, v := range serverHello.Extensions {
-			if _, ok := v.(*extension.SupportedEllipticCurves); ok {
-				gotGroups = true
+			var err error
+			if tt.isClient {
+				_, err = Client(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.config)
+			} else {
+				_, err = Server(dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), tt.config)
 			}
-		}
+			if !errors.Is(err, ErrNoSupportedCipherSuites) {
+				t.Fatalf("Expected error %v, got %v", ErrNoSupportedCipherSuites, err)
+			}
+			if name := CipherSuiteName(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256); !strings.Contains(err.Error(), name) {
+				t.Errorf("Expected error to list %s, got %v", name, err)
+			}
+		})
+	}
+}
 
-		if gotGroups {
-			t.Errorf("TestSupportedGroups: supported_groups extension was sent in ServerHello")
-		}
-	})
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-func TestSessionResume(t *testing.T) {
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	atomic.AddInt64(&c.n, int64(n))
+	return n, err
+}
+
+func TestConfigRand(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2821,161 +7565,70 @@ func TestSessionResume(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	t.Run("resumed", func(t *testing.T) {
+	t.Run("Used", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		type result struct {
-			c   *Conn
-			err error
-		}
-		clientRes := make(chan result, 1)
-
-		ss := &memSessStore{}
-
-		id, _ := hex.DecodeString("9b9fc92255634d9fb109febed42166717bb8ded8c738ba71bc7f2a0d9dae0306")
-		secret, _ := hex.DecodeString("2e942a37aca5241deb2295b5fcedac221c7078d2503d2b62aeb48c880d7da73c001238b708559686b9da6e829c05ead7")
-
-		s := Session{ID: id, Secret: secret}
-
-		ca, cb := dpipe.Pipe()
+		clientRand := &countingReader{r: rand.Reader}
+		serverRand := &countingReader{r: rand.Reader}
 
-		_ = ss.Set(id, s)
-		_ = ss.Set([]byte(ca.RemoteAddr().String()+"_example.com"), s)
+		client, server := pipeConnPair(t, ctx, &Config{
+			Rand:               clientRand,
+			InsecureSkipVerify: true,
+		}, &Config{
+			Rand: serverRand,
+		})
+		defer func() {
+			_ = client.Close()
+			_ = server.Close()
+		}()
 
 		go func() {
-			config := &Config{
-				CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-				ServerName:   "example.com",
-				SessionStore: ss,
-				MTU:          100,
-			}
-			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
-			clientRes <- result{c, err}
+			buf := make([]byte, 1)
+			_, _ = server.Read(buf)
 		}()
 
-		config := &Config{
-			CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			ServerName:   "example.com",
-			SessionStore: ss,
-			MTU:          100,
+		handshakeRead := atomic.LoadInt64(&clientRand.n)
+		if handshakeRead == 0 {
+			t.Error("Client did not read from Config.Rand during the handshake")
 		}
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-		if err != nil {
-			t.Fatalf("TestSessionResume: Server failed(%v)", err)
+		if atomic.LoadInt64(&serverRand.n) == 0 {
+			t.Error("Server did not read from Config.Rand during the handshake")
 		}
 
-		actualSessionID := server.ConnectionState().SessionID
-		actualMasterSecret := server.ConnectionState().masterSecret
-		if !bytes.Equal(actualSessionID, id) {
-			t.Errorf("TestSessionResumetion: SessionID Mismatch: expected(%v) actual(%v)", id, actualSessionID)
-		}
-		if !bytes.Equal(actualMasterSecret, secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", secret, actualMasterSecret)
+		if _, err := client.Write([]byte("hello")); err != nil {
+			t.Fatal(err)
 		}
-
-		defer func() {
-			_ = server.Close()
-		}()
-
-		res := <-clientRes
-		if res.err != nil {
-			t.Fatal(res.err)
+		if atomic.LoadInt64(&clientRand.n) == handshakeRead {
+			t.Error("Client did not read an explicit nonce from Config.Rand")
 		}
-		_ = res.c.Close()
 	})
 
-	t.Run("new session", func(t *testing.T) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Run("Error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 		defer cancel()
 
-		type result struct {
-			c   *Conn
-			err error
-		}
-		clientRes := make(chan result, 1)
-
-		s1 := &memSessStore{}
-		s2 := &memSessStore{}
-
+		errRand := errors.New("rand failure")
 		ca, cb := dpipe.Pipe()
-		go func() {
-			config := &Config{
-				ServerName:   "example.com",
-				SessionStore: s1,
-			}
-			c, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), config, false)
-			clientRes <- result{c, err}
-		}()
-
-		config := &Config{
-			SessionStore: s2,
-		}
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), config, true)
-		if err != nil {
-			t.Fatalf("TestSessionResumetion: Server failed(%v)", err)
-		}
-
-		actualSessionID := server.ConnectionState().SessionID
-		actualMasterSecret := server.ConnectionState().masterSecret
-		ss, _ := s2.Get(actualSessionID)
-		if !bytes.Equal(actualMasterSecret, ss.Secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
-		}
-
 		defer func() {
-			_ = server.Close()
+			_ = cb.Close()
 		}()
 
-		res := <-clientRes
-		if res.err != nil {
-			t.Fatal(res.err)
+		client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+			Rand:               iotest.ErrReader(errRand),
+			InsecureSkipVerify: true,
+		}, false)
+		if err == nil {
+			_ = client.Close()
+			t.Fatal("Expected the handshake to fail")
 		}
-		cs, _ := s1.Get([]byte(ca.RemoteAddr().String() + "_example.com"))
-		if !bytes.Equal(actualMasterSecret, cs.Secret) {
-			t.Errorf("TestSessionResumetion: masterSecret Mismatch: expected(%v) actual(%v)", ss.Secret, actualMasterSecret)
+		if !errors.Is(err, errRand) {
+			t.Fatalf("Expected error %v, got %v", errRand, err)
 		}
-		_ = res.c.Close()
 	})
 }
-
-type memSessStore struct {
-	sync.Map
-}
-
-func (ms *memSessStore) Set(key []byte, s Session) error {
-	k := hex.EncodeToString(key)
-	ms.Store(k, s)
-
-	return nil
-}
-
-func (ms *memSessStore) Get(key []byte) (Session, error) {
-	k := hex.EncodeToString(key)
-
-	v, ok := ms.Load(k)
-	if !ok {
-		return Session{}, nil
-	}
-
-	s, ok := v.(Session)
-	if !ok {
-		return Session{}, nil
-	}
-
-	return s, nil
-}
-
-func (ms *memSessStore) Del(key []byte) error {
-	k := hex.EncodeToString(key)
-	ms.Delete(k)
-
-	return nil
-}
-
-// Assert that the server only uses CipherSuites with a hash+signature that matches
-// the certificate. As specified in rfc5246#section-7.4.3
-func TestCipherSuiteMatchesCertificateType(t *testing.T) {
+
+func TestSCTs(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -2984,303 +7637,440 @@ func TestCipherSuiteMatchesCertificateType(t *testing.T) {
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name           string
-		cipherList     []CipherSuiteID
-		expectedCipher CipherSuiteID
-		generateRSA    bool
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	scts := [][]byte{{0x00, 0x01, 0x02}, {0x03, 0x04}}
+
+	for name, tt := range map[string]struct {
+		scts         [][]byte
+		request      bool
+		expectedSCTs [][]byte
 	}{
-		{
-			Name:           "ECDSA Certificate with RSA CipherSuite first",
-			cipherList:     []CipherSuiteID{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
-			expectedCipher: TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		"Sent": {
+			scts:         scts,
+			request:      true,
+			expectedSCTs: scts,
 		},
-		{
-			Name:           "RSA Certificate with ECDSA CipherSuite first",
-			cipherList:     []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
-			expectedCipher: TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			generateRSA:    true,
+		"NoSCTs": {
+			request: true,
+		},
+		"NotRequested": {
+			scts: scts,
 		},
 	} {
-		test := test
-		t.Run(test.Name, func(t *testing.T) {
-			clientErr := make(chan error, 1)
-			client := make(chan *Conn, 1)
-
-			ca, cb := dpipe.Pipe()
-			go func() {
-				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: test.cipherList}, false)
-				clientErr <- err
-				client <- c
-			}()
-
-			var (
-				priv crypto.PrivateKey
-				err  error
-			)
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-			if test.generateRSA {
-				if priv, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
-					t.Fatal(err)
-				}
-			} else {
-				if priv, err = ecdsa.GenerateKey(cryptoElliptic.P256(), rand.Reader); err != nil {
-					t.Fatal(err)
-				}
+			cert := serverCert
+			cert.SignedCertificateTimestamps = tt.scts
+
+			var sctsRequested bool
+			clientConfig := &Config{
+				InsecureSkipVerify: true,
+				RequestSCTs:        tt.request,
+				ClientHelloMessageHook: func(ch handshake.MessageClientHello) handshake.Message {
+					for _, e := range ch.Extensions {
+						if _, ok := e.(*extension.SignedCertificateTimestamp); ok {
+							sctsRequested = true
+						}
+					}
+					return &ch
+				},
 			}
 
-			serverCert, err := selfsign.SelfSign(priv)
-			if err != nil {
-				t.Fatal(err)
-			}
+			client, server := pipeConnPair(t, ctx, clientConfig, &Config{
+				Certificates: []tls.Certificate{cert},
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
 
-			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-				CipherSuites: test.cipherList,
-				Certificates: []tls.Certificate{serverCert},
-			}, false); err != nil {
-				t.Fatal(err)
-			} else if err = s.Close(); err != nil {
-				t.Fatal(err)
+			if sctsRequested != tt.request {
+				t.Errorf("signed_certificate_timestamp in ClientHello: expected %v, got %v", tt.request, sctsRequested)
 			}
-
-			if c, err := <-client, <-clientErr; err != nil {
-				t.Fatal(err)
-			} else if err := c.Close(); err != nil {
-				t.Fatal(err)
-			} else if c.ConnectionState().cipherSuite.ID() != test.expectedCipher {
-				t.Fatalf("Expected(%s) and Actual(%s) CipherSuite do not match", test.expectedCipher, c.ConnectionState().cipherSuite.ID())
+			if actual := client.ConnectionState().SCTs; !reflect.DeepEqual(actual, tt.expectedSCTs) {
+				t.Errorf("SCTs mismatch: expected %v, got %v", tt.expectedSCTs, actual)
 			}
 		})
 	}
 }
 
-// Test that we return the proper certificate if we are serving multiple ServerNames on a single Server
-func TestMultipleServerCertificates(t *testing.T) {
-	fooCert, err := selfsign.GenerateSelfSignedWithDNS("foo")
-	if err != nil {
-		t.Fatal(err)
-	}
+func TestCertificateCompression(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
 
-	barCert, err := selfsign.GenerateSelfSignedWithDNS("bar")
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	caPool := x509.NewCertPool()
-	for _, cert := range []tls.Certificate{fooCert, barCert} {
-		certificate, err := x509.ParseCertificate(cert.Certificate[0])
-		if err != nil {
-			t.Fatal(err)
-		}
-		caPool.AddCert(certificate)
+	// Repeating the certificate makes the chain compress well.
+	for i := 0; i < 3; i++ {
+		serverCert.Certificate = append(serverCert.Certificate, serverCert.Certificate[0])
 	}
+	zlibOnly := []CertificateCompressionAlgorithm{CertificateCompressionZlib}
 
-	for _, test := range []struct {
-		RequestServerName string
-		ExpectedDNSName   string
+	for name, tt := range map[string]struct {
+		clientAlgorithms  []CertificateCompressionAlgorithm
+		serverAlgorithms  []CertificateCompressionAlgorithm
+		expectedType      handshake.Type
+		expectedAlgorithm CertificateCompressionAlgorithm
 	}{
-		{
-			"foo",
-			"foo",
+		"Compressed": {
+			clientAlgorithms:  zlibOnly,
+			serverAlgorithms:  zlibOnly,
+			expectedType:      handshake.TypeCompressedCertificate,
+			expectedAlgorithm: CertificateCompressionZlib,
 		},
-		{
-			"bar",
-			"bar",
+		"Brotli": {
+			clientAlgorithms:  []CertificateCompressionAlgorithm{CertificateCompressionZlib, CertificateCompressionBrotli},
+			serverAlgorithms:  []CertificateCompressionAlgorithm{CertificateCompressionBrotli, CertificateCompressionZlib},
+			expectedType:      handshake.TypeCompressedCertificate,
+			expectedAlgorithm: CertificateCompressionBrotli,
 		},
-		{
-			"invalid",
-			"foo",
+		"NotOffered": {
+			serverAlgorithms: zlibOnly,
+			expectedType:     handshake.TypeCertificate,
+		},
+		"NotSupportedByServer": {
+			clientAlgorithms: zlibOnly,
+			expectedType:     handshake.TypeCertificate,
 		},
 	} {
-		test := test
-		t.Run(test.RequestServerName, func(t *testing.T) {
-			clientErr := make(chan error, 2)
-			client := make(chan *Conn, 1)
-
-			ca, cb := dpipe.Pipe()
-			go func() {
-				c, err := testClient(context.TODO(), dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
-					RootCAs:    caPool,
-					ServerName: test.RequestServerName,
-					VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-						certificate, err := x509.ParseCertificate(rawCerts[0])
-						if err != nil {
-							return err
-						}
-
-						if certificate.DNSNames[0] != test.ExpectedDNSName {
-							return errWrongCert
-						}
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-						return nil
-					},
-				}, false)
-				clientErr <- err
-				client <- c
+			client, server := pipeConnPair(t, ctx, &Config{
+				InsecureSkipVerify:                true,
+				NonStandardCertificateCompression: tt.clientAlgorithms,
+			}, &Config{
+				Certificates:                      []tls.Certificate{serverCert},
+				NonStandardCertificateCompression: tt.serverAlgorithms,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
 			}()
 
-			if s, err := testServer(context.TODO(), dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{Certificates: []tls.Certificate{fooCert, barCert}}, false); err != nil {
-				t.Fatal(err)
-			} else if err = s.Close(); err != nil {
-				t.Fatal(err)
+			received := client.handshakeCache.pull(handshakeCachePullRule{handshake.TypeCertificate, 0, false, false})[0]
+			if actual := handshake.Type(received.data[0]); actual != tt.expectedType {
+				t.Errorf("Server certificate sent as %s, expected %s", actual, tt.expectedType)
+			} else if actual == handshake.TypeCompressedCertificate {
+				compressed := &handshake.MessageCompressedCertificate{}
+				if err := compressed.Unmarshal(received.data[handshake.HeaderLength:]); err != nil {
+					t.Fatal(err)
+				} else if compressed.Algorithm != tt.expectedAlgorithm {
+					t.Errorf("Server certificate compressed with %s, expected %s", compressed.Algorithm, tt.expectedAlgorithm)
+				}
 			}
-
-			if c, err := <-client, <-clientErr; err != nil {
-				t.Fatal(err)
-			} else if err := c.Close(); err != nil {
-				t.Fatal(err)
+			if actual := client.ConnectionState().PeerCertificates; !reflect.DeepEqual(actual, serverCert.Certificate) {
+				t.Error("Peer certificates do not match the server chain")
+			}
+			if log := client.GetHandshakeLog(); log == nil || log.ServerCertificates == nil ||
+				!bytes.Equal(log.ServerCertificates.Certificate.Raw, serverCert.Certificate[0]) {
+				t.Error("Handshake log is missing the server certificate")
 			}
 		})
 	}
 }
 
-func TestEllipticCurveConfiguration(t *testing.T) {
+func TestTruncatedHMAC(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
 	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
 
-	for _, test := range []struct {
-		Name            string
-		ConfigCurves    []elliptic.Curve
-		HandshakeCurves []elliptic.Curve
+	for name, tt := range map[string]struct {
+		cipherSuite         CipherSuiteID
+		clientTruncatedHMAC bool
+		serverTruncatedHMAC bool
+		expected            bool
 	}{
-		{
-			Name:            "Curve defaulting",
-			ConfigCurves:    nil,
-			HandshakeCurves: defaultCurves,
+		"CBC": {
+			cipherSuite:         TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			clientTruncatedHMAC: true,
+			serverTruncatedHMAC: true,
+			expected:            true,
 		},
-		{
-			Name:            "Single curve",
-			ConfigCurves:    []elliptic.Curve{elliptic.X25519},
-			HandshakeCurves: []elliptic.Curve{elliptic.X25519},
+		"AEAD": {
+			cipherSuite:         TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			clientTruncatedHMAC: true,
+			serverTruncatedHMAC: true,
 		},
-		{
-			Name:            "Multiple curves",
-			ConfigCurves:    []elliptic.Curve{elliptic.P384, elliptic.X25519},
-			HandshakeCurves: []elliptic.Curve{elliptic.P384, elliptic.X25519},
+		"NotOffered": {
+			cipherSuite:         TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			serverTruncatedHMAC: true,
+		},
+		"NotSupportedByServer": {
+			cipherSuite:         TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			clientTruncatedHMAC: true,
 		},
 	} {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-		ca, cb := dpipe.Pipe()
-		type result struct {
-			c   *Conn
-			err error
-		}
-		c := make(chan result)
+			client, server := pipeConnPair(t, ctx, &Config{
+				CipherSuites:  []CipherSuiteID{tt.cipherSuite},
+				TruncatedHMAC: tt.clientTruncatedHMAC,
+			}, &Config{
+				CipherSuites:  []CipherSuiteID{tt.cipherSuite},
+				TruncatedHMAC: tt.serverTruncatedHMAC,
+			})
+			defer func() {
+				_ = client.Close()
+				_ = server.Close()
+			}()
 
-		go func() {
-			client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
-			c <- result{client, err}
-		}()
+			if client.state.truncatedHMAC != tt.expected || server.state.truncatedHMAC != tt.expected {
+				t.Errorf("Truncated HMAC negotiated by client %v and server %v, expected %v",
+					client.state.truncatedHMAC, server.state.truncatedHMAC, tt.expected)
+			}
 
-		server, err := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256}, EllipticCurves: test.ConfigCurves}, true)
-		if err != nil {
-			t.Fatalf("Server error: %v", err)
-		}
+			// Both sides must agree on the MAC length to exchange records.
+			for _, pair := range [][2]*Conn{{client, server}, {server, client}} {
+				if _, err := pair[0].Write([]byte("hello")); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 16)
+				n, err := pair[1].Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(buf[:n]) != "hello" {
+					t.Errorf("Received %q, expected %q", buf[:n], "hello")
+				}
+			}
+		})
+	}
+}
 
-		if len(test.ConfigCurves) == 0 && len(test.HandshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
-			t.Fatalf("Failed to default Elliptic curves, expected %d, got: %d", len(test.HandshakeCurves), len(server.fsm.cfg.ellipticCurves))
-		}
+func TestStrictHandshakeOrder(t *testing.T) {
+	for _, strict := range []bool{false, true} {
+		strict := strict
+		t.Run(fmt.Sprintf("Strict=%v", strict), func(t *testing.T) {
+			// Limit runtime in case of deadlocks
+			lim := test.TimeOut(time.Second * 10)
+			defer lim.Stop()
 
-		if len(test.ConfigCurves) != 0 {
-			if len(test.HandshakeCurves) != len(server.fsm.cfg.ellipticCurves) {
-				t.Fatalf("Failed to configure Elliptic curves, expect %d, got %d", len(test.HandshakeCurves), len(server.fsm.cfg.ellipticCurves))
-			}
-			for i, c := range test.ConfigCurves {
-				if c != server.fsm.cfg.ellipticCurves[i] {
-					t.Fatalf("Failed to maintain Elliptic curve order, expected %s, got %s", c, server.fsm.cfg.ellipticCurves[i])
-				}
+			// Check for leaking routines
+			report := test.CheckRoutines(t)
+			defer report()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ca, cb := dpipe.Pipe()
+			type result struct {
+				c   *Conn
+				err error
 			}
-		}
+			c := make(chan result)
+			go func() {
+				client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+					FlightInterval:       500 * time.Millisecond,
+					StrictHandshakeOrder: strict,
+				}, true)
+				c <- result{client, err}
+			}()
 
-		res := <-c
-		if res.err != nil {
-			t.Fatalf("Client error; %v", err)
-		}
+			// The server's Finished is lost, so the client receives
+			// application data before it.
+			server, err := testServer(ctx, dtlsnet.PacketConnFromConn(&finishedDroppingConn{Conn: cb}), cb.RemoteAddr(), &Config{}, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer func() {
+				_ = server.Close()
+			}()
+			if _, err = server.Write([]byte("early")); err != nil {
+				t.Fatal(err)
+			}
 
-		defer func() {
-			err = server.Close()
+			res := <-c
+			if strict {
+				if !errors.Is(res.err, errApplicationDataBeforeFinished) {
+					t.Errorf("Client error expected: \"%v\", got: \"%v\"", errApplicationDataBeforeFinished, res.err)
+				}
+				if res.c != nil {
+					_ = res.c.Close()
+				}
+				return
+			}
+			if res.err != nil {
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = res.c.Close()
+			}()
+			buf := make([]byte, 16)
+			n, err := res.c.Read(buf)
 			if err != nil {
 				t.Fatal(err)
 			}
-			err = res.c.Close()
-			if err != nil {
-				t.Fatal(err)
+			if string(buf[:n]) != "early" {
+				t.Errorf("Expected application data %q, got %q", "early", buf[:n])
 			}
-		}()
+		})
 	}
 }
 
-func TestSkipHelloVerify(t *testing.T) {
-	report := test.CheckRoutines(t)
-	defer report()
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// finishedDroppingConn removes the encrypted handshake records, i.e. the
+// Finished message, from the first datagram it writes that carries them.
+type finishedDroppingConn struct {
+	net.Conn
 
-	ca, cb := dpipe.Pipe()
-	certificate, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
-	}
-	gotHello := make(chan struct{})
+	mu      sync.Mutex
+	dropped bool
+}
 
-	go func() {
-		server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-			Certificates:            []tls.Certificate{certificate},
-			LoggerFactory:           logging.NewDefaultLoggerFactory(),
-			InsecureSkipVerifyHello: true,
-		}, false)
-		if sErr != nil {
-			t.Error(sErr)
-			return
-		}
-		buf := make([]byte, 1024)
-		if _, sErr = server.Read(buf); sErr != nil {
-			t.Error(sErr)
+func (c *finishedDroppingConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped {
+		return c.Conn.Write(b)
+	}
+	var out []byte
+	for offset := 0; offset+recordlayer.FixedHeaderSize <= len(b); {
+		end := offset + recordlayer.FixedHeaderSize + int(binary.BigEndian.Uint16(b[offset+11:]))
+		if end > len(b) {
+			break
 		}
-		gotHello <- struct{}{}
-		if sErr = server.Close(); sErr != nil { //nolint:contextcheck
-			t.Error(sErr)
+		if b[offset] == byte(protocol.ContentTypeHandshake) && binary.BigEndian.Uint16(b[offset+3:]) != 0 {
+			c.dropped = true
+		} else {
+			out = append(out, b[offset:end]...)
 		}
-	}()
-
-	client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
-		LoggerFactory:      logging.NewDefaultLoggerFactory(),
-		InsecureSkipVerify: true,
-	}, false)
-	if err != nil {
-		t.Fatal(err)
+		offset = end
 	}
-	if _, err = client.Write([]byte("hello")); err != nil {
-		t.Error(err)
+	if !c.dropped {
+		return c.Conn.Write(b)
 	}
-	select {
-	case <-gotHello:
-		// OK
-	case <-time.After(time.Second * 5):
-		t.Error("timeout")
+	if len(out) > 0 {
+		if _, err := c.Conn.Write(out); err != nil {
+			return 0, err
+		}
 	}
+	return len(b), nil
+}
 
-	if err = client.Close(); err != nil {
-		t.Error(err)
+func TestRequireRenegotiationInfo(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	withoutRenegotiationInfo := func(extensions []extension.Extension) []extension.Extension {
+		var out []extension.Extension
+		for _, e := range extensions {
+			if _, ok := e.(*extension.RenegotiationInfo); !ok {
+				out = append(out, e)
+			}
+		}
+		return out
 	}
-}
 
-type connWithCallback struct {
-	net.Conn
-	onWrite func([]byte)
-}
+	for name, tt := range map[string]struct {
+		clientRequires, serverRequires bool
+		clientOmits, serverOmits       bool
+		clientSendsSCSV                bool
+		expectedClientErr              error
+		expectedServerErr              error
+	}{
+		"Required": {
+			clientRequires: true,
+			serverRequires: true,
+		},
+		"NotRequired": {
+			clientOmits: true,
+			serverOmits: true,
+		},
+		"ClientOmits": {
+			serverRequires:    true,
+			clientOmits:       true,
+			expectedServerErr: errClientNoRenegotiationInfo,
+		},
+		"ClientSendsSCSV": {
+			serverRequires:  true,
+			clientOmits:     true,
+			clientSendsSCSV: true,
+		},
+		"ServerOmits": {
+			clientRequires:    true,
+			serverOmits:       true,
+			expectedClientErr: errServerNoRenegotiationInfo,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
 
-func (c *connWithCallback) Write(b []byte) (int, error) {
-	if c.onWrite != nil {
-		c.onWrite(b)
+			serverConfig := &Config{
+				RequireRenegotiationInfo: tt.serverRequires,
+				ServerHelloMessageHook: func(sh handshake.MessageServerHello) handshake.Message {
+					if tt.serverOmits {
+						sh.Extensions = withoutRenegotiationInfo(sh.Extensions)
+					}
+					return &sh
+				},
+			}
+			clientConfig := &Config{
+				RequireRenegotiationInfo: tt.clientRequires,
+				ClientHelloMessageHook: func(ch handshake.MessageClientHello) handshake.Message {
+					if tt.clientOmits {
+						ch.Extensions = withoutRenegotiationInfo(ch.Extensions)
+					}
+					if tt.clientSendsSCSV {
+						ch.CipherSuiteIDs = append(ch.CipherSuiteIDs, renegotiationInfoSCSV)
+					}
+					return &ch
+				},
+			}
+
+			ca, cb := dpipe.Pipe()
+			client, server, clientErr, serverErr := handshakePair(ctx, ca, cb, clientConfig, serverConfig)
+			defer func() {
+				if clientErr == nil {
+					_ = client.Close()
+				}
+				if serverErr == nil {
+					_ = server.Close()
+				}
+			}()
+
+			if tt.expectedClientErr != nil && !errors.Is(clientErr, tt.expectedClientErr) {
+				t.Errorf("Expected client error %v, got %v", tt.expectedClientErr, clientErr)
+			}
+			if tt.expectedServerErr != nil && !errors.Is(serverErr, tt.expectedServerErr) {
+				t.Errorf("Expected server error %v, got %v", tt.expectedServerErr, serverErr)
+			}
+			if tt.expectedClientErr == nil && tt.expectedServerErr == nil && (clientErr != nil || serverErr != nil) {
+				t.Errorf("Unexpected handshake errors: client %v, server %v", clientErr, serverErr)
+			}
+		})
 	}
-	return c.Conn.Write(b)
 }
 
-func TestApplicationDataQueueLimited(t *testing.T) {
+func TestSequenceNumberNonces(t *testing.T) {
 	// Limit runtime in case of deadlocks
 	lim := test.TimeOut(time.Second * 20)
 	defer lim.Stop()
@@ -3293,146 +8083,125 @@ func TestApplicationDataQueueLimited(t *testing.T) {
 	defer cancel()
 
 	ca, cb := dpipe.Pipe()
-	defer ca.Close() //nolint:errcheck
-	defer cb.Close() //nolint:errcheck
-
-	done := make(chan struct{})
-	go func() {
-		serverCert, err := selfsign.GenerateSelfSigned()
-		if err != nil {
-			t.Error(err)
-			return
-		}
-		cfg := &Config{}
-		cfg.Certificates = []tls.Certificate{serverCert}
-
-		dconn, err := createConn(dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), cfg, false)
-		if err != nil {
-			t.Error(err)
-			return
-		}
-		go func() {
-			for i := 0; i < 5; i++ {
-				dconn.lock.RLock()
-				qlen := len(dconn.encryptedPackets)
-				dconn.lock.RUnlock()
-				if qlen > maxAppDataPacketQueueSize {
-					t.Error("too many encrypted packets enqueued", len(dconn.encryptedPackets))
-				}
-				t.Log(qlen)
-				time.Sleep(1 * time.Second)
-			}
-		}()
-		if _, err := handshakeConn(ctx, dconn, cfg, false, nil); err == nil {
-			t.Error("expected handshake to fail")
-		}
-		close(done)
+	recorder := &lastWriteConn{Conn: ca}
+	client, server := connPair(t, ctx, recorder, cb, &Config{
+		CipherSuites:         []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		SequenceNumberNonces: true,
+	}, &Config{
+		CipherSuites: []CipherSuiteID{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
 	}()
-	extensions := []extension.Extension{}
-
-	time.Sleep(50 * time.Millisecond)
 
-	err := sendClientHello([]byte{}, ca, 0, extensions)
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
 	if err != nil {
 		t.Fatal(err)
+	} else if string(buf[:n]) != "hello" {
+		t.Errorf("Expected %q, got %q", "hello", buf[:n])
 	}
 
-	time.Sleep(50 * time.Millisecond)
+	// The explicit nonce follows the record header and repeats its epoch
+	// and sequence number.
+	record := recorder.last()
+	if len(record) < recordlayer.FixedHeaderSize+8 {
+		t.Fatalf("Record too short: %v", record)
+	}
+	if nonce := record[recordlayer.FixedHeaderSize : recordlayer.FixedHeaderSize+8]; !bytes.Equal(nonce, record[3:11]) {
+		t.Errorf("Expected the explicit nonce %v to be the epoch and sequence number %v", nonce, record[3:11])
+	}
 
-	for i := 0; i < 1000; i++ {
-		// Send an application data packet
-		packet, err := (&recordlayer.RecordLayer{
-			Header: recordlayer.Header{
-				Version:        protocol.Version1_2,
-				SequenceNumber: uint64(3),
-				Epoch:          1, // use an epoch greater than 0
-			},
-			Content: &protocol.ApplicationData{
-				Data: []byte{1, 2, 3, 4},
-			},
-		}).Marshal()
-		if err != nil {
-			t.Fatal(err)
-		}
-		ca.Write(packet) // nolint
-		if i%100 == 0 {
-			time.Sleep(10 * time.Millisecond)
-		}
+	// The nonce is still sent, so the record overhead is unchanged.
+	p := client.RecordProtectionParams()
+	if !p.SequenceNumberNonce {
+		t.Error("Expected the client to report sequence number nonces")
+	}
+	if server.RecordProtectionParams().SequenceNumberNonce {
+		t.Error("Expected the server to report random nonces")
+	}
+	if expected := recordlayer.FixedHeaderSize + p.ExplicitNonceLength + len("hello") + p.TagLength; len(record) != expected {
+		t.Errorf("Expected a record of %d bytes, got %d", expected, len(record))
 	}
-	time.Sleep(1 * time.Second)
-	ca.Close() // nolint
-	<-done
 }
 
-func TestHelloRandom(t *testing.T) {
+// lastWriteConn keeps a copy of the last datagram written.
+type lastWriteConn struct {
+	net.Conn
+
+	mu   sync.Mutex
+	data []byte
+}
+
+func (c *lastWriteConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	c.data = append(c.data[:0], b...)
+	c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func (c *lastWriteConn) last() []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]byte{}, c.data...)
+}
+
+func TestFinishedVerifyDataMismatch(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
 	report := test.CheckRoutines(t)
 	defer report()
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Without the extended master secret the transcript only enters the
+	// Finished messages, so both sides derive the same keys but disagree
+	// on the verify data.
 	ca, cb := dpipe.Pipe()
-	certificate, err := selfsign.GenerateSelfSigned()
-	if err != nil {
-		t.Fatal(err)
+	client, server, clientErr, serverErr := handshakePair(ctx, ca, &extensionSwappingConn{Conn: cb}, &Config{
+		ExtendedMasterSecret: DisableExtendedMasterSecret,
+	}, &Config{
+		ExtendedMasterSecret: DisableExtendedMasterSecret,
+	})
+	if client != nil {
+		_ = client.Close()
 	}
-	gotHello := make(chan struct{})
-
-	chRandom := [handshake.RandomBytesLength]byte{}
-	_, err = rand.Read(chRandom[:])
-	if err != nil {
-		t.Fatal(err)
+	if server != nil {
+		_ = server.Close()
 	}
 
-	go func() {
-		server, sErr := testServer(ctx, dtlsnet.PacketConnFromConn(cb), cb.RemoteAddr(), &Config{
-			GetCertificate: func(chi *ClientHelloInfo) (*tls.Certificate, error) {
-				if len(chi.CipherSuites) == 0 {
-					return &certificate, nil
-				}
-
-				if !bytes.Equal(chi.RandomBytes[:], chRandom[:]) {
-					t.Error("client hello random differs")
-				}
-
-				return &certificate, nil
-			},
-			LoggerFactory: logging.NewDefaultLoggerFactory(),
-		}, false)
-		if sErr != nil {
-			t.Error(sErr)
-			return
-		}
-		buf := make([]byte, 1024)
-		if _, sErr = server.Read(buf); sErr != nil {
-			t.Error(sErr)
-		}
-		gotHello <- struct{}{}
-		if sErr = server.Close(); sErr != nil { //nolint:contextcheck
-			t.Error(sErr)
-		}
-	}()
-
-	client, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
-		LoggerFactory: logging.NewDefaultLoggerFactory(),
-		HelloRandomBytesGenerator: func() [handshake.RandomBytesLength]byte {
-			return chRandom
-		},
-		InsecureSkipVerify: true,
-	}, false)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if _, err = client.Write([]byte("hello")); err != nil {
-		t.Error(err)
+	if !errors.Is(serverErr, errVerifyDataMismatch) {
+		t.Errorf("Server error expected: \"%v\", got: \"%v\"", errVerifyDataMismatch, serverErr)
 	}
-	select {
-	case <-gotHello:
-		// OK
-	case <-time.After(time.Second * 5):
-		t.Error("timeout")
+	var alertErr *alertError
+	if !errors.As(clientErr, &alertErr) || alertErr.Description != alert.DecryptError {
+		t.Errorf("Client expected a %v alert, got: \"%v\"", alert.DecryptError, clientErr)
 	}
+}
 
-	if err = client.Close(); err != nil {
-		t.Error(err)
+// extensionSwappingConn swaps the renegotiation_info and ec_point_formats
+// extensions of the ServerHello on the wire, which the client accepts but
+// which changes its handshake transcript.
+type extensionSwappingConn struct {
+	net.Conn
+}
+
+func (c *extensionSwappingConn) Write(b []byte) (int, error) {
+	renegotiationInfo := []byte{0xff, 0x01, 0x00, 0x01, 0x00}
+	pointFormats := []byte{0x00, 0x0b, 0x00, 0x02, 0x01, 0x00}
+	swapped := bytes.Replace(b,
+		append(append([]byte{}, renegotiationInfo...), pointFormats...),
+		append(append([]byte{}, pointFormats...), renegotiationInfo...), 1)
+	if _, err := c.Conn.Write(swapped); err != nil {
+		return 0, err
 	}
+	return len(b), nil
 }
@@ -12,9 +12,15 @@ import (
 	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 )
 
+// maxConnectionIDLength is the largest connection ID that can be carried in
+// the connection_id extension.
+// https://datatracker.ietf.org/doc/html/rfc9146#section-3
+const maxConnectionIDLength = 255
+
 // RandomCIDGenerator is a random Connection ID generator where CID is the
 // specified size. Specifying a size of 0 will indicate to peers that sending a
-// Connection ID is not necessary.
+// Connection ID is not necessary, while still allowing Connection IDs to be
+// received from them. The size must not exceed 255 bytes.
 func RandomCIDGenerator(size int) func() []byte {
 	return func() []byte {
 		cid := make([]byte, size)
@@ -34,6 +40,18 @@ func OnlySendCIDGenerator() func() []byte {
 	}
 }
 
+// generateConnectionID calls the configured generator and verifies that the
+// generated connection ID can be negotiated with the peer. The length of the
+// returned connection ID is the length expected on all incoming connection ID
+// records for the lifetime of the connection.
+func generateConnectionID(generator func() []byte) ([]byte, error) {
+	cid := generator()
+	if len(cid) > maxConnectionIDLength {
+		return nil, errInvalidConnectionIDLength
+	}
+	return cid, nil
+}
+
 // cidDatagramRouter extracts connection IDs from incoming datagram payloads and
 // uses them to route to the proper connection.
 // NOTE: properly routing datagrams based on connection IDs requires using
@@ -4,13 +4,23 @@
 package dtls
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
 	"testing"
 	"time"
 
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v3/dpipe"
+	"github.com/pion/transport/v3/test"
 )
 
 func TestRandomConnectionIDGenerator(t *testing.T) {
@@ -53,6 +63,342 @@ func TestOnlySendCIDGenerator(t *testing.T) {
 	}
 }
 
+func TestRandomCIDGeneratorHandshake(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for _, size := range []int{0, 8, 16, 18, maxConnectionIDLength} {
+		size := size
+		t.Run(fmt.Sprintf("Size%d", size), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			client, server := pipeConnPair(t, ctx, &Config{
+				ConnectionIDGenerator: RandomCIDGenerator(size),
+			}, &Config{
+				ConnectionIDGenerator: RandomCIDGenerator(size),
+			})
+			defer func() {
+				_ = server.Close()
+				_ = client.Close()
+			}()
+
+			for _, conn := range []*Conn{client, server} {
+				if l := len(conn.state.localConnectionID); l != size {
+					t.Errorf("Unexpected local connection ID length: expected %d, got %d", size, l)
+				}
+				if l := len(conn.state.remoteConnectionID); l != size {
+					t.Errorf("Unexpected remote connection ID length: expected %d, got %d", size, l)
+				}
+				state := conn.ConnectionState()
+				if state.SendsConnectionID() != (size > 0) || state.ReceivesConnectionID() != (size > 0) {
+					t.Errorf("Unexpected connection ID use: sends %v, receives %v", state.SendsConnectionID(), state.ReceivesConnectionID())
+				}
+			}
+
+			// Records must be parsed using the negotiated connection ID length
+			// in both directions.
+			for _, pair := range [][2]*Conn{{client, server}, {server, client}} {
+				msg := []byte("connection id")
+				if _, err := pair[0].Write(msg); err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 32)
+				n, err := pair[1].Read(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(buf[:n], msg) {
+					t.Errorf("Unexpected application data: expected %q, got %q", msg, buf[:n])
+				}
+			}
+		})
+	}
+}
+
+func TestConnectionIDNotNegotiated(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = server.Close()
+		_ = client.Close()
+	}()
+
+	for _, conn := range []*Conn{client, server} {
+		state := conn.ConnectionState()
+		if state.SendsConnectionID() || state.ReceivesConnectionID() {
+			t.Errorf("Unexpected connection ID use: sends %v, receives %v", state.SendsConnectionID(), state.ReceivesConnectionID())
+		}
+	}
+}
+
+func TestConnectionIDGeneratorTooLong(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		_ = cb.Close()
+	}()
+
+	_, err := testClient(ctx, dtlsnet.PacketConnFromConn(ca), ca.RemoteAddr(), &Config{
+		ConnectionIDGenerator: RandomCIDGenerator(maxConnectionIDLength + 1),
+	}, true)
+	if !errors.Is(err, errInvalidConnectionIDLength) {
+		t.Errorf("Client error expected: \"%v\" but got \"%v\"", errInvalidConnectionIDLength, err)
+	}
+}
+
+func TestListenPacketConnClientMigration(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	serverSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := ListenPacketConn(serverSock, &Config{
+		Certificates:          []tls.Certificate{serverCert},
+		ConnectionIDGenerator: RandomCIDGenerator(8),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	accepted := make(chan result)
+	go func() {
+		c, err := l.Accept()
+		accepted <- result{c, err}
+	}()
+
+	clientSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ClientWithContext(ctx, clientSock, l.Addr(), &Config{
+		InsecureSkipVerify:    true,
+		ConnectionIDGenerator: OnlySendCIDGenerator(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	server := res.c
+	defer func() {
+		_ = server.Close()
+	}()
+
+	buf := make([]byte, 64)
+	if _, err = client.Write([]byte("before")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = server.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// Send the next record from a new source port, as if the client had
+	// been rebound by a NAT.
+	migratedSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = migratedSock.Close()
+	}()
+	client.lock.Lock()
+	raw, err := client.processPacket(&packet{
+		record: &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Epoch:   client.state.getLocalEpoch(),
+				Version: protocol.Version1_2,
+			},
+			Content: &protocol.ApplicationData{
+				Data: []byte("after"),
+			},
+		},
+		shouldWrapCID: true,
+		shouldEncrypt: true,
+	})
+	client.lock.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = migratedSock.WriteTo(raw, l.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "after" {
+		t.Errorf("Unexpected application data: expected %q, got %q", "after", buf[:n])
+	}
+	if server.RemoteAddr().String() != migratedSock.LocalAddr().String() {
+		t.Errorf("Expected server to follow client to %v, got %v", migratedSock.LocalAddr(), server.RemoteAddr())
+	}
+
+	// Responses must be sent to the new address.
+	if _, err = server.Write([]byte("reply")); err != nil {
+		t.Fatal(err)
+	}
+	if err = migratedSock.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if n, _, err = migratedSock.ReadFrom(buf); err != nil {
+		t.Fatalf("Expected reply on migrated socket: %v", err)
+	}
+	if _, _, err = client.handleIncomingPacket(ctx, buf[:n], l.Addr(), false); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = client.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "reply" {
+		t.Errorf("Unexpected application data: expected %q, got %q", "reply", buf[:n])
+	}
+}
+
+func TestListenerDropsUnknownConnectionID(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(10 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loopback := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}
+	l, err := Listen("udp", loopback, &Config{
+		Certificates:          []tls.Certificate{serverCert},
+		ConnectionIDGenerator: RandomCIDGenerator(8),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = l.Close()
+	}()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	accepted := make(chan result)
+	go func() {
+		c, err := l.Accept()
+		accepted <- result{c, err}
+	}()
+
+	clientSock, err := net.ListenUDP("udp", loopback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ClientWithContext(ctx, clientSock, l.Addr(), &Config{
+		InsecureSkipVerify:    true,
+		ConnectionIDGenerator: OnlySendCIDGenerator(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+
+	res := <-accepted
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	server := res.c
+	defer func() {
+		_ = server.Close()
+	}()
+
+	// Send a CID record with an unknown connection ID from the address of
+	// the established connection. It must not reach the server Conn.
+	h := &recordlayer.Header{
+		ContentType:    protocol.ContentTypeConnectionID,
+		Version:        protocol.Version1_2,
+		Epoch:          1,
+		SequenceNumber: 1,
+		ConnectionID:   []byte{0, 1, 2, 3, 4, 5, 6, 7},
+		ContentLen:     16,
+	}
+	raw, err := h.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw = append(raw, make([]byte, 16)...)
+	if _, err = clientSock.WriteTo(raw, l.Addr()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client's own records are still delivered.
+	if _, err = client.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "hello" {
+		t.Errorf("Unexpected application data: expected %q, got %q", "hello", buf[:n])
+	}
+
+	stats := l.(interface{ Stats() ListenerStats }).Stats() //nolint:forcetypeassert
+	if stats.DroppedUnknownConnectionID != 1 {
+		t.Errorf("Expected 1 dropped unknown connection ID, got %d", stats.DroppedUnknownConnectionID)
+	}
+}
+
 func TestCIDDatagramRouter(t *testing.T) {
 	cid := []byte("abcd1234")
 	cidLen := 8
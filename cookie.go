@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+)
+
+// CookieGenerator creates and checks the cookies a server sends in
+// HelloVerifyRequest messages. Implementations that derive the cookie from
+// a shared secret, for example with an HMAC, let any server of a cluster
+// verify a cookie issued by another one, without keeping state between the
+// two ClientHellos.
+//
+// clientHello is the encoded ClientHello message with its cookie removed,
+// which is the same in the initial ClientHello and in the one carrying the
+// cookie.
+//
+// https://tools.ietf.org/html/rfc6347#section-4.2.1
+type CookieGenerator interface {
+	// Generate returns the cookie for the client at clientAddr. It must not
+	// be longer than 255 bytes.
+	Generate(clientAddr net.Addr, clientHello []byte) ([]byte, error)
+	// Verify reports whether cookie was generated for the client.
+	Verify(clientAddr net.Addr, clientHello, cookie []byte) bool
+}
+
+// maxCookieLength is the largest cookie a HelloVerifyRequest can carry.
+const maxCookieLength = 255
+
+// encodeClientHelloWithoutCookie returns clientHello encoded with an empty
+// cookie, as passed to a CookieGenerator.
+func encodeClientHelloWithoutCookie(clientHello *handshake.MessageClientHello) ([]byte, error) {
+	withoutCookie := *clientHello
+	withoutCookie.Cookie = nil
+	return withoutCookie.Marshal()
+}
+
+// generateCookie sets the cookie sent to the client in a HelloVerifyRequest
+// using the CookieGenerator. Without one, the random cookie drawn by
+// flight0Generate is kept in state until the client echoes it.
+func generateCookie(c flightConn, state *State, cfg *handshakeConfig, clientHello *handshake.MessageClientHello) error {
+	raw, err := encodeClientHelloWithoutCookie(clientHello)
+	if err != nil {
+		return err
+	}
+	cookie, err := cfg.cookieGenerator.Generate(c.remoteAddr(), raw)
+	if err != nil {
+		return err
+	}
+	if len(cookie) > maxCookieLength {
+		return errCookieTooLong
+	}
+	state.cookie = cookie
+	return nil
+}
+
+// verifyCookie reports whether the cookie of clientHello is the one sent to
+// the client.
+func verifyCookie(c flightConn, state *State, cfg *handshakeConfig, clientHello *handshake.MessageClientHello) bool {
+	if cfg.cookieGenerator == nil {
+		return bytes.Equal(state.cookie, clientHello.Cookie)
+	}
+
+	raw, err := encodeClientHelloWithoutCookie(clientHello)
+	if err != nil {
+		return false
+	}
+	return cfg.cookieGenerator.Verify(c.remoteAddr(), raw, clientHello.Cookie)
+}
@@ -7,11 +7,12 @@ import (
 	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
-	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/binary"
+	"io"
 	"math/big"
 	"time"
 
@@ -38,23 +39,45 @@ func valueKeyMessage(clientRandom, serverRandom, publicKey []byte, namedCurve el
 	return plaintext
 }
 
+// dhValueKeyMessage returns the signed part of a DHE ServerKeyExchange, the
+// randoms followed by the ServerDHParams.
+//
+// https://tools.ietf.org/html/rfc5246#section-7.4.3
+func dhValueKeyMessage(clientRandom, serverRandom, prime, generator, publicKey []byte) []byte {
+	plaintext := []byte{}
+	plaintext = append(plaintext, clientRandom...)
+	plaintext = append(plaintext, serverRandom...)
+	for _, v := range [][]byte{prime, generator, publicKey} {
+		plaintext = append(plaintext, 0x00, 0x00)
+		binary.BigEndian.PutUint16(plaintext[len(plaintext)-2:], uint16(len(v)))
+		plaintext = append(plaintext, v...)
+	}
+
+	return plaintext
+}
+
 // If the client provided a "signature_algorithms" extension, then all
 // certificates provided by the server MUST be signed by a
 // hash/signature algorithm pair that appears in that extension
 //
 // https://tools.ietf.org/html/rfc5246#section-7.4.2
-func generateKeySignature(clientRandom, serverRandom, publicKey []byte, namedCurve elliptic.Curve, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
-	msg := valueKeyMessage(clientRandom, serverRandom, publicKey, namedCurve)
+func generateKeySignature(rand io.Reader, clientRandom, serverRandom, publicKey []byte, namedCurve elliptic.Curve, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
+	return signKeyMessage(rand, valueKeyMessage(clientRandom, serverRandom, publicKey, namedCurve), privateKey, hashAlgorithm)
+}
+
+// signKeyMessage signs the parameters of a ServerKeyExchange as built by
+// valueKeyMessage or dhValueKeyMessage.
+func signKeyMessage(rand io.Reader, msg []byte, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
 	switch p := privateKey.(type) {
 	case ed25519.PrivateKey:
 		// https://crypto.stackexchange.com/a/55483
-		return p.Sign(rand.Reader, msg, crypto.Hash(0))
+		return p.Sign(rand, msg, crypto.Hash(0))
 	case *ecdsa.PrivateKey:
 		hashed := hashAlgorithm.Digest(msg)
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return p.Sign(rand, hashed, hashAlgorithm.CryptoHash())
 	case *rsa.PrivateKey:
 		hashed := hashAlgorithm.Digest(msg)
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return p.Sign(rand, hashed, hashAlgorithm.CryptoHash())
 	}
 
 	return nil, errKeySignatureGenerateUnimplemented
@@ -109,21 +132,21 @@ func verifyKeySignature(message, remoteKeySignature []byte, hashAlgorithm hash.A
 // CertificateVerify message is sent to explicitly verify possession of
 // the private key in the certificate.
 // https://tools.ietf.org/html/rfc5246#section-7.3
-func generateCertificateVerify(handshakeBodies []byte, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
+func generateCertificateVerify(rand io.Reader, handshakeBodies []byte, privateKey crypto.PrivateKey, hashAlgorithm hash.Algorithm) ([]byte, error) {
 	if p, ok := privateKey.(ed25519.PrivateKey); ok {
 		// https://pkg.go.dev/crypto/ed25519#PrivateKey.Sign
 		// Sign signs the given message with priv. Ed25519 performs two passes over
 		// messages to be signed and therefore cannot handle pre-hashed messages.
-		return p.Sign(rand.Reader, handshakeBodies, crypto.Hash(0))
+		return p.Sign(rand, handshakeBodies, crypto.Hash(0))
 	}
 
 	hashed := hashAlgorithm.Digest(handshakeBodies)
 
 	switch p := privateKey.(type) {
 	case *ecdsa.PrivateKey:
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return p.Sign(rand, hashed, hashAlgorithm.CryptoHash())
 	case *rsa.PrivateKey:
-		return p.Sign(rand.Reader, hashed, hashAlgorithm.CryptoHash())
+		return p.Sign(rand, hashed, hashAlgorithm.CryptoHash())
 	}
 
 	return nil, errInvalidSignatureAlgorithm
@@ -204,6 +227,26 @@ func verifyClientCert(rawCertificates [][]byte, roots *x509.CertPool) (chains []
 	return certificate[0].Verify(opts)
 }
 
+// sniMatchesCertificate reports whether the leaf of rawCertificates is valid
+// for serverName. It does not verify the certificate chain.
+func sniMatchesCertificate(rawCertificates [][]byte, serverName string) bool {
+	if serverName == "" || len(rawCertificates) == 0 {
+		return false
+	}
+	leaf, err := x509.ParseCertificate(rawCertificates[0])
+	if err != nil {
+		return false
+	}
+	return leaf.VerifyHostname(serverName) == nil
+}
+
+// verifyDataMatches reports whether the verify data of a received Finished
+// message is the expected one. The comparison takes constant time so that it
+// does not reveal how many leading bytes of a forged verify data are right.
+func verifyDataMatches(expected, actual []byte) bool {
+	return subtle.ConstantTimeCompare(expected, actual) == 1
+}
+
 func verifyServerCert(rawCertificates [][]byte, roots *x509.CertPool, serverName string) (chains [][]*x509.Certificate, err error) {
 	certificate, err := loadCerts(rawCertificates)
 	if err != nil {
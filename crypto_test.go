@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/pem"
 	"testing"
@@ -68,10 +69,27 @@ func TestGenerateKeySignature(t *testing.T) {
 		0x87, 0x5e, 0x5c, 0x36, 0x75, 0x86,
 	}
 
-	signature, err := generateKeySignature(clientRandom, serverRandom, publicKey, elliptic.X25519, key, hash.SHA256)
+	signature, err := generateKeySignature(rand.Reader, clientRandom, serverRandom, publicKey, elliptic.X25519, key, hash.SHA256)
 	if err != nil {
 		t.Error(err)
 	} else if !bytes.Equal(expectedSignature, signature) {
 		t.Errorf("Signature generation failed \nexp % 02x \nactual % 02x ", expectedSignature, signature)
 	}
 }
+
+func TestVerifyDataMatches(t *testing.T) {
+	expected := []byte{0xcf, 0x91, 0x96, 0x26, 0xf1, 0x36, 0x0c, 0x53, 0x6a, 0xaa, 0xd7, 0x3a}
+	for name, tt := range map[string]struct {
+		actual  []byte
+		matches bool
+	}{
+		"Equal":           {append([]byte{}, expected...), true},
+		"LastByteDiffers": {append(append([]byte{}, expected[:11]...), 0x3b), false},
+		"Truncated":       {expected[:11], false},
+		"Empty":           {nil, false},
+	} {
+		if matches := verifyDataMatches(expected, tt.actual); matches != tt.matches {
+			t.Errorf("%s: expected %v, got %v", name, tt.matches, matches)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"errors"
+	"net"
+
+	"github.com/pion/logging"
+)
+
+// applyDontFragment sets the IP don't fragment bit on conn, logging a
+// warning if the platform does not support it. Connections accepted from a
+// Listener share the listening socket, which has already been configured, so
+// they are skipped quietly.
+func applyDontFragment(conn net.PacketConn, log logging.LeveledLogger) {
+	err := setDontFragment(conn)
+	switch {
+	case err == nil:
+	case errors.Is(err, errDontFragmentNotSocket):
+		log.Debugf("don't fragment bit not set: %v", err)
+	default:
+		log.Warnf("don't fragment bit not supported, datagrams may be fragmented: %v", err)
+	}
+}
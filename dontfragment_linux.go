@@ -0,0 +1,41 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build linux
+// +build linux
+
+package dtls
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment sets the IP don't fragment bit on the datagrams written to
+// conn, so that datagrams larger than the path MTU are rejected with
+// EMSGSIZE instead of being fragmented.
+// https://man7.org/linux/man-pages/man7/ip.7.html
+func setDontFragment(conn net.PacketConn) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return errDontFragmentNotSocket
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	// Only one of the options applies to an IPv4 or IPv6-only socket, a
+	// dual-stack socket takes both.
+	var errIPv4, errIPv6 error
+	if err = rc.Control(func(fd uintptr) {
+		errIPv4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		errIPv6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	}); err != nil {
+		return err
+	}
+	if errIPv4 != nil && errIPv6 != nil {
+		return errIPv4
+	}
+	return nil
+}
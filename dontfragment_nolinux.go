@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+// +build !linux
+
+package dtls
+
+import (
+	"net"
+	"syscall"
+)
+
+func setDontFragment(conn net.PacketConn) error {
+	if _, ok := conn.(syscall.Conn); !ok {
+		return errDontFragmentNotSocket
+	}
+	return errDontFragmentNotSupported
+}
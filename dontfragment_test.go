@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package dtls
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/transport/v3/dpipe"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestSetDontFragment(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err = setDontFragment(conn); err != nil {
+		t.Skipf("DF not supported on this platform: %v", err)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+	if err = setDontFragment(dtlsnet.PacketConnFromConn(ca)); !errors.Is(err, errDontFragmentNotSocket) {
+		t.Errorf("Expected %v for a non-socket connection, got %v", errDontFragmentNotSocket, err)
+	}
+}
+
+func TestMessageTooLongLowersMTU(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		serverCert.Certificate = append(serverCert.Certificate, serverCert.Certificate[0])
+	}
+
+	ca, cb := dpipe.Pipe()
+	client, server := connPair(t, ctx, ca, &messageTooLongConn{Conn: cb, mtu: 700}, &Config{
+		FlightInterval: 5 * time.Second,
+	}, &Config{
+		Certificates:   []tls.Certificate{serverCert},
+		FlightInterval: 20 * time.Millisecond,
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	if mtu := server.MTU(); mtu > 700 {
+		t.Errorf("Expected the server MTU to be lowered below 700, got %d", mtu)
+	}
+
+	if _, err := server.Write(make([]byte, 1000)); !errors.Is(err, ErrMessageTooLong) {
+		t.Errorf("Expected %v writing application data, got %v", ErrMessageTooLong, err)
+	}
+}
+
+// messageTooLongConn rejects written datagrams larger than mtu with
+// EMSGSIZE, as a socket with the DF bit set does.
+type messageTooLongConn struct {
+	net.Conn
+	mtu int
+}
+
+func (c *messageTooLongConn) Write(b []byte) (int, error) {
+	if len(b) > c.mtu {
+		return 0, &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("sendto", syscall.EMSGSIZE)}
+	}
+	return c.Conn.Write(b)
+}
@@ -0,0 +1,50 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"errors"
+	"net"
+	"syscall"
+
+	"github.com/pion/logging"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// applyDSCP sets dscp on conn, logging a warning if the platform does not
+// support it. Connections accepted from a Listener share the listening
+// socket, which has already been marked, so they are skipped quietly.
+func applyDSCP(conn net.PacketConn, dscp int, log logging.LeveledLogger) {
+	err := setDSCP(conn, dscp)
+	switch {
+	case err == nil:
+	case errors.Is(err, errDSCPNotSocket):
+		log.Debugf("DSCP not set: %v", err)
+	default:
+		log.Warnf("DSCP %d not supported, sending unmarked datagrams: %v", dscp, err)
+	}
+}
+
+// setDSCP marks the datagrams written to conn with the Differentiated
+// Services Code Point dscp by setting the IPv4 TOS or IPv6 traffic class
+// field of the socket. The two ECN bits are left zero.
+// https://datatracker.ietf.org/doc/html/rfc2474#section-3
+func setDSCP(conn net.PacketConn, dscp int) error {
+	if _, ok := conn.(syscall.Conn); !ok {
+		return errDSCPNotSocket
+	}
+	tos := dscp << 2
+
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return ipv4.NewPacketConn(conn).SetTOS(tos)
+	}
+	if err := ipv6.NewPacketConn(conn).SetTrafficClass(tos); err != nil {
+		return err
+	}
+	// A dual-stack socket also sends IPv4 datagrams, which are marked
+	// through the IPv4 option where the platform allows it.
+	_ = ipv4.NewPacketConn(conn).SetTOS(tos)
+	return nil
+}
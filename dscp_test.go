@@ -0,0 +1,48 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+//go:build !js
+// +build !js
+
+package dtls
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/transport/v3/dpipe"
+	"golang.org/x/net/ipv4"
+)
+
+func TestSetDSCP(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// Expedited Forwarding
+	if err = setDSCP(conn, 46); err != nil {
+		t.Skipf("DSCP not supported on this platform: %v", err)
+	}
+	tos, err := ipv4.NewPacketConn(conn).TOS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tos != 46<<2 {
+		t.Errorf("TOS mismatch: expected %#x, got %#x", 46<<2, tos)
+	}
+
+	ca, cb := dpipe.Pipe()
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+	if err = setDSCP(dtlsnet.PacketConnFromConn(ca), 46); !errors.Is(err, errDSCPNotSocket) {
+		t.Errorf("Expected %v for a non-socket connection, got %v", errDSCPNotSocket, err)
+	}
+}
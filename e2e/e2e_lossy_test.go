@@ -17,6 +17,8 @@ import (
 )
 
 const (
+	// flightInterval is also used as MaxFlightInterval, as backing off
+	// retransmissions could exceed lossyTestTimeout at high loss rates.
 	flightInterval   = time.Millisecond * 100
 	lossyTestTimeout = 30 * time.Second
 )
@@ -136,6 +138,7 @@ func TestPionE2ELossy(t *testing.T) {
 			go func() {
 				cfg := &dtls.Config{
 					FlightInterval:     flightInterval,
+					MaxFlightInterval:  flightInterval,
 					CipherSuites:       test.CipherSuites,
 					InsecureSkipVerify: true,
 					MTU:                test.MTU,
@@ -151,9 +154,10 @@ func TestPionE2ELossy(t *testing.T) {
 
 			go func() {
 				cfg := &dtls.Config{
-					Certificates:   []tls.Certificate{serverCert},
-					FlightInterval: flightInterval,
-					MTU:            test.MTU,
+					Certificates:      []tls.Certificate{serverCert},
+					FlightInterval:    flightInterval,
+					MaxFlightInterval: flightInterval,
+					MTU:               test.MTU,
 				}
 
 				if test.DoClientAuth {
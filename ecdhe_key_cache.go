@@ -0,0 +1,58 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+)
+
+// ecdheKeyCacheInit guards the lazy creation of Config.ecdheKeys. Config is
+// copied by value, so it cannot hold the lock itself.
+var ecdheKeyCacheInit sync.Mutex //nolint:gochecknoglobals
+
+type ecdheKeyCacheEntry struct {
+	keypair *elliptic.Keypair
+	expires time.Time
+}
+
+// ecdheKeyCache shares ECDHE keypairs between the connections of a Config,
+// one per curve. It is held by the Config, so it is released along with it.
+type ecdheKeyCache struct {
+	mu      sync.Mutex
+	entries map[elliptic.Curve]ecdheKeyCacheEntry
+}
+
+// ecdheKeyCache returns the cache of the ECDHE keys reused by the
+// connections of c, creating it on first use. Copies of c made after that
+// share the cache.
+func (c *Config) ecdheKeyCache() *ecdheKeyCache {
+	ecdheKeyCacheInit.Lock()
+	defer ecdheKeyCacheInit.Unlock()
+	if c.ecdheKeys == nil {
+		c.ecdheKeys = &ecdheKeyCache{entries: map[elliptic.Curve]ecdheKeyCacheEntry{}}
+	}
+	return c.ecdheKeys
+}
+
+// get returns the keypair for curve generated within window of now,
+// generating a new one from rand if there is none.
+func (c *ecdheKeyCache) get(rand io.Reader, curve elliptic.Curve, window time.Duration, now time.Time) (*elliptic.Keypair, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[curve]; ok && now.Before(e.expires) {
+		return e.keypair, nil
+	}
+
+	keypair, err := elliptic.GenerateKeypairFrom(rand, curve)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[curve] = ecdheKeyCacheEntry{keypair, now.Add(window)}
+	return keypair, nil
+}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+)
+
+func TestECDHEKeyCache(t *testing.T) {
+	config := &Config{}
+	window := time.Minute
+	now := time.Now()
+
+	get := func(config *Config, curve elliptic.Curve, now time.Time) *elliptic.Keypair {
+		keypair, err := config.ecdheKeyCache().get(config.randReader(), curve, window, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return keypair
+	}
+
+	first := get(config, elliptic.X25519, now)
+	if reused := get(config, elliptic.X25519, now.Add(window-time.Second)); !bytes.Equal(first.PublicKey, reused.PublicKey) {
+		t.Error("Key must be reused within the window")
+	}
+	if other := get(config, elliptic.P256, now); other.Curve != elliptic.P256 {
+		t.Errorf("Curve mismatch: expected %s, got %s", elliptic.P256, other.Curve)
+	}
+	if other := get(&Config{}, elliptic.X25519, now); bytes.Equal(first.PublicKey, other.PublicKey) {
+		t.Error("Key must not be shared between configs")
+	}
+
+	rotated := get(config, elliptic.X25519, now.Add(window))
+	if bytes.Equal(first.PublicKey, rotated.PublicKey) {
+		t.Error("Key must be rotated after the window")
+	}
+	if copied := *config; get(&copied, elliptic.X25519, now.Add(window)) != rotated {
+		t.Error("Key must be shared with copies of the config")
+	}
+}
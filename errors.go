@@ -10,6 +10,7 @@ import (
 	"io"
 	"net"
 	"os"
+	"strings"
 
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
@@ -18,9 +19,27 @@ import (
 // Typed errors
 var (
 	ErrConnClosed = &FatalError{Err: errors.New("conn is closed")} //nolint:goerr113
+	// ErrIncompleteServerFlight is returned by a client handshake that timed
+	// out after the server sent a ServerHello but not the rest of its flight,
+	// e.g. ServerHelloDone.
+	ErrIncompleteServerFlight = &TimeoutError{Err: errors.New("server flight is incomplete")} //nolint:goerr113
+	// ErrNoSupportedCipherSuites is returned when setting up a connection if
+	// none of the requested cipher suites can be used with the Config.
+	ErrNoSupportedCipherSuites = &FatalError{Err: errors.New("no supported cipher suites remain after filtering")} //nolint:goerr113
+	// ErrRecordTooLarge is returned by Write when a payload does not fit in a
+	// single record that a peer can receive. Nothing is sent.
+	ErrRecordTooLarge = &TemporaryError{Err: errors.New("payload does not fit in a single record")} //nolint:goerr113
+	// ErrMessageTooLong is matched by the MessageTooLongError returned when
+	// the network rejects a datagram as larger than the path MTU.
+	ErrMessageTooLong = &TemporaryError{Err: errors.New("datagram exceeds the path MTU")} //nolint:goerr113
+	// ErrUnsupportedCompressionMethod is matched by the
+	// CompressionMethodError returned when a server selects a compression
+	// method other than null.
+	ErrUnsupportedCompressionMethod = &FatalError{Err: errors.New("server selected an unsupported compression method")} //nolint:goerr113
 
-	errDeadlineExceeded   = &TimeoutError{Err: fmt.Errorf("read/write timeout: %w", context.DeadlineExceeded)}
-	errInvalidContentType = &TemporaryError{Err: errors.New("invalid content type")} //nolint:goerr113
+	errDeadlineExceeded       = &TimeoutError{Err: fmt.Errorf("read/write timeout: %w", context.DeadlineExceeded)}
+	errMaxRetransmitsExceeded = &TimeoutError{Err: errors.New("maximum number of flight retransmissions exceeded")} //nolint:goerr113
+	errInvalidContentType     = &TemporaryError{Err: errors.New("invalid content type")}                            //nolint:goerr113
 
 	errBufferTooSmall               = &TemporaryError{Err: errors.New("buffer is too small")}                                        //nolint:goerr113
 	errContextUnsupported           = &TemporaryError{Err: errors.New("context is not supported for ExportKeyingMaterial")}          //nolint:goerr113
@@ -28,6 +47,12 @@ var (
 	errReservedExportKeyingMaterial = &TemporaryError{Err: errors.New("ExportKeyingMaterial can not be used with a reserved label")} //nolint:goerr113
 	errApplicationDataEpochZero     = &TemporaryError{Err: errors.New("ApplicationData with epoch of 0")}                            //nolint:goerr113
 	errUnhandledContextType         = &TemporaryError{Err: errors.New("unhandled contentType")}                                      //nolint:goerr113
+	errHeartbeatNotAllowed          = &TemporaryError{Err: errors.New("peer does not accept heartbeat requests")}                    //nolint:goerr113
+	errWriteAddressMismatch         = &TemporaryError{Err: errors.New("address is not of the network of the underlying connection")} //nolint:goerr113
+	errRekeyNotClient               = &TemporaryError{Err: errors.New("only the client can start a rekey")}                          //nolint:goerr113
+	errRekeyInProgress              = &TemporaryError{Err: errors.New("rekey is in progress")}                                       //nolint:goerr113
+	errRekeyUnavailable             = &TemporaryError{Err: errors.New("connection was not established by a handshake it can rekey")} //nolint:goerr113
+	errRekeyEpochExhausted          = &TemporaryError{Err: errors.New("no epoch is left to rekey the connection")}                   //nolint:goerr113
 
 	errCertificateVerifyNoCertificate    = &FatalError{Err: errors.New("client sent certificate verify but we have no certificate to verify")}                      //nolint:goerr113
 	errCipherSuiteNoIntersection         = &FatalError{Err: errors.New("client+server do not support any shared cipher suites")}                                    //nolint:goerr113
@@ -35,11 +60,17 @@ var (
 	errClientCertificateRequired         = &FatalError{Err: errors.New("server required client verification, but got none")}                                        //nolint:goerr113
 	errClientNoMatchingSRTPProfile       = &FatalError{Err: errors.New("server responded with SRTP Profile we do not support")}                                     //nolint:goerr113
 	errClientRequiredButNoServerEMS      = &FatalError{Err: errors.New("client required Extended Master Secret extension, but server does not support it")}         //nolint:goerr113
+	errServerNoRenegotiationInfo         = &FatalError{Err: errors.New("client required secure renegotiation, but server does not signal support for it")}          //nolint:goerr113
+	errRenegotiationInfoMismatch         = &FatalError{Err: errors.New("renegotiation_info does not match the handshake being renegotiated")}                       //nolint:goerr113
+	errRekeyPeerChanged                  = &FatalError{Err: errors.New("peer presented a different identity in a rekey")}                                           //nolint:goerr113
+	errRekeyRefused                      = &FatalError{Err: errors.New("peer refused to rekey the connection")}                                                     //nolint:goerr113
 	errCookieMismatch                    = &FatalError{Err: errors.New("client+server cookie does not match")}                                                      //nolint:goerr113
+	errCookieTooLong                     = &FatalError{Err: errors.New("cookie must not be longer than 255 bytes")}                                                 //nolint:goerr113
 	errIdentityNoPSK                     = &FatalError{Err: errors.New("PSK Identity Hint provided but PSK is nil")}                                                //nolint:goerr113
 	errInvalidCertificate                = &FatalError{Err: errors.New("no certificate provided")}                                                                  //nolint:goerr113
 	errInvalidCipherSuite                = &FatalError{Err: errors.New("invalid or unknown cipher suite")}                                                          //nolint:goerr113
 	errInvalidECDSASignature             = &FatalError{Err: errors.New("ECDSA signature contained zero or negative values")}                                        //nolint:goerr113
+	errInvalidOCSPStaple                 = &FatalError{Err: errors.New("OCSP staple is not a DER encoded OCSPResponse")}                                            //nolint:goerr113
 	errInvalidPrivateKey                 = &FatalError{Err: errors.New("invalid private key type")}                                                                 //nolint:goerr113
 	errInvalidSignatureAlgorithm         = &FatalError{Err: errors.New("invalid signature algorithm")}                                                              //nolint:goerr113
 	errKeySignatureMismatch              = &FatalError{Err: errors.New("expected and actual key signature do not match")}                                           //nolint:goerr113
@@ -50,23 +81,56 @@ var (
 	errNoAvailableSignatureSchemes       = &FatalError{Err: errors.New("connection can not be created, no SignatureScheme satisfy this Config")}                    //nolint:goerr113
 	errNoCertificates                    = &FatalError{Err: errors.New("no certificates configured")}                                                               //nolint:goerr113
 	errNoConfigProvided                  = &FatalError{Err: errors.New("no config provided")}                                                                       //nolint:goerr113
+	errRequiredCurveNotOffered           = &FatalError{Err: errors.New("client did not offer the elliptic curve required by the server")}                           //nolint:goerr113
 	errNoSupportedEllipticCurves         = &FatalError{Err: errors.New("client requested zero or more elliptic curves that are not supported by the server")}       //nolint:goerr113
+	errServerSelectedUnsupportedCurve    = &FatalError{Err: errors.New("server selected an elliptic curve the client does not support")}                            //nolint:goerr113
 	errUnsupportedProtocolVersion        = &FatalError{Err: errors.New("unsupported protocol version")}                                                             //nolint:goerr113
 	errPSKAndIdentityMustBeSetForClient  = &FatalError{Err: errors.New("PSK and PSK Identity Hint must both be set for client")}                                    //nolint:goerr113
 	errRequestedButNoSRTPExtension       = &FatalError{Err: errors.New("SRTP support was requested but server did not respond with use_srtp extension")}            //nolint:goerr113
 	errServerNoMatchingSRTPProfile       = &FatalError{Err: errors.New("client requested SRTP but we have no matching profiles")}                                   //nolint:goerr113
 	errServerRequiredButNoClientEMS      = &FatalError{Err: errors.New("server requires the Extended Master Secret extension, but the client does not support it")} //nolint:goerr113
+	errClientNoRenegotiationInfo         = &FatalError{Err: errors.New("server requires secure renegotiation, but the client does not signal support for it")}      //nolint:goerr113
 	errVerifyDataMismatch                = &FatalError{Err: errors.New("expected and actual verify data does not match")}                                           //nolint:goerr113
 	errNotAcceptableCertificateChain     = &FatalError{Err: errors.New("certificate chain is not signed by an acceptable CA")}                                      //nolint:goerr113
+	errHeartbeatNotNegotiated            = &FatalError{Err: errors.New("received heartbeat but the extension was not negotiated")}                                  //nolint:goerr113
+	errApplicationDataBeforeFinished     = &FatalError{Err: errors.New("received application data before the Finished message of the peer")}                        //nolint:goerr113
+	errWriteClosed                       = &FatalError{Err: errors.New("write side of the connection is closed")}                                                   //nolint:goerr113
+	errInvalidConnectionIDLength         = &FatalError{Err: errors.New("generated connection ID exceeds the maximum length of 255 bytes")}                          //nolint:goerr113
+	errTooManyWarningAlerts              = &FatalError{Err: errors.New("peer sent too many warning alerts")}                                                        //nolint:goerr113
+	errECDHEPSKNoPSK                     = &FatalError{Err: errors.New("ECDHE_PSK cipher suites require a PSK")}                                                    //nolint:goerr113
+	errECDHEPSKNoCurve                   = &FatalError{Err: errors.New("ECDHE_PSK cipher suites require a supported elliptic curve")}                               //nolint:goerr113
+	errRSAKeyExchangeNotAllowed          = &FatalError{Err: errors.New("RSA key exchange cipher suites require InsecureRSAKeyExchange")}                            //nolint:goerr113
+	errRSAKeyExchangeNoRSAKey            = &FatalError{Err: errors.New("RSA key exchange requires an RSA certificate")}                                             //nolint:goerr113
+	errInvalidDSCP                       = &FatalError{Err: errors.New("DSCP must be between 0 and 63")}                                                            //nolint:goerr113
+	errInvalidEarlyPacketQueue           = &FatalError{Err: errors.New("MaxEarlyPacketQueue must not be negative")}                                                 //nolint:goerr113
+	errInvalidMaxRecordsPerDatagram      = &FatalError{Err: errors.New("MaxRecordsPerDatagram must not be negative")}                                               //nolint:goerr113
+	errInvalidIdleTimeout                = &FatalError{Err: errors.New("IdleTimeout must not be negative")}                                                         //nolint:goerr113
+	errInvalidKeepAlive                  = &FatalError{Err: errors.New("KeepAlive must not be negative")}                                                           //nolint:goerr113
+	errInvalidSequenceWarnThreshold      = &FatalError{Err: errors.New("SequenceNumberWarnThreshold must not exceed the largest sequence number")}                  //nolint:goerr113
+	errInvalidVersionRange               = &FatalError{Err: errors.New("MinVersion must be DTLS 1.2 and MaxVersion DTLS 1.2 or 1.3")}                               //nolint:goerr113
+	errSNICertificateMismatch            = &FatalError{Err: errors.New("server certificate does not cover the server name")}                                        //nolint:goerr113
+	errNoSupportedDHGroups               = &FatalError{Err: errors.New("client offered no finite field groups supported by the server")}                            //nolint:goerr113
+	errInvalidDHGroup                    = &FatalError{Err: errors.New("invalid finite field Diffie-Hellman group")}                                                //nolint:goerr113
+	errDHPrimeTooSmall                   = &FatalError{Err: errors.New("server sent a Diffie-Hellman prime smaller than MinDHPrimeBits")}                           //nolint:goerr113
+	errInvalidCertificateCompression     = &FatalError{Err: errors.New("only zlib and brotli certificate compression are supported")}                               //nolint:goerr113
+	errUnexpectedCertificateCompression  = &FatalError{Err: errors.New("peer compressed its certificate with an algorithm that was not offered")}                   //nolint:goerr113
+	errCipherSuiteNotNegotiated          = &FatalError{Err: errors.New("no cipher suite has been negotiated yet")}                                                  //nolint:goerr113
+	errTransportFailed                   = &FatalError{Err: errors.New("write to the underlying connection failed")}                                                //nolint:goerr113
 
 	errInvalidFlight                     = &InternalError{Err: errors.New("invalid flight number")}                           //nolint:goerr113
 	errKeySignatureGenerateUnimplemented = &InternalError{Err: errors.New("unable to generate key signature, unimplemented")} //nolint:goerr113
 	errKeySignatureVerifyUnimplemented   = &InternalError{Err: errors.New("unable to verify key signature, unimplemented")}   //nolint:goerr113
 	errLengthMismatch                    = &InternalError{Err: errors.New("data length and declared length do not match")}    //nolint:goerr113
 	errSequenceNumberOverflow            = &InternalError{Err: errors.New("sequence number overflow")}                        //nolint:goerr113
+	errEpochNotRetained                  = &InternalError{Err: errors.New("record epoch is not retained")}                    //nolint:goerr113
 	errInvalidFSMTransition              = &InternalError{Err: errors.New("invalid state machine transition")}                //nolint:goerr113
 	errFailedToAccessPoolReadBuffer      = &InternalError{Err: errors.New("failed to access pool read buffer")}               //nolint:goerr113
+	errFailedToAccessPoolWriteBuffer     = &InternalError{Err: errors.New("failed to access pool write buffer")}              //nolint:goerr113
 	errFragmentBufferOverflow            = &InternalError{Err: errors.New("fragment buffer overflow")}                        //nolint:goerr113
+	errDSCPNotSocket                     = &InternalError{Err: errors.New("DSCP requires a socket as underlying connection")} //nolint:goerr113
+	errDontFragmentNotSocket             = &InternalError{Err: errors.New("DF requires a socket as underlying connection")}   //nolint:goerr113
+	errDontFragmentNotSupported          = &InternalError{Err: errors.New("DF is not supported on this platform")}            //nolint:goerr113
+	errTranscriptCorrupted               = &InternalError{Err: errors.New("cached handshake message was modified")}           //nolint:goerr113
 )
 
 // FatalError indicates that the DTLS connection is no longer available.
@@ -84,7 +148,58 @@ type TemporaryError = protocol.TemporaryError
 type TimeoutError = protocol.TimeoutError
 
 // HandshakeError indicates that the handshake failed.
-type HandshakeError = protocol.HandshakeError
+type HandshakeError struct {
+	Err error
+
+	// Alert is the alert received from the peer that caused the handshake to
+	// fail, or nil if the failure was not caused by a received alert.
+	Alert *alert.Alert
+
+	// LastFlight names the last flight the handshake reached before it
+	// failed, or is empty if it is unknown. Flights are named "Flight 0" to
+	// "Flight 6" after RFC 6347 Section 4.2.4, and "Flight 4b" and
+	// "Flight 5b" in a resumed handshake. A client waiting for the server's
+	// flight 4, for example, fails in "Flight 3".
+	LastFlight string
+}
+
+// Timeout implements net.Error.Timeout()
+func (e *HandshakeError) Timeout() bool {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		return netErr.Timeout()
+	}
+	return false
+}
+
+// Temporary implements net.Error.Temporary()
+func (e *HandshakeError) Temporary() bool {
+	var netErr net.Error
+	if errors.As(e.Err, &netErr) {
+		return netErr.Temporary() //nolint
+	}
+	return false
+}
+
+// Unwrap implements Go1.13 error unwrapper.
+func (e *HandshakeError) Unwrap() error { return e.Err }
+
+// As lets errors.As find a HandshakeError as a protocol.HandshakeError,
+// which it was an alias of.
+func (e *HandshakeError) As(target interface{}) bool {
+	if t, ok := target.(**protocol.HandshakeError); ok {
+		*t = &protocol.HandshakeError{Err: e.Err}
+		return true
+	}
+	return false
+}
+
+func (e *HandshakeError) Error() string {
+	if e.LastFlight != "" {
+		return fmt.Sprintf("handshake error in %s: %v", e.LastFlight, e.Err)
+	}
+	return fmt.Sprintf("handshake error: %v", e.Err)
+}
 
 // errInvalidCipherSuite indicates an attempt at using an unsupported cipher suite.
 type invalidCipherSuiteError struct {
@@ -103,6 +218,73 @@ func (e *invalidCipherSuiteError) Is(err error) bool {
 	return false
 }
 
+// noSupportedCipherSuitesError is returned if every requested cipher suite
+// was filtered out. err is the reason, one of the errNoAvailable* errors.
+type noSupportedCipherSuitesError struct {
+	err      error
+	filtered []CipherSuite
+}
+
+func (e *noSupportedCipherSuitesError) Error() string {
+	names := make([]string, len(e.filtered))
+	for i, c := range e.filtered {
+		names[i] = c.String()
+	}
+	return fmt.Sprintf("%v, filtered: %s", e.err, strings.Join(names, ", "))
+}
+
+func (e *noSupportedCipherSuitesError) Is(err error) bool {
+	return err == ErrNoSupportedCipherSuites //nolint:errorlint
+}
+
+func (e *noSupportedCipherSuitesError) Unwrap() error {
+	return e.err
+}
+
+// MessageTooLongError is returned when the network rejects a datagram as
+// larger than the path MTU, such as with EMSGSIZE when
+// Config.DisableIPFragmentation is set. It matches ErrMessageTooLong.
+type MessageTooLongError struct {
+	// Size is the length of the rejected datagram, or zero if it is not
+	// known.
+	Size int
+	Err  error
+}
+
+func (e *MessageTooLongError) Error() string {
+	return fmt.Sprintf("%v: %d byte datagram: %v", ErrMessageTooLong, e.Size, e.Err)
+}
+
+func (e *MessageTooLongError) Is(err error) bool {
+	return err == ErrMessageTooLong //nolint:errorlint
+}
+
+func (e *MessageTooLongError) Unwrap() error {
+	return e.Err
+}
+
+// Timeout implements net.Error.
+func (e *MessageTooLongError) Timeout() bool { return false }
+
+// Temporary implements net.Error. A smaller datagram can still be sent.
+func (e *MessageTooLongError) Temporary() bool { return true }
+
+// CompressionMethodError is returned by a client handshake when the server
+// selects a compression method other than null in its ServerHello, which
+// DTLS 1.2 clients never offer. It matches ErrUnsupportedCompressionMethod.
+type CompressionMethodError struct {
+	// Method is the compression method selected by the server.
+	Method protocol.CompressionMethodID
+}
+
+func (e *CompressionMethodError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrUnsupportedCompressionMethod, e.Method)
+}
+
+func (e *CompressionMethodError) Unwrap() error {
+	return ErrUnsupportedCompressionMethod
+}
+
 // errAlert wraps DTLS alert notification as an error
 type alertError struct {
 	*alert.Alert
@@ -146,6 +328,9 @@ func netError(err error) error {
 			if isOpErrorTemporary(se) {
 				return &TemporaryError{Err: err}
 			}
+			if isOpErrorMessageTooLong(se) {
+				return &MessageTooLongError{Err: err}
+			}
 		}
 	}
 
@@ -155,3 +340,14 @@ func netError(err error) error {
 
 	return &FatalError{Err: err}
 }
+
+// netWriteError is netError for the failed write of a datagram of size bytes,
+// which is reported by a MessageTooLongError.
+func netWriteError(err error, size int) error {
+	err = netError(err)
+	var e *MessageTooLongError
+	if errors.As(err, &e) {
+		e.Size = size
+	}
+	return err
+}
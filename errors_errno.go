@@ -20,3 +20,7 @@ import (
 func isOpErrorTemporary(err *os.SyscallError) bool {
 	return errors.Is(err.Err, syscall.ECONNREFUSED)
 }
+
+func isOpErrorMessageTooLong(err *os.SyscallError) bool {
+	return errors.Is(err.Err, syscall.EMSGSIZE)
+}
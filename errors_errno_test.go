@@ -60,3 +60,33 @@ func TestErrorsTemporary(t *testing.T) {
 		t.Errorf("%v must be temporary error", err)
 	}
 }
+
+func TestErrorsMessageTooLong(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("Unexpected failure to listen: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	// No IPv4 datagram can carry more than 65507 bytes of UDP payload.
+	const size = 1 << 16
+	_, err = conn.WriteTo(make([]byte, size), conn.LocalAddr())
+	if err == nil {
+		t.Skip("EMSGSIZE is not set by system")
+	}
+
+	err = netWriteError(err, size)
+	if !errors.Is(err, ErrMessageTooLong) {
+		t.Fatalf("Expected %v, got %v", ErrMessageTooLong, err)
+	}
+	var e *MessageTooLongError
+	if !errors.As(err, &e) || e.Size != size {
+		t.Errorf("Expected a MessageTooLongError of %d bytes, got %v", size, err)
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Temporary() { //nolint:staticcheck
+		t.Errorf("%v must be temporary error", err)
+	}
+}
@@ -16,3 +16,7 @@ import (
 func isOpErrorTemporary(err *os.SyscallError) bool {
 	return false
 }
+
+func isOpErrorMessageTooLong(err *os.SyscallError) bool {
+	return false
+}
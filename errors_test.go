@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"net"
 	"testing"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
 )
 
 var errExample = errors.New("an example error")
@@ -64,6 +66,7 @@ func TestErrorNetError(t *testing.T) {
 		{&TimeoutError{Err: errExample}, "dtls timeout: an example error", true, true},
 		{&HandshakeError{Err: errExample}, "handshake error: an example error", false, false},
 		{&HandshakeError{Err: &TimeoutError{Err: errExample}}, "handshake error: dtls timeout: an example error", true, true},
+		{&HandshakeError{Err: &TimeoutError{Err: errExample}, LastFlight: flight3.String()}, "handshake error in Flight 3: dtls timeout: an example error", true, true},
 	}
 	for _, c := range cases {
 		c := c
@@ -84,3 +87,15 @@ func TestErrorNetError(t *testing.T) {
 		})
 	}
 }
+
+func TestHandshakeErrorAsProtocolError(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", &HandshakeError{Err: errExample})
+
+	var protocolErr *protocol.HandshakeError
+	if !errors.As(err, &protocolErr) {
+		t.Fatalf("Expected %v to be a protocol.HandshakeError", err)
+	}
+	if !errors.Is(protocolErr.Err, errExample) {
+		t.Errorf("Expected %v, got %v", errExample, protocolErr.Err)
+	}
+}
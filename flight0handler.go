@@ -4,30 +4,43 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
-	"crypto/rand"
+	"errors"
+	"io"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
 )
 
-func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
+// renegotiationInfoSCSV is TLS_EMPTY_RENEGOTIATION_INFO_SCSV, which a client
+// may offer instead of an empty renegotiation_info extension.
+// https://datatracker.ietf.org/doc/html/rfc5746#section-3.3
+const renegotiationInfoSCSV = 0x00ff
+
+func flight0Parse(_ context.Context, c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
 	seq, msgs, ok := cache.fullPullMap(0, state.cipherSuite,
 		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 	)
+	// With a CookieGenerator, the ClientHello carrying the cookie may be the
+	// first one this server receives, if the HelloVerifyRequest was sent by
+	// another server sharing the generator.
+	statelessCookie := false
+	if !ok && cfg.cookieGenerator != nil && cfg.verifyHello(c.remoteAddr()) {
+		seq, msgs, ok = cache.fullPullMap(1, state.cipherSuite,
+			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
+		)
+		statelessCookie = ok
+	}
 	if !ok {
 		// No valid message received. Keep reading
 		return 0, nil, nil
 	}
 
-	// Connection Identifiers must be negotiated afresh on session resumption.
-	// https://datatracker.ietf.org/doc/html/rfc9146#name-the-connection_id-extension
-	state.localConnectionID = nil
-	state.remoteConnectionID = nil
-
 	state.handshakeRecvSequence = seq
 
 	var clientHello *handshake.MessageClientHello
@@ -41,18 +54,105 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
 	}
 
+	if statelessCookie {
+		if len(clientHello.Cookie) == 0 {
+			return 0, nil, nil
+		}
+		if !verifyCookie(c, state, cfg, clientHello) {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.AccessDenied}, errCookieMismatch
+		}
+		// Number the ServerHello as if this server had sent the
+		// HelloVerifyRequest [RFC6347 Section 4.2.2].
+		state.handshakeSendSequence = 1
+		return handleClientHello(state, cfg, clientHello, flight4)
+	}
+
+	if !cfg.verifyHello(c.remoteAddr()) {
+		return handleClientHello(state, cfg, clientHello, flight4)
+	}
+	// The ClientHello is only acted upon once the client has proven its
+	// address by echoing the cookie.
+	if cfg.cookieGenerator != nil {
+		if err := generateCookie(c, state, cfg, clientHello); err != nil {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+	}
+	return flight2, nil, nil
+}
+
+// handleClientHello negotiates the parameters of the handshake from
+// clientHello, once the cookie exchange is over, and returns the flight
+// that follows: nextFlight, or the abbreviated handshake if the session is
+// resumed.
+func handleClientHello(state *State, cfg *handshakeConfig, clientHello *handshake.MessageClientHello, nextFlight flightVal) (flightVal, *alert.Alert, error) {
+	// Connection Identifiers must be negotiated afresh on session resumption.
+	// https://datatracker.ietf.org/doc/html/rfc9146#name-the-connection_id-extension
+	state.localConnectionID = nil
+	state.remoteConnectionID = nil
+
+	state.remoteHeartbeatMode = 0
+	state.remoteRequestedOCSPStaple = false
+	state.remoteRequestedSCTs = false
+	state.certificateCompression = 0
+	state.truncatedHMAC = false
+
 	state.remoteRandom = clientHello.Random
+	state.clientVersion = clientHello.Version
+	state.clientHelloInfo = newClientHelloInfo(clientHello)
+
+	if cfg.getConfigForClient != nil {
+		config, err := cfg.getConfigForClient(state.clientHelloInfo)
+		if err != nil {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		if config != nil {
+			if err = validateConfig(config); err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			clientCfg, err := newHandshakeConfig(config, cfg.log, false)
+			if err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			cfg.useConfig(clientCfg)
+		}
+	}
 
 	cipherSuites := []CipherSuite{}
+	renegotiationInfo := false
+	renegotiationVerified := false
 	for _, id := range clientHello.CipherSuiteIDs {
+		if id == renegotiationInfoSCSV {
+			renegotiationInfo = true
+		}
 		if c := cipherSuiteForID(CipherSuiteID(id), cfg.customCipherSuites); c != nil {
 			cipherSuites = append(cipherSuites, c)
 		}
 	}
 
-	if state.cipherSuite, ok = findMatchingCipherSuite(cipherSuites, cfg.localCipherSuites); !ok {
+	// rfc5246#section-7.4.3
+	// In addition, the hash and signature algorithms MUST be compatible
+	// with the key in the server's end-entity certificate.
+	localCipherSuites := cfg.localCipherSuites
+	if cert, err := cfg.getCertificate(state.clientHelloInfo); err == nil {
+		localCipherSuites = filterCipherSuitesForCertificate(cert, localCipherSuites)
+	} else if !errors.Is(err, errNoCertificates) {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+	}
+
+	preferred := cipherSuites
+	if cfg.preferServerCipherSuites {
+		preferred = orderCipherSuites(cipherSuites, localCipherSuites)
+	}
+	var ok bool
+	if state.cipherSuite, ok = findMatchingCipherSuite(preferred, localCipherSuites); !ok {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errCipherSuiteNoIntersection
 	}
+	state.CipherSuiteID = state.cipherSuite.ID()
+
+	// If the server requires a specific curve, the client must offer it
+	// whenever an ECDHE key exchange is negotiated.
+	isEcdhe := state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe)
+	offeredRequiredCurve := cfg.requiredCurve == 0 || !isEcdhe
 
 	for _, val := range clientHello.Extensions {
 		switch e := val.(type) {
@@ -60,7 +160,29 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 			if len(e.EllipticCurves) == 0 {
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoSupportedEllipticCurves
 			}
-			state.namedCurve = e.EllipticCurves[0]
+			// Pick the first curve offered by the client that we implement
+			// and are configured to use.
+			sharedCurve := false
+			for _, c := range e.EllipticCurves {
+				if cfg.supportsCurve(c) {
+					state.namedCurve = c
+					sharedCurve = true
+					break
+				}
+			}
+			if !sharedCurve && isEcdhe {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errNoSupportedEllipticCurves
+			}
+			if cfg.requiredCurve != 0 {
+				offeredRequiredCurve = false
+				for _, c := range e.EllipticCurves {
+					if c == cfg.requiredCurve {
+						offeredRequiredCurve = true
+						state.namedCurve = c
+						break
+					}
+				}
+			}
 		case *extension.UseSRTP:
 			profile, ok := findMatchingSRTPProfile(e.ProtectionProfiles, cfg.localSRTPProtectionProfiles)
 			if !ok {
@@ -71,6 +193,14 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 			if cfg.extendedMasterSecret != DisableExtendedMasterSecret {
 				state.extendedMasterSecret = true
 			}
+		case *extension.RenegotiationInfo:
+			renegotiationInfo = true
+			renegotiationVerified = bytes.Equal(e.VerifyData, cfg.renegotiatedConnection(true))
+		case *extension.SupportedVersions:
+			// DTLS 1.2 is the only version this server implements.
+			if !containsVersion(e.Versions, protocol.Version1_2) {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
+			}
 		case *extension.ServerName:
 			state.serverName = e.ServerName // remote server name
 		case *extension.ALPN:
@@ -81,9 +211,25 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 			if cfg.connectionIDGenerator != nil {
 				state.remoteConnectionID = e.CID
 			}
+		case *extension.Heartbeat:
+			if cfg.heartbeat {
+				state.remoteHeartbeatMode = e.Mode
+			}
+		case *extension.StatusRequest:
+			state.remoteRequestedOCSPStaple = e.StatusType == extension.CertificateStatusTypeOCSP
+		case *extension.SignedCertificateTimestamp:
+			state.remoteRequestedSCTs = true
+		case *extension.CompressCertificate:
+			state.certificateCompression = cfg.selectCertificateCompression(e.Algorithms)
+		case *extension.TruncatedHMAC:
+			state.truncatedHMAC = cfg.truncatedHMAC && supportsTruncatedMAC(state.cipherSuite)
 		}
 	}
 
+	if !offeredRequiredCurve {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRequiredCurveNotOffered
+	}
+
 	// If the client doesn't support connection IDs, the server should not
 	// expect one to be sent.
 	if state.remoteConnectionID == nil {
@@ -93,24 +239,91 @@ func flight0Parse(_ context.Context, _ flightConn, state *State, cache *handshak
 	if cfg.extendedMasterSecret == RequireExtendedMasterSecret && !state.extendedMasterSecret {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errServerRequiredButNoClientEMS
 	}
+	if cfg.requireRenegotiationInfo && !renegotiationInfo {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errClientNoRenegotiationInfo
+	}
+	// A rekey must carry the verify_data of the handshake it renegotiates,
+	// the SCSV is not enough [RFC5746 Section 3.7].
+	if cfg.initialEpoch > 0 && !renegotiationVerified {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+	}
 
 	if state.localKeypair == nil {
 		var err error
-		state.localKeypair, err = elliptic.GenerateKeypair(state.namedCurve)
+		state.localKeypair, err = cfg.generateKeypair(state.namedCurve)
 		if err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, err
 		}
 	}
 
-	nextFlight := flight2
-
-	if cfg.insecureSkipHelloVerify {
-		nextFlight = flight4
+	if state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmDhe) {
+		group, ok := selectDHGroup(state.clientHelloInfo.SupportedCurves, cfg)
+		if !ok {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errNoSupportedDHGroups
+		}
+		if state.localDHKeypair == nil || state.dhGroup != group {
+			p, g, err := group.Params()
+			if err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			if state.localDHKeypair, err = ffdhe.GenerateKeypair(cfg.rand, p, g); err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			state.dhGroup = group
+		}
 	}
 
 	return handleHelloResume(clientHello.SessionID, state, cfg, nextFlight)
 }
 
+// newClientHelloInfo returns the ClientHelloInfo describing clientHello.
+func newClientHelloInfo(clientHello *handshake.MessageClientHello) *ClientHelloInfo {
+	info := &ClientHelloInfo{
+		RandomBytes: clientHello.Random.RandomBytes,
+	}
+	for _, id := range clientHello.CipherSuiteIDs {
+		info.CipherSuites = append(info.CipherSuites, CipherSuiteID(id))
+	}
+	for _, val := range clientHello.Extensions {
+		switch e := val.(type) {
+		case *extension.ServerName:
+			info.ServerName = e.ServerName
+		case *extension.SupportedEllipticCurves:
+			info.SupportedCurves = e.EllipticCurves
+		case *extension.SupportedSignatureAlgorithms:
+			info.SignatureSchemes = e.SignatureHashAlgorithms
+		case *extension.ALPN:
+			info.SupportedProtocols = e.ProtocolNameList
+		case *extension.TrustedCAKeys:
+			info.TrustedCAKeys = e.Authorities
+		}
+	}
+	return info
+}
+
+// selectDHGroup returns the first finite field group offered by the client
+// that we support. A client that offers no finite field groups at all
+// accepts any group, so the most preferred local group is used.
+//
+// https://datatracker.ietf.org/doc/html/rfc7919#section-4
+func selectDHGroup(offered []elliptic.Curve, cfg *handshakeConfig) (ffdhe.Group, bool) {
+	offeredDHGroups := false
+	for _, c := range offered {
+		group := ffdhe.Group(c)
+		if !ffdhe.Groups()[group] {
+			continue
+		}
+		offeredDHGroups = true
+		if cfg.supportsDHGroup(group) {
+			return group, true
+		}
+	}
+	if offeredDHGroups || len(cfg.dhGroups) == 0 {
+		return 0, false
+	}
+	return cfg.dhGroups[0], true
+}
+
 func handleHelloResume(sessionID []byte, state *State, cfg *handshakeConfig, next flightVal) (flightVal, *alert.Alert, error) {
 	if len(sessionID) > 0 && cfg.sessionStore != nil {
 		if s, err := cfg.sessionStore.Get(sessionID); err != nil {
@@ -121,7 +334,7 @@ func handleHelloResume(sessionID []byte, state *State, cfg *handshakeConfig, nex
 			state.SessionID = sessionID
 			state.masterSecret = s.Secret
 
-			if err := state.initCipherSuite(); err != nil {
+			if err := state.initCipherSuite(cfg.rand, cfg.sequenceNonce); err != nil {
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 			}
 
@@ -136,9 +349,9 @@ func handleHelloResume(sessionID []byte, state *State, cfg *handshakeConfig, nex
 
 func flight0Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	// Initialize
-	if !cfg.insecureSkipHelloVerify {
+	if !cfg.insecureSkipHelloVerify && cfg.cookieGenerator == nil {
 		state.cookie = make([]byte, cookieLength)
-		if _, err := rand.Read(state.cookie); err != nil {
+		if _, err := io.ReadFull(cfg.rand, state.cookie); err != nil {
 			return nil, nil, err
 		}
 	}
@@ -148,7 +361,7 @@ func flight0Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 	state.remoteEpoch.Store(zeroEpoch)
 	state.namedCurve = defaultNamedCurve
 
-	if err := state.localRandom.Populate(); err != nil {
+	if err := state.localRandom.PopulateFrom(cfg.rand); err != nil {
 		return nil, nil, err
 	}
 
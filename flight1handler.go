@@ -53,7 +53,7 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 	state.namedCurve = defaultNamedCurve
 	state.cookie = nil
 
-	if err := state.localRandom.Populate(); err != nil {
+	if err := state.localRandom.PopulateFrom(cfg.rand); err != nil {
 		return nil, nil, err
 	}
 
@@ -67,6 +67,7 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		},
 		&extension.RenegotiationInfo{
 			RenegotiatedConnection: 0,
+			VerifyData:             cfg.renegotiatedConnection(true),
 		},
 	}
 
@@ -81,12 +82,16 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 	if setEllipticCurveCryptographyClientHelloExtensions {
 		extensions = append(extensions, []extension.Extension{
 			&extension.SupportedEllipticCurves{
-				EllipticCurves: cfg.ellipticCurves,
+				EllipticCurves: cfg.supportedGroups(true),
 			},
 			&extension.SupportedPointFormats{
 				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
 			},
 		}...)
+	} else if cfg.offersDHE() {
+		extensions = append(extensions, &extension.SupportedEllipticCurves{
+			EllipticCurves: cfg.supportedGroups(false),
+		})
 	}
 
 	if len(cfg.localSRTPProtectionProfiles) > 0 {
@@ -110,6 +115,32 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		extensions = append(extensions, &extension.ALPN{ProtocolNameList: cfg.supportedProtocols})
 	}
 
+	if cfg.requestOCSPStaple {
+		extensions = append(extensions, &extension.StatusRequest{StatusType: extension.CertificateStatusTypeOCSP})
+	}
+
+	if cfg.requestSCTs {
+		extensions = append(extensions, &extension.SignedCertificateTimestamp{})
+	}
+
+	if len(cfg.trustedCAKeys) > 0 {
+		extensions = append(extensions, &extension.TrustedCAKeys{Authorities: cfg.trustedCAKeys})
+	}
+
+	if len(cfg.certificateCompression) > 0 {
+		extensions = append(extensions, &extension.CompressCertificate{Algorithms: cfg.certificateCompression})
+	}
+
+	if cfg.truncatedHMAC {
+		extensions = append(extensions, &extension.TruncatedHMAC{})
+	}
+
+	// supported_versions is only needed to offer versions newer than DTLS
+	// 1.2. https://tools.ietf.org/html/rfc8446#section-4.2.1
+	if len(cfg.supportedVersions) > 1 {
+		extensions = append(extensions, &extension.SupportedVersions{Versions: cfg.supportedVersions})
+	}
+
 	if cfg.sessionStore != nil {
 		cfg.log.Tracef("[handshake] try to resume session")
 		if s, err := cfg.sessionStore.Get(c.sessionKey()); err != nil {
@@ -122,11 +153,19 @@ func flight1Generate(c flightConn, state *State, _ *handshakeCache, cfg *handsha
 		}
 	}
 
+	if cfg.heartbeat {
+		extensions = append(extensions, &extension.Heartbeat{Mode: extension.HeartbeatModePeerAllowedToSend})
+	}
+
 	// If we have a connection ID generator, use it. The CID may be zero length,
 	// in which case we are just requesting that the server send us a CID to
 	// use.
 	if cfg.connectionIDGenerator != nil {
-		state.localConnectionID = cfg.connectionIDGenerator()
+		cid, err := generateConnectionID(cfg.connectionIDGenerator)
+		if err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		state.localConnectionID = cid
 		// The presence of a generator indicates support for connection IDs. We
 		// use the presence of a non-nil local CID in flight 3 to determine
 		// whether we send a CID in the second ClientHello, so we convert any
@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"context"
+	"net"
 	"testing"
 	"time"
 
@@ -17,7 +18,7 @@ import (
 
 type flight1TestMockFlightConn struct{}
 
-func (f *flight1TestMockFlightConn) notify(context.Context, alert.Level, alert.Description) error {
+func (f *flight1TestMockFlightConn) notify(context.Context, alert.Level, alert.Description, error) error {
 	return nil
 }
 func (f *flight1TestMockFlightConn) writePackets(context.Context, []*packet) error { return nil }
@@ -25,6 +26,9 @@ func (f *flight1TestMockFlightConn) recvHandshake() <-chan chan struct{}
 func (f *flight1TestMockFlightConn) setLocalEpoch(uint16)                          {}
 func (f *flight1TestMockFlightConn) handleQueuedPackets(context.Context) error     { return nil }
 func (f *flight1TestMockFlightConn) sessionKey() []byte                            { return nil }
+func (f *flight1TestMockFlightConn) lowerMTU(int)                                  {}
+func (f *flight1TestMockFlightConn) MTU() int                                      { return 0 }
+func (f *flight1TestMockFlightConn) remoteAddr() net.Addr                          { return nil }
 
 type flight1TestMockCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -51,7 +55,7 @@ func TestFlight1_Process_ServerHelloLateArrival(t *testing.T) {
 
 	mockConn := &flight1TestMockFlightConn{}
 	state := &State{
-		cipherSuite: &flight1TestMockCipherSuite{t: t},
+		handshakeResult: handshakeResult{cipherSuite: &flight1TestMockCipherSuite{t: t}},
 	}
 	cache := newHandshakeCache()
 	cfg := &handshakeConfig{
@@ -4,7 +4,6 @@
 package dtls
 
 import (
-	"bytes"
 	"context"
 
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
@@ -38,10 +37,10 @@ func flight2Parse(ctx context.Context, c flightConn, state *State, cache *handsh
 	if len(clientHello.Cookie) == 0 {
 		return 0, nil, nil
 	}
-	if !bytes.Equal(state.cookie, clientHello.Cookie) {
+	if !verifyCookie(c, state, cfg, clientHello) {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.AccessDenied}, errCookieMismatch
 	}
-	return flight4, nil, nil
+	return handleClientHello(state, cfg, clientHello, flight4)
 }
 
 func flight2Generate(_ flightConn, state *State, _ *handshakeCache, _ *handshakeConfig) ([]*packet, *alert.Alert, error) {
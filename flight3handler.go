@@ -6,9 +6,13 @@ package dtls
 import (
 	"bytes"
 	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"math/big"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite/types"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
@@ -50,6 +54,12 @@ func flight3Parse( //nolint:gocognit
 		if !h.Version.Equal(protocol.Version1_2) {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
 		}
+		state.CompressionMethod = h.CompressionMethod.ID
+		if _, ok := protocol.CompressionMethods()[h.CompressionMethod.ID]; !ok {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, &CompressionMethodError{Method: h.CompressionMethod.ID}
+		}
+		renegotiationInfo := false
+		renegotiationVerified := false
 		for _, v := range h.Extensions {
 			switch e := v.(type) {
 			case *extension.UseSRTP:
@@ -62,6 +72,13 @@ func flight3Parse( //nolint:gocognit
 				if cfg.extendedMasterSecret != DisableExtendedMasterSecret {
 					state.extendedMasterSecret = true
 				}
+			case *extension.RenegotiationInfo:
+				renegotiationInfo = true
+				renegotiationVerified = bytes.Equal(e.VerifyData, cfg.renegotiatedConnection(false))
+			case *extension.SupportedVersions:
+				if !e.SelectedVersion.Equal(protocol.Version1_2) {
+					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.ProtocolVersion}, errUnsupportedProtocolVersion
+				}
 			case *extension.ALPN:
 				if len(e.ProtocolNameList) > 1 { // This should be exactly 1, the zero case is handle when unmarshalling
 					return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, extension.ErrALPNInvalidFormat // Meh, internal error?
@@ -73,6 +90,14 @@ func flight3Parse( //nolint:gocognit
 				if cfg.connectionIDGenerator != nil {
 					state.remoteConnectionID = e.CID
 				}
+			case *extension.Heartbeat:
+				if cfg.heartbeat {
+					state.remoteHeartbeatMode = e.Mode
+				}
+			case *extension.SignedCertificateTimestamp:
+				state.SCTs = e.SignedCertificateTimestamps
+			case *extension.TruncatedHMAC:
+				state.truncatedHMAC = cfg.truncatedHMAC
 			}
 		}
 		// If the server doesn't support connection IDs, the client should not
@@ -84,6 +109,14 @@ func flight3Parse( //nolint:gocognit
 		if cfg.extendedMasterSecret == RequireExtendedMasterSecret && !state.extendedMasterSecret {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errClientRequiredButNoServerEMS
 		}
+		if cfg.requireRenegotiationInfo && !renegotiationInfo {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errServerNoRenegotiationInfo
+		}
+		// A server accepting a rekey must echo the verify_data of the
+		// handshake it renegotiates [RFC5746 Section 3.5].
+		if cfg.initialEpoch > 0 && !renegotiationVerified {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errRenegotiationInfoMismatch
+		}
 		if len(cfg.localSRTPProtectionProfiles) > 0 && state.getSRTPProtectionProfile() == 0 {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errRequestedButNoSRTPExtension
 		}
@@ -99,6 +132,7 @@ func flight3Parse( //nolint:gocognit
 		}
 
 		state.cipherSuite = selectedCipherSuite
+		state.CipherSuiteID = selectedCipherSuite.ID()
 		state.remoteRandom = h.Random
 		cfg.log.Tracef("[handshake] use cipher suite: %s", selectedCipherSuite.String())
 
@@ -122,14 +156,25 @@ func flight3Parse( //nolint:gocognit
 		state.masterSecret = []byte{}
 	}
 
-	if cfg.localPSKCallback != nil {
+	rsaKeyExchange := state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmRsa)
+	switch {
+	case cfg.localPSKCallback != nil:
 		seq, msgs, ok = cache.fullPullMap(state.handshakeRecvSequence+1, state.cipherSuite,
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, true},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
 		)
-	} else {
+	case rsaKeyExchange:
+		// The static RSA key exchange has no ServerKeyExchange.
+		seq, msgs, ok = cache.fullPullMap(state.handshakeRecvSequence+1, state.cipherSuite,
+			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, true},
+			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, true},
+			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
+		)
+	default:
 		seq, msgs, ok = cache.fullPullMap(state.handshakeRecvSequence+1, state.cipherSuite,
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, true},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, true},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, true},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -141,10 +186,23 @@ func flight3Parse( //nolint:gocognit
 	}
 	state.handshakeRecvSequence = seq
 
-	if h, ok := msgs[handshake.TypeCertificate].(*handshake.MessageCertificate); ok {
+	switch h := msgs[handshake.TypeCertificate].(type) {
+	case *handshake.MessageCertificate:
 		state.PeerCertificates = h.Certificate
-	} else if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.NoCertificate}, errInvalidCertificate
+	case *handshake.MessageCompressedCertificate:
+		certificate, alertPtr, err := decompressCertificate(h, cfg)
+		if err != nil {
+			return 0, alertPtr, err
+		}
+		state.PeerCertificates = certificate.Certificate
+	default:
+		if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate {
+			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.NoCertificate}, errInvalidCertificate
+		}
+	}
+
+	if h, ok := msgs[handshake.TypeCertificateStatus].(*handshake.MessageCertificateStatus); ok {
+		state.OCSPResponse = h.OCSPResponse
 	}
 
 	if h, ok := msgs[handshake.TypeServerKeyExchange].(*handshake.MessageServerKeyExchange); ok {
@@ -153,6 +211,12 @@ func flight3Parse( //nolint:gocognit
 			return 0, alertPtr, err
 		}
 	}
+	if rsaKeyExchange {
+		alertPtr, err := handleRSAKeyExchange(state, cfg)
+		if err != nil {
+			return 0, alertPtr, err
+		}
+	}
 
 	if creq, ok := msgs[handshake.TypeCertificateRequest].(*handshake.MessageCertificateRequest); ok {
 		state.remoteCertRequestAlgs = creq.SignatureHashAlgorithms
@@ -163,7 +227,7 @@ func flight3Parse( //nolint:gocognit
 }
 
 func handleResumption(ctx context.Context, c flightConn, state *State, cache *handshakeCache, cfg *handshakeConfig) (flightVal, *alert.Alert, error) {
-	if err := state.initCipherSuite(); err != nil {
+	if err := state.initCipherSuite(cfg.rand, cfg.sequenceNonce); err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
 
@@ -193,8 +257,8 @@ func handleResumption(ctx context.Context, c flightConn, state *State, cache *ha
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if !verifyDataMatches(expectedVerifyData, finished.VerifyData) {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, errVerifyDataMismatch
 	}
 
 	clientRandom := state.localRandom.MarshalFixed()
@@ -218,7 +282,10 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 		case types.KeyExchangeAlgorithmPsk:
 			state.preMasterSecret = prf.PSKPreMasterSecret(psk)
 		case (types.KeyExchangeAlgorithmEcdhe | types.KeyExchangeAlgorithmPsk):
-			if state.localKeypair, err = elliptic.GenerateKeypair(h.NamedCurve); err != nil {
+			if !cfg.supportsCurve(h.NamedCurve) {
+				return &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errServerSelectedUnsupportedCurve
+			}
+			if state.localKeypair, err = elliptic.GenerateKeypairFrom(cfg.rand, h.NamedCurve); err != nil {
 				return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 			}
 			state.preMasterSecret, err = prf.EcdhePSKPreMasterSecret(psk, h.PublicKey, state.localKeypair.PrivateKey, state.localKeypair.Curve)
@@ -228,8 +295,32 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 		default:
 			return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errInvalidCipherSuite
 		}
+	} else if state.cipherSuite.KeyExchangeAlgorithm().Has(types.KeyExchangeAlgorithmDhe) {
+		// The parameters are chosen by the server, so only check them, which
+		// may take a primality test, once it has signed them.
+		if alertPtr, err := verifyServerKeyExchange(state, cfg, h); err != nil {
+			return alertPtr, err
+		}
+		p := new(big.Int).SetBytes(h.DHPrime)
+		g := new(big.Int).SetBytes(h.DHGenerator)
+		if p.BitLen() < cfg.minDHPrimeBits {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errDHPrimeTooSmall
+		}
+		if err = ffdhe.ValidateParams(p, g, cfg.minDHPrimeBits); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, err
+		}
+		if state.localDHKeypair, err = ffdhe.GenerateKeypair(cfg.rand, p, g); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+
+		if state.preMasterSecret, err = prf.DHPreMasterSecret(h.DHPublicKey, state.localDHKeypair); err != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, err
+		}
 	} else {
-		if state.localKeypair, err = elliptic.GenerateKeypair(h.NamedCurve); err != nil {
+		if !cfg.supportsCurve(h.NamedCurve) {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, errServerSelectedUnsupportedCurve
+		}
+		if state.localKeypair, err = elliptic.GenerateKeypairFrom(cfg.rand, h.NamedCurve); err != nil {
 			return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
 
@@ -241,6 +332,27 @@ func handleServerKeyExchange(_ flightConn, state *State, cfg *handshakeConfig, h
 	return nil, nil //nolint:nilnil
 }
 
+// handleRSAKeyExchange generates the premaster secret of the static RSA key
+// exchange and encrypts it to the server's certificate.
+func handleRSAKeyExchange(state *State, cfg *handshakeConfig) (*alert.Alert, error) {
+	if len(state.PeerCertificates) == 0 {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.NoCertificate}, errInvalidCertificate
+	}
+	certificate, err := x509.ParseCertificate(state.PeerCertificates[0])
+	if err != nil {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+	}
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.UnsupportedCertificate}, errRSAKeyExchangeNoRSAKey
+	}
+
+	if state.preMasterSecret, state.encryptedPreMasterSecret, err = prf.RSAPreMasterSecret(cfg.rand, publicKey); err != nil {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	}
+	return nil, nil //nolint:nilnil
+}
+
 func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) {
 	extensions := []extension.Extension{
 		&extension.SupportedSignatureAlgorithms{
@@ -248,13 +360,14 @@ func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		},
 		&extension.RenegotiationInfo{
 			RenegotiatedConnection: 0,
+			VerifyData:             cfg.renegotiatedConnection(true),
 		},
 	}
 
 	if state.namedCurve != 0 {
 		extensions = append(extensions, []extension.Extension{
 			&extension.SupportedEllipticCurves{
-				EllipticCurves: cfg.ellipticCurves,
+				EllipticCurves: cfg.supportedGroups(true),
 			},
 			&extension.SupportedPointFormats{
 				PointFormats: []elliptic.CurvePointFormat{elliptic.CurvePointFormatUncompressed},
@@ -283,6 +396,36 @@ func flight3Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		extensions = append(extensions, &extension.ALPN{ProtocolNameList: cfg.supportedProtocols})
 	}
 
+	if cfg.requestOCSPStaple {
+		extensions = append(extensions, &extension.StatusRequest{StatusType: extension.CertificateStatusTypeOCSP})
+	}
+
+	if cfg.requestSCTs {
+		extensions = append(extensions, &extension.SignedCertificateTimestamp{})
+	}
+
+	if len(cfg.trustedCAKeys) > 0 {
+		extensions = append(extensions, &extension.TrustedCAKeys{Authorities: cfg.trustedCAKeys})
+	}
+
+	if len(cfg.certificateCompression) > 0 {
+		extensions = append(extensions, &extension.CompressCertificate{Algorithms: cfg.certificateCompression})
+	}
+
+	if cfg.truncatedHMAC {
+		extensions = append(extensions, &extension.TruncatedHMAC{})
+	}
+
+	// supported_versions is only needed to offer versions newer than DTLS
+	// 1.2. https://tools.ietf.org/html/rfc8446#section-4.2.1
+	if len(cfg.supportedVersions) > 1 {
+		extensions = append(extensions, &extension.SupportedVersions{Versions: cfg.supportedVersions})
+	}
+
+	if cfg.heartbeat {
+		extensions = append(extensions, &extension.Heartbeat{Mode: extension.HeartbeatModePeerAllowedToSend})
+	}
+
 	// If we sent a connection ID on the first ClientHello, send it on the
 	// second.
 	if state.localConnectionID != nil {
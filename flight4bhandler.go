@@ -4,7 +4,6 @@
 package dtls
 
 import (
-	"bytes"
 	"context"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
@@ -39,8 +38,8 @@ func flight4bParse(_ context.Context, _ flightConn, state *State, cache *handsha
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if !verifyDataMatches(expectedVerifyData, finished.VerifyData) {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, errVerifyDataMismatch
 	}
 
 	// Other party may re-transmit the last flight. Keep state to be flight4b.
@@ -52,6 +51,7 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 
 	extensions := []extension.Extension{&extension.RenegotiationInfo{
 		RenegotiatedConnection: 0,
+		VerifyData:             cfg.renegotiatedConnection(false),
 	}}
 	if (cfg.extendedMasterSecret == RequestExtendedMasterSecret ||
 		cfg.extendedMasterSecret == RequireExtendedMasterSecret) && state.extendedMasterSecret {
@@ -76,6 +76,11 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 		state.NegotiatedProtocol = selectedProto
 	}
 
+	// Only answer with the Heartbeat extension if the client offered it.
+	if state.remoteHeartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: extension.HeartbeatModePeerAllowedToSend})
+	}
+
 	cipherSuiteID := uint16(state.cipherSuite.ID())
 	var serverHello handshake.Handshake
 
@@ -93,6 +98,9 @@ func flight4bGenerate(_ flightConn, state *State, cache *handshakeCache, cfg *ha
 	} else {
 		serverHello = handshake.Handshake{Message: serverHelloMessage}
 	}
+	if h, ok := serverHello.Message.(*handshake.MessageServerHello); ok && h.CompressionMethod != nil {
+		state.CompressionMethod = h.CompressionMethod.ID
+	}
 
 	serverHello.Header.MessageSequence = uint16(state.handshakeSendSequence)
 
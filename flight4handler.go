@@ -5,10 +5,11 @@ package dtls
 
 import (
 	"context"
-	"crypto/rand"
-	"crypto/x509"
+	"crypto/rsa"
+	"crypto/tls"
+	"io"
+	"time"
 
-	"github.com/censys-oss/dtls/v2/internal/ciphersuite"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/clientcertificate"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
@@ -57,6 +58,7 @@ func flight4Parse( //nolint:gocognit
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -79,14 +81,17 @@ func flight4Parse( //nolint:gocognit
 		if err := verifyCertificateVerify(plainText, h.HashAlgorithm, h.Signature, state.PeerCertificates); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
 		}
-		var chains [][]*x509.Certificate
-		var err error
+		state.SignatureScheme = signaturehash.Algorithm{Hash: h.HashAlgorithm, Signature: h.SignatureAlgorithm}
+		chains, verifyErr := verifyClientCert(state.PeerCertificates, cfg.clientCAs)
+		state.CertificateValidityStatus = certificateValidity(state.PeerCertificates, verifyErr, time.Now())
 		var verified bool
 		if cfg.clientAuth >= VerifyClientCertIfGiven {
-			if chains, err = verifyClientCert(state.PeerCertificates, cfg.clientCAs); err != nil {
-				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+			if verifyErr != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, verifyErr
 			}
 			verified = true
+		} else {
+			chains = nil
 		}
 		if cfg.verifyPeerCertificate != nil {
 			if err := cfg.verifyPeerCertificate(state.PeerCertificates, chains); err != nil {
@@ -122,6 +127,22 @@ func flight4Parse( //nolint:gocognit
 			default:
 				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, errInvalidCipherSuite
 			}
+		} else if state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmRsa) {
+			if state.localCertificate == nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, errNoCertificates
+			}
+			privateKey, isRSA := state.localCertificate.PrivateKey.(*rsa.PrivateKey)
+			if !isRSA {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, errRSAKeyExchangeNoRSAKey
+			}
+			if preMasterSecret, err = prf.RSADecryptPreMasterSecret(cfg.rand, privateKey, clientKeyExchange.EncryptedPreMasterSecret, state.clientVersion); err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, err
+			}
+		} else if state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmDhe) {
+			preMasterSecret, err = prf.DHPreMasterSecret(clientKeyExchange.DHPublicKey, state.localDHKeypair)
+			if err != nil {
+				return 0, &alert.Alert{Level: alert.Fatal, Description: alert.IllegalParameter}, err
+			}
 		} else {
 			preMasterSecret, err = prf.PreMasterSecret(clientKeyExchange.PublicKey, state.localKeypair.PrivateKey, state.localKeypair.Curve)
 			if err != nil {
@@ -147,6 +168,9 @@ func flight4Parse( //nolint:gocognit
 			}
 		}
 
+		setCipherSuiteRand(state.cipherSuite, cfg.rand)
+		setCipherSuiteTruncatedMAC(state.cipherSuite, state.truncatedHMAC)
+		setCipherSuiteSequenceNonce(state.cipherSuite, cfg.sequenceNonce)
 		if err := state.cipherSuite.Init(state.masterSecret, clientRandom[:], serverRandom[:], false); err != nil {
 			return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
@@ -178,10 +202,32 @@ func flight4Parse( //nolint:gocognit
 	}
 	state.handshakeRecvSequence = seq
 
-	if _, ok = msgs[handshake.TypeFinished].(*handshake.MessageFinished); !ok {
+	var finished *handshake.MessageFinished
+	if finished, ok = msgs[handshake.TypeFinished].(*handshake.MessageFinished); !ok {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, nil
 	}
 
+	plainText := cache.pullAndMerge(
+		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeClientKeyExchange, cfg.initialEpoch, true, false},
+		handshakeCachePullRule{handshake.TypeCertificateVerify, cfg.initialEpoch, true, false},
+	)
+
+	expectedVerifyData, err := prf.VerifyDataClient(state.masterSecret, plainText, state.cipherSuite.HashFunc())
+	if err != nil {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+	}
+	if !verifyDataMatches(expectedVerifyData, finished.VerifyData) {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, errVerifyDataMismatch
+	}
+
 	if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeAnonymous {
 		if cfg.verifyConnection != nil {
 			if err := cfg.verifyConnection(state.clone()); err != nil {
@@ -220,8 +266,18 @@ func flight4Parse( //nolint:gocognit
 }
 
 func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handshakeConfig) ([]*packet, *alert.Alert, error) { //nolint:gocognit
+	var certificate *tls.Certificate
+	if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate {
+		var err error
+		if certificate, err = cfg.getCertificate(state.clientHelloInfo); err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+		}
+		state.localCertificate = certificate
+	}
+
 	extensions := []extension.Extension{&extension.RenegotiationInfo{
 		RenegotiatedConnection: 0,
+		VerifyData:             cfg.renegotiatedConnection(false),
 	}}
 	if (cfg.extendedMasterSecret == RequestExtendedMasterSecret ||
 		cfg.extendedMasterSecret == RequireExtendedMasterSecret) && state.extendedMasterSecret {
@@ -251,12 +307,44 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		state.NegotiatedProtocol = selectedProto
 	}
 
+	// Only staple an OCSP response if the client asked for one. The empty
+	// status_request extension announces the CertificateStatus message.
+	// https://datatracker.ietf.org/doc/html/rfc6066#section-8
+	stapleOCSP := state.remoteRequestedOCSPStaple && certificate != nil && len(certificate.OCSPStaple) > 0
+	if stapleOCSP {
+		if err := validateOCSPStaple(certificate.OCSPStaple); err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		extensions = append(extensions, &extension.StatusRequest{})
+	}
+
+	// Likewise, SCTs are only sent to clients that asked for them.
+	// https://datatracker.ietf.org/doc/html/rfc6962#section-3.3.1
+	if state.remoteRequestedSCTs && certificate != nil && len(certificate.SignedCertificateTimestamps) > 0 {
+		extensions = append(extensions, &extension.SignedCertificateTimestamp{
+			SignedCertificateTimestamps: certificate.SignedCertificateTimestamps,
+		})
+	}
+
+	if state.truncatedHMAC {
+		extensions = append(extensions, &extension.TruncatedHMAC{})
+	}
+
+	// Only answer with the Heartbeat extension if the client offered it.
+	if state.remoteHeartbeatMode != 0 {
+		extensions = append(extensions, &extension.Heartbeat{Mode: extension.HeartbeatModePeerAllowedToSend})
+	}
+
 	// If we have a connection ID generator, we are willing to use connection
 	// IDs. We already know whether the client supports connection IDs from
 	// parsing the ClientHello, so avoid setting local connection ID if the
 	// client won't send it.
 	if cfg.connectionIDGenerator != nil && state.remoteConnectionID != nil {
-		state.localConnectionID = cfg.connectionIDGenerator()
+		cid, err := generateConnectionID(cfg.connectionIDGenerator)
+		if err != nil {
+			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+		}
+		state.localConnectionID = cid
 		extensions = append(extensions, &extension.ConnectionID{CID: state.localConnectionID})
 	}
 
@@ -265,7 +353,7 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 
 	if cfg.sessionStore != nil {
 		state.SessionID = make([]byte, sessionLength)
-		if _, err := rand.Read(state.SessionID); err != nil {
+		if _, err := io.ReadFull(cfg.rand, state.SessionID); err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
 	}
@@ -286,6 +374,9 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 	} else {
 		content = handshake.Handshake{Message: serverHello}
 	}
+	if h, ok := content.Message.(*handshake.MessageServerHello); ok && h.CompressionMethod != nil {
+		state.CompressionMethod = h.CompressionMethod.ID
+	}
 
 	pkts = append(pkts, &packet{
 		record: &recordlayer.RecordLayer{
@@ -298,60 +389,91 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 
 	switch {
 	case state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate:
-		certificate, err := cfg.getCertificate(&ClientHelloInfo{
-			ServerName:   state.serverName,
-			CipherSuites: []ciphersuite.ID{state.cipherSuite.ID()},
-			RandomBytes:  state.remoteRandom.RandomBytes,
-		})
-		if err != nil {
-			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, err
+		var certificateMsg handshake.Message = &handshake.MessageCertificate{
+			Certificate: certificate.Certificate,
+		}
+		if state.certificateCompression != 0 {
+			compressed, err := handshake.CompressCertificate(&handshake.MessageCertificate{
+				Certificate: certificate.Certificate,
+			}, state.certificateCompression)
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			certificateMsg = compressed
 		}
-
 		pkts = append(pkts, &packet{
 			record: &recordlayer.RecordLayer{
 				Header: recordlayer.Header{
 					Version: protocol.Version1_2,
 				},
 				Content: &handshake.Handshake{
-					Message: &handshake.MessageCertificate{
-						Certificate: certificate.Certificate,
-					},
+					Message: certificateMsg,
 				},
 			},
 		})
 
-		serverRandom := state.localRandom.MarshalFixed()
-		clientRandom := state.remoteRandom.MarshalFixed()
-
-		// Find compatible signature scheme
-		signatureHashAlgo, err := signaturehash.SelectSignatureScheme(cfg.localSignatureSchemes, certificate.PrivateKey)
-		if err != nil {
-			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, err
+		if stapleOCSP {
+			pkts = append(pkts, &packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Version: protocol.Version1_2,
+					},
+					Content: &handshake.Handshake{
+						Message: &handshake.MessageCertificateStatus{
+							OCSPResponse: certificate.OCSPStaple,
+						},
+					},
+				},
+			})
 		}
 
-		signature, err := generateKeySignature(clientRandom[:], serverRandom[:], state.localKeypair.PublicKey, state.namedCurve, certificate.PrivateKey, signatureHashAlgo.Hash)
-		if err != nil {
-			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
-		}
-		state.localKeySignature = signature
+		// The static RSA key exchange has no ServerKeyExchange.
+		if !state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmRsa) {
+			serverRandom := state.localRandom.MarshalFixed()
+			clientRandom := state.remoteRandom.MarshalFixed()
 
-		pkts = append(pkts, &packet{
-			record: &recordlayer.RecordLayer{
-				Header: recordlayer.Header{
-					Version: protocol.Version1_2,
-				},
-				Content: &handshake.Handshake{
-					Message: &handshake.MessageServerKeyExchange{
-						EllipticCurveType:  elliptic.CurveTypeNamedCurve,
-						NamedCurve:         state.namedCurve,
-						PublicKey:          state.localKeypair.PublicKey,
-						HashAlgorithm:      signatureHashAlgo.Hash,
-						SignatureAlgorithm: signatureHashAlgo.Signature,
-						Signature:          state.localKeySignature,
+			// Find compatible signature scheme
+			signatureHashAlgo, err := signaturehash.SelectSignatureScheme(cfg.localSignatureSchemes, certificate.PrivateKey)
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, err
+			}
+
+			srvExchange := &handshake.MessageServerKeyExchange{
+				EllipticCurveType: elliptic.CurveTypeNamedCurve,
+				NamedCurve:        state.namedCurve,
+				PublicKey:         state.localKeypair.PublicKey,
+			}
+			msg := valueKeyMessage(clientRandom[:], serverRandom[:], state.localKeypair.PublicKey, state.namedCurve)
+			if state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmDhe) {
+				srvExchange = &handshake.MessageServerKeyExchange{
+					DHPrime:     state.localDHKeypair.P.Bytes(),
+					DHGenerator: state.localDHKeypair.G.Bytes(),
+					DHPublicKey: state.localDHKeypair.PublicKey,
+				}
+				msg = dhValueKeyMessage(clientRandom[:], serverRandom[:], srvExchange.DHPrime, srvExchange.DHGenerator, srvExchange.DHPublicKey)
+			}
+
+			signature, err := signKeyMessage(cfg.rand, msg, certificate.PrivateKey, signatureHashAlgo.Hash)
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+			state.localKeySignature = signature
+
+			srvExchange.HashAlgorithm = signatureHashAlgo.Hash
+			srvExchange.SignatureAlgorithm = signatureHashAlgo.Signature
+			srvExchange.Signature = state.localKeySignature
+
+			pkts = append(pkts, &packet{
+				record: &recordlayer.RecordLayer{
+					Header: recordlayer.Header{
+						Version: protocol.Version1_2,
+					},
+					Content: &handshake.Handshake{
+						Message: srvExchange,
 					},
 				},
-			},
-		})
+			})
+		}
 
 		if cfg.clientAuth > NoClientCert {
 			// An empty list of certificateAuthorities signals to
@@ -388,7 +510,19 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 				},
 			})
 		}
-	case cfg.localPSKIdentityHint != nil || state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe):
+	default:
+		identityHint := cfg.localPSKIdentityHint
+		if cfg.localGetPSKIdentityHint != nil && state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypePreSharedKey {
+			var err error
+			identityHint, err = cfg.localGetPSKIdentityHint(state.clientHelloInfo)
+			if err != nil {
+				return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
+			}
+		}
+		if identityHint == nil && !state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe) {
+			break
+		}
+
 		// To help the client in selecting which identity to use, the server
 		// can provide a "PSK identity hint" in the ServerKeyExchange message.
 		// If no hint is provided and cipher suite doesn't use elliptic curve,
@@ -396,7 +530,7 @@ func flight4Generate(_ flightConn, state *State, _ *handshakeCache, cfg *handsha
 		//
 		// https://tools.ietf.org/html/rfc4279#section-2
 		srvExchange := &handshake.MessageServerKeyExchange{
-			IdentityHint: cfg.localPSKIdentityHint,
+			IdentityHint: identityHint,
 		}
 		if state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmEcdhe) {
 			srvExchange.EllipticCurveType = elliptic.CurveTypeNamedCurve
@@ -7,6 +7,7 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"net"
 	"testing"
 	"time"
 
@@ -23,7 +24,7 @@ type flight4TestMockFlightConn struct{}
 
 var errHookCertReqFailed = errors.New("hook failed to modify SignatureHashAlgorithms")
 
-func (f *flight4TestMockFlightConn) notify(context.Context, alert.Level, alert.Description) error {
+func (f *flight4TestMockFlightConn) notify(context.Context, alert.Level, alert.Description, error) error {
 	return nil
 }
 func (f *flight4TestMockFlightConn) writePackets(context.Context, []*packet) error { return nil }
@@ -31,6 +32,9 @@ func (f *flight4TestMockFlightConn) recvHandshake() <-chan chan struct{}
 func (f *flight4TestMockFlightConn) setLocalEpoch(uint16)                          {}
 func (f *flight4TestMockFlightConn) handleQueuedPackets(context.Context) error     { return nil }
 func (f *flight4TestMockFlightConn) sessionKey() []byte                            { return nil }
+func (f *flight4TestMockFlightConn) lowerMTU(int)                                  {}
+func (f *flight4TestMockFlightConn) MTU() int                                      { return 0 }
+func (f *flight4TestMockFlightConn) remoteAddr() net.Addr                          { return nil }
 
 type flight4TestMockCipherSuite struct {
 	ciphersuite.TLSEcdheEcdsaWithAes128GcmSha256
@@ -58,7 +62,7 @@ func TestFlight4_Process_CertificateVerify(t *testing.T) {
 
 	mockConn := &flight4TestMockFlightConn{}
 	state := &State{
-		cipherSuite: &flight4TestMockCipherSuite{t: t},
+		handshakeResult: handshakeResult{cipherSuite: &flight4TestMockCipherSuite{t: t}},
 	}
 	cache := newHandshakeCache()
 	cfg := &handshakeConfig{}
@@ -141,8 +145,8 @@ func TestFlight4_CertificateRequestHook(t *testing.T) {
 
 	mockConn := &flight4TestMockFlightConn{}
 	state := &State{
-		cipherSuite:  &flight4TestMockCipherSuite{t: t},
-		localKeypair: localKeypair,
+		handshakeResult: handshakeResult{cipherSuite: &flight4TestMockCipherSuite{t: t}},
+		localKeypair:    localKeypair,
 	}
 
 	cert, err := selfsign.GenerateSelfSignedWithDNS("localhost")
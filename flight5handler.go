@@ -4,10 +4,9 @@
 package dtls
 
 import (
-	"bytes"
 	"context"
 	"crypto"
-	"crypto/x509"
+	"time"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/signaturehash"
@@ -34,6 +33,7 @@ func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshak
 		handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 		handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -47,8 +47,8 @@ func flight5Parse(_ context.Context, c flightConn, state *State, cache *handshak
 	if err != nil {
 		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
-	if !bytes.Equal(expectedVerifyData, finished.VerifyData) {
-		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.HandshakeFailure}, errVerifyDataMismatch
+	if !verifyDataMatches(expectedVerifyData, finished.VerifyData) {
+		return 0, &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, errVerifyDataMismatch
 	}
 
 	if len(state.SessionID) > 0 {
@@ -105,10 +105,16 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			})
 	}
 
+	rsaKeyExchange := state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmRsa)
 	clientKeyExchange := &handshake.MessageClientKeyExchange{}
-	if cfg.localPSKCallback == nil {
+	switch {
+	case rsaKeyExchange:
+		clientKeyExchange.EncryptedPreMasterSecret = state.encryptedPreMasterSecret
+	case state.cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmDhe):
+		clientKeyExchange.DHPublicKey = state.localDHKeypair.PublicKey
+	case cfg.localPSKCallback == nil:
 		clientKeyExchange.PublicKey = state.localKeypair.PublicKey
-	} else {
+	default:
 		clientKeyExchange.IdentityHint = cfg.localPSKIdentityHint
 	}
 	if state != nil && state.localKeypair != nil && len(state.localKeypair.PublicKey) > 0 {
@@ -133,13 +139,17 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 
 	serverKeyExchange := &handshake.MessageServerKeyExchange{}
 
-	// handshakeMessageServerKeyExchange is optional for PSK
-	if len(serverKeyExchangeData) == 0 {
+	switch {
+	case rsaKeyExchange:
+		// The premaster secret of the static RSA key exchange was generated
+		// when the server's flight was parsed.
+	case len(serverKeyExchangeData) == 0:
+		// handshakeMessageServerKeyExchange is optional for PSK
 		alertPtr, err := handleServerKeyExchange(c, state, cfg, &handshake.MessageServerKeyExchange{})
 		if err != nil {
 			return nil, alertPtr, err
 		}
-	} else {
+	default:
 		rawHandshake := &handshake.Handshake{
 			KeyExchangeAlgorithm: state.cipherSuite.KeyExchangeAlgorithm(),
 		}
@@ -185,6 +195,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -199,7 +210,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, err
 		}
 
-		certVerify, err := generateCertificateVerify(plainText, privateKey, signatureHashAlgo.Hash)
+		certVerify, err := generateCertificateVerify(cfg.rand, plainText, privateKey, signatureHashAlgo.Hash)
 		if err != nil {
 			return nil, &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 		}
@@ -249,6 +260,7 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 			handshakeCachePullRule{handshake.TypeClientHello, cfg.initialEpoch, true, false},
 			handshakeCachePullRule{handshake.TypeServerHello, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificate, cfg.initialEpoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerKeyExchange, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeCertificateRequest, cfg.initialEpoch, false, false},
 			handshakeCachePullRule{handshake.TypeServerHelloDone, cfg.initialEpoch, false, false},
@@ -286,6 +298,36 @@ func flight5Generate(c flightConn, state *State, cache *handshakeCache, cfg *han
 	return pkts, nil, nil
 }
 
+// verifyServerKeyExchange checks the signature of the server over the key
+// exchange parameters of h with the key of its certificate.
+func verifyServerKeyExchange(state *State, cfg *handshakeConfig, h *handshake.MessageServerKeyExchange) (*alert.Alert, error) {
+	// Verify that the pair of hash algorithm and signiture is listed.
+	var validSignatureScheme bool
+	for _, ss := range cfg.localSignatureSchemes {
+		if ss.Hash == h.HashAlgorithm && ss.Signature == h.SignatureAlgorithm {
+			validSignatureScheme = true
+			break
+		}
+	}
+	if !validSignatureScheme {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoAvailableSignatureSchemes
+	}
+
+	clientRandom := state.localRandom.MarshalFixed()
+	serverRandom := state.remoteRandom.MarshalFixed()
+	expectedMsg := valueKeyMessage(clientRandom[:], serverRandom[:], h.PublicKey, h.NamedCurve)
+	if len(h.DHPrime) > 0 {
+		expectedMsg = dhValueKeyMessage(clientRandom[:], serverRandom[:], h.DHPrime, h.DHGenerator, h.DHPublicKey)
+	}
+	// The signed_params bind the key exchange parameters to the
+	// server's certificate, a mismatch means they were substituted.
+	if err := verifyKeySignature(expectedMsg, h.Signature, h.HashAlgorithm, state.PeerCertificates); err != nil {
+		return &alert.Alert{Level: alert.Fatal, Description: alert.DecryptError}, err
+	}
+	state.SignatureScheme = signaturehash.Algorithm{Hash: h.HashAlgorithm, Signature: h.SignatureAlgorithm}
+	return nil, nil //nolint:nilnil
+}
+
 func initializeCipherSuite(state *State, cache *handshakeCache, cfg *handshakeConfig, h *handshake.MessageServerKeyExchange, sendingPlainText []byte) (*alert.Alert, error) { //nolint:gocognit
 	if state.cipherSuite.IsInitialized() {
 		return nil, nil //nolint
@@ -315,27 +357,27 @@ func initializeCipherSuite(state *State, cache *handshakeCache, cfg *handshakeCo
 	}
 
 	if state.cipherSuite.AuthenticationType() == CipherSuiteAuthenticationTypeCertificate {
-		// Verify that the pair of hash algorithm and signiture is listed.
-		var validSignatureScheme bool
-		for _, ss := range cfg.localSignatureSchemes {
-			if ss.Hash == h.HashAlgorithm && ss.Signature == h.SignatureAlgorithm {
-				validSignatureScheme = true
-				break
+		// The static RSA key exchange has no signed ServerKeyExchange, the
+		// server proves possession of its key by decrypting the premaster
+		// secret.
+		// A DHE ServerKeyExchange was verified before its parameters were
+		// checked, when the server's flight was parsed.
+		keyExchange := state.cipherSuite.KeyExchangeAlgorithm()
+		if !keyExchange.Has(CipherSuiteKeyExchangeAlgorithmRsa) && !keyExchange.Has(CipherSuiteKeyExchangeAlgorithmDhe) {
+			if alertPtr, err := verifyServerKeyExchange(state, cfg, h); err != nil {
+				return alertPtr, err
 			}
 		}
-		if !validSignatureScheme {
-			return &alert.Alert{Level: alert.Fatal, Description: alert.InsufficientSecurity}, errNoAvailableSignatureSchemes
-		}
-
-		expectedMsg := valueKeyMessage(clientRandom[:], serverRandom[:], h.PublicKey, h.NamedCurve)
-		if err = verifyKeySignature(expectedMsg, h.Signature, h.HashAlgorithm, state.PeerCertificates); err != nil {
-			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
+		chains, verifyErr := verifyServerCert(state.PeerCertificates, cfg.rootCAs, cfg.serverName)
+		state.CertificateValidityStatus = certificateValidity(state.PeerCertificates, verifyErr, time.Now())
+		state.SNIMatchesCertificate = sniMatchesCertificate(state.PeerCertificates, cfg.serverName)
+		if cfg.insecureSkipVerify {
+			chains = nil
+		} else if verifyErr != nil {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, verifyErr
 		}
-		var chains [][]*x509.Certificate
-		if !cfg.insecureSkipVerify {
-			if chains, err = verifyServerCert(state.PeerCertificates, cfg.rootCAs, cfg.serverName); err != nil {
-				return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, err
-			}
+		if cfg.requireSNIMatch && !state.SNIMatchesCertificate {
+			return &alert.Alert{Level: alert.Fatal, Description: alert.BadCertificate}, errSNICertificateMismatch
 		}
 		if cfg.verifyPeerCertificate != nil {
 			if err = cfg.verifyPeerCertificate(state.PeerCertificates, chains); err != nil {
@@ -349,6 +391,9 @@ func initializeCipherSuite(state *State, cache *handshakeCache, cfg *handshakeCo
 		}
 	}
 
+	setCipherSuiteRand(state.cipherSuite, cfg.rand)
+	setCipherSuiteTruncatedMAC(state.cipherSuite, state.truncatedHMAC)
+	setCipherSuiteSequenceNonce(state.cipherSuite, cfg.sequenceNonce)
 	if err = state.cipherSuite.Init(state.masterSecret, clientRandom[:], serverRandom[:], true); err != nil {
 		return &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}, err
 	}
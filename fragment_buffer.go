@@ -23,6 +23,16 @@ type fragmentBuffer struct {
 	cache map[uint16][]*fragment
 
 	currentMessageSequenceNumber uint16
+
+	// epoch is the epoch of the first message of the current handshake. A
+	// rekey starts a handshake in a later epoch, whose message sequence
+	// numbers start over.
+	epoch uint16
+
+	// statelessCookie lets a server start the handshake at the ClientHello
+	// carrying a cookie, whose message sequence number is 1, when the
+	// HelloVerifyRequest was sent by another server.
+	statelessCookie bool
 }
 
 func newFragmentBuffer() *fragmentBuffer {
@@ -63,8 +73,16 @@ func (f *fragmentBuffer) push(buf []byte) (bool, error) {
 			return false, err
 		}
 
-		if _, ok := f.cache[frag.handshakeHeader.MessageSequence]; !ok {
-			f.cache[frag.handshakeHeader.MessageSequence] = []*fragment{}
+		epoch := frag.recordLayerHeader.Epoch
+		if frag.handshakeHeader.MessageSequence == 0 && epoch > f.epoch {
+			f.cache = map[uint16][]*fragment{}
+			f.currentMessageSequenceNumber = 0
+			f.epoch = epoch
+		}
+		if f.statelessCookie && epoch == 0 && f.currentMessageSequenceNumber == 0 &&
+			frag.handshakeHeader.Type == handshake.TypeClientHello && frag.handshakeHeader.MessageSequence == 1 {
+			delete(f.cache, 0)
+			f.currentMessageSequenceNumber = 1
 		}
 
 		// end index should be the length of handshake header but if the handshake
@@ -74,6 +92,18 @@ func (f *fragmentBuffer) push(buf []byte) (bool, error) {
 			end = size
 		}
 
+		// Retransmissions of the handshake before a rekey would take the
+		// place of its messages. They were sent in earlier epochs, except
+		// for the Finished message in the epoch the rekey started in.
+		if epoch < f.epoch || (f.epoch > 0 && epoch == f.epoch && frag.handshakeHeader.Type == handshake.TypeFinished) {
+			buf = buf[end:]
+			continue
+		}
+
+		if _, ok := f.cache[frag.handshakeHeader.MessageSequence]; !ok {
+			f.cache[frag.handshakeHeader.MessageSequence] = []*fragment{}
+		}
+
 		// Discard all headers, when rebuilding the packet we will re-build
 		frag.data = append([]byte{}, buf[handshake.HeaderLength:end]...)
 		f.cache[frag.handshakeHeader.MessageSequence] = append(f.cache[frag.handshakeHeader.MessageSequence], frag)
@@ -1,8 +1,11 @@
 module github.com/censys-oss/dtls/v2
 
 require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/cloudflare/circl v1.3.7
 	github.com/pion/dtls/v2 v2.2.11
 	github.com/pion/logging v0.2.2
+	github.com/pion/sctp v1.8.16
 	github.com/pion/transport/v3 v3.0.2
 	github.com/zmap/zcrypto v0.0.0-20230908155002-4ba27e9c97cc
 	golang.org/x/crypto v0.24.0
@@ -10,6 +13,7 @@ require (
 )
 
 require (
+	github.com/pion/randutil v0.1.0 // indirect
 	github.com/weppos/publicsuffix-go v0.30.2 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
@@ -4,6 +4,8 @@
 package dtls
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"sync"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
@@ -28,6 +30,12 @@ type handshakeCachePullRule struct {
 type handshakeCache struct {
 	cache []*handshakeCacheItem
 	mu    sync.Mutex
+
+	// verifyIntegrity records the digest of every cached message, in the
+	// same order as cache, so checkIntegrity can detect a message that was
+	// modified after it entered the transcript.
+	verifyIntegrity bool
+	digests         [][sha256.Size]byte
 }
 
 func newHandshakeCache() *handshakeCache {
@@ -38,6 +46,12 @@ func (h *handshakeCache) push(data []byte, epoch, messageSequence uint16, typ ha
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	// A CompressedCertificate takes the place of the Certificate in the
+	// transcript, so it is pulled by the same rules.
+	if typ == handshake.TypeCompressedCertificate {
+		typ = handshake.TypeCertificate
+	}
+
 	h.cache = append(h.cache, &handshakeCacheItem{
 		data:            append([]byte{}, data...),
 		epoch:           epoch,
@@ -45,6 +59,28 @@ func (h *handshakeCache) push(data []byte, epoch, messageSequence uint16, typ ha
 		typ:             typ,
 		isClient:        isClient,
 	})
+	if h.verifyIntegrity {
+		h.digests = append(h.digests, sha256.Sum256(data))
+	}
+}
+
+// checkIntegrity recomputes the digest of every cached message and returns
+// an error if any of them changed since it was pushed. It does nothing
+// unless verifyIntegrity is set.
+func (h *handshakeCache) checkIntegrity() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.verifyIntegrity {
+		return nil
+	}
+	for i, c := range h.cache {
+		digest := sha256.Sum256(c.data)
+		if subtle.ConstantTimeCompare(digest[:], h.digests[i][:]) != 1 {
+			return errTranscriptCorrupted
+		}
+	}
+	return nil
 }
 
 // returns a list handshakes that match the requested rules
@@ -128,6 +164,30 @@ func (h *handshakeCache) fullPullMap(startSeq int, cipherSuite CipherSuite, rule
 	return seq, out, true
 }
 
+// size returns the total length of the handshake messages in the cache.
+// Retransmitted messages are counted once.
+func (h *handshakeCache) size() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	type messageID struct {
+		isClient        bool
+		epoch           uint16
+		messageSequence uint16
+	}
+	seen := make(map[messageID]bool)
+	size := 0
+	for _, c := range h.cache {
+		id := messageID{c.isClient, c.epoch, c.messageSequence}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		size += len(c.data)
+	}
+	return size
+}
+
 // pullAndMerge calls pull and then merges the results, ignoring any null entries
 func (h *handshakeCache) pullAndMerge(rules ...handshakeCachePullRule) []byte {
 	merged := []byte{}
@@ -140,6 +200,41 @@ func (h *handshakeCache) pullAndMerge(rules ...handshakeCachePullRule) []byte {
 	return merged
 }
 
+// transcript returns the handshake messages of epoch exchanged so far, in the
+// order in which they are hashed for the Finished messages.
+func (h *handshakeCache) transcript(epoch uint16) []byte {
+	rules := []handshakeCachePullRule{
+		{handshake.TypeClientHello, epoch, true, false},
+		{handshake.TypeServerHello, epoch, false, false},
+	}
+
+	serverHelloDone := h.pull(handshakeCachePullRule{handshake.TypeServerHelloDone, epoch, false, false})[0]
+	serverFinished := h.pull(handshakeCachePullRule{handshake.TypeFinished, epoch + 1, false, false})[0]
+	if serverHelloDone == nil && serverFinished != nil {
+		// In an abbreviated handshake resuming a session the server
+		// finishes first.
+		rules = append(rules,
+			handshakeCachePullRule{handshake.TypeFinished, epoch + 1, false, false},
+			handshakeCachePullRule{handshake.TypeFinished, epoch + 1, true, false},
+		)
+	} else {
+		// Order defined by https://tools.ietf.org/html/rfc5246#section-7.3
+		rules = append(rules,
+			handshakeCachePullRule{handshake.TypeCertificate, epoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateStatus, epoch, false, false},
+			handshakeCachePullRule{handshake.TypeServerKeyExchange, epoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificateRequest, epoch, false, false},
+			handshakeCachePullRule{handshake.TypeServerHelloDone, epoch, false, false},
+			handshakeCachePullRule{handshake.TypeCertificate, epoch, true, false},
+			handshakeCachePullRule{handshake.TypeClientKeyExchange, epoch, true, false},
+			handshakeCachePullRule{handshake.TypeCertificateVerify, epoch, true, false},
+			handshakeCachePullRule{handshake.TypeFinished, epoch + 1, true, false},
+			handshakeCachePullRule{handshake.TypeFinished, epoch + 1, false, false},
+		)
+	}
+	return h.pullAndMerge(rules...)
+}
+
 // sessionHash returns the session hash for Extended Master Secret support
 // https://tools.ietf.org/html/draft-ietf-tls-session-hash-06#section-4
 func (h *handshakeCache) sessionHash(hf prf.HashFunc, epoch uint16, additional ...[]byte) ([]byte, error) {
@@ -150,6 +245,7 @@ func (h *handshakeCache) sessionHash(hf prf.HashFunc, epoch uint16, additional .
 		handshakeCachePullRule{handshake.TypeClientHello, epoch, true, false},
 		handshakeCachePullRule{handshake.TypeServerHello, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificate, epoch, false, false},
+		handshakeCachePullRule{handshake.TypeCertificateStatus, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerKeyExchange, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeCertificateRequest, epoch, false, false},
 		handshakeCachePullRule{handshake.TypeServerHelloDone, epoch, false, false},
@@ -5,6 +5,7 @@ package dtls
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite"
@@ -211,3 +212,83 @@ func TestHandshakeCacheSessionHash(t *testing.T) {
 		}
 	}
 }
+
+func TestHandshakeCacheSize(t *testing.T) {
+	h := newHandshakeCache()
+	h.push([]byte{0x00, 0x01}, 0, 0, handshake.TypeClientHello, true)
+	h.push([]byte{0x00, 0x01, 0x02}, 0, 0, handshake.TypeServerHello, false)
+	// Retransmission of the ClientHello
+	h.push([]byte{0x00, 0x01}, 0, 0, handshake.TypeClientHello, true)
+	h.push([]byte{0x00, 0x01, 0x02, 0x03}, 0, 1, handshake.TypeClientHello, true)
+
+	if size := h.size(); size != 9 {
+		t.Errorf("Expected size 9, got %d", size)
+	}
+}
+
+func TestHandshakeCacheTranscript(t *testing.T) {
+	for name, tt := range map[string]struct {
+		push     func(h *handshakeCache)
+		expected []byte
+	}{
+		"Full": {
+			push: func(h *handshakeCache) {
+				h.push([]byte{0x01}, 0, 0, handshake.TypeClientHello, true)
+				h.push([]byte{0x02}, 0, 0, handshake.TypeServerHello, false)
+				h.push([]byte{0x03}, 0, 1, handshake.TypeServerHelloDone, false)
+				h.push([]byte{0x04}, 0, 1, handshake.TypeClientKeyExchange, true)
+				h.push([]byte{0x06}, 1, 2, handshake.TypeFinished, false)
+				h.push([]byte{0x05}, 1, 2, handshake.TypeFinished, true)
+			},
+			expected: []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06},
+		},
+		"Resumed": {
+			push: func(h *handshakeCache) {
+				h.push([]byte{0x01}, 0, 0, handshake.TypeClientHello, true)
+				h.push([]byte{0x02}, 0, 0, handshake.TypeServerHello, false)
+				h.push([]byte{0x04}, 1, 1, handshake.TypeFinished, true)
+				h.push([]byte{0x03}, 1, 1, handshake.TypeFinished, false)
+			},
+			expected: []byte{0x01, 0x02, 0x03, 0x04},
+		},
+		"InProgress": {
+			push: func(h *handshakeCache) {
+				h.push([]byte{0x01}, 0, 0, handshake.TypeClientHello, true)
+				h.push([]byte{0x02}, 0, 0, handshake.TypeServerHello, false)
+			},
+			expected: []byte{0x01, 0x02},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			h := newHandshakeCache()
+			tt.push(h)
+			if transcript := h.transcript(0); !bytes.Equal(transcript, tt.expected) {
+				t.Errorf("Expected transcript %v, got %v", tt.expected, transcript)
+			}
+		})
+	}
+}
+
+func TestHandshakeCacheIntegrity(t *testing.T) {
+	h := newHandshakeCache()
+	h.verifyIntegrity = true
+	h.push([]byte{0x00, 0x01}, 0, 0, handshake.TypeClientHello, true)
+	h.push([]byte{0x00, 0x01, 0x02}, 0, 0, handshake.TypeServerHello, false)
+	if err := h.checkIntegrity(); err != nil {
+		t.Fatal(err)
+	}
+
+	h.pull(handshakeCachePullRule{handshake.TypeServerHello, 0, false, false})[0].data[1] ^= 0xff
+	if err := h.checkIntegrity(); !errors.Is(err, errTranscriptCorrupted) {
+		t.Errorf("Expected error %v, got %v", errTranscriptCorrupted, err)
+	}
+
+	// Without verifyIntegrity nothing is checked.
+	h = newHandshakeCache()
+	h.push([]byte{0x00, 0x01}, 0, 0, handshake.TypeClientHello, true)
+	h.pull(handshakeCachePullRule{handshake.TypeClientHello, 0, true, false})[0].data[0] ^= 0xff
+	if err := h.checkIntegrity(); err != nil {
+		t.Errorf("Expected no error with verification disabled, got %v", err)
+	}
+}
@@ -4,18 +4,24 @@
 package dtls
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"sync"
 	"time"
 
 	"github.com/pion/logging"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
 )
 
@@ -81,6 +87,18 @@ func (s handshakeState) String() string {
 	}
 }
 
+// HandshakeStep is a state the handshake state machine entered, as reported
+// to Config.OnHandshakeStep.
+type HandshakeStep struct {
+	// Flight is the flight the handshake is working on, "Flight 0" to
+	// "Flight 6" after RFC 6347 Section 4.2.4, or "Flight 4b" and
+	// "Flight 5b" in a resumed handshake.
+	Flight string
+
+	// State is "Preparing", "Sending", "Waiting", "Finished" or "Errored".
+	State string
+}
+
 type handshakeFSM struct {
 	currentFlight flightVal
 	flights       []*packet
@@ -89,6 +107,12 @@ type handshakeFSM struct {
 	cache         *handshakeCache
 	cfg           *handshakeConfig
 	closed        chan struct{}
+
+	// retransmitInterval is the current retransmission interval of the
+	// flight, doubled on every retransmission up to maxRetransmitInterval.
+	retransmitInterval time.Duration
+	// retransmissions counts the retransmissions of the current flight.
+	retransmissions int
 }
 
 type handshakeConfig struct {
@@ -99,6 +123,7 @@ type handshakeConfig struct {
 	extendedMasterSecret        ExtendedMasterSecretType  // Policy for the Extended Master Support extension
 	localSRTPProtectionProfiles []SRTPProtectionProfile   // Available SRTPProtectionProfiles, if empty no SRTP support
 	serverName                  string
+	requireSNIMatch             bool
 	supportedProtocols          []string
 	clientAuth                  ClientAuthType // If we are a client should we request a client certificate
 	localCertificates           []tls.Certificate
@@ -108,45 +133,214 @@ type handshakeConfig struct {
 	verifyConnection            func(*State) error
 	sessionStore                SessionStore
 	rootCAs                     *x509.CertPool
+	trustedCAKeys               []extension.TrustedAuthority
 	clientCAs                   *x509.CertPool
 	retransmitInterval          time.Duration
+	maxRetransmitInterval       time.Duration
+	maxRetransmissions          int
+	retransmitMTU               int
+	minMTU                      int
 	customCipherSuites          func() []CipherSuite
 	ellipticCurves              []elliptic.Curve
+	dhGroups                    []ffdhe.Group
+	minDHPrimeBits              int
 	insecureSkipHelloVerify     bool
+	shouldVerifyHello           func(net.Addr) bool
+	cookieGenerator             CookieGenerator
 	connectionIDGenerator       func() []byte
 	helloRandomBytesGenerator   func() [handshake.RandomBytesLength]byte
+	rand                        io.Reader
+	heartbeat                   bool
+	requestOCSPStaple           bool
+	requestSCTs                 bool
+	certificateCompression      []CertificateCompressionAlgorithm
+	truncatedHMAC               bool
+	requireRenegotiationInfo    bool
+	sequenceNonce               bool
+	preferServerCipherSuites    bool
+	requiredCurve               elliptic.Curve
+	supportedVersions           []protocol.Version
 
-	onFlightState func(flightVal, handshakeState)
-	log           logging.LeveledLogger
-	keyLogWriter  io.Writer
+	onFlightState   func(flightVal, handshakeState)
+	onHandshakeStep func(HandshakeStep)
+	onRetransmit    func()
+	log             logging.LeveledLogger
+	keyLogWriter    io.Writer
 
 	localGetCertificate       func(*ClientHelloInfo) (*tls.Certificate, error)
 	localGetClientCertificate func(*CertificateRequestInfo) (*tls.Certificate, error)
+	localGetPSKIdentityHint   func(*ClientHelloInfo) ([]byte, error)
+	localGenerateKeypair      func(elliptic.Curve) (*elliptic.Keypair, error)
 
 	initialEpoch uint16
 
-	mu sync.Mutex
+	// clientVerifyData and serverVerifyData are the verify_data of the
+	// Finished messages of the handshake that a rekey renegotiates, which
+	// binds the rekey to the connection [RFC5746 Section 3.5]. They are empty
+	// for the initial handshake.
+	clientVerifyData, serverVerifyData []byte
+
+	// restrict, if not nil, limits what the handshake may negotiate. It is
+	// applied again when useConfig replaces the configuration.
+	restrict func(*handshakeConfig)
 
 	clientHelloMessageHook        func(handshake.MessageClientHello) handshake.Message
 	serverHelloMessageHook        func(handshake.MessageServerHello) handshake.Message
 	certificateRequestMessageHook func(handshake.MessageCertificateRequest) handshake.Message
+
+	getConfigForClient func(*ClientHelloInfo) (*Config, error)
 }
 
 type flightConn interface {
-	notify(ctx context.Context, level alert.Level, desc alert.Description) error
+	notify(ctx context.Context, level alert.Level, desc alert.Description, reason error) error
 	writePackets(context.Context, []*packet) error
 	recvHandshake() <-chan chan struct{}
 	setLocalEpoch(epoch uint16)
 	handleQueuedPackets(context.Context) error
 	sessionKey() []byte
+	lowerMTU(mtu int)
+	MTU() int
+	remoteAddr() net.Addr
+}
+
+// useConfig replaces the configuration of a server with other, built from
+// the Config returned by GetConfigForClient. Transport settings such as
+// retransmission timers, the cookie exchange, which is already over, and
+// what is tied to the running handshake are kept, and the restrictions of a
+// rekey apply to other as well.
+func (c *handshakeConfig) useConfig(other *handshakeConfig) {
+	other.retransmitInterval = c.retransmitInterval
+	other.maxRetransmitInterval = c.maxRetransmitInterval
+	other.maxRetransmissions = c.maxRetransmissions
+	other.retransmitMTU = c.retransmitMTU
+	other.minMTU = c.minMTU
+	other.insecureSkipHelloVerify = c.insecureSkipHelloVerify
+	other.shouldVerifyHello = c.shouldVerifyHello
+	other.cookieGenerator = c.cookieGenerator
+	other.getConfigForClient = c.getConfigForClient
+	other.onFlightState = c.onFlightState
+	other.onHandshakeStep = c.onHandshakeStep
+	other.onRetransmit = c.onRetransmit
+	other.log = c.log
+	other.initialEpoch = c.initialEpoch
+	other.clientVerifyData = c.clientVerifyData
+	other.serverVerifyData = c.serverVerifyData
+	other.restrict = c.restrict
+	if other.restrict != nil {
+		other.restrict(other)
+	}
+	*c = *other
+}
+
+// verifyHello reports whether a server sends a HelloVerifyRequest to the
+// client at addr.
+func (c *handshakeConfig) verifyHello(addr net.Addr) bool {
+	if c.insecureSkipHelloVerify {
+		return false
+	}
+	if c.shouldVerifyHello != nil {
+		return c.shouldVerifyHello(addr)
+	}
+	return true
+}
+
+// generateKeypair returns the local ECDHE keypair for curve, which is shared
+// with other handshakes if ECDHE key reuse is configured.
+func (c *handshakeConfig) generateKeypair(curve elliptic.Curve) (*elliptic.Keypair, error) {
+	if c.localGenerateKeypair != nil {
+		return c.localGenerateKeypair(curve)
+	}
+	return elliptic.GenerateKeypairFrom(c.rand, curve)
+}
+
+// supportsCurve reports whether curve is both configured and implemented.
+func (c *handshakeConfig) supportsCurve(curve elliptic.Curve) bool {
+	if !elliptic.Curves()[curve] {
+		return false
+	}
+	for _, cur := range c.ellipticCurves {
+		if cur == curve {
+			return true
+		}
+	}
+	return false
+}
+
+// offersDHE reports whether any of the local cipher suites uses the finite
+// field Diffie-Hellman key exchange.
+func (c *handshakeConfig) offersDHE() bool {
+	for _, cipherSuite := range c.localCipherSuites {
+		if cipherSuite.KeyExchangeAlgorithm().Has(CipherSuiteKeyExchangeAlgorithmDhe) {
+			return true
+		}
+	}
+	return false
+}
+
+// supportedGroups returns the groups to advertise in the supported_groups
+// extension: the elliptic curves if ecc is set, followed by the finite field
+// groups if a DHE cipher suite is offered.
+func (c *handshakeConfig) supportedGroups(ecc bool) []elliptic.Curve {
+	groups := []elliptic.Curve{}
+	if ecc {
+		groups = append(groups, c.ellipticCurves...)
+	}
+	if c.offersDHE() {
+		for _, group := range c.dhGroups {
+			groups = append(groups, elliptic.Curve(group))
+		}
+	}
+	return groups
+}
+
+// supportsDHGroup reports whether group is both configured and implemented.
+func (c *handshakeConfig) supportsDHGroup(group ffdhe.Group) bool {
+	if !ffdhe.Groups()[group] {
+		return false
+	}
+	for _, g := range c.dhGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// selectCertificateCompression returns the first locally configured
+// certificate compression algorithm that is in offered, or 0 if there is
+// none.
+func (c *handshakeConfig) selectCertificateCompression(offered []CertificateCompressionAlgorithm) CertificateCompressionAlgorithm {
+	for _, local := range c.certificateCompression {
+		for _, a := range offered {
+			if a == local {
+				return local
+			}
+		}
+	}
+	return 0
 }
 
+// renegotiatedConnection returns the renegotiated_connection of the
+// renegotiation_info extension sent by the client if fromClient is set, or
+// by the server otherwise [RFC5746 Section 3.2].
+func (c *handshakeConfig) renegotiatedConnection(fromClient bool) []byte {
+	out := append([]byte{}, c.clientVerifyData...)
+	if !fromClient {
+		out = append(out, c.serverVerifyData...)
+	}
+	return out
+}
+
+// keyLogMu serializes the writes to key log writers, which may be shared
+// between connections.
+var keyLogMu sync.Mutex //nolint:gochecknoglobals
+
 func (c *handshakeConfig) writeKeyLog(label string, clientRandom, secret []byte) {
 	if c.keyLogWriter == nil {
 		return
 	}
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	keyLogMu.Lock()
+	defer keyLogMu.Unlock()
 	_, err := c.keyLogWriter.Write([]byte(fmt.Sprintf("%s %x %x\n", label, clientRandom, secret)))
 	if err != nil {
 		c.log.Debugf("failed to write key log file: %s", err)
@@ -180,6 +374,11 @@ func (s *handshakeFSM) Run(ctx context.Context, c flightConn, initialState hands
 	}()
 	for {
 		s.cfg.log.Tracef("[handshake:%s] %s: %s", srvCliStr(s.state.isClient), s.currentFlight.String(), state.String())
+		// Report the step before onFlightState so that the Finished step
+		// is observed before the handshake returns.
+		if s.cfg.onHandshakeStep != nil {
+			s.cfg.onHandshakeStep(HandshakeStep{Flight: s.currentFlight.String(), State: state.String()})
+		}
 		if s.cfg.onFlightState != nil {
 			s.cfg.onFlightState(s.currentFlight, state)
 		}
@@ -208,6 +407,8 @@ func (s *handshakeFSM) Done() <-chan struct{} {
 
 func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeState, error) {
 	s.flights = nil
+	s.retransmitInterval = s.cfg.retransmitInterval
+	s.retransmissions = 0
 	// Prepare flights
 	var (
 		a    *alert.Alert
@@ -218,12 +419,14 @@ func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeStat
 	if errFlight != nil {
 		err = errFlight
 		a = &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}
+	} else if err = s.cache.checkIntegrity(); err != nil {
+		a = &alert.Alert{Level: alert.Fatal, Description: alert.InternalError}
 	} else {
 		pkts, a, err = gen(c, s.state, s.cache, s.cfg)
 		s.retransmit = retransmit
 	}
 	if a != nil {
-		if alertErr := c.notify(ctx, a.Level, a.Description); alertErr != nil {
+		if alertErr := c.notify(ctx, a.Level, a.Description, err); alertErr != nil {
 			if err != nil {
 				err = alertErr
 			}
@@ -238,6 +441,10 @@ func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeStat
 	nextEpoch := epoch
 	for _, p := range s.flights {
 		p.record.Header.Epoch += epoch
+		if epoch > 0 {
+			// A rekey is protected by the keys of the epoch it runs in.
+			p.shouldEncrypt = true
+		}
 		if p.record.Header.Epoch > nextEpoch {
 			nextEpoch = p.record.Header.Epoch
 		}
@@ -256,7 +463,12 @@ func (s *handshakeFSM) prepare(ctx context.Context, c flightConn) (handshakeStat
 func (s *handshakeFSM) send(ctx context.Context, c flightConn) (handshakeState, error) {
 	// Send flights
 	if err := c.writePackets(ctx, s.flights); err != nil {
-		return handshakeErrored, err
+		if !errors.Is(err, ErrMessageTooLong) {
+			return handshakeErrored, err
+		}
+		// The MTU has been lowered, the flight is retransmitted in smaller
+		// fragments as if it were lost.
+		s.cfg.log.Debugf("flight exceeds the path MTU: %v", err)
 	}
 
 	if s.currentFlight.isLastSendFlight() {
@@ -268,20 +480,27 @@ func (s *handshakeFSM) send(ctx context.Context, c flightConn) (handshakeState,
 func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState, error) { //nolint:gocognit
 	parse, errFlight := s.currentFlight.getFlightParser()
 	if errFlight != nil {
-		if alertErr := c.notify(ctx, alert.Fatal, alert.InternalError); alertErr != nil {
+		if alertErr := c.notify(ctx, alert.Fatal, alert.InternalError, errFlight); alertErr != nil {
 			return handshakeErrored, alertErr
 		}
 		return handshakeErrored, errFlight
 	}
 
-	retransmitTimer := time.NewTimer(s.cfg.retransmitInterval)
+	retransmitTimer := time.NewTimer(s.retransmitInterval)
 	for {
 		select {
 		case done := <-c.recvHandshake():
+			if err := s.cache.checkIntegrity(); err != nil {
+				close(done)
+				if alertErr := c.notify(ctx, alert.Fatal, alert.InternalError, err); alertErr != nil {
+					return handshakeErrored, alertErr
+				}
+				return handshakeErrored, err
+			}
 			nextFlight, alert, err := parse(ctx, c, s.state, s.cache, s.cfg)
 			close(done)
 			if alert != nil {
-				if alertErr := c.notify(ctx, alert.Level, alert.Description); alertErr != nil {
+				if alertErr := c.notify(ctx, alert.Level, alert.Description, err); alertErr != nil {
 					if err != nil {
 						err = alertErr
 					}
@@ -304,6 +523,18 @@ func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState,
 			if !s.retransmit {
 				return handshakeWaiting, nil
 			}
+			s.retransmissions++
+			if s.cfg.maxRetransmissions > 0 && s.retransmissions > s.cfg.maxRetransmissions {
+				return handshakeErrored, errMaxRetransmitsExceeded
+			}
+			s.backoffRetransmitInterval()
+			c.lowerMTU(s.cfg.retransmitMTU)
+			if s.cfg.minMTU > 0 && s.retransmissions > 1 {
+				c.lowerMTU(nextPathMTU(c.MTU(), s.cfg.minMTU))
+			}
+			if s.cfg.onRetransmit != nil {
+				s.cfg.onRetransmit()
+			}
 			return handshakeSending, nil
 		case <-ctx.Done():
 			return handshakeErrored, ctx.Err()
@@ -311,10 +542,64 @@ func (s *handshakeFSM) wait(ctx context.Context, c flightConn) (handshakeState,
 	}
 }
 
+// incompleteServerFlight returns the mandatory messages of the server's
+// flight 4 that a client is still waiting for after receiving its
+// ServerHello. It returns nil if the flight has not started, is complete, or
+// the server is resuming a session.
+func (s *handshakeFSM) incompleteServerFlight() []handshake.Type {
+	if !s.state.isClient || (s.currentFlight != flight1 && s.currentFlight != flight3) {
+		return nil
+	}
+
+	rules := []handshakeCachePullRule{
+		{handshake.TypeServerHello, s.cfg.initialEpoch, false, false},
+		{handshake.TypeServerKeyExchange, s.cfg.initialEpoch, false, false},
+		{handshake.TypeServerHelloDone, s.cfg.initialEpoch, false, false},
+	}
+	if s.cfg.localPSKCallback != nil {
+		// The ServerKeyExchange is optional for PSK cipher suites.
+		rules = append(rules[:1], rules[2])
+	}
+	items := s.cache.pull(rules...)
+	if items[0] == nil {
+		return nil
+	}
+	serverHello := &handshake.Handshake{}
+	if err := serverHello.Unmarshal(items[0].data); err != nil {
+		return nil
+	}
+	if h, ok := serverHello.Message.(*handshake.MessageServerHello); ok &&
+		len(h.SessionID) > 0 && bytes.Equal(h.SessionID, s.state.SessionID) {
+		return nil
+	}
+
+	var missing []handshake.Type
+	for i, item := range items[1:] {
+		if item == nil {
+			missing = append(missing, rules[i+1].typ)
+		}
+	}
+	return missing
+}
+
+// backoffRetransmitInterval doubles the retransmission interval up to the
+// configured maximum [RFC6347 Section 4.2.4.1]. A maximum below the initial
+// interval disables the backoff.
+func (s *handshakeFSM) backoffRetransmitInterval() {
+	maxInterval := s.cfg.maxRetransmitInterval
+	if maxInterval < s.cfg.retransmitInterval {
+		maxInterval = s.cfg.retransmitInterval
+	}
+	s.retransmitInterval *= 2
+	if s.retransmitInterval > maxInterval {
+		s.retransmitInterval = maxInterval
+	}
+}
+
 func (s *handshakeFSM) finish(ctx context.Context, c flightConn) (handshakeState, error) {
 	parse, errFlight := s.currentFlight.getFlightParser()
 	if errFlight != nil {
-		if alertErr := c.notify(ctx, alert.Fatal, alert.InternalError); alertErr != nil {
+		if alertErr := c.notify(ctx, alert.Fatal, alert.InternalError, errFlight); alertErr != nil {
 			return handshakeErrored, alertErr
 		}
 		return handshakeErrored, errFlight
@@ -326,7 +611,7 @@ func (s *handshakeFSM) finish(ctx context.Context, c flightConn) (handshakeState
 		nextFlight, alert, err := parse(ctx, c, s.state, s.cache, s.cfg)
 		close(done)
 		if alert != nil {
-			if alertErr := c.notify(ctx, alert.Level, alert.Description); alertErr != nil {
+			if alertErr := c.notify(ctx, alert.Level, alert.Description, err); alertErr != nil {
 				if err != nil {
 					err = alertErr
 				}
@@ -341,8 +626,15 @@ func (s *handshakeFSM) finish(ctx context.Context, c flightConn) (handshakeState
 		if nextFlight.isLastRecvFlight() && s.currentFlight == nextFlight {
 			return handshakeFinished, nil
 		}
-		<-retransmitTimer.C
+		select {
+		case <-retransmitTimer.C:
+		case <-ctx.Done():
+			return handshakeErrored, ctx.Err()
+		}
 		// Retransmit last flight
+		if s.cfg.onRetransmit != nil {
+			s.cfg.onRetransmit()
+		}
 		return handshakeSending, nil
 
 	case <-ctx.Done():
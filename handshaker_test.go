@@ -6,8 +6,10 @@ package dtls
 import (
 	"bytes"
 	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"errors"
+	"net"
 	"sync"
 	"testing"
 	"time"
@@ -270,6 +272,7 @@ func TestHandshaker(t *testing.T) {
 					ellipticCurves:        defaultCurves,
 					localSignatureSchemes: signaturehash.Algorithms(),
 					insecureSkipVerify:    true,
+					rand:                  rand.Reader,
 					log:                   logger,
 					onFlightState: func(_ flightVal, s handshakeState) {
 						if s == handshakeFinished {
@@ -303,6 +306,7 @@ func TestHandshaker(t *testing.T) {
 					ellipticCurves:        defaultCurves,
 					localSignatureSchemes: signaturehash.Algorithms(),
 					insecureSkipVerify:    true,
+					rand:                  rand.Reader,
 					log:                   logger,
 					onFlightState: func(_ flightVal, s handshakeState) {
 						if s == handshakeFinished {
@@ -337,6 +341,95 @@ func TestHandshaker(t *testing.T) {
 	}
 }
 
+func TestHandshakeFSMRetransmitBackoff(t *testing.T) {
+	for name, tt := range map[string]struct {
+		maxRetransmitInterval time.Duration
+		expected              []time.Duration
+	}{
+		"Capped": {
+			maxRetransmitInterval: 350 * time.Millisecond,
+			expected:              []time.Duration{200 * time.Millisecond, 350 * time.Millisecond, 350 * time.Millisecond},
+		},
+		"Disabled": {
+			maxRetransmitInterval: nonZeroRetransmitInterval,
+			expected:              []time.Duration{nonZeroRetransmitInterval, nonZeroRetransmitInterval},
+		},
+		"Unset": {
+			expected: []time.Duration{nonZeroRetransmitInterval, nonZeroRetransmitInterval},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			fsm := newHandshakeFSM(&State{}, newHandshakeCache(), &handshakeConfig{
+				retransmitInterval:    nonZeroRetransmitInterval,
+				maxRetransmitInterval: tt.maxRetransmitInterval,
+				rand:                  rand.Reader,
+			}, flight1)
+			fsm.retransmitInterval = fsm.cfg.retransmitInterval
+
+			for i, expected := range tt.expected {
+				fsm.backoffRetransmitInterval()
+				if fsm.retransmitInterval != expected {
+					t.Errorf("Retransmission %d: expected interval %v, got %v", i+1, expected, fsm.retransmitInterval)
+				}
+			}
+		})
+	}
+}
+
+func TestHandshakeFSMMaxRetransmissions(t *testing.T) {
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	const maxRetransmissions = 3
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cipherSuites, err := parseCipherSuites(nil, nil, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop every packet sent by the client to simulate total loss.
+	cntClientHello := 0
+	ca, _ := flightTestPipe(ctx, TestEndpoint{
+		Filter: func(p *packet) bool {
+			if h, ok := p.record.Content.(*handshake.Handshake); ok {
+				if _, ok := h.Message.(*handshake.MessageClientHello); ok {
+					cntClientHello++
+				}
+			}
+			return false
+		},
+	}, TestEndpoint{})
+	ca.state.isClient = true
+
+	cfg := &handshakeConfig{
+		localCipherSuites:     cipherSuites,
+		ellipticCurves:        defaultCurves,
+		localSignatureSchemes: signaturehash.Algorithms(),
+		insecureSkipVerify:    true,
+		rand:                  rand.Reader,
+		log:                   logging.NewDefaultLoggerFactory().NewLogger("dtls"),
+		retransmitInterval:    10 * time.Millisecond,
+		maxRetransmissions:    maxRetransmissions,
+	}
+
+	fsm := newHandshakeFSM(&ca.state, ca.handshakeCache, cfg, flight1)
+	if err := fsm.Run(ctx, ca, handshakePreparing); !errors.Is(err, errMaxRetransmitsExceeded) {
+		t.Fatalf("Expected error %v, got %v", errMaxRetransmitsExceeded, err)
+	}
+
+	// The initial flight plus the allowed retransmissions must have been sent.
+	if cntClientHello != maxRetransmissions+1 {
+		t.Errorf("Expected ClientHello to be sent %d times, got %d", maxRetransmissions+1, cntClientHello)
+	}
+
+	cancel()
+}
+
 type packetFilter func(p *packet) bool
 
 type TestEndpoint struct {
@@ -352,22 +445,22 @@ func flightTestPipe(ctx context.Context, clientEndpoint TestEndpoint, serverEndp
 	chA := make(chan chan struct{})
 	chB := make(chan chan struct{})
 	return &flightTestConn{
-			handshakeCache: ca,
-			otherEndCache:  cb,
-			recv:           chA,
-			otherEndRecv:   chB,
-			done:           ctx.Done(),
-			filter:         clientEndpoint.Filter,
-			delay:          clientEndpoint.Delay,
-		}, &flightTestConn{
-			handshakeCache: cb,
-			otherEndCache:  ca,
-			recv:           chB,
-			otherEndRecv:   chA,
-			done:           ctx.Done(),
-			filter:         serverEndpoint.Filter,
-			delay:          serverEndpoint.Delay,
-		}
+		handshakeCache: ca,
+		otherEndCache:  cb,
+		recv:           chA,
+		otherEndRecv:   chB,
+		done:           ctx.Done(),
+		filter:         clientEndpoint.Filter,
+		delay:          clientEndpoint.Delay,
+	}, &flightTestConn{
+		handshakeCache: cb,
+		otherEndCache:  ca,
+		recv:           chB,
+		otherEndRecv:   chA,
+		done:           ctx.Done(),
+		filter:         serverEndpoint.Filter,
+		delay:          serverEndpoint.Delay,
+	}
 }
 
 type flightTestConn struct {
@@ -393,7 +486,7 @@ func (c *flightTestConn) setLocalEpoch(epoch uint16) {
 	c.epoch = epoch
 }
 
-func (c *flightTestConn) notify(context.Context, alert.Level, alert.Description) error {
+func (c *flightTestConn) notify(context.Context, alert.Level, alert.Description, error) error {
 	return nil
 }
 
@@ -442,6 +535,12 @@ func (c *flightTestConn) handleQueuedPackets(context.Context) error {
 	return nil
 }
 
+func (c *flightTestConn) lowerMTU(int) {}
+
+func (c *flightTestConn) MTU() int { return 0 }
+
+func (c *flightTestConn) remoteAddr() net.Addr { return nil }
+
 func (c *flightTestConn) sessionKey() []byte {
 	return nil
 }
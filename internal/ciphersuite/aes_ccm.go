@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
 	"sync/atomic"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
@@ -24,6 +25,7 @@ type AesCcm struct {
 	keyExchangeAlgorithm  KeyExchangeAlgorithm
 	cryptoCCMTagLen       ciphersuite.CCMTagLen
 	ecc                   bool
+	rand                  io.Reader
 }
 
 // CertificateType returns what type of certificate this CipherSuite exchanges
@@ -69,6 +71,12 @@ func (c *AesCcm) IsInitialized() bool {
 	return c.ccm.Load() != nil
 }
 
+// SetRand sets the source of the explicit nonces, crypto/rand by default. It
+// takes effect on the next call to Init.
+func (c *AesCcm) SetRand(r io.Reader) {
+	c.rand = r
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *AesCcm) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool, prfKeyLen int) error {
 	const (
@@ -87,6 +95,9 @@ func (c *AesCcm) Init(masterSecret, clientRandom, serverRandom []byte, isClient
 	} else {
 		ccm, err = ciphersuite.NewCCM(c.cryptoCCMTagLen, keys.ServerWriteKey, keys.ServerWriteIV, keys.ClientWriteKey, keys.ClientWriteIV)
 	}
+	if err == nil && c.rand != nil {
+		ccm.SetRand(c.rand)
+	}
 	c.ccm.Store(ccm)
 
 	return err
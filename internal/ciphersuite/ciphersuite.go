@@ -48,6 +48,12 @@ func (i ID) String() string {
 		return "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"
 	case TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
 		return "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256"
+	case TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256:
+		return "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256"
+	case TLS_RSA_WITH_AES_128_GCM_SHA256:
+		return "TLS_RSA_WITH_AES_128_GCM_SHA256"
+	case TLS_DHE_RSA_WITH_AES_128_GCM_SHA256:
+		return "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256"
 	default:
 		return fmt.Sprintf("unknown(%v)", uint16(i))
 	}
@@ -76,6 +82,13 @@ const (
 	TLS_PSK_WITH_AES_128_CBC_SHA256 ID = 0x00ae //nolint:revive,stylecheck
 
 	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 ID = 0xC037 //nolint:revive,stylecheck
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 ID = 0xD001 //nolint:revive,stylecheck
+
+	// Static RSA key exchange, without forward secrecy
+	TLS_RSA_WITH_AES_128_GCM_SHA256 ID = 0x009c //nolint:revive,stylecheck
+
+	// Ephemeral finite field Diffie-Hellman key exchange
+	TLS_DHE_RSA_WITH_AES_128_GCM_SHA256 ID = 0x009e //nolint:revive,stylecheck
 )
 
 // AuthenticationType controls what authentication method is using during the handshake
@@ -96,4 +109,6 @@ const (
 	KeyExchangeAlgorithmNone  KeyExchangeAlgorithm = types.KeyExchangeAlgorithmNone
 	KeyExchangeAlgorithmPsk   KeyExchangeAlgorithm = types.KeyExchangeAlgorithmPsk
 	KeyExchangeAlgorithmEcdhe KeyExchangeAlgorithm = types.KeyExchangeAlgorithmEcdhe
+	KeyExchangeAlgorithmRsa   KeyExchangeAlgorithm = types.KeyExchangeAlgorithmRsa
+	KeyExchangeAlgorithmDhe   KeyExchangeAlgorithm = types.KeyExchangeAlgorithmDhe
 )
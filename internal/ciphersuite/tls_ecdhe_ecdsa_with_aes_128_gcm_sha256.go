@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
 	"sync/atomic"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
@@ -17,7 +18,9 @@ import (
 
 // TLSEcdheEcdsaWithAes128GcmSha256  represents a TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 CipherSuite
 type TLSEcdheEcdsaWithAes128GcmSha256 struct {
-	gcm atomic.Value // *cryptoGCM
+	gcm           atomic.Value // *cryptoGCM
+	rand          io.Reader
+	sequenceNonce bool
 }
 
 // CertificateType returns what type of certficate this CipherSuite exchanges
@@ -60,6 +63,25 @@ func (c *TLSEcdheEcdsaWithAes128GcmSha256) IsInitialized() bool {
 	return c.gcm.Load() != nil
 }
 
+// SetRand sets the source of the explicit nonces, crypto/rand by default. It
+// takes effect on the next call to Init.
+func (c *TLSEcdheEcdsaWithAes128GcmSha256) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetSequenceNonce makes the explicit nonces the epoch and sequence number
+// of each record instead of random bytes. It takes effect on the next call
+// to Init.
+func (c *TLSEcdheEcdsaWithAes128GcmSha256) SetSequenceNonce(enabled bool) {
+	c.sequenceNonce = enabled
+}
+
+// SequenceNonce reports whether SetSequenceNonce enabled sequence number
+// nonces.
+func (c *TLSEcdheEcdsaWithAes128GcmSha256) SequenceNonce() bool {
+	return c.sequenceNonce
+}
+
 func (c *TLSEcdheEcdsaWithAes128GcmSha256) init(masterSecret, clientRandom, serverRandom []byte, isClient bool, prfMacLen, prfKeyLen, prfIvLen int, hashFunc func() hash.Hash) error {
 	keys, err := prf.GenerateEncryptionKeys(masterSecret, clientRandom, serverRandom, prfMacLen, prfKeyLen, prfIvLen, hashFunc)
 	if err != nil {
@@ -72,6 +94,12 @@ func (c *TLSEcdheEcdsaWithAes128GcmSha256) init(masterSecret, clientRandom, serv
 	} else {
 		gcm, err = ciphersuite.NewGCM(keys.ServerWriteKey, keys.ServerWriteIV, keys.ClientWriteKey, keys.ClientWriteIV)
 	}
+	if err == nil && c.rand != nil {
+		gcm.SetRand(c.rand)
+	}
+	if err == nil {
+		gcm.SetSequenceNonce(c.sequenceNonce)
+	}
 	c.gcm.Store(gcm)
 	return err
 }
@@ -8,6 +8,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
 	"sync/atomic"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
@@ -18,7 +19,9 @@ import (
 
 // TLSEcdheEcdsaWithAes256CbcSha represents a TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA CipherSuite
 type TLSEcdheEcdsaWithAes256CbcSha struct {
-	cbc atomic.Value // *cryptoCBC
+	cbc          atomic.Value // *cryptoCBC
+	rand         io.Reader
+	truncatedMAC bool
 }
 
 // CertificateType returns what type of certficate this CipherSuite exchanges
@@ -61,6 +64,18 @@ func (c *TLSEcdheEcdsaWithAes256CbcSha) IsInitialized() bool {
 	return c.cbc.Load() != nil
 }
 
+// SetRand sets the source of the explicit IVs, crypto/rand by default. It
+// takes effect on the next call to Init.
+func (c *TLSEcdheEcdsaWithAes256CbcSha) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetTruncatedMAC truncates record MACs to 80 bits, as negotiated with the
+// truncated_hmac extension. It takes effect on the next call to Init.
+func (c *TLSEcdheEcdsaWithAes256CbcSha) SetTruncatedMAC(truncated bool) {
+	c.truncatedMAC = truncated
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdheEcdsaWithAes256CbcSha) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
 	const (
@@ -88,6 +103,12 @@ func (c *TLSEcdheEcdsaWithAes256CbcSha) Init(masterSecret, clientRandom, serverR
 			sha1.New,
 		)
 	}
+	if err == nil && c.rand != nil {
+		cbc.SetRand(c.rand)
+	}
+	if err == nil {
+		cbc.SetTruncatedMAC(c.truncatedMAC)
+	}
 	c.cbc.Store(cbc)
 
 	return err
@@ -8,7 +8,7 @@ import (
 	"hash"
 )
 
-// TLSEcdheEcdsaWithAes256GcmSha384  represents a TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 CipherSuite
+// TLSEcdheEcdsaWithAes256GcmSha384 represents a TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 CipherSuite
 type TLSEcdheEcdsaWithAes256GcmSha384 struct {
 	TLSEcdheEcdsaWithAes128GcmSha256
 }
@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
 	"sync/atomic"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
@@ -17,7 +18,9 @@ import (
 
 // TLSEcdhePskWithAes128CbcSha256 implements the TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 CipherSuite
 type TLSEcdhePskWithAes128CbcSha256 struct {
-	cbc atomic.Value // *cryptoCBC
+	cbc          atomic.Value // *cryptoCBC
+	rand         io.Reader
+	truncatedMAC bool
 }
 
 // NewTLSEcdhePskWithAes128CbcSha256 creates TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256 cipher.
@@ -46,7 +49,7 @@ func (c *TLSEcdhePskWithAes128CbcSha256) ID() ID {
 }
 
 func (c *TLSEcdhePskWithAes128CbcSha256) String() string {
-	return "TLS-ECDHE-PSK-WITH-AES-128-CBC-SHA256"
+	return "TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256"
 }
 
 // HashFunc returns the hashing func for this CipherSuite
@@ -65,6 +68,18 @@ func (c *TLSEcdhePskWithAes128CbcSha256) IsInitialized() bool {
 	return c.cbc.Load() != nil
 }
 
+// SetRand sets the source of the explicit IVs, crypto/rand by default. It
+// takes effect on the next call to Init.
+func (c *TLSEcdhePskWithAes128CbcSha256) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetTruncatedMAC truncates record MACs to 80 bits, as negotiated with the
+// truncated_hmac extension. It takes effect on the next call to Init.
+func (c *TLSEcdhePskWithAes128CbcSha256) SetTruncatedMAC(truncated bool) {
+	c.truncatedMAC = truncated
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSEcdhePskWithAes128CbcSha256) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
 	const (
@@ -92,6 +107,12 @@ func (c *TLSEcdhePskWithAes128CbcSha256) Init(masterSecret, clientRandom, server
 			c.HashFunc(),
 		)
 	}
+	if err == nil && c.rand != nil {
+		cbc.SetRand(c.rand)
+	}
+	if err == nil {
+		cbc.SetTruncatedMAC(c.truncatedMAC)
+	}
 	c.cbc.Store(cbc)
 
 	return err
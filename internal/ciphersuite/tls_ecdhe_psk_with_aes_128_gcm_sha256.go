@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+import "github.com/censys-oss/dtls/v2/pkg/crypto/clientcertificate"
+
+// TLSEcdhePskWithAes128GcmSha256 implements the TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256 CipherSuite
+// https://datatracker.ietf.org/doc/html/rfc8442
+type TLSEcdhePskWithAes128GcmSha256 struct {
+	TLSEcdheEcdsaWithAes128GcmSha256
+}
+
+// CertificateType returns what type of certificate this CipherSuite exchanges
+func (c *TLSEcdhePskWithAes128GcmSha256) CertificateType() clientcertificate.Type {
+	return clientcertificate.Type(0)
+}
+
+// KeyExchangeAlgorithm controls what key exchange algorithm is using during the handshake
+func (c *TLSEcdhePskWithAes128GcmSha256) KeyExchangeAlgorithm() KeyExchangeAlgorithm {
+	return (KeyExchangeAlgorithmPsk | KeyExchangeAlgorithmEcdhe)
+}
+
+// ID returns the ID of the CipherSuite
+func (c *TLSEcdhePskWithAes128GcmSha256) ID() ID {
+	return TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256
+}
+
+func (c *TLSEcdhePskWithAes128GcmSha256) String() string {
+	return "TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256"
+}
+
+// AuthenticationType controls what authentication method is using during the handshake
+func (c *TLSEcdhePskWithAes128GcmSha256) AuthenticationType() AuthenticationType {
+	return AuthenticationTypePreSharedKey
+}
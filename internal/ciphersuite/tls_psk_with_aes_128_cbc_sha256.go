@@ -7,6 +7,7 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
 	"sync/atomic"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ciphersuite"
@@ -17,7 +18,9 @@ import (
 
 // TLSPskWithAes128CbcSha256 implements the TLS_PSK_WITH_AES_128_CBC_SHA256 CipherSuite
 type TLSPskWithAes128CbcSha256 struct {
-	cbc atomic.Value // *cryptoCBC
+	cbc          atomic.Value // *cryptoCBC
+	rand         io.Reader
+	truncatedMAC bool
 }
 
 // CertificateType returns what type of certificate this CipherSuite exchanges
@@ -60,6 +63,18 @@ func (c *TLSPskWithAes128CbcSha256) IsInitialized() bool {
 	return c.cbc.Load() != nil
 }
 
+// SetRand sets the source of the explicit IVs, crypto/rand by default. It
+// takes effect on the next call to Init.
+func (c *TLSPskWithAes128CbcSha256) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetTruncatedMAC truncates record MACs to 80 bits, as negotiated with the
+// truncated_hmac extension. It takes effect on the next call to Init.
+func (c *TLSPskWithAes128CbcSha256) SetTruncatedMAC(truncated bool) {
+	c.truncatedMAC = truncated
+}
+
 // Init initializes the internal Cipher with keying material
 func (c *TLSPskWithAes128CbcSha256) Init(masterSecret, clientRandom, serverRandom []byte, isClient bool) error {
 	const (
@@ -87,6 +102,12 @@ func (c *TLSPskWithAes128CbcSha256) Init(masterSecret, clientRandom, serverRando
 			c.HashFunc(),
 		)
 	}
+	if err == nil && c.rand != nil {
+		cbc.SetRand(c.rand)
+	}
+	if err == nil {
+		cbc.SetTruncatedMAC(c.truncatedMAC)
+	}
 	c.cbc.Store(cbc)
 
 	return err
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ciphersuite
+
+// TLSRsaWithAes128GcmSha256 implements the TLS_RSA_WITH_AES_128_GCM_SHA256 CipherSuite
+// It uses the static RSA key exchange and does not provide forward secrecy.
+//
+// https://datatracker.ietf.org/doc/html/rfc5288
+type TLSRsaWithAes128GcmSha256 struct {
+	TLSEcdheRsaWithAes128GcmSha256
+}
+
+// KeyExchangeAlgorithm controls what key exchange algorithm is using during the handshake
+func (c *TLSRsaWithAes128GcmSha256) KeyExchangeAlgorithm() KeyExchangeAlgorithm {
+	return KeyExchangeAlgorithmRsa
+}
+
+// ECC uses Elliptic Curve Cryptography
+func (c *TLSRsaWithAes128GcmSha256) ECC() bool {
+	return false
+}
+
+// ID returns the ID of the CipherSuite
+func (c *TLSRsaWithAes128GcmSha256) ID() ID {
+	return TLS_RSA_WITH_AES_128_GCM_SHA256
+}
+
+func (c *TLSRsaWithAes128GcmSha256) String() string {
+	return "TLS_RSA_WITH_AES_128_GCM_SHA256"
+}
@@ -12,6 +12,12 @@ const (
 	KeyExchangeAlgorithmNone KeyExchangeAlgorithm = 0
 	KeyExchangeAlgorithmPsk  KeyExchangeAlgorithm = iota << 1
 	KeyExchangeAlgorithmEcdhe
+	// KeyExchangeAlgorithmRsa is the static RSA key exchange, the premaster
+	// secret is encrypted to the server's certificate.
+	KeyExchangeAlgorithmRsa KeyExchangeAlgorithm = 1 << 3
+	// KeyExchangeAlgorithmDhe is the ephemeral finite field Diffie-Hellman
+	// key exchange.
+	KeyExchangeAlgorithmDhe KeyExchangeAlgorithm = 1 << 4
 )
 
 // Has check if keyExchangeAlgorithm is supported.
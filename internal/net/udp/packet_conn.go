@@ -42,7 +42,11 @@ var (
 
 // listener augments a connection-oriented Listener over a UDP PacketConn
 type listener struct {
-	pConn *net.UDPConn
+	// droppedUnknownID is accessed atomically and kept first for 64-bit
+	// alignment.
+	droppedUnknownID uint64
+
+	pConn net.PacketConn
 
 	accepting      atomic.Value // bool
 	acceptCh       chan *PacketConn
@@ -133,6 +137,13 @@ func (l *listener) Addr() net.Addr {
 	return l.pConn.LocalAddr()
 }
 
+// DroppedUnknownID returns the number of datagrams dropped because the
+// identifier extracted by the DatagramRouter was not associated with any
+// connection.
+func (l *listener) DroppedUnknownID() uint64 {
+	return atomic.LoadUint64(&l.droppedUnknownID)
+}
+
 // ListenConfig stores options for listening to an address.
 type ListenConfig struct {
 	// Backlog defines the maximum length of the queue of pending
@@ -148,7 +159,9 @@ type ListenConfig struct {
 	AcceptFilter func([]byte) bool
 
 	// DatagramRouter routes an incoming datagram to a connection by extracting
-	// an identifier from the its paylod
+	// an identifier from the its paylod. Datagrams carrying an identifier that
+	// is not associated with any connection are dropped and counted, see
+	// DroppedUnknownID.
 	DatagramRouter func([]byte) (string, bool)
 
 	// ConnectionIdentifier extracts an identifier from an outgoing packet. If
@@ -159,15 +172,23 @@ type ListenConfig struct {
 
 // Listen creates a new listener based on the ListenConfig.
 func (lc *ListenConfig) Listen(network string, laddr *net.UDPAddr) (dtlsnet.PacketListener, error) {
-	if lc.Backlog == 0 {
-		lc.Backlog = defaultListenBacklog
-	}
-
 	conn, err := net.ListenUDP(network, laddr)
 	if err != nil {
 		return nil, err
 	}
 
+	return lc.ListenPacketConn(conn), nil
+}
+
+// ListenPacketConn creates a new listener based on the ListenConfig that
+// accepts connections from datagrams read from conn. The listener takes
+// ownership of conn and closes it once the listener and all of its
+// connections are closed.
+func (lc *ListenConfig) ListenPacketConn(conn net.PacketConn) dtlsnet.PacketListener {
+	if lc.Backlog == 0 {
+		lc.Backlog = defaultListenBacklog
+	}
+
 	l := &listener{
 		pConn:          conn,
 		acceptCh:       make(chan *PacketConn, lc.Backlog),
@@ -192,7 +213,7 @@ func (lc *ListenConfig) Listen(network string, laddr *net.UDPAddr) (dtlsnet.Pack
 		l.readWG.Done()
 	}()
 
-	return l, nil
+	return l
 }
 
 // Listen creates a new listener using default ListenConfig.
@@ -234,11 +255,16 @@ func (l *listener) getConn(raddr net.Addr, buf []byte) (*PacketConn, bool, error
 			if conn, ok := l.conns[id]; ok {
 				return conn, true, nil
 			}
+			// The identifier does not belong to any connection, so the
+			// datagram must not be handed to the one owning the remote
+			// address.
+			atomic.AddUint64(&l.droppedUnknownID, 1)
+			return nil, false, nil
 		}
 	}
 
-	// If we don't have a custom resolver, or we were unable to find an
-	// associated connection, fall back to remote address.
+	// If we don't have a custom resolver, or the datagram carries no
+	// identifier, fall back to remote address.
 	conn, ok := l.conns[raddr.String()]
 	if !ok {
 		if isAccepting, ok := l.accepting.Load().(bool); !isAccepting || !ok {
@@ -10,15 +10,63 @@ import (
 	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/logging"
 )
 
-// Listen creates a DTLS listener
+// Listen creates a DTLS listener. The returned listener implements
+// interface{ Stats() ListenerStats }.
 func Listen(network string, laddr *net.UDPAddr, config *Config) (net.Listener, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, err
 	}
 
-	lc := udp.ListenConfig{
+	conn, err := net.ListenUDP(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return listenPacketConn(conn, config), nil
+}
+
+// ListenPacketConn creates a DTLS listener which accepts connections from
+// datagrams read from an existing packet connection, such as a server socket
+// shared with other protocols. Datagrams are dispatched to connections as in
+// Listen: if ConnectionIDGenerator is set, records carrying a connection ID
+// are routed by that ID rather than by source address, so a client whose
+// address changes, for example due to NAT rebinding, keeps being served by
+// the same Conn, and records carrying an unknown connection ID are dropped
+// and counted in ListenerStats. The listener takes ownership of conn and
+// closes it once the listener and all accepted connections are closed.
+func ListenPacketConn(conn net.PacketConn, config *Config) (net.Listener, error) {
+	if err := validateConfig(config); err != nil {
+		return nil, err
+	}
+
+	return listenPacketConn(conn, config), nil
+}
+
+func listenPacketConn(conn net.PacketConn, config *Config) *listener {
+	if config.DSCP != 0 || config.DisableIPFragmentation {
+		loggerFactory := config.LoggerFactory
+		if loggerFactory == nil {
+			loggerFactory = logging.NewDefaultLoggerFactory()
+		}
+		log := loggerFactory.NewLogger("dtls")
+		if config.DSCP != 0 {
+			applyDSCP(conn, config.DSCP, log)
+		}
+		if config.DisableIPFragmentation {
+			applyDontFragment(conn, log)
+		}
+	}
+
+	return &listener{
+		config: config,
+		parent: listenConfig(config).ListenPacketConn(conn),
+	}
+}
+
+func listenConfig(config *Config) *udp.ListenConfig {
+	lc := &udp.ListenConfig{
 		AcceptFilter: func(packet []byte) bool {
 			pkts, err := recordlayer.UnpackDatagram(packet)
 			if err != nil || len(pkts) < 1 {
@@ -37,14 +85,7 @@ func Listen(network string, laddr *net.UDPAddr, config *Config) (net.Listener, e
 		lc.DatagramRouter = cidDatagramRouter(len(config.ConnectionIDGenerator()))
 		lc.ConnectionIdentifier = cidConnIdentifier()
 	}
-	parent, err := lc.Listen(network, laddr)
-	if err != nil {
-		return nil, err
-	}
-	return &listener{
-		config: config,
-		parent: parent,
-	}, nil
+	return lc
 }
 
 // NewListener creates a DTLS listener which accepts connections from an inner Listener.
@@ -88,3 +129,13 @@ func (l *listener) Close() error {
 func (l *listener) Addr() net.Addr {
 	return l.parent.Addr()
 }
+
+// Stats returns a snapshot of the listener counters. Counters that the
+// parent listener does not track are zero.
+func (l *listener) Stats() ListenerStats {
+	var stats ListenerStats
+	if p, ok := l.parent.(interface{ DroppedUnknownID() uint64 }); ok {
+		stats.DroppedUnknownConnectionID = p.DroppedUnknownID()
+	}
+	return stats
+}
@@ -10,6 +10,7 @@ import ( //nolint:gci
 	"crypto/rand"
 	"encoding/binary"
 	"hash"
+	"io"
 
 	"github.com/censys-oss/dtls/v2/internal/util"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
@@ -18,6 +19,11 @@ import ( //nolint:gci
 	"golang.org/x/crypto/cryptobyte"
 )
 
+// truncatedMACLength is the length of a MAC truncated with the
+// truncated_hmac extension.
+// https://tools.ietf.org/html/rfc6066#section-7
+const truncatedMACLength = 10
+
 // block ciphers using cipher block chaining.
 type cbcMode interface {
 	cipher.BlockMode
@@ -29,6 +35,8 @@ type CBC struct {
 	writeCBC, readCBC cbcMode
 	writeMac, readMac []byte
 	h                 prf.HashFunc
+	rand              io.Reader
+	truncatedMAC      bool
 }
 
 // NewCBC creates a DTLS CBC Cipher
@@ -60,9 +68,30 @@ func NewCBC(localKey, localWriteIV, localMac, remoteKey, remoteWriteIV, remoteMa
 		readCBC: readCBC,
 		readMac: remoteMac,
 		h:       h,
+		rand:    rand.Reader,
 	}, nil
 }
 
+// SetRand sets the source of the explicit IVs, crypto/rand by default. It
+// must not be called concurrently with Encrypt.
+func (c *CBC) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// SetTruncatedMAC truncates the MAC of written and read records to 80 bits,
+// as negotiated with the truncated_hmac extension. It must not be called
+// concurrently with Encrypt or Decrypt.
+func (c *CBC) SetTruncatedMAC(truncated bool) {
+	c.truncatedMAC = truncated
+}
+
+func (c *CBC) macSize() int {
+	if c.truncatedMAC {
+		return truncatedMACLength
+	}
+	return c.h().Size()
+}
+
 // Encrypt encrypt a DTLS RecordLayer message
 func (c *CBC) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
 	payload := raw[pkt.Header.Size():]
@@ -82,7 +111,7 @@ func (c *CBC) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error)
 	if err != nil {
 		return nil, err
 	}
-	payload = append(payload, mac...)
+	payload = append(payload, mac[:c.macSize()]...)
 
 	// Generate + Append padding
 	padding := make([]byte, blockSize-len(payload)%blockSize)
@@ -94,7 +123,7 @@ func (c *CBC) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error)
 
 	// Generate IV
 	iv := make([]byte, blockSize)
-	if _, err := rand.Read(iv); err != nil {
+	if _, err := io.ReadFull(c.rand, iv); err != nil {
 		return nil, err
 	}
 
@@ -115,7 +144,7 @@ func (c *CBC) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error)
 // Decrypt decrypts a DTLS RecordLayer message
 func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 	blockSize := c.readCBC.BlockSize()
-	mac := c.h()
+	macSize := c.macSize()
 
 	if err := h.Unmarshal(in); err != nil {
 		return nil, err
@@ -126,7 +155,7 @@ func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 	case h.ContentType == protocol.ContentTypeChangeCipherSpec:
 		// Nothing to encrypt with ChangeCipherSpec
 		return in, nil
-	case len(body)%blockSize != 0 || len(body) < blockSize+util.Max(mac.Size()+1, blockSize):
+	case len(body)%blockSize != 0 || len(body) < blockSize+util.Max(macSize+1, blockSize):
 		return nil, errNotEnoughRoomForNonce
 	}
 
@@ -144,7 +173,6 @@ func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 		return nil, errInvalidMAC
 	}
 
-	macSize := mac.Size()
 	if len(body) < macSize {
 		return nil, errInvalidMAC
 	}
@@ -160,7 +188,7 @@ func (c *CBC) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 		actualMAC, err = c.hmac(h.Epoch, h.SequenceNumber, h.ContentType, h.Version, body[:dataEnd], c.readMac, c.h)
 	}
 	// Compute Local MAC and compare
-	if err != nil || !hmac.Equal(actualMAC, expectedMAC) {
+	if err != nil || !hmac.Equal(actualMAC[:macSize], expectedMAC) {
 		return nil, errInvalidMAC
 	}
 
@@ -8,6 +8,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/ccm"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
@@ -29,6 +30,7 @@ type CCM struct {
 	localCCM, remoteCCM         ccm.CCM
 	localWriteIV, remoteWriteIV []byte
 	tagLen                      CCMTagLen
+	rand                        io.Reader
 }
 
 // NewCCM creates a DTLS GCM Cipher
@@ -57,33 +59,42 @@ func NewCCM(tagLen CCMTagLen, localKey, localWriteIV, remoteKey, remoteWriteIV [
 		remoteCCM:     remoteCCM,
 		remoteWriteIV: remoteWriteIV,
 		tagLen:        tagLen,
+		rand:          rand.Reader,
 	}, nil
 }
 
-// Encrypt encrypt a DTLS RecordLayer message
+// SetRand sets the source of the explicit nonces, crypto/rand by default. It
+// must not be called concurrently with Encrypt.
+func (c *CCM) SetRand(r io.Reader) {
+	c.rand = r
+}
+
+// Encrypt encrypt a DTLS RecordLayer message. The result is written in place
+// if raw has enough spare capacity for the explicit nonce and the tag.
 func (c *CCM) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
-	payload := raw[pkt.Header.Size():]
-	raw = raw[:pkt.Header.Size()]
+	hs := pkt.Header.Size()
+	payloadLen := len(raw) - hs
 
-	nonce := append(append([]byte{}, c.localWriteIV[:4]...), make([]byte, 8)...)
-	if _, err := rand.Read(nonce[4:]); err != nil {
+	var nonce [ccmNonceLength]byte
+	copy(nonce[:], c.localWriteIV[:4])
+	if _, err := io.ReadFull(c.rand, nonce[4:]); err != nil {
 		return nil, err
 	}
 
 	var additionalData []byte
 	if pkt.Header.ContentType == protocol.ContentTypeConnectionID {
-		additionalData = generateAEADAdditionalDataCID(&pkt.Header, len(payload))
+		additionalData = generateAEADAdditionalDataCID(&pkt.Header, payloadLen)
 	} else {
-		additionalData = generateAEADAdditionalData(&pkt.Header, len(payload))
+		additionalData = generateAEADAdditionalData(&pkt.Header, payloadLen)
 	}
-	encryptedPayload := c.localCCM.Seal(nil, nonce, payload, additionalData)
 
-	encryptedPayload = append(nonce[4:], encryptedPayload...)
-	raw = append(raw, encryptedPayload...)
+	r := growForSeal(raw, hs, len(nonce[4:]), int(c.tagLen))
+	copy(r[hs:], nonce[4:])
+	r = c.localCCM.Seal(r[:hs+len(nonce[4:])], nonce[:], r[hs+len(nonce[4:]):], additionalData)
 
 	// Update recordLayer size to include explicit nonce
-	binary.BigEndian.PutUint16(raw[pkt.Header.Size()-2:], uint16(len(raw)-pkt.Header.Size()))
-	return raw, nil
+	binary.BigEndian.PutUint16(r[hs-2:], uint16(len(r)-hs))
+	return r, nil
 }
 
 // Decrypt decrypts a DTLS RecordLayer message
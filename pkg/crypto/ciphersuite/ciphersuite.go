@@ -102,3 +102,20 @@ func examinePadding(payload []byte) (toRemove int, good byte) {
 
 	return toRemove, good
 }
+
+// growForSeal makes room in raw, a record with a header of hs bytes, for an
+// explicit nonce of nonceLen bytes in front of the payload and tagLen spare
+// bytes for the AEAD tag. raw is reused if its capacity allows, otherwise a
+// single buffer of the final size is allocated.
+func growForSeal(raw []byte, hs, nonceLen, tagLen int) []byte {
+	n := len(raw) + nonceLen
+	var r []byte
+	if cap(raw) >= n+tagLen {
+		r = raw[:n]
+	} else {
+		r = make([]byte, n, n+tagLen)
+		copy(r, raw[:hs])
+	}
+	copy(r[hs+nonceLen:], raw[hs:])
+	return r
+}
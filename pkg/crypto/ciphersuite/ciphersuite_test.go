@@ -6,6 +6,8 @@ package ciphersuite
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"testing"
 
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
@@ -53,3 +55,193 @@ func TestGenerateAEADAdditionalDataCID(t *testing.T) {
 		})
 	}
 }
+
+func TestGCMEncryptInPlace(t *testing.T) {
+	key := make([]byte, 16)
+	iv := []byte{1, 2, 3, 4}
+	gcm, err := NewGCM(key, iv, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version: protocol.Version1_2,
+			Epoch:   1,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("hello")},
+	}
+	plain, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, spare := range map[string]int{
+		"Allocating": 0,
+		"InPlace":    gcmTagLength + 8,
+	} {
+		spare := spare
+		t.Run(name, func(t *testing.T) {
+			raw := append(make([]byte, 0, len(plain)+spare), plain...)
+			encrypted, err := gcm.Encrypt(pkt, raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reused := &encrypted[0] == &raw[0]; reused != (spare > 0) {
+				t.Errorf("Expected buffer reuse to be %v, got %v", spare > 0, reused)
+			}
+
+			decrypted, err := gcm.Decrypt(recordlayer.Header{}, encrypted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Decrypt keeps the encrypted length in the header.
+			hs := pkt.Header.Size()
+			if !bytes.Equal(decrypted[hs:], plain[hs:]) {
+				t.Errorf("Decrypted payload mismatch\nwant: %v\ngot: %v", plain[hs:], decrypted[hs:])
+			}
+		})
+	}
+}
+
+func TestGCMSequenceNonce(t *testing.T) {
+	key := make([]byte, 16)
+	iv := []byte{1, 2, 3, 4}
+	gcm, err := NewGCM(key, iv, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm.SetRand(bytes.NewReader(nil)) // Fail if randomness is used.
+	gcm.SetSequenceNonce(true)
+
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version:        protocol.Version1_2,
+			Epoch:          1,
+			SequenceNumber: 0x0203040506,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("hello")},
+	}
+	plain, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := gcm.Encrypt(pkt, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := pkt.Header.Size()
+	expected := []byte{0x00, 0x01, 0x00, 0x02, 0x03, 0x04, 0x05, 0x06}
+	if nonce := encrypted[hs : hs+8]; !bytes.Equal(nonce, expected) {
+		t.Errorf("Explicit nonce mismatch\nwant: %v\ngot: %v", expected, nonce)
+	}
+
+	decrypted, err := gcm.Decrypt(recordlayer.Header{}, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[hs:], plain[hs:]) {
+		t.Errorf("Decrypted payload mismatch\nwant: %v\ngot: %v", plain[hs:], decrypted[hs:])
+	}
+}
+
+func TestGCMParseHeader(t *testing.T) {
+	key := make([]byte, 16)
+	iv := []byte{1, 2, 3, 4}
+	gcm, err := NewGCM(key, iv, key, iv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	payload := []byte("hello")
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			ContentType:    protocol.ContentTypeConnectionID,
+			ContentLen:     uint16(len(payload)),
+			ConnectionID:   cid,
+			Version:        protocol.Version1_2,
+			Epoch:          1,
+			SequenceNumber: 7,
+		},
+	}
+	plain, err := pkt.Header.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain = append(plain, payload...)
+	encrypted, err := gcm.Encrypt(pkt, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := recordlayer.Header{ConnectionID: make([]byte, len(cid))}
+	if err = gcm.ParseHeader(&h, encrypted); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(h.ConnectionID, cid) || h.SequenceNumber != 7 {
+		t.Errorf("Header mismatch: %+v", h)
+	}
+
+	// Parsing the header leaves the record intact for decryption.
+	decrypted, err := gcm.Decrypt(recordlayer.Header{ConnectionID: make([]byte, len(cid))}, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := pkt.Header.Size()
+	if !bytes.Equal(decrypted[hs:], plain[hs:]) {
+		t.Errorf("Decrypted payload mismatch\nwant: %v\ngot: %v", plain[hs:], decrypted[hs:])
+	}
+
+	h = recordlayer.Header{ConnectionID: make([]byte, len(cid))}
+	if err = gcm.ParseHeader(&h, encrypted[:hs+8]); !errors.Is(err, errNotEnoughRoomForNonce) {
+		t.Errorf("Expected %v, got %v", errNotEnoughRoomForNonce, err)
+	}
+}
+
+func TestCBCTruncatedMAC(t *testing.T) {
+	key, iv, mac := make([]byte, 16), make([]byte, 16), make([]byte, 32)
+	newCBC := func(truncated bool) *CBC {
+		cbc, err := NewCBC(key, iv, mac, key, iv, mac, sha256.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cbc.SetTruncatedMAC(truncated)
+		return cbc
+	}
+
+	pkt := &recordlayer.RecordLayer{
+		Header: recordlayer.Header{
+			Version: protocol.Version1_2,
+			Epoch:   1,
+		},
+		Content: &protocol.ApplicationData{Data: []byte("hello")},
+	}
+	plain, err := pkt.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := newCBC(true).Encrypt(pkt, append([]byte{}, plain...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// IV, then 5 bytes of data and the 10 byte MAC padded to a single block.
+	hs := pkt.Header.Size()
+	if len(encrypted)-hs != 2*16 {
+		t.Errorf("Expected a payload of %d bytes, got %d", 2*16, len(encrypted)-hs)
+	}
+
+	if _, err := newCBC(false).Decrypt(recordlayer.Header{}, append([]byte{}, encrypted...)); err == nil {
+		t.Error("Expected a full length MAC check to fail")
+	}
+
+	decrypted, err := newCBC(true).Decrypt(recordlayer.Header{}, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted[hs:], plain[hs:]) {
+		t.Errorf("Decrypted payload mismatch\nwant: %v\ngot: %v", plain[hs:], decrypted[hs:])
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"io"
 
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
@@ -23,6 +24,8 @@ const (
 type GCM struct {
 	localGCM, remoteGCM         cipher.AEAD
 	localWriteIV, remoteWriteIV []byte
+	rand                        io.Reader
+	sequenceNonce               bool
 }
 
 // NewGCM creates a DTLS GCM Cipher
@@ -50,48 +53,80 @@ func NewGCM(localKey, localWriteIV, remoteKey, remoteWriteIV []byte) (*GCM, erro
 		localWriteIV:  localWriteIV,
 		remoteGCM:     remoteGCM,
 		remoteWriteIV: remoteWriteIV,
+		rand:          rand.Reader,
 	}, nil
 }
 
-// Encrypt encrypt a DTLS RecordLayer message
+// SetRand sets the source of the explicit nonces, crypto/rand by default. It
+// must not be called concurrently with Encrypt.
+func (g *GCM) SetRand(r io.Reader) {
+	g.rand = r
+}
+
+// SetSequenceNonce makes Encrypt use the epoch and sequence number of each
+// record as its explicit nonce instead of random bytes, which saves a read
+// from the source of randomness per record. The DTLS 1.2 write IV is only
+// the 4 byte salt of the nonce, so the sequence number forms the explicit
+// part as suggested by RFC 5288, rather than being XORed with a full length
+// IV as in DTLS 1.3. The peer reads the explicit nonce from the record
+// either way. It must not be called concurrently with Encrypt.
+// https://datatracker.ietf.org/doc/html/rfc5288#section-3
+func (g *GCM) SetSequenceNonce(enabled bool) {
+	g.sequenceNonce = enabled
+}
+
+// Encrypt encrypt a DTLS RecordLayer message. The result is written in place
+// if raw has enough spare capacity for the explicit nonce and the tag.
 func (g *GCM) Encrypt(pkt *recordlayer.RecordLayer, raw []byte) ([]byte, error) {
-	payload := raw[pkt.Header.Size():]
-	raw = raw[:pkt.Header.Size()]
+	hs := pkt.Header.Size()
+	payloadLen := len(raw) - hs
 
-	nonce := make([]byte, gcmNonceLength)
-	copy(nonce, g.localWriteIV[:4])
-	if _, err := rand.Read(nonce[4:]); err != nil {
+	var nonce [gcmNonceLength]byte
+	copy(nonce[:], g.localWriteIV[:4])
+	if g.sequenceNonce {
+		binary.BigEndian.PutUint64(nonce[4:], uint64(pkt.Header.Epoch)<<48|pkt.Header.SequenceNumber)
+	} else if _, err := io.ReadFull(g.rand, nonce[4:]); err != nil {
 		return nil, err
 	}
 
 	var additionalData []byte
 	if pkt.Header.ContentType == protocol.ContentTypeConnectionID {
-		additionalData = generateAEADAdditionalDataCID(&pkt.Header, len(payload))
+		additionalData = generateAEADAdditionalDataCID(&pkt.Header, payloadLen)
 	} else {
-		additionalData = generateAEADAdditionalData(&pkt.Header, len(payload))
+		additionalData = generateAEADAdditionalData(&pkt.Header, payloadLen)
 	}
-	encryptedPayload := g.localGCM.Seal(nil, nonce, payload, additionalData)
-	r := make([]byte, len(raw)+len(nonce[4:])+len(encryptedPayload))
-	copy(r, raw)
-	copy(r[len(raw):], nonce[4:])
-	copy(r[len(raw)+len(nonce[4:]):], encryptedPayload)
+
+	r := growForSeal(raw, hs, len(nonce[4:]), gcmTagLength)
+	copy(r[hs:], nonce[4:])
+	r = g.localGCM.Seal(r[:hs+len(nonce[4:])], nonce[:], r[hs+len(nonce[4:]):], additionalData)
 
 	// Update recordLayer size to include explicit nonce
-	binary.BigEndian.PutUint16(r[pkt.Header.Size()-2:], uint16(len(r)-pkt.Header.Size()))
+	binary.BigEndian.PutUint16(r[hs-2:], uint16(len(r)-hs))
 	return r, nil
 }
 
+// ParseHeader parses the header of the DTLS RecordLayer message in into h
+// without decrypting it, so that callers can discard records, e.g. with an
+// unexpected connection ID, before doing any AEAD work. As with Decrypt,
+// h.ConnectionID must be sized to the expected connection ID.
+func (g *GCM) ParseHeader(h *recordlayer.Header, in []byte) error {
+	if err := h.Unmarshal(in); err != nil {
+		return err
+	}
+	if h.ContentType != protocol.ContentTypeChangeCipherSpec && len(in) <= (8+h.Size()) {
+		return errNotEnoughRoomForNonce
+	}
+	return nil
+}
+
 // Decrypt decrypts a DTLS RecordLayer message
 func (g *GCM) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
-	err := h.Unmarshal(in)
-	switch {
-	case err != nil:
+	if err := g.ParseHeader(&h, in); err != nil {
 		return nil, err
-	case h.ContentType == protocol.ContentTypeChangeCipherSpec:
+	}
+	if h.ContentType == protocol.ContentTypeChangeCipherSpec {
 		// Nothing to encrypt with ChangeCipherSpec
 		return in, nil
-	case len(in) <= (8 + h.Size()):
-		return nil, errNotEnoughRoomForNonce
 	}
 
 	nonce := make([]byte, 0, gcmNonceLength)
@@ -104,7 +139,7 @@ func (g *GCM) Decrypt(h recordlayer.Header, in []byte) ([]byte, error) {
 	} else {
 		additionalData = generateAEADAdditionalData(&h, len(out)-gcmTagLength)
 	}
-	out, err = g.remoteGCM.Open(out[:0], nonce, out, additionalData)
+	out, err := g.remoteGCM.Open(out[:0], nonce, out, additionalData)
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", errDecryptPacket, err) //nolint:errorlint
 	}
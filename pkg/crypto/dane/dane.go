@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package dane verifies certificate chains against DNS TLSA records as
+// described by DANE, RFC 6698 and RFC 7671. Looking up the records is left to
+// the caller.
+package dane
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"errors"
+)
+
+var (
+	errEmptyChain       = errors.New("dane: no certificates to verify")
+	errNoUsableRecords  = errors.New("dane: no usable TLSA records")
+	errNoMatchingRecord = errors.New("dane: no TLSA record matches the certificate chain")
+)
+
+// Usage is the certificate usage field of a TLSA record
+type Usage uint8
+
+// Usage enums
+const (
+	UsagePKIXTA Usage = 0
+	UsagePKIXEE Usage = 1
+	UsageDANETA Usage = 2
+	UsageDANEEE Usage = 3
+)
+
+// Selector is the selector field of a TLSA record
+type Selector uint8
+
+// Selector enums
+const (
+	SelectorCert Selector = 0
+	SelectorSPKI Selector = 1
+)
+
+// MatchingType is the matching type field of a TLSA record
+type MatchingType uint8
+
+// MatchingType enums
+const (
+	MatchingTypeFull   MatchingType = 0
+	MatchingTypeSHA256 MatchingType = 1
+	MatchingTypeSHA512 MatchingType = 2
+)
+
+// TLSARecord is a DNS TLSA resource record.
+//
+// https://datatracker.ietf.org/doc/html/rfc6698#section-2.1
+type TLSARecord struct {
+	Usage        Usage
+	Selector     Selector
+	MatchingType MatchingType
+	// Certificate is the certificate association data.
+	Certificate []byte
+}
+
+// VerifyChain checks that the DER encoded chain rawCerts, leaf first, matches
+// at least one of the TLSA records. Records with usage PKIX-EE or DANE-EE
+// match the leaf certificate. Records with usage PKIX-TA or DANE-TA match any
+// certificate of the chain, and for DANE-TA the leaf must chain up to the
+// matched certificate. Records with unknown parameters are ignored.
+//
+// PKIX validation of the chain, required by the PKIX usages, is not done
+// here; it is left to the normal certificate verification of the handshake.
+func VerifyChain(tlsa []TLSARecord, rawCerts [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errEmptyChain
+	}
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, rawCert := range rawCerts {
+		cert, err := x509.ParseCertificate(rawCert)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+
+	usable := false
+	for _, record := range tlsa {
+		var candidates []*x509.Certificate
+		switch record.Usage {
+		case UsagePKIXEE, UsageDANEEE:
+			candidates = certs[:1]
+		case UsagePKIXTA, UsageDANETA:
+			candidates = certs
+		default:
+			continue
+		}
+
+		for i, cert := range candidates {
+			match, ok := record.matches(cert)
+			if !ok {
+				break
+			}
+			usable = true
+			if match && (record.Usage != UsageDANETA || chainsTo(certs, i)) {
+				return nil
+			}
+		}
+	}
+
+	if !usable {
+		return errNoUsableRecords
+	}
+	return errNoMatchingRecord
+}
+
+// VerifyPeerCertificate returns a function that can be used as the
+// VerifyPeerCertificate of a dtls.Config to check the peer's chain with
+// VerifyChain.
+func VerifyPeerCertificate(tlsa []TLSARecord) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return VerifyChain(tlsa, rawCerts)
+	}
+}
+
+// matches reports whether cert matches the record. ok is false if the
+// selector or the matching type of the record is unknown.
+func (r TLSARecord) matches(cert *x509.Certificate) (match, ok bool) {
+	var data []byte
+	switch r.Selector {
+	case SelectorCert:
+		data = cert.Raw
+	case SelectorSPKI:
+		data = cert.RawSubjectPublicKeyInfo
+	default:
+		return false, false
+	}
+
+	switch r.MatchingType {
+	case MatchingTypeFull:
+	case MatchingTypeSHA256:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case MatchingTypeSHA512:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	default:
+		return false, false
+	}
+	return bytes.Equal(data, r.Certificate), true
+}
+
+// chainsTo reports whether the leaf of certs chains up to certs[anchor],
+// using the certificates in between as intermediates.
+func chainsTo(certs []*x509.Certificate, anchor int) bool {
+	if anchor == 0 {
+		return true
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(certs[anchor])
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:anchor] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	return err == nil
+}
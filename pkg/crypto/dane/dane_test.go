@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dane
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func createCertificate(t *testing.T, template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func caTemplate(serial int64) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	ca, caKey := createCertificate(t, caTemplate(1), nil, nil)
+	otherCA, _ := createCertificate(t, caTemplate(2), nil, nil)
+	leaf, _ := createCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "leaf"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}, ca, caKey)
+
+	leafSPKI := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+	caCert := sha512.Sum512(ca.Raw)
+	otherCACert := sha512.Sum512(otherCA.Raw)
+
+	for name, tt := range map[string]struct {
+		tlsa     []TLSARecord
+		chain    []*x509.Certificate
+		expected error
+	}{
+		"DANEEESPKI": {
+			tlsa:  []TLSARecord{{UsageDANEEE, SelectorSPKI, MatchingTypeSHA256, leafSPKI[:]}},
+			chain: []*x509.Certificate{leaf, ca},
+		},
+		"DANEEEFull": {
+			tlsa:  []TLSARecord{{UsageDANEEE, SelectorCert, MatchingTypeFull, leaf.Raw}},
+			chain: []*x509.Certificate{leaf},
+		},
+		"DANEEEMismatch": {
+			tlsa:     []TLSARecord{{UsageDANEEE, SelectorCert, MatchingTypeFull, ca.Raw}},
+			chain:    []*x509.Certificate{leaf, ca},
+			expected: errNoMatchingRecord,
+		},
+		"DANETA": {
+			tlsa: []TLSARecord{
+				{UsageDANETA, SelectorCert, MatchingTypeSHA512, otherCACert[:]},
+				{UsageDANETA, SelectorCert, MatchingTypeSHA512, caCert[:]},
+			},
+			chain: []*x509.Certificate{leaf, ca},
+		},
+		"DANETANotIssuer": {
+			tlsa:     []TLSARecord{{UsageDANETA, SelectorCert, MatchingTypeSHA512, otherCACert[:]}},
+			chain:    []*x509.Certificate{leaf, otherCA},
+			expected: errNoMatchingRecord,
+		},
+		"PKIXTA": {
+			tlsa:  []TLSARecord{{UsagePKIXTA, SelectorCert, MatchingTypeSHA512, caCert[:]}},
+			chain: []*x509.Certificate{leaf, ca},
+		},
+		"UnknownParameters": {
+			tlsa: []TLSARecord{
+				{4, SelectorCert, MatchingTypeFull, leaf.Raw},
+				{UsageDANEEE, 2, MatchingTypeFull, leaf.Raw},
+				{UsageDANEEE, SelectorCert, 3, leaf.Raw},
+			},
+			chain:    []*x509.Certificate{leaf},
+			expected: errNoUsableRecords,
+		},
+		"EmptyChain": {
+			tlsa:     []TLSARecord{{UsageDANEEE, SelectorCert, MatchingTypeFull, leaf.Raw}},
+			expected: errEmptyChain,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			rawCerts := [][]byte{}
+			for _, cert := range tt.chain {
+				rawCerts = append(rawCerts, cert.Raw)
+			}
+			if err := VerifyChain(tt.tlsa, rawCerts); !errors.Is(err, tt.expected) {
+				t.Errorf("Expected error %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
@@ -13,6 +13,8 @@ func TestString(t *testing.T) {
 		{X25519, "X25519"},
 		{P256, "P-256"},
 		{P384, "P-384"},
+		{P521, "P-521"},
+		{X448, "X448"},
 		{0, "0x0"},
 	}
 
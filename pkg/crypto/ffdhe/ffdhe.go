@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package ffdhe implements the finite field Diffie-Hellman key exchange with
+// the named groups of RFC 7919.
+package ffdhe
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+var (
+	errInvalidGroup     = errors.New("invalid finite field group")
+	errInvalidPrime     = errors.New("invalid finite field group prime")
+	errInvalidGenerator = errors.New("invalid finite field group generator")
+	errInvalidPublicKey = errors.New("invalid finite field Diffie-Hellman public value")
+)
+
+// Group is used to represent the IANA registered finite field groups, which
+// share the supported_groups extension with elliptic curves.
+//
+// https://www.iana.org/assignments/tls-parameters/tls-parameters.xml#tls-parameters-8
+type Group uint16
+
+// Group enums
+const (
+	FFDHE2048 Group = 0x0100
+	FFDHE3072 Group = 0x0101
+	FFDHE4096 Group = 0x0102
+)
+
+func (g Group) String() string {
+	switch g {
+	case FFDHE2048:
+		return "ffdhe2048"
+	case FFDHE3072:
+		return "ffdhe3072"
+	case FFDHE4096:
+		return "ffdhe4096"
+	}
+	return fmt.Sprintf("%#x", uint16(g))
+}
+
+// Groups returns all groups we implement
+func Groups() map[Group]bool {
+	return map[Group]bool{
+		FFDHE2048: true,
+		FFDHE3072: true,
+		FFDHE4096: true,
+	}
+}
+
+// The primes of RFC 7919, Appendix A. All groups use the generator 2.
+const (
+	ffdhe2048Prime = "" +
+		"FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B423861285C97FFFFFFFFFFFFFFFF"
+	ffdhe3072Prime = "" +
+		"FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B66C62E37FFFFFFFFFFFFFFFF"
+	ffdhe4096Prime = "" +
+		"FFFFFFFFFFFFFFFFADF85458A2BB4A9AAFDC5620273D3CF1D8B9C583CE2D3695" +
+		"A9E13641146433FBCC939DCE249B3EF97D2FE363630C75D8F681B202AEC4617A" +
+		"D3DF1ED5D5FD65612433F51F5F066ED0856365553DED1AF3B557135E7F57C935" +
+		"984F0C70E0E68B77E2A689DAF3EFE8721DF158A136ADE73530ACCA4F483A797A" +
+		"BC0AB182B324FB61D108A94BB2C8E3FBB96ADAB760D7F4681D4F42A3DE394DF4" +
+		"AE56EDE76372BB190B07A7C8EE0A6D709E02FCE1CDF7E2ECC03404CD28342F61" +
+		"9172FE9CE98583FF8E4F1232EEF28183C3FE3B1B4C6FAD733BB5FCBC2EC22005" +
+		"C58EF1837D1683B2C6F34A26C1B2EFFA886B4238611FCFDCDE355B3B6519035B" +
+		"BC34F4DEF99C023861B46FC9D6E6C9077AD91D2691F7F7EE598CB0FAC186D91C" +
+		"AEFE130985139270B4130C93BC437944F4FD4452E2D74DD364F2E21E71F54BFF" +
+		"5CAE82AB9C9DF69EE86D2BC522363A0DABC521979B0DEADA1DBF9A42D5C4484E" +
+		"0ABCD06BFA53DDEF3C1B20EE3FD59D7C25E41D2B669E1EF16E6F52C3164DF4FB" +
+		"7930E9E4E58857B6AC7D5F42D69F6D187763CF1D5503400487F55BA57E31CC7A" +
+		"7135C886EFB4318AED6A1E012D9E6832A907600A918130C46DC778F971AD0038" +
+		"092999A333CB8B7A1A1DB93D7140003C2A4ECEA9F98D0ACC0A8291CDCEC97DCF" +
+		"8EC9B55A7F88A46B4DB5A851F44182E1C68A007E5E655F6AFFFFFFFFFFFFFFFF"
+)
+
+// Params returns the prime and the generator of g.
+func (g Group) Params() (p, gen *big.Int, err error) {
+	var prime string
+	switch g {
+	case FFDHE2048:
+		prime = ffdhe2048Prime
+	case FFDHE3072:
+		prime = ffdhe3072Prime
+	case FFDHE4096:
+		prime = ffdhe4096Prime
+	default:
+		return nil, nil, errInvalidGroup
+	}
+	p, _ = new(big.Int).SetString(prime, 16)
+	return p, big.NewInt(2), nil
+}
+
+// Keypair is a Diffie-Hellman keypair for the group with prime P and
+// generator G.
+type Keypair struct {
+	P, G       *big.Int
+	PublicKey  []byte
+	PrivateKey []byte
+}
+
+// GenerateKeypair generates a keypair for the group with prime p and
+// generator g, using rand as the source of randomness.
+func GenerateKeypair(rand io.Reader, p, g *big.Int) (*Keypair, error) {
+	// The private value is chosen uniformly in [2, p-2].
+	max := new(big.Int).Sub(p, big.NewInt(3))
+	if max.Sign() <= 0 {
+		return nil, errInvalidPrime
+	}
+	x, err := randInt(rand, max)
+	if err != nil {
+		return nil, err
+	}
+	x.Add(x, big.NewInt(2))
+
+	y := new(big.Int).Exp(g, x, p)
+	return &Keypair{P: p, G: g, PublicKey: y.Bytes(), PrivateKey: x.Bytes()}, nil
+}
+
+var randInt = rand.Int //nolint:gochecknoglobals
+
+// MaxPrimeBits is the largest prime, in bits, ValidateParams accepts other
+// than those of the named groups. It bounds the cost of the primality test.
+const MaxPrimeBits = 4096
+
+// ValidateParams checks that p is an odd prime of at least minBits bits and
+// that g lies in [2, p-2]. The parameters of a named group are accepted as
+// is; any other prime must not be longer than MaxPrimeBits, and is tested
+// for primality probabilistically.
+func ValidateParams(p, g *big.Int, minBits int) error {
+	if p.BitLen() < minBits {
+		return errInvalidPrime
+	}
+	if isNamedGroup(p, g) {
+		return nil
+	}
+	if p.BitLen() > MaxPrimeBits || p.Bit(0) == 0 || !p.ProbablyPrime(20) {
+		return errInvalidPrime
+	}
+	return validateRange(g, p, errInvalidGenerator)
+}
+
+// isNamedGroup reports whether p and g are the parameters of one of the
+// groups we implement.
+func isNamedGroup(p, g *big.Int) bool {
+	for group := range Groups() {
+		groupP, groupG, err := group.Params()
+		if err == nil && p.Cmp(groupP) == 0 && g.Cmp(groupG) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePublicKey checks that the public value y lies in [2, p-2], which
+// rejects the values that would force a trivial shared secret.
+//
+// https://datatracker.ietf.org/doc/html/rfc7919#section-5.1
+func ValidatePublicKey(p *big.Int, y []byte) error {
+	return validateRange(new(big.Int).SetBytes(y), p, errInvalidPublicKey)
+}
+
+func validateRange(v, p *big.Int, err error) error {
+	max := new(big.Int).Sub(p, big.NewInt(1))
+	if v.Cmp(big.NewInt(1)) <= 0 || v.Cmp(max) >= 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package ffdhe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestGroupParams(t *testing.T) {
+	for group, bits := range map[Group]int{
+		FFDHE2048: 2048,
+		FFDHE3072: 3072,
+		FFDHE4096: 4096,
+	} {
+		group, bits := group, bits
+		t.Run(group.String(), func(t *testing.T) {
+			p, g, err := group.Params()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.BitLen() != bits {
+				t.Errorf("Prime has %d bits, expected %d", p.BitLen(), bits)
+			}
+			if err := ValidateParams(p, g, bits); err != nil {
+				t.Error(err)
+			}
+			// The groups are safe primes, p = 2q + 1.
+			q := new(big.Int).Rsh(p, 1)
+			if !q.ProbablyPrime(20) {
+				t.Error("(p-1)/2 is not prime")
+			}
+		})
+	}
+
+	if _, _, err := Group(0x0103).Params(); !errors.Is(err, errInvalidGroup) {
+		t.Errorf("Expected error %v, got %v", errInvalidGroup, err)
+	}
+}
+
+func TestKeyAgreement(t *testing.T) {
+	p, g, err := FFDHE2048.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := GenerateKeypair(rand.Reader, p, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := GenerateKeypair(rand.Reader, p, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []*Keypair{a, b} {
+		if err := ValidatePublicKey(p, k.PublicKey); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ab := new(big.Int).Exp(new(big.Int).SetBytes(b.PublicKey), new(big.Int).SetBytes(a.PrivateKey), p)
+	ba := new(big.Int).Exp(new(big.Int).SetBytes(a.PublicKey), new(big.Int).SetBytes(b.PrivateKey), p)
+	if !bytes.Equal(ab.Bytes(), ba.Bytes()) {
+		t.Error("Shared secrets do not match")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	p, g, err := FFDHE2048.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pMinusOne := new(big.Int).Sub(p, big.NewInt(1))
+	p4096, _, err := FFDHE4096.Params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Odd, and too long to be tested for primality.
+	tooLong := new(big.Int).Add(new(big.Int).Lsh(p4096, 1), big.NewInt(1))
+
+	for name, tt := range map[string]struct {
+		err      error
+		expected error
+	}{
+		"SmallPrime":     {ValidateParams(p, g, 3072), errInvalidPrime},
+		"EvenPrime":      {ValidateParams(pMinusOne, g, 2048), errInvalidPrime},
+		"GeneratorOne":   {ValidateParams(p, big.NewInt(1), 2048), errInvalidGenerator},
+		"TooLongPrime":   {ValidateParams(tooLong, g, 2048), errInvalidPrime},
+		"NamedGroup":     {ValidateParams(p4096, g, 2048), nil},
+		"PublicKeyZero":  {ValidatePublicKey(p, nil), errInvalidPublicKey},
+		"PublicKeyOne":   {ValidatePublicKey(p, []byte{1}), errInvalidPublicKey},
+		"PublicKeyPMin1": {ValidatePublicKey(p, pMinusOne.Bytes()), errInvalidPublicKey},
+		"PublicKeyValid": {ValidatePublicKey(p, []byte{2}), nil},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.expected) {
+				t.Errorf("Expected error %v, got %v", tt.expected, tt.err)
+			}
+		})
+	}
+}
@@ -5,16 +5,23 @@
 package prf
 
 import ( //nolint:gci
+	"crypto"
 	ellipticStdlib "crypto/elliptic"
 	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/subtle"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash"
+	"io"
 	"math"
+	"math/big"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/cloudflare/circl/dh/x448"
 	"golang.org/x/crypto/curve25519"
 )
 
@@ -24,6 +31,8 @@ const (
 	keyExpansionLabel         = "key expansion"
 	verifyDataClientLabel     = "client finished"
 	verifyDataServerLabel     = "server finished"
+
+	rsaPreMasterSecretLength = 48
 )
 
 // HashFunc allows callers to decide what hash is used in PRF
@@ -40,7 +49,11 @@ type EncryptionKeys struct {
 	ServerWriteIV  []byte
 }
 
-var errInvalidNamedCurve = &protocol.FatalError{Err: errors.New("invalid named curve")} //nolint:goerr113
+var (
+	errInvalidNamedCurve = &protocol.FatalError{Err: errors.New("invalid named curve")}             //nolint:goerr113
+	errInvalidX448Key    = &protocol.FatalError{Err: errors.New("X448 keys must be 56 bytes")}      //nolint:goerr113
+	errX448LowOrderPoint = &protocol.FatalError{Err: errors.New("X448 public key has a low order")} //nolint:goerr113
+)
 
 func (e *EncryptionKeys) String() string {
 	return fmt.Sprintf(`encryptionKeys:
@@ -110,15 +123,96 @@ func PreMasterSecret(publicKey, privateKey []byte, curve elliptic.Curve) ([]byte
 	switch curve {
 	case elliptic.X25519:
 		return curve25519.X25519(privateKey, publicKey)
+	case elliptic.X448:
+		return x448PreMasterSecret(publicKey, privateKey)
 	case elliptic.P256:
 		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P256(), ellipticStdlib.P256())
 	case elliptic.P384:
 		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P384(), ellipticStdlib.P384())
+	case elliptic.P521:
+		return ellipticCurvePreMasterSecret(publicKey, privateKey, ellipticStdlib.P521(), ellipticStdlib.P521())
 	default:
 		return nil, errInvalidNamedCurve
 	}
 }
 
+// x448PreMasterSecret returns the X448 shared secret of the keys, and an
+// error if the public key is a point of low order.
+func x448PreMasterSecret(publicKey, privateKey []byte) ([]byte, error) {
+	if len(publicKey) != x448.Size || len(privateKey) != x448.Size {
+		return nil, errInvalidX448Key
+	}
+	var public, private, shared x448.Key
+	copy(public[:], publicKey)
+	copy(private[:], privateKey)
+	if !x448.Shared(&shared, &private, &public) {
+		return nil, errX448LowOrderPoint
+	}
+	return shared[:], nil
+}
+
+// RSAPreMasterSecret generates a Premaster Secret for the RSA key exchange and
+// encrypts it to the server's public key. The secret is the client version
+// followed by 46 random bytes.
+//
+// https://tools.ietf.org/html/rfc5246#section-7.4.7.1
+func RSAPreMasterSecret(rand io.Reader, publicKey *rsa.PublicKey) (preMasterSecret, encrypted []byte, err error) {
+	preMasterSecret = make([]byte, rsaPreMasterSecretLength)
+	preMasterSecret[0] = protocol.Version1_2.Major
+	preMasterSecret[1] = protocol.Version1_2.Minor
+	if _, err = io.ReadFull(rand, preMasterSecret[2:]); err != nil {
+		return nil, nil, err
+	}
+
+	if encrypted, err = rsa.EncryptPKCS1v15(rand, publicKey, preMasterSecret); err != nil {
+		return nil, nil, err
+	}
+	return preMasterSecret, encrypted, nil
+}
+
+// RSADecryptPreMasterSecret decrypts a Premaster Secret received with the RSA
+// key exchange. The secret must start with clientVersion, the version offered
+// in the ClientHello, to prevent version rollback. If the padding or the
+// version is invalid a random secret is returned instead of an error, without
+// branching on which check failed, so that the handshake only fails at the
+// Finished message. This prevents Bleichenbacher style padding oracles.
+//
+// https://tools.ietf.org/html/rfc5246#section-7.4.7.1
+func RSADecryptPreMasterSecret(rand io.Reader, privateKey crypto.Decrypter, encrypted []byte, clientVersion protocol.Version) ([]byte, error) {
+	randomPreMasterSecret := make([]byte, rsaPreMasterSecretLength)
+	if _, err := io.ReadFull(rand, randomPreMasterSecret); err != nil {
+		return nil, err
+	}
+
+	preMasterSecret, err := privateKey.Decrypt(rand, encrypted, &rsa.PKCS1v15DecryptOptions{SessionKeyLen: rsaPreMasterSecretLength})
+	if err != nil {
+		return nil, err
+	}
+	if len(preMasterSecret) != rsaPreMasterSecretLength {
+		return randomPreMasterSecret, nil
+	}
+
+	versionMatches := subtle.ConstantTimeByteEq(preMasterSecret[0], clientVersion.Major) &
+		subtle.ConstantTimeByteEq(preMasterSecret[1], clientVersion.Minor)
+	subtle.ConstantTimeCopy(1-versionMatches, preMasterSecret, randomPreMasterSecret)
+	return preMasterSecret, nil
+}
+
+// DHPreMasterSecret implements TLS 1.2 Premaster Secret generation for the
+// finite field Diffie-Hellman key exchange given the peer's public value and
+// our keypair. Leading zero bytes of the shared secret are stripped.
+//
+// https://tools.ietf.org/html/rfc5246#section-8.1.2
+func DHPreMasterSecret(publicKey []byte, keypair *ffdhe.Keypair) ([]byte, error) {
+	if err := ffdhe.ValidatePublicKey(keypair.P, publicKey); err != nil {
+		return nil, &protocol.FatalError{Err: err}
+	}
+
+	y := new(big.Int).SetBytes(publicKey)
+	x := new(big.Int).SetBytes(keypair.PrivateKey)
+	return new(big.Int).Exp(y, x, keypair.P).Bytes(), nil
+}
+
 func ellipticCurvePreMasterSecret(publicKey, privateKey []byte, c1, c2 ellipticStdlib.Curve) ([]byte, error) {
 	x, y := ellipticStdlib.Unmarshal(c1, publicKey)
 	if x == nil || y == nil {
@@ -184,24 +278,35 @@ func PHash(secret, seed []byte, requestedLength int, h HashFunc) ([]byte, error)
 	return out[:requestedLength], nil
 }
 
+// PRF is the TLS 1.2 pseudorandom function PRF(secret, label, seed), which
+// expands secret with P_hash over the concatenation of label and seed. It
+// can be used to derive further keys from a DTLS session, e.g. from its
+// master secret.
+//
+// https://tools.ietf.org/html/rfc5246#section-5
+func PRF(secret []byte, label string, seed []byte, requestedLength int, h HashFunc) ([]byte, error) {
+	labelAndSeed := make([]byte, 0, len(label)+len(seed))
+	labelAndSeed = append(append(labelAndSeed, label...), seed...)
+	return PHash(secret, labelAndSeed, requestedLength, h)
+}
+
 // ExtendedMasterSecret generates a Extended MasterSecret as defined in
 // https://tools.ietf.org/html/rfc7627
 func ExtendedMasterSecret(preMasterSecret, sessionHash []byte, h HashFunc) ([]byte, error) {
-	seed := append([]byte(extendedMasterSecretLabel), sessionHash...)
-	return PHash(preMasterSecret, seed, 48, h)
+	return PRF(preMasterSecret, extendedMasterSecretLabel, sessionHash, 48, h)
 }
 
 // MasterSecret generates a TLS 1.2 MasterSecret
 func MasterSecret(preMasterSecret, clientRandom, serverRandom []byte, h HashFunc) ([]byte, error) {
-	seed := append(append([]byte(masterSecretLabel), clientRandom...), serverRandom...)
-	return PHash(preMasterSecret, seed, 48, h)
+	seed := append(append([]byte{}, clientRandom...), serverRandom...)
+	return PRF(preMasterSecret, masterSecretLabel, seed, 48, h)
 }
 
 // GenerateEncryptionKeys is the final step TLS 1.2 PRF. Given all state generated so far generates
 // the final keys need for encryption
 func GenerateEncryptionKeys(masterSecret, clientRandom, serverRandom []byte, macLen, keyLen, ivLen int, h HashFunc) (*EncryptionKeys, error) {
-	seed := append(append([]byte(keyExpansionLabel), serverRandom...), clientRandom...)
-	keyMaterial, err := PHash(masterSecret, seed, (2*macLen)+(2*keyLen)+(2*ivLen), h)
+	seed := append(append([]byte{}, serverRandom...), clientRandom...)
+	keyMaterial, err := PRF(masterSecret, keyExpansionLabel, seed, (2*macLen)+(2*keyLen)+(2*ivLen), h)
 	if err != nil {
 		return nil, err
 	}
@@ -240,8 +345,7 @@ func prfVerifyData(masterSecret, handshakeBodies []byte, label string, hashFunc
 		return nil, err
 	}
 
-	seed := append([]byte(label), h.Sum(nil)...)
-	return PHash(masterSecret, seed, 12, hashFunc)
+	return PRF(masterSecret, label, h.Sum(nil), 12, hashFunc)
 }
 
 // VerifyDataClient is caled on the Client Side to either verify or generate the VerifyData message
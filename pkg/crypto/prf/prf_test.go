@@ -5,11 +5,19 @@ package prf
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"math/big"
 	"reflect"
 	"testing"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
 )
 
 func TestPreMasterSecret(t *testing.T) {
@@ -25,6 +33,90 @@ func TestPreMasterSecret(t *testing.T) {
 	}
 }
 
+func TestX448PreMasterSecret(t *testing.T) {
+	// Test vectors from RFC 7748, Section 6.2.
+	alicePrivate, _ := hex.DecodeString("9a8f4925d1519f5775cf46b04b5800d4ee9ee8bae8bc5565d498c28dd9c9baf574a9419744897391006382a6f127ab1d9ac2d8c0a598726b")
+	bobPublic, _ := hex.DecodeString("3eb7a829b0cd20f5bcfc0b599b6feccf6da4627107bdb0d4f345b43027d8b972fc3e34fb4232a13ca706dcb57aec3dae07bdc1c67bf33609")
+	expectedPreMasterSecret, _ := hex.DecodeString("07fff4181ac6cc95ec1c16a94a0f74d12da232ce40a77552281d282bb60c0b56fd2464c335543936521c24403085d59a449a5037514a879d")
+
+	preMasterSecret, err := PreMasterSecret(bobPublic, alicePrivate, elliptic.X448)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(expectedPreMasterSecret, preMasterSecret) {
+		t.Fatalf("PremasterSecret exp: % 02x actual: % 02x", expectedPreMasterSecret, preMasterSecret)
+	}
+
+	// u = 0 is a point of low order.
+	if _, err := PreMasterSecret(make([]byte, len(bobPublic)), alicePrivate, elliptic.X448); !errors.Is(err, errX448LowOrderPoint) {
+		t.Fatalf("Expected error %v, got %v", errX448LowOrderPoint, err)
+	}
+	if _, err := PreMasterSecret(bobPublic[1:], alicePrivate, elliptic.X448); !errors.Is(err, errInvalidX448Key) {
+		t.Fatalf("Expected error %v, got %v", errInvalidX448Key, err)
+	}
+}
+
+func TestRSADecryptPreMasterSecret(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	preMasterSecret, encrypted, err := RSAPreMasterSecret(rand.Reader, &privateKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := RSADecryptPreMasterSecret(rand.Reader, privateKey, encrypted, protocol.Version1_2)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(preMasterSecret, decrypted) {
+		t.Fatalf("PremasterSecret exp: % 02x actual: % 02x", preMasterSecret, decrypted)
+	}
+
+	// A secret that does not start with the version of the ClientHello is
+	// replaced by a random one, as is one with invalid padding.
+	rolledBack := append([]byte{protocol.Version1_0.Major, protocol.Version1_0.Minor}, preMasterSecret[2:]...)
+	rolledBackEncrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &privateKey.PublicKey, rolledBack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalidPadding := append([]byte{}, encrypted...)
+	invalidPadding[len(invalidPadding)-1] ^= 0xff
+	for name, test := range map[string]struct {
+		encrypted     []byte
+		clientVersion protocol.Version
+	}{
+		"VersionMismatch":  {encrypted, protocol.Version1_0},
+		"RolledBackSecret": {rolledBackEncrypted, protocol.Version1_2},
+		"InvalidPadding":   {invalidPadding, protocol.Version1_2},
+	} {
+		decrypted, err := RSADecryptPreMasterSecret(rand.Reader, privateKey, test.encrypted, test.clientVersion)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(decrypted) != rsaPreMasterSecretLength {
+			t.Errorf("%s: PremasterSecret length exp: %d actual: %d", name, rsaPreMasterSecretLength, len(decrypted))
+		}
+		if bytes.Equal(decrypted, preMasterSecret) || bytes.Equal(decrypted, rolledBack) {
+			t.Errorf("%s: Expected a random PremasterSecret, got the encrypted one", name)
+		}
+	}
+}
+
+func TestDHPreMasterSecret(t *testing.T) {
+	keypair := &ffdhe.Keypair{P: big.NewInt(23), G: big.NewInt(5), PrivateKey: []byte{6}}
+
+	preMasterSecret, err := DHPreMasterSecret([]byte{19}, keypair)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal([]byte{2}, preMasterSecret) {
+		t.Fatalf("PremasterSecret exp: 02 actual: % 02x", preMasterSecret)
+	}
+
+	if _, err := DHPreMasterSecret([]byte{22}, keypair); err == nil {
+		t.Fatal("Expected an error for the public value p-1")
+	}
+}
+
 func TestMasterSecret(t *testing.T) {
 	preMasterSecret := []byte{0xdf, 0x4a, 0x29, 0x1b, 0xaa, 0x1e, 0xb7, 0xcf, 0xa6, 0x93, 0x4b, 0x29, 0xb4, 0x74, 0xba, 0xad, 0x26, 0x97, 0xe2, 0x9f, 0x1f, 0x92, 0x0d, 0xcc, 0x77, 0xc8, 0xa0, 0xa0, 0x88, 0x44, 0x76, 0x24}
 	clientRandom := []byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x15, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x1b, 0x1c, 0x1d, 0x1e, 0x1f}
@@ -80,4 +172,54 @@ func TestVerifyData(t *testing.T) {
 	} else if !bytes.Equal(expectedVerifyData, verifyData) {
 		t.Fatalf("verifyData exp: %q actual: %q", expectedVerifyData, verifyData)
 	}
+
+	// The server Finished additionally covers the client Finished.
+	clientFinished := append([]byte{0x14, 0x00, 0x00, 0x0c}, verifyData...)
+	expectedVerifyData = []byte{0x84, 0x4d, 0x3c, 0x10, 0x74, 0x6d, 0xd7, 0x22, 0xf9, 0x2f, 0x0c, 0x7e}
+	verifyData, err = VerifyDataServer(masterSecret, append(finalMsg, clientFinished...), sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	} else if !bytes.Equal(expectedVerifyData, verifyData) {
+		t.Fatalf("server verifyData exp: %q actual: %q", expectedVerifyData, verifyData)
+	}
+}
+
+func TestPRF(t *testing.T) {
+	for name, tt := range map[string]struct {
+		hash     HashFunc
+		secret   string
+		seed     string
+		expected string
+	}{
+		"SHA256": {
+			hash:     sha256.New,
+			secret:   "9bbe436ba940f017b17652849a71db35",
+			seed:     "a0ba9f936cda311827a6f796ffd5198c",
+			expected: "e3f229ba727be17b8d122620557cd453c2aab21d07c3d495329b52d4e61edb5a6b301791e90d35c9c9a46b4e14baf9af0fa022f7077def17abfd3797c0564bab4fbc91666e9def9b97fce34f796789baa48082d122ee42c5a72e5a5110fff70187347b66",
+		},
+		"SHA384": {
+			hash:     sha512.New384,
+			secret:   "b80b733d6ceefcdc71566ea48e5567df",
+			seed:     "cd665cf6a8447dd6ff8b27555edb7465",
+			expected: "7b0c18e9ced410ed1804f2cfa34a336a1c14dffb4900bb5fd7942107e81c83cde9ca0faa60be9fe34f82b1233c9146a0e534cb400fed2700884f9dc236f80edd8bfa961144c9e8d792eca722a7b32fc3d416d473ebc2c5fd4abfdad05d9184259b5bf8cd4d90fa0d31e2dec479e4f1a26066f2eea9a69236a3e52655c9e9aee691c8f3a26854308d5eaa3be85e0990703d73e56f",
+		},
+	} {
+		secret, _ := hex.DecodeString(tt.secret)
+		seed, _ := hex.DecodeString(tt.seed)
+		expected, _ := hex.DecodeString(tt.expected)
+
+		out, err := PHash(secret, append([]byte("test label"), seed...), len(expected), tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(expected, out) {
+			t.Errorf("%s: PHash exp: % 02x actual: % 02x", name, expected, out)
+		}
+
+		out, err = PRF(secret, "test label", seed, len(expected), tt.hash)
+		if err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(expected, out) {
+			t.Errorf("%s: PRF exp: % 02x actual: % 02x", name, expected, out)
+		}
+	}
 }
@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	idtlsnet "github.com/censys-oss/dtls/v2/internal/net"
+)
+
+// LossOptions configures the impairments LossyConn applies to the datagrams
+// of a connection. Loss, duplication and reordering apply to the datagrams
+// written; wrap both ends of a connection to impair both directions.
+type LossOptions struct {
+	// Loss is the probability, from 0 to 1, that a datagram is dropped.
+	Loss float64
+
+	// Duplicate is the probability, from 0 to 1, that a datagram is sent
+	// twice.
+	Duplicate float64
+
+	// Reorder is the probability, from 0 to 1, that a datagram is held back
+	// and sent after up to ReorderWindow, but at least one, later
+	// datagrams.
+	Reorder float64
+	// ReorderWindow is the largest number of datagrams a datagram held back
+	// is sent after. It defaults to 1 if Reorder is set.
+	ReorderWindow int
+
+	// WriteLatency delays the datagrams written, and ReadLatency the
+	// datagrams read.
+	WriteLatency, ReadLatency time.Duration
+
+	// Seed seeds the random source of Loss, Duplicate and Reorder, so that
+	// the same writes are impaired the same way.
+	Seed int64
+}
+
+// LossyConn wraps conn and impairs its datagrams according to options. It
+// allows testing how a Client and a Server recover from lost, duplicated
+// and reordered datagrams.
+//
+// Datagrams held back for reordering are only sent by later writes, which
+// the retransmissions of DTLS eventually provide.
+func LossyConn(conn net.PacketConn, options LossOptions) net.PacketConn {
+	if options.Reorder > 0 && options.ReorderWindow < 1 {
+		options.ReorderWindow = 1
+	}
+	c := &lossyConn{
+		PacketConn: conn,
+		options:    options,
+		rand:       rand.New(rand.NewSource(options.Seed)), //nolint:gosec
+	}
+	if options.WriteLatency > 0 {
+		c.writeQueue = newDelayQueue(options.WriteLatency, func(b []byte, addr net.Addr) {
+			_, _ = conn.WriteTo(b, addr)
+		})
+	}
+	if options.ReadLatency > 0 {
+		c.readBuffer = idtlsnet.NewPacketBuffer()
+		c.readQueue = newDelayQueue(options.ReadLatency, func(b []byte, addr net.Addr) {
+			_, _ = c.readBuffer.WriteTo(b, addr)
+		})
+		c.readErr = make(chan error, 1)
+		go c.readLoop()
+	}
+	return c
+}
+
+type lossyConn struct {
+	net.PacketConn
+	options LossOptions
+
+	mu   sync.Mutex
+	rand *rand.Rand
+	held []heldDatagram
+
+	writeQueue *delayQueue
+
+	readQueue  *delayQueue
+	readBuffer *idtlsnet.PacketBuffer
+	readErr    chan error
+}
+
+type heldDatagram struct {
+	data  []byte
+	addr  net.Addr
+	after int
+}
+
+// WriteTo impairs b and writes what remains of it to the wrapped
+// connection.
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	if c.options.Loss > 0 && c.rand.Float64() < c.options.Loss {
+		c.mu.Unlock()
+		return len(b), nil
+	}
+	copies := 1
+	if c.options.Duplicate > 0 && c.rand.Float64() < c.options.Duplicate {
+		copies = 2
+	}
+
+	var datagrams []heldDatagram
+	if c.options.Reorder > 0 && c.rand.Float64() < c.options.Reorder {
+		after := 1 + c.rand.Intn(c.options.ReorderWindow)
+		for i := 0; i < copies; i++ {
+			c.held = append(c.held, heldDatagram{append([]byte{}, b...), addr, after})
+		}
+	} else {
+		for i := 0; i < copies; i++ {
+			datagrams = append(datagrams, heldDatagram{b, addr, 0})
+		}
+		// Send the datagrams held back that this one was the last to
+		// overtake.
+		held := c.held[:0]
+		for _, h := range c.held {
+			if h.after--; h.after > 0 {
+				held = append(held, h)
+			} else {
+				datagrams = append(datagrams, h)
+			}
+		}
+		c.held = held
+	}
+	c.mu.Unlock()
+
+	for _, d := range datagrams {
+		if c.writeQueue != nil {
+			c.writeQueue.push(append([]byte{}, d.data...), d.addr)
+			continue
+		}
+		if _, err := c.PacketConn.WriteTo(d.data, d.addr); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads a datagram from the wrapped connection, delayed by
+// ReadLatency.
+func (c *lossyConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if c.readBuffer == nil {
+		return c.PacketConn.ReadFrom(b)
+	}
+	n, addr, err := c.readBuffer.ReadFrom(b)
+	if errors.Is(err, io.EOF) {
+		// The read loop has stopped, report why.
+		select {
+		case err = <-c.readErr:
+			c.readErr <- err
+		default:
+		}
+	}
+	return n, addr, err
+}
+
+func (c *lossyConn) readLoop() {
+	b := make([]byte, 1<<16)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			c.readErr <- err
+			c.readQueue.close(func() {
+				_ = c.readBuffer.Close()
+			})
+			return
+		}
+		c.readQueue.push(append([]byte{}, b[:n]...), addr)
+	}
+}
+
+// SetDeadline sets the deadlines of the wrapped connection, or of the
+// delayed datagrams if ReadLatency is set.
+func (c *lossyConn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.PacketConn.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the wrapped connection, or of
+// the delayed datagrams if ReadLatency is set.
+func (c *lossyConn) SetReadDeadline(t time.Time) error {
+	if c.readBuffer != nil {
+		return c.readBuffer.SetReadDeadline(t)
+	}
+	return c.PacketConn.SetReadDeadline(t)
+}
+
+// delayQueue delays datagrams by a fixed latency and delivers them in
+// order, with a single timer for the oldest.
+type delayQueue struct {
+	latency time.Duration
+	deliver func([]byte, net.Addr)
+
+	mu      sync.Mutex
+	queue   []delayedDatagram
+	onClose func()
+}
+
+type delayedDatagram struct {
+	at   time.Time
+	data []byte
+	addr net.Addr
+}
+
+func newDelayQueue(latency time.Duration, deliver func([]byte, net.Addr)) *delayQueue {
+	return &delayQueue{latency: latency, deliver: deliver}
+}
+
+// push queues b, which must not be modified afterwards.
+func (q *delayQueue) push(b []byte, addr net.Addr) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.queue = append(q.queue, delayedDatagram{time.Now().Add(q.latency), b, addr})
+	if len(q.queue) == 1 {
+		time.AfterFunc(q.latency, q.flush)
+	}
+}
+
+// close calls f once the queued datagrams have been delivered.
+func (q *delayQueue) close(f func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.queue) == 0 {
+		f()
+		return
+	}
+	q.onClose = f
+}
+
+// flush delivers the datagrams that are due, and schedules itself for the
+// next one.
+func (q *delayQueue) flush() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for len(q.queue) > 0 && !q.queue[0].at.After(now) {
+		q.deliver(q.queue[0].data, q.queue[0].addr)
+		q.queue = q.queue[1:]
+	}
+	switch {
+	case len(q.queue) > 0:
+		time.AfterFunc(q.queue[0].at.Sub(now), q.flush)
+	case q.onClose != nil:
+		q.onClose()
+		q.onClose = nil
+	}
+}
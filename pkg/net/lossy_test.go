@@ -0,0 +1,173 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestLossyConn(t *testing.T) {
+	options := dtlsnet.LossOptions{
+		Loss:          0.2,
+		Duplicate:     0.2,
+		Reorder:       0.2,
+		ReorderWindow: 3,
+		ReadLatency:   time.Millisecond,
+		Seed:          1,
+	}
+
+	run := func() []int {
+		ca, cb := dtlsnet.Pipe(nil)
+		lossy := dtlsnet.LossyConn(ca, options)
+		peer := dtlsnet.LossyConn(cb, options)
+		defer func() {
+			_ = lossy.Close()
+			_ = peer.Close()
+		}()
+
+		for i := 0; i < 100; i++ {
+			if _, err := lossy.WriteTo([]byte{byte(i)}, cb.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+		var received []int
+		buf := make([]byte, 8)
+		for {
+			n, _, err := peer.ReadFrom(buf)
+			if err != nil {
+				return received
+			}
+			received = append(received, int(buf[:n][0]))
+		}
+	}
+
+	received := run()
+	if again := run(); !reflect.DeepEqual(received, again) {
+		t.Fatalf("The same seed impaired the datagrams differently:\n%v\n%v", received, again)
+	}
+
+	seen := map[int]int{}
+	reordered := false
+	for i, d := range received {
+		seen[d]++
+		if i > 0 && d < received[i-1] {
+			reordered = true
+		}
+	}
+	duplicated := false
+	for _, n := range seen {
+		if n > 1 {
+			duplicated = true
+		}
+	}
+	if len(seen) == 0 || len(seen) >= 100 || !duplicated || !reordered {
+		t.Errorf("Expected lost, duplicated and reordered datagrams, got %v", received)
+	}
+}
+
+func TestLossyConnHandshake(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(20 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &dtls.Config{
+		Certificates:       []tls.Certificate{certificate},
+		InsecureSkipVerify: true,
+		FlightInterval:     50 * time.Millisecond,
+		// Fragment the handshake messages, so that their fragments have to
+		// be reassembled in spite of the impairments.
+		MTU: 200,
+	}
+
+	ca, cb := dtlsnet.Pipe(nil)
+	clientConn := dtlsnet.LossyConn(ca, dtlsnet.LossOptions{
+		Duplicate:     0.3,
+		Reorder:       0.3,
+		ReorderWindow: 3,
+		WriteLatency:  time.Millisecond,
+		Seed:          1,
+	})
+	serverConn := dtlsnet.LossyConn(cb, dtlsnet.LossOptions{
+		Loss:        0.2,
+		Duplicate:   0.3,
+		Reorder:     0.3,
+		ReadLatency: time.Millisecond,
+		Seed:        2,
+	})
+
+	type result struct {
+		c   *dtls.Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		client, err := dtls.ClientWithContext(ctx, clientConn, cb.LocalAddr(), config)
+		c <- result{client, err}
+	}()
+
+	server, err := dtls.ServerWithContext(ctx, serverConn, ca.LocalAddr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		t.Fatal(res.err)
+	}
+	defer func() {
+		_ = server.Close()
+		_ = res.c.Close()
+	}()
+
+	for i := 0; i < 20; i++ {
+		if _, err = res.c.Write([]byte(fmt.Sprintf("message %d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = server.SetReadDeadline(time.Now().Add(200 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 32)
+	for {
+		if _, err = server.Read(buf); err != nil {
+			break
+		}
+	}
+
+	// Duplicated records are caught by replay protection.
+	var duplicates uint64
+	for _, r := range server.Stats().Replay {
+		duplicates += r.DroppedDuplicate
+	}
+	if duplicates == 0 {
+		t.Error("Expected duplicated records to be dropped")
+	}
+	if res.c.Stats().RetransmittedFlights == 0 {
+		t.Error("Expected lost flights to be retransmitted")
+	}
+}
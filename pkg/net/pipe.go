@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	idtlsnet "github.com/censys-oss/dtls/v2/internal/net"
+)
+
+// PipeConfig configures the impairments of the datagrams sent through a pipe
+// created by Pipe. The zero value is a lossless pipe without latency that
+// delivers datagrams in order.
+type PipeConfig struct {
+	// LossOptions impair the datagrams written to either end of the pipe as
+	// with LossyConn. Each end draws from its own random source seeded with
+	// Seed, and ReadLatency is added on top of WriteLatency.
+	LossOptions
+
+	// Drop, if set, is called for every datagram written to the end i of
+	// the pipe, with n the number of datagrams written to that end before,
+	// and drops the datagram if it returns true. It allows dropping specific
+	// datagrams, e.g. a flight, in addition to Loss.
+	Drop func(i, n int, b []byte) bool
+}
+
+// PipeAddr is the address of an end of a pipe created by Pipe.
+type PipeAddr int
+
+// Network returns "pipe".
+func (a PipeAddr) Network() string {
+	return "pipe"
+}
+
+func (a PipeAddr) String() string {
+	if a == 0 {
+		return "pipe0"
+	}
+	return "pipe1"
+}
+
+// Pipe creates two connected in-memory net.PacketConns, at the addresses
+// PipeAddr(0) and PipeAddr(1), whose datagrams are impaired according to
+// config, which may be nil. It allows testing a Client and a Server against
+// each other, including their retransmissions, without sockets.
+func Pipe(config *PipeConfig) (net.PacketConn, net.PacketConn) {
+	if config == nil {
+		config = &PipeConfig{}
+	}
+
+	p := &pipe{}
+	var ends [2]net.PacketConn
+	for i := range p.ends {
+		p.ends[i] = &pipeConn{pipe: p, i: i, buffer: idtlsnet.NewPacketBuffer()}
+		ends[i] = LossyConn(p.ends[i], config.LossOptions)
+		if config.Drop != nil {
+			ends[i] = &dropConn{PacketConn: ends[i], i: i, drop: config.Drop}
+		}
+	}
+	return ends[0], ends[1]
+}
+
+type pipe struct {
+	ends [2]*pipeConn
+}
+
+// pipeConn is an end of a lossless pipe and implements net.PacketConn.
+type pipeConn struct {
+	pipe   *pipe
+	i      int
+	buffer *idtlsnet.PacketBuffer
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// ReadFrom reads a datagram written to the other end of the pipe.
+func (c *pipeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.buffer.ReadFrom(b)
+}
+
+// WriteTo sends a datagram to the other end of the pipe, regardless of addr.
+func (c *pipeConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	c.mu.Lock()
+	closed := c.closed
+	c.mu.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	c.deliver(append([]byte{}, b...))
+	return len(b), nil
+}
+
+// deliver hands b to the other end of the pipe.
+func (c *pipeConn) deliver(b []byte) {
+	// The peer may have been closed, which drops the datagram as with an
+	// unreachable host.
+	_, _ = c.pipe.ends[1-c.i].buffer.WriteTo(b, PipeAddr(c.i))
+}
+
+// Close closes the end of the pipe. Reads from it return io.EOF once the
+// datagrams already delivered have been read.
+func (c *pipeConn) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	return c.buffer.Close()
+}
+
+// LocalAddr returns the address of the end of the pipe.
+func (c *pipeConn) LocalAddr() net.Addr {
+	return PipeAddr(c.i)
+}
+
+// SetDeadline sets the read deadline. Writes never block.
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline.
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	return c.buffer.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing as writes never block.
+func (c *pipeConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// dropConn drops the datagrams written to the end i of a pipe for which
+// drop returns true.
+type dropConn struct {
+	net.PacketConn
+	i    int
+	drop func(i, n int, b []byte) bool
+
+	mu      sync.Mutex
+	written int
+}
+
+// WriteTo writes b unless drop returns true for it.
+func (c *dropConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	n := c.written
+	c.written++
+	c.mu.Unlock()
+	if c.drop(c.i, n, b) {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
@@ -0,0 +1,149 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net_test
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestPipe(t *testing.T) {
+	for name, tt := range map[string]struct {
+		config   *dtlsnet.PipeConfig
+		expected []string
+	}{
+		"Lossless": {
+			expected: []string{"a", "b", "c", "d"},
+		},
+		"Drop": {
+			config: &dtlsnet.PipeConfig{
+				Drop: func(i, n int, _ []byte) bool { return i == 0 && n%2 == 1 },
+			},
+			expected: []string{"a", "c"},
+		},
+		"Reorder": {
+			config:   &dtlsnet.PipeConfig{LossOptions: dtlsnet.LossOptions{Reorder: 0.5, Seed: 6}},
+			expected: []string{"b", "a", "d", "c"},
+		},
+		"Latency": {
+			config:   &dtlsnet.PipeConfig{LossOptions: dtlsnet.LossOptions{WriteLatency: 10 * time.Millisecond}},
+			expected: []string{"a", "b", "c", "d"},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ca, cb := dtlsnet.Pipe(tt.config)
+			defer func() {
+				_ = ca.Close()
+				_ = cb.Close()
+			}()
+
+			start := time.Now()
+			for _, d := range []string{"a", "b", "c", "d"} {
+				if _, err := ca.WriteTo([]byte(d), cb.LocalAddr()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := cb.SetReadDeadline(time.Now().Add(100 * time.Millisecond)); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 8)
+			for _, expected := range tt.expected {
+				n, addr, err := cb.ReadFrom(buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(buf[:n]) != expected || addr != ca.LocalAddr() {
+					t.Errorf("Expected %q from %v, got %q from %v", expected, ca.LocalAddr(), buf[:n], addr)
+				}
+			}
+			if tt.config != nil && time.Since(start) < tt.config.WriteLatency {
+				t.Errorf("Datagrams delivered before the latency of %v", tt.config.WriteLatency)
+			}
+			if _, _, err := cb.ReadFrom(buf); err == nil {
+				t.Errorf("Unexpected datagram %q", buf)
+			}
+		})
+	}
+
+	t.Run("Close", func(t *testing.T) {
+		ca, cb := dtlsnet.Pipe(nil)
+		if err := cb.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := cb.ReadFrom(make([]byte, 8)); !errors.Is(err, io.EOF) {
+			t.Errorf("Expected %v, got %v", io.EOF, err)
+		}
+		if err := ca.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ca.WriteTo([]byte("a"), cb.LocalAddr()); !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("Expected %v, got %v", io.ErrClosedPipe, err)
+		}
+	})
+}
+
+func TestPipeHandshake(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(20 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &dtls.Config{
+		Certificates:       []tls.Certificate{certificate},
+		InsecureSkipVerify: true,
+		FlightInterval:     50 * time.Millisecond,
+	}
+
+	// Lose the first flight of the server and impair the rest.
+	ca, cb := dtlsnet.Pipe(&dtlsnet.PipeConfig{
+		LossOptions: dtlsnet.LossOptions{
+			Loss:         0.1,
+			Reorder:      0.2,
+			WriteLatency: time.Millisecond,
+		},
+		Drop: func(i, n int, _ []byte) bool { return i == 1 && n == 0 },
+	})
+
+	type result struct {
+		c   *dtls.Conn
+		err error
+	}
+	c := make(chan result)
+	go func() {
+		client, err := dtls.ClientWithContext(ctx, ca, cb.LocalAddr(), config)
+		c <- result{client, err}
+	}()
+
+	server, err := dtls.ServerWithContext(ctx, cb, ca.LocalAddr(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := <-c
+	if res.err != nil {
+		_ = server.Close()
+		t.Fatal(res.err)
+	}
+	_ = server.Close()
+	_ = res.c.Close()
+}
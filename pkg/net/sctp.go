@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+var errSCTPDeadlineUnsupported = errors.New("SCTP stream does not support deadlines")
+
+// SCTPStream is a stream of an SCTP association, such as a *sctp.Stream of
+// github.com/pion/sctp. Each Write must send a single SCTP user message and
+// each Read must return a single one, so that DTLS records keep their
+// boundaries as over UDP.
+type SCTPStream interface {
+	io.ReadWriteCloser
+
+	// StreamIdentifier returns the SCTP stream identifier.
+	StreamIdentifier() uint16
+}
+
+// SCTPAddr is the address of a stream of an SCTP association, as returned
+// by the net.PacketConn of PacketConnFromSCTP.
+type SCTPAddr struct {
+	// Addr is the address of the association, or nil if not known.
+	Addr net.Addr
+
+	// StreamIdentifier is the SCTP stream identifier.
+	StreamIdentifier uint16
+}
+
+// Network returns "sctp".
+func (a *SCTPAddr) Network() string {
+	return "sctp"
+}
+
+func (a *SCTPAddr) String() string {
+	if a.Addr == nil {
+		return fmt.Sprintf("stream %d", a.StreamIdentifier)
+	}
+	return fmt.Sprintf("%s/stream %d", a.Addr, a.StreamIdentifier)
+}
+
+// PacketConnFromSCTP converts a stream of an SCTP association into a
+// net.PacketConn, so that DTLS can be run over SCTP with each record sent
+// as a single user message. localAddr and remoteAddr are the addresses of
+// the association and may be nil.
+//
+// This is not DTLS over SCTP as specified by RFC 6083: SCTP-AUTH is not
+// used, the whole connection runs over a single stream, and DTLS keeps its
+// own retransmissions and replay detection. A reliable, ordered stream,
+// the default of github.com/pion/sctp, is recommended; DTLS retransmissions
+// are then redundant, and a long Config.FlightInterval avoids most of them.
+// Since DTLS recovers from lost records, unreliable streams work as well.
+//
+// Deadlines are passed on to the stream if it implements SetReadDeadline
+// and SetWriteDeadline, and return an error otherwise.
+func PacketConnFromSCTP(stream SCTPStream, localAddr, remoteAddr net.Addr) net.PacketConn {
+	id := stream.StreamIdentifier()
+	return &sctpPacketConn{
+		stream:     stream,
+		localAddr:  &SCTPAddr{Addr: localAddr, StreamIdentifier: id},
+		remoteAddr: &SCTPAddr{Addr: remoteAddr, StreamIdentifier: id},
+	}
+}
+
+// sctpPacketConn wraps an SCTPStream and implements net.PacketConn.
+type sctpPacketConn struct {
+	stream     SCTPStream
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+// ReadFrom reads a user message from the stream and returns the remote
+// address of the stream.
+func (s *sctpPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := s.stream.Read(b)
+	return n, s.remoteAddr, err
+}
+
+// WriteTo writes b as a user message to the stream.
+func (s *sctpPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return s.stream.Write(b)
+}
+
+// Close closes the stream.
+func (s *sctpPacketConn) Close() error {
+	return s.stream.Close()
+}
+
+// LocalAddr returns the local address of the stream.
+func (s *sctpPacketConn) LocalAddr() net.Addr {
+	return s.localAddr
+}
+
+// SetDeadline sets the read and write deadlines of the stream.
+func (s *sctpPacketConn) SetDeadline(t time.Time) error {
+	if err := s.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return s.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the stream.
+func (s *sctpPacketConn) SetReadDeadline(t time.Time) error {
+	d, ok := s.stream.(interface{ SetReadDeadline(time.Time) error })
+	if !ok {
+		return errSCTPDeadlineUnsupported
+	}
+	return d.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the stream.
+func (s *sctpPacketConn) SetWriteDeadline(t time.Time) error {
+	d, ok := s.stream.(interface{ SetWriteDeadline(time.Time) error })
+	if !ok {
+		return errSCTPDeadlineUnsupported
+	}
+	return d.SetWriteDeadline(t)
+}
@@ -0,0 +1,277 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package net_test
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	dtlsnet "github.com/censys-oss/dtls/v2/pkg/net"
+	"github.com/pion/logging"
+	"github.com/pion/sctp"
+	"github.com/pion/transport/v3/dpipe"
+	"github.com/pion/transport/v3/test"
+)
+
+// memSCTPStream is an in-memory stand-in for an SCTP stream. Like an
+// unreliable SCTP stream it keeps message boundaries, and it drops every
+// dropEvery-th message it writes.
+type memSCTPStream struct {
+	net.Conn
+	id        uint16
+	dropEvery int
+
+	mu      sync.Mutex
+	written int
+}
+
+func (s *memSCTPStream) StreamIdentifier() uint16 {
+	return s.id
+}
+
+func (s *memSCTPStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	s.written++
+	drop := s.dropEvery > 0 && s.written%s.dropEvery == 0
+	s.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return s.Conn.Write(b)
+}
+
+func newMemSCTPStreams(id uint16, dropEvery int) (*memSCTPStream, *memSCTPStream) {
+	ca, cb := dpipe.Pipe()
+	return &memSCTPStream{Conn: ca, id: id, dropEvery: dropEvery},
+		&memSCTPStream{Conn: cb, id: id, dropEvery: dropEvery}
+}
+
+func TestPacketConnFromSCTP(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(20 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	for name, dropEvery := range map[string]int{
+		"Reliable":   0,
+		"Unreliable": 3,
+	} {
+		dropEvery := dropEvery
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			certificate, err := selfsign.GenerateSelfSigned()
+			if err != nil {
+				t.Fatal(err)
+			}
+			config := &dtls.Config{
+				Certificates:       []tls.Certificate{certificate},
+				InsecureSkipVerify: true,
+				FlightInterval:     100 * time.Millisecond,
+			}
+
+			ca, cb := newMemSCTPStreams(1, dropEvery)
+			clientConn := dtlsnet.PacketConnFromSCTP(ca, nil, nil)
+			serverConn := dtlsnet.PacketConnFromSCTP(cb, nil, nil)
+
+			addr := &dtlsnet.SCTPAddr{StreamIdentifier: 1}
+			if actual := clientConn.LocalAddr(); actual.Network() != "sctp" || actual.String() != addr.String() {
+				t.Errorf("Unexpected local address: %v", actual)
+			}
+			if err = clientConn.SetDeadline(time.Time{}); err != nil {
+				t.Errorf("Deadlines are not passed on to the stream: %v", err)
+			}
+
+			type result struct {
+				c   *dtls.Conn
+				err error
+			}
+			c := make(chan result)
+			go func() {
+				client, err := dtls.ClientWithContext(ctx, clientConn, addr, config)
+				c <- result{client, err}
+			}()
+
+			server, err := dtls.ServerWithContext(ctx, serverConn, addr, config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := <-c
+			if res.err != nil {
+				_ = server.Close()
+				t.Fatal(res.err)
+			}
+			defer func() {
+				_ = server.Close()
+				_ = res.c.Close()
+			}()
+
+			if _, err = res.c.Write([]byte("over sctp")); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 16)
+			n, err := server.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "over sctp" {
+				t.Errorf("Unexpected application data: %q", buf[:n])
+			}
+		})
+	}
+}
+
+func TestPacketConnFromSCTPAssociation(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(20 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &dtls.Config{
+		Certificates:       []tls.Certificate{certificate},
+		InsecureSkipVerify: true,
+	}
+
+	// An SCTP association over an in-memory connection, whose streams are
+	// reliable and ordered by default.
+	ca, cb := dpipe.Pipe()
+	loggerFactory := logging.NewDefaultLoggerFactory()
+	type associationResult struct {
+		a   *sctp.Association
+		err error
+	}
+	serverAssociation := make(chan associationResult, 1)
+	go func() {
+		a, err := sctp.Server(sctp.Config{NetConn: cb, LoggerFactory: loggerFactory})
+		serverAssociation <- associationResult{a, err}
+	}()
+	client, err := sctp.Client(sctp.Config{NetConn: ca, LoggerFactory: loggerFactory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+	}()
+	server := <-serverAssociation
+	if server.err != nil {
+		t.Fatal(server.err)
+	}
+	defer func() {
+		_ = server.a.Close()
+	}()
+
+	stream, err := client.OpenStream(1, sctp.PayloadTypeWebRTCBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		c   *dtls.Conn
+		err error
+	}
+	c := make(chan result, 1)
+	go func() {
+		// The stream is accepted once the ClientHello arrives on it.
+		peer, err := server.a.AcceptStream()
+		if err != nil {
+			c <- result{nil, err}
+			return
+		}
+		conn, err := dtls.ServerWithContext(ctx, dtlsnet.PacketConnFromSCTP(peer, nil, nil), &dtlsnet.SCTPAddr{StreamIdentifier: 1}, config)
+		c <- result{conn, err}
+	}()
+
+	conn, err := dtls.ClientWithContext(ctx, dtlsnet.PacketConnFromSCTP(stream, nil, nil), &dtlsnet.SCTPAddr{StreamIdentifier: 1}, config)
+	res := <-c
+	if err != nil || res.err != nil {
+		if err == nil {
+			_ = conn.Close()
+		}
+		if res.err == nil {
+			_ = res.c.Close()
+		}
+		t.Fatalf("Unexpected handshake errors: client %v, server %v", err, res.err)
+	}
+	defer func() {
+		_ = conn.Close()
+		_ = res.c.Close()
+	}()
+
+	if _, err = conn.Write([]byte("over sctp")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := res.c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "over sctp" {
+		t.Errorf("Unexpected application data: %q", buf[:n])
+	}
+}
+
+func ExamplePacketConnFromSCTP() {
+	// The streams of an established SCTP association, e.g. one opened with
+	// (*sctp.Association).OpenStream of github.com/pion/sctp and the one
+	// accepted by the peer.
+	stream, peer := newMemSCTPStreams(0, 0)
+
+	certificate, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		panic(err)
+	}
+	config := &dtls.Config{
+		Certificates:       []tls.Certificate{certificate},
+		InsecureSkipVerify: true,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	go func() {
+		server, err := dtls.ServerWithContext(ctx, dtlsnet.PacketConnFromSCTP(peer, nil, nil), &dtlsnet.SCTPAddr{}, config)
+		if err != nil {
+			return
+		}
+		_, _ = server.Write([]byte("hello"))
+		_ = server.Close()
+	}()
+
+	conn, err := dtls.ClientWithContext(ctx, dtlsnet.PacketConnFromSCTP(stream, nil, nil), &dtlsnet.SCTPAddr{}, config)
+	if err != nil {
+		panic(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	buf := make([]byte, 16)
+	n, err := conn.Read(buf)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(string(buf[:n]))
+	// Output: hello
+}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// CertificateCompressionAlgorithm identifies an algorithm used to compress
+// the Certificate handshake message.
+//
+// https://datatracker.ietf.org/doc/html/rfc8879#section-7.3
+type CertificateCompressionAlgorithm uint16
+
+// CertificateCompressionAlgorithm enums
+const (
+	CertificateCompressionAlgorithmZlib   CertificateCompressionAlgorithm = 1
+	CertificateCompressionAlgorithmBrotli CertificateCompressionAlgorithm = 2
+	CertificateCompressionAlgorithmZstd   CertificateCompressionAlgorithm = 3
+)
+
+func (a CertificateCompressionAlgorithm) String() string {
+	switch a {
+	case CertificateCompressionAlgorithmZlib:
+		return "zlib"
+	case CertificateCompressionAlgorithmBrotli:
+		return "brotli"
+	case CertificateCompressionAlgorithmZstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// CompressCertificate is a TLS extension with which a client lists the
+// algorithms it can decompress, allowing the server to send its
+// certificate chain as a CompressedCertificate message.
+//
+// https://datatracker.ietf.org/doc/html/rfc8879#section-3
+type CompressCertificate struct {
+	Algorithms []CertificateCompressionAlgorithm
+}
+
+// TypeValue returns the extension TypeValue
+func (c CompressCertificate) TypeValue() TypeValue {
+	return CompressCertificateTypeValue
+}
+
+// Marshal encodes the extension
+func (c *CompressCertificate) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(c.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		b.AddUint8LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, a := range c.Algorithms {
+				b.AddUint16(uint16(a))
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (c *CompressCertificate) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+
+	var extension uint16
+	if !val.ReadUint16(&extension) {
+		return errBufferTooSmall
+	} else if TypeValue(extension) != c.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errBufferTooSmall
+	}
+
+	var algorithms cryptobyte.String
+	if !extData.ReadUint8LengthPrefixed(&algorithms) || !extData.Empty() || algorithms.Empty() || len(algorithms)%2 != 0 {
+		return errInvalidCompressCertificateFormat
+	}
+
+	c.Algorithms = nil
+	for !algorithms.Empty() {
+		var a uint16
+		algorithms.ReadUint16(&a)
+		c.Algorithms = append(c.Algorithms, CertificateCompressionAlgorithm(a))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompressCertificate(t *testing.T) {
+	raw := []byte{0x00, 0x1b, 0x00, 0x05, 0x04, 0x00, 0x02, 0x00, 0x01}
+	parsed := &CompressCertificate{
+		Algorithms: []CertificateCompressionAlgorithm{CertificateCompressionAlgorithmBrotli, CertificateCompressionAlgorithmZlib},
+	}
+
+	marshaled, err := parsed.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(marshaled, raw) {
+		t.Errorf("compressCertificate marshal: got %#v, want %#v", marshaled, raw)
+	}
+
+	roundtrip := &CompressCertificate{}
+	if err := roundtrip.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roundtrip, parsed) {
+		t.Errorf("compressCertificate unmarshal: got %#v, want %#v", roundtrip, parsed)
+	}
+
+	for name, raw := range map[string][]byte{
+		"EmptyList":    {0x00, 0x1b, 0x00, 0x01, 0x00},
+		"OddLength":    {0x00, 0x1b, 0x00, 0x04, 0x03, 0x00, 0x01, 0x00},
+		"Truncated":    {0x00, 0x1b, 0x00, 0x03, 0x04, 0x00, 0x01},
+		"TrailingData": {0x00, 0x1b, 0x00, 0x04, 0x02, 0x00, 0x01, 0x00},
+	} {
+		raw := raw
+		t.Run(name, func(t *testing.T) {
+			if err := (&CompressCertificate{}).Unmarshal(raw); !errors.Is(err, errInvalidCompressCertificateFormat) {
+				t.Errorf("Expected error %v, got %v", errInvalidCompressCertificateFormat, err)
+			}
+		})
+	}
+}
@@ -11,11 +11,17 @@ import (
 
 var (
 	// ErrALPNInvalidFormat is raised when the ALPN format is invalid
-	ErrALPNInvalidFormat    = &protocol.FatalError{Err: errors.New("invalid alpn format")}                             //nolint:goerr113
-	errALPNNoAppProto       = &protocol.FatalError{Err: errors.New("no application protocol")}                         //nolint:goerr113
-	errBufferTooSmall       = &protocol.TemporaryError{Err: errors.New("buffer is too small")}                         //nolint:goerr113
-	errInvalidExtensionType = &protocol.FatalError{Err: errors.New("invalid extension type")}                          //nolint:goerr113
-	errInvalidSNIFormat     = &protocol.FatalError{Err: errors.New("invalid server name format")}                      //nolint:goerr113
-	errInvalidCIDFormat     = &protocol.FatalError{Err: errors.New("invalid connection ID format")}                    //nolint:goerr113
-	errLengthMismatch       = &protocol.InternalError{Err: errors.New("data length and declared length do not match")} //nolint:goerr113
+	ErrALPNInvalidFormat                = &protocol.FatalError{Err: errors.New("invalid alpn format")}                             //nolint:goerr113
+	errALPNNoAppProto                   = &protocol.FatalError{Err: errors.New("no application protocol")}                         //nolint:goerr113
+	errBufferTooSmall                   = &protocol.TemporaryError{Err: errors.New("buffer is too small")}                         //nolint:goerr113
+	errInvalidExtensionType             = &protocol.FatalError{Err: errors.New("invalid extension type")}                          //nolint:goerr113
+	errInvalidSNIFormat                 = &protocol.FatalError{Err: errors.New("invalid server name format")}                      //nolint:goerr113
+	errInvalidCIDFormat                 = &protocol.FatalError{Err: errors.New("invalid connection ID format")}                    //nolint:goerr113
+	errInvalidHeartbeatMode             = &protocol.FatalError{Err: errors.New("invalid heartbeat mode")}                          //nolint:goerr113
+	errInvalidSCTFormat                 = &protocol.FatalError{Err: errors.New("invalid signed certificate timestamp format")}     //nolint:goerr113
+	errInvalidCompressCertificateFormat = &protocol.FatalError{Err: errors.New("invalid compress certificate format")}             //nolint:goerr113
+	errInvalidSupportedVersionsFormat   = &protocol.FatalError{Err: errors.New("invalid supported versions format")}               //nolint:goerr113
+	errInvalidTrustedCAKeysFormat       = &protocol.FatalError{Err: errors.New("invalid trusted CA keys format")}                  //nolint:goerr113
+	errInvalidRenegotiationInfoFormat   = &protocol.FatalError{Err: errors.New("invalid renegotiation info format")}               //nolint:goerr113
+	errLengthMismatch                   = &protocol.InternalError{Err: errors.New("data length and declared length do not match")} //nolint:goerr113
 )
@@ -14,12 +14,19 @@ type TypeValue uint16
 // TypeValue constants
 const (
 	ServerNameTypeValue                   TypeValue = 0
+	TrustedCAKeysTypeValue                TypeValue = 3
+	TruncatedHMACTypeValue                TypeValue = 4
+	StatusRequestTypeValue                TypeValue = 5
 	SupportedEllipticCurvesTypeValue      TypeValue = 10
 	SupportedPointFormatsTypeValue        TypeValue = 11
 	SupportedSignatureAlgorithmsTypeValue TypeValue = 13
 	UseSRTPTypeValue                      TypeValue = 14
+	HeartbeatTypeValue                    TypeValue = 15
 	ALPNTypeValue                         TypeValue = 16
+	SignedCertificateTimestampTypeValue   TypeValue = 18
 	UseExtendedMasterSecretTypeValue      TypeValue = 23
+	CompressCertificateTypeValue          TypeValue = 27
+	SupportedVersionsTypeValue            TypeValue = 43
 	ConnectionIDTypeValue                 TypeValue = 54
 	RenegotiationInfoTypeValue            TypeValue = 65281
 )
@@ -63,6 +70,12 @@ func Unmarshal(buf []byte) ([]Extension, error) {
 		switch TypeValue(binary.BigEndian.Uint16(buf[offset:])) {
 		case ServerNameTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &ServerName{})
+		case TrustedCAKeysTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &TrustedCAKeys{})
+		case TruncatedHMACTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &TruncatedHMAC{})
+		case StatusRequestTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &StatusRequest{})
 		case SupportedEllipticCurvesTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &SupportedEllipticCurves{})
 		case SupportedPointFormatsTypeValue:
@@ -71,10 +84,18 @@ func Unmarshal(buf []byte) ([]Extension, error) {
 			err = unmarshalAndAppend(buf[offset:], &SupportedSignatureAlgorithms{})
 		case UseSRTPTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &UseSRTP{})
+		case HeartbeatTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &Heartbeat{})
 		case ALPNTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &ALPN{})
+		case SignedCertificateTimestampTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &SignedCertificateTimestamp{})
 		case UseExtendedMasterSecretTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &UseExtendedMasterSecret{})
+		case CompressCertificateTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &CompressCertificate{})
+		case SupportedVersionsTypeValue:
+			err = unmarshalAndAppend(buf[offset:], &SupportedVersions{})
 		case RenegotiationInfoTypeValue:
 			err = unmarshalAndAppend(buf[offset:], &RenegotiationInfo{})
 		case ConnectionIDTypeValue:
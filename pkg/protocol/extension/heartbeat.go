@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import "encoding/binary"
+
+const (
+	heartbeatHeaderSize = 4
+)
+
+// HeartbeatMode indicates whether the sender of the Heartbeat extension
+// accepts HeartbeatRequest messages from its peer.
+type HeartbeatMode byte
+
+// HeartbeatMode enums
+const (
+	HeartbeatModePeerAllowedToSend    HeartbeatMode = 1
+	HeartbeatModePeerNotAllowedToSend HeartbeatMode = 2
+)
+
+// Heartbeat is a DTLS extension that negotiates the use of the heartbeat
+// protocol.
+//
+// https://tools.ietf.org/html/rfc6520#section-2
+type Heartbeat struct {
+	Mode HeartbeatMode
+}
+
+// TypeValue returns the extension TypeValue
+func (h Heartbeat) TypeValue() TypeValue {
+	return HeartbeatTypeValue
+}
+
+// Marshal encodes the extension
+func (h *Heartbeat) Marshal() ([]byte, error) {
+	out := make([]byte, heartbeatHeaderSize+1)
+
+	binary.BigEndian.PutUint16(out, uint16(h.TypeValue()))
+	binary.BigEndian.PutUint16(out[2:], uint16(1)) // length
+	out[heartbeatHeaderSize] = byte(h.Mode)
+	return out, nil
+}
+
+// Unmarshal populates the extension from encoded data
+func (h *Heartbeat) Unmarshal(data []byte) error {
+	if len(data) <= heartbeatHeaderSize {
+		return errBufferTooSmall
+	} else if TypeValue(binary.BigEndian.Uint16(data)) != h.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	switch mode := HeartbeatMode(data[heartbeatHeaderSize]); mode {
+	case HeartbeatModePeerAllowedToSend, HeartbeatModePeerNotAllowedToSend:
+		h.Mode = mode
+	default:
+		return errInvalidHeartbeatMode
+	}
+
+	return nil
+}
@@ -15,6 +15,13 @@ const (
 // https://tools.ietf.org/html/rfc5746
 type RenegotiationInfo struct {
 	RenegotiatedConnection uint8
+
+	// VerifyData is the renegotiated_connection sent when renegotiating:
+	// the verify_data of the client's Finished message of the previous
+	// handshake, followed by that of the server's if sent by a server. It is
+	// empty in an initial handshake, and takes precedence over
+	// RenegotiatedConnection if set.
+	VerifyData []byte
 }
 
 // TypeValue returns the extension TypeValue
@@ -24,12 +31,18 @@ func (r RenegotiationInfo) TypeValue() TypeValue {
 
 // Marshal encodes the extension
 func (r *RenegotiationInfo) Marshal() ([]byte, error) {
-	out := make([]byte, renegotiationInfoHeaderSize)
+	if len(r.VerifyData) > 255 {
+		return nil, errInvalidRenegotiationInfoFormat
+	}
+	out := make([]byte, renegotiationInfoHeaderSize, renegotiationInfoHeaderSize+len(r.VerifyData))
 
 	binary.BigEndian.PutUint16(out, uint16(r.TypeValue()))
-	binary.BigEndian.PutUint16(out[2:], uint16(1)) // length
+	binary.BigEndian.PutUint16(out[2:], uint16(1+len(r.VerifyData))) // length
 	out[4] = r.RenegotiatedConnection
-	return out, nil
+	if len(r.VerifyData) > 0 {
+		out[4] = uint8(len(r.VerifyData))
+	}
+	return append(out, r.VerifyData...), nil
 }
 
 // Unmarshal populates the extension from encoded data
@@ -42,5 +55,12 @@ func (r *RenegotiationInfo) Unmarshal(data []byte) error {
 
 	r.RenegotiatedConnection = data[4]
 
+	// Any verify_data follows the length in RenegotiatedConnection, within
+	// the length of the extension.
+	r.VerifyData = nil
+	if n := int(data[4]); n > 0 && int(binary.BigEndian.Uint16(data[2:])) == 1+n && len(data) >= renegotiationInfoHeaderSize+n {
+		r.VerifyData = append([]byte{}, data[renegotiationInfoHeaderSize:renegotiationInfoHeaderSize+n]...)
+	}
+
 	return nil
 }
@@ -0,0 +1,78 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// SignedCertificateTimestamp is a TLS extension with which a client asks
+// the server for the Signed Certificate Timestamps (SCTs) of its
+// certificate. The client sends it empty, and the server answers with the
+// list of SCTs.
+//
+// https://tools.ietf.org/html/rfc6962#section-3.3.1
+type SignedCertificateTimestamp struct {
+	SignedCertificateTimestamps [][]byte
+}
+
+// TypeValue returns the extension TypeValue
+func (s SignedCertificateTimestamp) TypeValue() TypeValue {
+	return SignedCertificateTimestampTypeValue
+}
+
+// Marshal encodes the extension
+func (s *SignedCertificateTimestamp) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(s.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if len(s.SignedCertificateTimestamps) == 0 {
+			return
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, sct := range s.SignedCertificateTimestamps {
+				sct := sct // Satisfy range scope lint
+				b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+					b.AddBytes(sct)
+				})
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *SignedCertificateTimestamp) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+
+	var extension uint16
+	if !val.ReadUint16(&extension) {
+		return errBufferTooSmall
+	} else if TypeValue(extension) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errBufferTooSmall
+	}
+
+	s.SignedCertificateTimestamps = nil
+	if extData.Empty() {
+		return nil
+	}
+
+	var sctList cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&sctList) || !extData.Empty() || sctList.Empty() {
+		return errInvalidSCTFormat
+	}
+	for !sctList.Empty() {
+		var sct cryptobyte.String
+		if !sctList.ReadUint16LengthPrefixed(&sct) || sct.Empty() {
+			return errInvalidSCTFormat
+		}
+		s.SignedCertificateTimestamps = append(s.SignedCertificateTimestamps, append([]byte{}, sct...))
+	}
+	return nil
+}
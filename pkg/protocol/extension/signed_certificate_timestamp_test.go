@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSignedCertificateTimestamp(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw    []byte
+		parsed *SignedCertificateTimestamp
+	}{
+		"Client": {
+			raw:    []byte{0x00, 0x12, 0x00, 0x00},
+			parsed: &SignedCertificateTimestamp{},
+		},
+		"Server": {
+			raw: []byte{
+				0x00, 0x12, 0x00, 0x0b, 0x00, 0x09,
+				0x00, 0x02, 0x01, 0x02,
+				0x00, 0x03, 0x03, 0x04, 0x05,
+			},
+			parsed: &SignedCertificateTimestamp{
+				SignedCertificateTimestamps: [][]byte{{0x01, 0x02}, {0x03, 0x04, 0x05}},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.parsed.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("signedCertificateTimestamp marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			roundtrip := &SignedCertificateTimestamp{}
+			if err := roundtrip.Unmarshal(raw); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(roundtrip, tt.parsed) {
+				t.Errorf("signedCertificateTimestamp unmarshal: got %#v, want %#v", roundtrip, tt.parsed)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"EmptyList":    {0x00, 0x12, 0x00, 0x02, 0x00, 0x00},
+		"EmptySCT":     {0x00, 0x12, 0x00, 0x04, 0x00, 0x02, 0x00, 0x00},
+		"Truncated":    {0x00, 0x12, 0x00, 0x05, 0x00, 0x03, 0x00, 0x02, 0x01},
+		"TrailingData": {0x00, 0x12, 0x00, 0x06, 0x00, 0x03, 0x00, 0x01, 0x01, 0x00},
+	} {
+		raw := raw
+		t.Run(name, func(t *testing.T) {
+			if err := (&SignedCertificateTimestamp{}).Unmarshal(raw); !errors.Is(err, errInvalidSCTFormat) {
+				t.Errorf("Expected error %v, got %v", errInvalidSCTFormat, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import "encoding/binary"
+
+const (
+	statusRequestHeaderSize = 4
+	// status_type, and the empty responder_id_list and request_extensions
+	statusRequestOCSPSize = 5
+)
+
+// CertificateStatusType is the type of certificate status requested with the
+// StatusRequest extension.
+type CertificateStatusType byte
+
+// CertificateStatusType enums
+const (
+	CertificateStatusTypeOCSP CertificateStatusType = 1
+)
+
+// StatusRequest is a TLS extension with which a client asks the server to
+// staple the status of its certificate to the handshake. A server that will
+// send a CertificateStatus message responds with an empty extension, which
+// is represented by a zero StatusType.
+//
+// https://tools.ietf.org/html/rfc6066#section-8
+type StatusRequest struct {
+	StatusType CertificateStatusType
+}
+
+// TypeValue returns the extension TypeValue
+func (s StatusRequest) TypeValue() TypeValue {
+	return StatusRequestTypeValue
+}
+
+// Marshal encodes the extension
+func (s *StatusRequest) Marshal() ([]byte, error) {
+	if s.StatusType == 0 {
+		out := make([]byte, statusRequestHeaderSize)
+		binary.BigEndian.PutUint16(out, uint16(s.TypeValue()))
+		return out, nil
+	}
+
+	out := make([]byte, statusRequestHeaderSize+statusRequestOCSPSize)
+	binary.BigEndian.PutUint16(out, uint16(s.TypeValue()))
+	binary.BigEndian.PutUint16(out[2:], statusRequestOCSPSize) // length
+	out[statusRequestHeaderSize] = byte(s.StatusType)
+	return out, nil
+}
+
+// Unmarshal populates the extension from encoded data
+func (s *StatusRequest) Unmarshal(data []byte) error {
+	if len(data) < statusRequestHeaderSize {
+		return errBufferTooSmall
+	} else if TypeValue(binary.BigEndian.Uint16(data)) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	length := int(binary.BigEndian.Uint16(data[2:]))
+	if length == 0 {
+		s.StatusType = 0
+		return nil
+	} else if len(data) < statusRequestHeaderSize+length {
+		return errBufferTooSmall
+	}
+
+	// The responder_id_list and request_extensions are not used.
+	s.StatusType = CertificateStatusType(data[statusRequestHeaderSize])
+	return nil
+}
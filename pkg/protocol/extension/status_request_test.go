@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStatusRequest(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw    []byte
+		parsed *StatusRequest
+	}{
+		"Client": {
+			raw:    []byte{0x00, 0x05, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00},
+			parsed: &StatusRequest{StatusType: CertificateStatusTypeOCSP},
+		},
+		"Server": {
+			raw:    []byte{0x00, 0x05, 0x00, 0x00},
+			parsed: &StatusRequest{},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.parsed.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("statusRequest marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			roundtrip := &StatusRequest{}
+			if err := roundtrip.Unmarshal(raw); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(roundtrip, tt.parsed) {
+				t.Errorf("statusRequest unmarshal: got %#v, want %#v", roundtrip, tt.parsed)
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"encoding/binary"
 
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
 )
 
 const (
@@ -14,7 +15,8 @@ const (
 )
 
 // SupportedEllipticCurves allows a Client/Server to communicate
-// what curves they both support
+// what curves they both support. The finite field groups of RFC 7919 share
+// the extension and are carried as well.
 //
 // https://tools.ietf.org/html/rfc8422#section-5.1.1
 type SupportedEllipticCurves struct {
@@ -57,7 +59,7 @@ func (s *SupportedEllipticCurves) Unmarshal(data []byte) error {
 
 	for i := 0; i < groupCount; i++ {
 		supportedGroupID := elliptic.Curve(binary.BigEndian.Uint16(data[(supportedGroupsHeaderSize + (i * 2)):]))
-		if _, ok := elliptic.Curves()[supportedGroupID]; ok {
+		if _, ok := elliptic.Curves()[supportedGroupID]; ok || ffdhe.Groups()[ffdhe.Group(supportedGroupID)] {
 			s.EllipticCurves = append(s.EllipticCurves, supportedGroupID)
 		}
 	}
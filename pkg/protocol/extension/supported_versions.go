@@ -0,0 +1,83 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"encoding/binary"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+)
+
+const (
+	supportedVersionsHeaderSize = 4
+	supportedVersionSize        = 2
+)
+
+// SupportedVersions lists the protocol versions offered by a client, most
+// preferred first. In a ServerHello it instead holds the single version
+// selected by the server, and Versions is empty.
+//
+// https://tools.ietf.org/html/rfc8446#section-4.2.1
+type SupportedVersions struct {
+	Versions        []protocol.Version
+	SelectedVersion protocol.Version
+}
+
+// TypeValue returns the extension TypeValue
+func (s SupportedVersions) TypeValue() TypeValue {
+	return SupportedVersionsTypeValue
+}
+
+// Marshal encodes the extension. The ServerHello form is used if Versions is
+// empty.
+func (s *SupportedVersions) Marshal() ([]byte, error) {
+	out := make([]byte, supportedVersionsHeaderSize)
+	binary.BigEndian.PutUint16(out, uint16(s.TypeValue()))
+
+	if len(s.Versions) == 0 {
+		binary.BigEndian.PutUint16(out[2:], supportedVersionSize)
+		return append(out, s.SelectedVersion.Major, s.SelectedVersion.Minor), nil
+	}
+
+	binary.BigEndian.PutUint16(out[2:], uint16(1+supportedVersionSize*len(s.Versions)))
+	out = append(out, byte(supportedVersionSize*len(s.Versions)))
+	for _, v := range s.Versions {
+		out = append(out, v.Major, v.Minor)
+	}
+	return out, nil
+}
+
+// Unmarshal populates the extension from encoded data. The ClientHello and
+// ServerHello forms are told apart by their length, which is odd for a list
+// of versions.
+func (s *SupportedVersions) Unmarshal(data []byte) error {
+	if len(data) < supportedVersionsHeaderSize {
+		return errBufferTooSmall
+	} else if TypeValue(binary.BigEndian.Uint16(data)) != s.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	dataLen := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < supportedVersionsHeaderSize+dataLen {
+		return errBufferTooSmall
+	}
+	data = data[supportedVersionsHeaderSize : supportedVersionsHeaderSize+dataLen]
+
+	if dataLen == supportedVersionSize {
+		s.SelectedVersion = protocol.Version{Major: data[0], Minor: data[1]}
+		return nil
+	}
+
+	if dataLen == 0 || int(data[0]) != dataLen-1 || data[0]%supportedVersionSize != 0 {
+		return errInvalidSupportedVersionsFormat
+	}
+	s.Versions = nil
+	for i := 1; i < dataLen; i += supportedVersionSize {
+		s.Versions = append(s.Versions, protocol.Version{Major: data[i], Minor: data[i+1]})
+	}
+	if len(s.Versions) == 0 {
+		return errInvalidSupportedVersionsFormat
+	}
+	return nil
+}
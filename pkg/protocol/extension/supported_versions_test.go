@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+)
+
+func TestSupportedVersions(t *testing.T) {
+	for name, tt := range map[string]struct {
+		raw    []byte
+		parsed *SupportedVersions
+	}{
+		"ClientHello": {
+			raw: []byte{0x00, 0x2b, 0x00, 0x05, 0x04, 0xfe, 0xfc, 0xfe, 0xfd},
+			parsed: &SupportedVersions{
+				Versions: []protocol.Version{protocol.Version1_3, protocol.Version1_2},
+			},
+		},
+		"ServerHello": {
+			raw: []byte{0x00, 0x2b, 0x00, 0x02, 0xfe, 0xfc},
+			parsed: &SupportedVersions{
+				SelectedVersion: protocol.Version1_3,
+			},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.parsed.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("supportedVersions marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			roundtrip := &SupportedVersions{}
+			if err := roundtrip.Unmarshal(raw); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(roundtrip, tt.parsed) {
+				t.Errorf("supportedVersions unmarshal: got %#v, want %#v", roundtrip, tt.parsed)
+			}
+		})
+	}
+
+	for name, raw := range map[string][]byte{
+		"Empty":          {0x00, 0x2b, 0x00, 0x00},
+		"EmptyList":      {0x00, 0x2b, 0x00, 0x01, 0x00},
+		"LengthMismatch": {0x00, 0x2b, 0x00, 0x05, 0x02, 0xfe, 0xfc, 0xfe, 0xfd},
+		"OddList":        {0x00, 0x2b, 0x00, 0x04, 0x03, 0xfe, 0xfc, 0xfe},
+	} {
+		if err := (&SupportedVersions{}).Unmarshal(raw); !errors.Is(err, errInvalidSupportedVersionsFormat) {
+			t.Errorf("supportedVersions unmarshal %s: got %v, want %v", name, err, errInvalidSupportedVersionsFormat)
+		}
+	}
+	if err := (&SupportedVersions{}).Unmarshal([]byte{0x00, 0x2b, 0x00, 0x05, 0x04}); !errors.Is(err, errBufferTooSmall) {
+		t.Errorf("supportedVersions unmarshal truncated: got %v, want %v", err, errBufferTooSmall)
+	}
+}
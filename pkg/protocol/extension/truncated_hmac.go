@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import "encoding/binary"
+
+const (
+	truncatedHMACHeaderSize = 4
+)
+
+// TruncatedHMAC is a TLS extension with which a client asks to truncate the
+// MAC of CBC records to 80 bits. The server echoes it if it agrees. The
+// extension has no data.
+//
+// https://tools.ietf.org/html/rfc6066#section-7
+type TruncatedHMAC struct{}
+
+// TypeValue returns the extension TypeValue
+func (t TruncatedHMAC) TypeValue() TypeValue {
+	return TruncatedHMACTypeValue
+}
+
+// Marshal encodes the extension
+func (t *TruncatedHMAC) Marshal() ([]byte, error) {
+	out := make([]byte, truncatedHMACHeaderSize)
+	binary.BigEndian.PutUint16(out, uint16(t.TypeValue()))
+	return out, nil
+}
+
+// Unmarshal populates the extension from encoded data
+func (t *TruncatedHMAC) Unmarshal(data []byte) error {
+	if len(data) < truncatedHMACHeaderSize {
+		return errBufferTooSmall
+	} else if TypeValue(binary.BigEndian.Uint16(data)) != t.TypeValue() {
+		return errInvalidExtensionType
+	} else if binary.BigEndian.Uint16(data[2:]) != 0 {
+		return errLengthMismatch
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTruncatedHMAC(t *testing.T) {
+	rawExtension := []byte{0x00, 0x04, 0x00, 0x00}
+	parsedExtension := &TruncatedHMAC{}
+
+	raw, err := parsedExtension.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	} else if !reflect.DeepEqual(raw, rawExtension) {
+		t.Errorf("truncatedHMAC marshal: got %#v, want %#v", raw, rawExtension)
+	}
+
+	roundtrip := &TruncatedHMAC{}
+	if err := roundtrip.Unmarshal(raw); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := roundtrip.Unmarshal([]byte{0x00, 0x04, 0x00, 0x01, 0x00}); !errors.Is(err, errLengthMismatch) {
+		t.Errorf("truncatedHMAC with data: got %v, want %v", err, errLengthMismatch)
+	}
+}
@@ -0,0 +1,133 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"golang.org/x/crypto/cryptobyte"
+)
+
+// TrustedAuthorityIdentifierType is the way a TrustedAuthority identifies a
+// certificate authority.
+type TrustedAuthorityIdentifierType byte
+
+// TrustedAuthorityIdentifierType enums
+const (
+	TrustedAuthorityPreAgreed    TrustedAuthorityIdentifierType = 0
+	TrustedAuthorityKeySHA1Hash  TrustedAuthorityIdentifierType = 1
+	TrustedAuthorityX509Name     TrustedAuthorityIdentifierType = 2
+	TrustedAuthorityCertSHA1Hash TrustedAuthorityIdentifierType = 3
+)
+
+const trustedAuthoritySHA1HashLength = 20
+
+// TrustedAuthority identifies a certificate authority trusted by a client.
+// Identifier is the SHA-1 hash of the CA's key or certificate for
+// TrustedAuthorityKeySHA1Hash and TrustedAuthorityCertSHA1Hash, the DER
+// encoded distinguished name for TrustedAuthorityX509Name, and empty for
+// TrustedAuthorityPreAgreed.
+type TrustedAuthority struct {
+	IdentifierType TrustedAuthorityIdentifierType
+	Identifier     []byte
+}
+
+// TrustedCAKeys is a TLS extension with which a client lists the
+// certificate authorities it trusts, so that the server can send a
+// certificate chain ending at one of them. A server that does so responds
+// with an empty extension, which is represented by no Authorities.
+//
+// https://tools.ietf.org/html/rfc6066#section-6
+type TrustedCAKeys struct {
+	Authorities []TrustedAuthority
+}
+
+// TypeValue returns the extension TypeValue
+func (t TrustedCAKeys) TypeValue() TypeValue {
+	return TrustedCAKeysTypeValue
+}
+
+// Marshal encodes the extension
+func (t *TrustedCAKeys) Marshal() ([]byte, error) {
+	var b cryptobyte.Builder
+	b.AddUint16(uint16(t.TypeValue()))
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		if len(t.Authorities) == 0 {
+			return
+		}
+		b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+			for _, a := range t.Authorities {
+				b.AddUint8(uint8(a.IdentifierType))
+				switch a.IdentifierType {
+				case TrustedAuthorityPreAgreed:
+				case TrustedAuthorityX509Name:
+					b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+						b.AddBytes(a.Identifier)
+					})
+				case TrustedAuthorityKeySHA1Hash, TrustedAuthorityCertSHA1Hash:
+					if len(a.Identifier) != trustedAuthoritySHA1HashLength {
+						b.SetError(errInvalidTrustedCAKeysFormat)
+						return
+					}
+					b.AddBytes(a.Identifier)
+				default:
+					b.SetError(errInvalidTrustedCAKeysFormat)
+					return
+				}
+			}
+		})
+	})
+	return b.Bytes()
+}
+
+// Unmarshal populates the extension from encoded data
+func (t *TrustedCAKeys) Unmarshal(data []byte) error {
+	val := cryptobyte.String(data)
+
+	var extension uint16
+	if !val.ReadUint16(&extension) {
+		return errBufferTooSmall
+	} else if TypeValue(extension) != t.TypeValue() {
+		return errInvalidExtensionType
+	}
+
+	var extData cryptobyte.String
+	if !val.ReadUint16LengthPrefixed(&extData) {
+		return errBufferTooSmall
+	}
+
+	t.Authorities = nil
+	if extData.Empty() {
+		return nil
+	}
+
+	var authorities cryptobyte.String
+	if !extData.ReadUint16LengthPrefixed(&authorities) || !extData.Empty() {
+		return errInvalidTrustedCAKeysFormat
+	}
+	for !authorities.Empty() {
+		var a TrustedAuthority
+		if !authorities.ReadUint8((*uint8)(&a.IdentifierType)) {
+			return errInvalidTrustedCAKeysFormat
+		}
+		var identifier cryptobyte.String
+		switch a.IdentifierType {
+		case TrustedAuthorityPreAgreed:
+		case TrustedAuthorityKeySHA1Hash, TrustedAuthorityCertSHA1Hash:
+			if !authorities.ReadBytes((*[]byte)(&identifier), trustedAuthoritySHA1HashLength) {
+				return errInvalidTrustedCAKeysFormat
+			}
+		case TrustedAuthorityX509Name:
+			if !authorities.ReadUint16LengthPrefixed(&identifier) || identifier.Empty() {
+				return errInvalidTrustedCAKeysFormat
+			}
+		default:
+			// The length of an unknown identifier is not known.
+			return errInvalidTrustedCAKeysFormat
+		}
+		if len(identifier) > 0 {
+			a.Identifier = append([]byte{}, identifier...)
+		}
+		t.Authorities = append(t.Authorities, a)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package extension
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestTrustedCAKeys(t *testing.T) {
+	keyHash := bytes.Repeat([]byte{0xaa}, 20)
+	for name, tt := range map[string]struct {
+		raw    []byte
+		parsed *TrustedCAKeys
+	}{
+		"Client": {
+			raw: append(append([]byte{
+				0x00, 0x03, 0x00, 0x1e, 0x00, 0x1c,
+				0x00,
+				0x01,
+			}, keyHash...), []byte{
+				0x02, 0x00, 0x03, 0x30, 0x01, 0x02,
+			}...),
+			parsed: &TrustedCAKeys{Authorities: []TrustedAuthority{
+				{IdentifierType: TrustedAuthorityPreAgreed},
+				{IdentifierType: TrustedAuthorityKeySHA1Hash, Identifier: keyHash},
+				{IdentifierType: TrustedAuthorityX509Name, Identifier: []byte{0x30, 0x01, 0x02}},
+			}},
+		},
+		"Server": {
+			raw:    []byte{0x00, 0x03, 0x00, 0x00},
+			parsed: &TrustedCAKeys{},
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			raw, err := tt.parsed.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			} else if !reflect.DeepEqual(raw, tt.raw) {
+				t.Errorf("trustedCAKeys marshal: got %#v, want %#v", raw, tt.raw)
+			}
+
+			roundtrip := &TrustedCAKeys{}
+			if err := roundtrip.Unmarshal(raw); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(roundtrip, tt.parsed) {
+				t.Errorf("trustedCAKeys unmarshal: got %#v, want %#v", roundtrip, tt.parsed)
+			}
+		})
+	}
+}
+
+func TestTrustedCAKeysInvalid(t *testing.T) {
+	for name, raw := range map[string][]byte{
+		"TruncatedHash":     {0x00, 0x03, 0x00, 0x05, 0x00, 0x03, 0x01, 0xaa, 0xbb},
+		"UnknownIdentifier": {0x00, 0x03, 0x00, 0x03, 0x00, 0x01, 0x04},
+		"TrailingData":      {0x00, 0x03, 0x00, 0x04, 0x00, 0x01, 0x00, 0x00},
+	} {
+		if err := (&TrustedCAKeys{}).Unmarshal(raw); !errors.Is(err, errInvalidTrustedCAKeysFormat) {
+			t.Errorf("%s: expected %v, got %v", name, errInvalidTrustedCAKeysFormat, err)
+		}
+	}
+
+	_, err := (&TrustedCAKeys{Authorities: []TrustedAuthority{
+		{IdentifierType: TrustedAuthorityCertSHA1Hash, Identifier: []byte{0x01}},
+	}}).Marshal()
+	if !errors.Is(err, errInvalidTrustedCAKeysFormat) {
+		t.Errorf("Expected %v marshaling a short hash, got %v", errInvalidTrustedCAKeysFormat, err)
+	}
+}
@@ -27,6 +27,9 @@ const (
 	TypeCertificateVerify  Type = 15
 	TypeClientKeyExchange  Type = 16
 	TypeFinished           Type = 20
+	TypeCertificateStatus  Type = 22
+
+	TypeCompressedCertificate Type = 25
 )
 
 // String returns the string representation of this type
@@ -54,6 +57,10 @@ func (t Type) String() string {
 		return "ClientKeyExchange"
 	case TypeFinished:
 		return "Finished"
+	case TypeCertificateStatus:
+		return "CertificateStatus"
+	case TypeCompressedCertificate:
+		return "CompressedCertificate"
 	}
 	return ""
 }
@@ -143,6 +150,10 @@ func (h *Handshake) Unmarshal(data []byte) error {
 		h.Message = &MessageFinished{}
 	case TypeCertificateVerify:
 		h.Message = &MessageCertificateVerify{}
+	case TypeCertificateStatus:
+		h.Message = &MessageCertificateStatus{}
+	case TypeCompressedCertificate:
+		h.Message = &MessageCompressedCertificate{}
 	default:
 		return errNotImplemented
 	}
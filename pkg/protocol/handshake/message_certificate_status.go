@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"github.com/censys-oss/dtls/v2/internal/util"
+)
+
+const (
+	certificateStatusTypeOCSP = 1
+
+	handshakeMessageCertificateStatusHeaderSize = 4
+)
+
+// MessageCertificateStatus is sent by a server after its Certificate to
+// staple an OCSP response for it, if the client sent the status_request
+// extension.
+//
+// https://tools.ietf.org/html/rfc6066#section-8
+type MessageCertificateStatus struct {
+	// OCSPResponse is the DER-encoded OCSP response.
+	OCSPResponse []byte
+}
+
+// Type returns the Handshake Type
+func (m MessageCertificateStatus) Type() Type {
+	return TypeCertificateStatus
+}
+
+// Marshal encodes the Handshake
+func (m *MessageCertificateStatus) Marshal() ([]byte, error) {
+	out := make([]byte, handshakeMessageCertificateStatusHeaderSize)
+	out[0] = certificateStatusTypeOCSP
+	util.PutBigEndianUint24(out[1:], uint32(len(m.OCSPResponse)))
+	return append(out, m.OCSPResponse...), nil
+}
+
+// Unmarshal populates the message from encoded data
+func (m *MessageCertificateStatus) Unmarshal(data []byte) error {
+	if len(data) < handshakeMessageCertificateStatusHeaderSize {
+		return errBufferTooSmall
+	} else if data[0] != certificateStatusTypeOCSP {
+		return errInvalidStatusType
+	}
+
+	if responseLen := int(util.BigEndianUint24(data[1:])); responseLen+handshakeMessageCertificateStatusHeaderSize != len(data) {
+		return errLengthMismatch
+	}
+
+	m.OCSPResponse = append([]byte{}, data[handshakeMessageCertificateStatusHeaderSize:]...)
+	return nil
+}
@@ -0,0 +1,47 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHandshakeMessageCertificateStatus(t *testing.T) {
+	rawCertificateStatus := []byte{0x01, 0x00, 0x00, 0x03, 0x30, 0x01, 0x02}
+	parsedCertificateStatus := &MessageCertificateStatus{
+		OCSPResponse: []byte{0x30, 0x01, 0x02},
+	}
+
+	c := &MessageCertificateStatus{}
+	if err := c.Unmarshal(rawCertificateStatus); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedCertificateStatus) {
+		t.Errorf("handshakeMessageCertificateStatus unmarshal: got %#v, want %#v", c, parsedCertificateStatus)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawCertificateStatus) {
+		t.Errorf("handshakeMessageCertificateStatus marshal: got %#v, want %#v", raw, rawCertificateStatus)
+	}
+
+	for name, tt := range map[string]struct {
+		raw []byte
+		err error
+	}{
+		"BufferTooSmall": {[]byte{0x01, 0x00, 0x00}, errBufferTooSmall},
+		"InvalidType":    {[]byte{0x02, 0x00, 0x00, 0x00}, errInvalidStatusType},
+		"LengthMismatch": {[]byte{0x01, 0x00, 0x00, 0x02, 0x30}, errLengthMismatch},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			if err := (&MessageCertificateStatus{}).Unmarshal(tt.raw); !errors.Is(err, tt.err) {
+				t.Errorf("handshakeMessageCertificateStatus unmarshal: got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ package handshake
 
 import (
 	"encoding/binary"
+	"math/big"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite/types"
 	zjson "github.com/zmap/zcrypto/json"
@@ -22,6 +23,14 @@ type MessageClientKeyExchange struct {
 	IdentityHint []byte
 	PublicKey    []byte
 
+	// EncryptedPreMasterSecret is the RSA-encrypted premaster secret of the
+	// static RSA key exchange.
+	EncryptedPreMasterSecret []byte
+
+	// DHPublicKey is the client's public value Yc of the finite field
+	// Diffie-Hellman key exchange.
+	DHPublicKey []byte
+
 	// for unmarshaling
 	KeyExchangeAlgorithm types.KeyExchangeAlgorithm
 }
@@ -33,10 +42,22 @@ func (m MessageClientKeyExchange) Type() Type {
 
 // Marshal encodes the Handshake
 func (m *MessageClientKeyExchange) Marshal() (out []byte, err error) {
-	if m.IdentityHint == nil && m.PublicKey == nil {
+	if m.IdentityHint == nil && m.PublicKey == nil && m.EncryptedPreMasterSecret == nil && m.DHPublicKey == nil {
 		return nil, errInvalidClientKeyExchange
 	}
 
+	if m.EncryptedPreMasterSecret != nil {
+		out = append([]byte{0x00, 0x00}, m.EncryptedPreMasterSecret...)
+		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+		return out, nil
+	}
+
+	if m.DHPublicKey != nil {
+		out = append([]byte{0x00, 0x00}, m.DHPublicKey...)
+		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
+		return out, nil
+	}
+
 	if m.IdentityHint != nil {
 		out = append([]byte{0x00, 0x00}, m.IdentityHint...)
 		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
@@ -59,6 +80,26 @@ func (m *MessageClientKeyExchange) Unmarshal(data []byte) error {
 		return errCipherSuiteUnset
 	}
 
+	if m.KeyExchangeAlgorithm.Has(types.KeyExchangeAlgorithmRsa) {
+		encryptedLength := int(binary.BigEndian.Uint16(data))
+		if encryptedLength != len(data)-2 {
+			return errBufferTooSmall
+		}
+
+		m.EncryptedPreMasterSecret = append([]byte{}, data[2:]...)
+		return nil
+	}
+
+	if m.KeyExchangeAlgorithm.Has(types.KeyExchangeAlgorithmDhe) {
+		publicKeyLength := int(binary.BigEndian.Uint16(data))
+		if publicKeyLength != len(data)-2 {
+			return errBufferTooSmall
+		}
+
+		m.DHPublicKey = append([]byte{}, data[2:]...)
+		return nil
+	}
+
 	offset := 0
 	if m.KeyExchangeAlgorithm.Has(types.KeyExchangeAlgorithmPsk) {
 		pskLength := int(binary.BigEndian.Uint16(data))
@@ -84,6 +125,12 @@ func (m *MessageClientKeyExchange) Unmarshal(data []byte) error {
 
 func (m *MessageClientKeyExchange) MakeLog() *tls.ClientKeyExchange {
 	ret := new(tls.ClientKeyExchange)
+	if m.DHPublicKey != nil {
+		ret.DHParams = &zjson.DHParams{
+			ClientPublic: new(big.Int).SetBytes(m.DHPublicKey),
+		}
+		return ret
+	}
 	// zcrypto's TLS currently does not give any more info than this
 	ret.ECDHParams = &zjson.ECDHParams{
 		ServerPublic: &zjson.ECPoint{},
@@ -37,3 +37,55 @@ func TestHandshakeMessageClientKeyExchange(t *testing.T) {
 		t.Errorf("handshakeMessageClientKeyExchange marshal: got %#v, want %#v", raw, rawClientKeyExchange)
 	}
 }
+
+func TestHandshakeMessageClientKeyExchangeRSA(t *testing.T) {
+	rawClientKeyExchange := []byte{0x00, 0x04, 0xde, 0xad, 0xbe, 0xef}
+	parsedClientKeyExchange := &MessageClientKeyExchange{
+		EncryptedPreMasterSecret: rawClientKeyExchange[2:],
+		KeyExchangeAlgorithm:     types.KeyExchangeAlgorithmRsa,
+	}
+
+	c := &MessageClientKeyExchange{
+		KeyExchangeAlgorithm: types.KeyExchangeAlgorithmRsa,
+	}
+	if err := c.Unmarshal(rawClientKeyExchange); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedClientKeyExchange) {
+		t.Errorf("handshakeMessageClientKeyExchange unmarshal: got %#v, want %#v", c, parsedClientKeyExchange)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawClientKeyExchange) {
+		t.Errorf("handshakeMessageClientKeyExchange marshal: got %#v, want %#v", raw, rawClientKeyExchange)
+	}
+
+	if err := (&MessageClientKeyExchange{KeyExchangeAlgorithm: types.KeyExchangeAlgorithmRsa}).Unmarshal(rawClientKeyExchange[:5]); err == nil {
+		t.Error("Expected an error for a truncated encrypted premaster secret")
+	}
+}
+
+func TestHandshakeMessageClientKeyExchangeDHE(t *testing.T) {
+	rawClientKeyExchange := []byte{0x00, 0x03, 0x01, 0x02, 0x03}
+	parsedClientKeyExchange := &MessageClientKeyExchange{
+		DHPublicKey:          rawClientKeyExchange[2:],
+		KeyExchangeAlgorithm: types.KeyExchangeAlgorithmDhe,
+	}
+
+	c := &MessageClientKeyExchange{
+		KeyExchangeAlgorithm: types.KeyExchangeAlgorithmDhe,
+	}
+	if err := c.Unmarshal(rawClientKeyExchange); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedClientKeyExchange) {
+		t.Errorf("handshakeMessageClientKeyExchange unmarshal: got %#v, want %#v", c, parsedClientKeyExchange)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawClientKeyExchange) {
+		t.Errorf("handshakeMessageClientKeyExchange marshal: got %#v, want %#v", raw, rawClientKeyExchange)
+	}
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+
+	"github.com/andybalholm/brotli"
+	"github.com/censys-oss/dtls/v2/internal/util"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+)
+
+const handshakeMessageCompressedCertificateHeaderSize = 8
+
+// MessageCompressedCertificate is sent in place of a Certificate message
+// when the peer advertised support for the compression algorithm with the
+// compress_certificate extension. It is the compressed body of the
+// Certificate message it replaces.
+//
+// https://datatracker.ietf.org/doc/html/rfc8879#section-4
+type MessageCompressedCertificate struct {
+	Algorithm             extension.CertificateCompressionAlgorithm
+	UncompressedLength    uint32
+	CompressedCertificate []byte
+}
+
+// Type returns the Handshake Type
+func (m MessageCompressedCertificate) Type() Type {
+	return TypeCompressedCertificate
+}
+
+// Marshal encodes the Handshake
+func (m *MessageCompressedCertificate) Marshal() ([]byte, error) {
+	out := make([]byte, handshakeMessageCompressedCertificateHeaderSize)
+	binary.BigEndian.PutUint16(out, uint16(m.Algorithm))
+	util.PutBigEndianUint24(out[2:], m.UncompressedLength)
+	util.PutBigEndianUint24(out[5:], uint32(len(m.CompressedCertificate)))
+	return append(out, m.CompressedCertificate...), nil
+}
+
+// Unmarshal populates the message from encoded data
+func (m *MessageCompressedCertificate) Unmarshal(data []byte) error {
+	if len(data) < handshakeMessageCompressedCertificateHeaderSize {
+		return errBufferTooSmall
+	}
+
+	if compressedLen := int(util.BigEndianUint24(data[5:])); compressedLen+handshakeMessageCompressedCertificateHeaderSize != len(data) {
+		return errLengthMismatch
+	} else if compressedLen == 0 {
+		return errInvalidCompressedCertificate
+	}
+
+	m.Algorithm = extension.CertificateCompressionAlgorithm(binary.BigEndian.Uint16(data))
+	m.UncompressedLength = util.BigEndianUint24(data[2:])
+	m.CompressedCertificate = append([]byte{}, data[handshakeMessageCompressedCertificateHeaderSize:]...)
+	return nil
+}
+
+// CompressCertificate compresses the body of a Certificate message with
+// algorithm. zlib and brotli are implemented.
+func CompressCertificate(m *MessageCertificate, algorithm extension.CertificateCompressionAlgorithm) (*MessageCompressedCertificate, error) {
+	var compressed bytes.Buffer
+	var w io.WriteCloser
+	switch algorithm {
+	case extension.CertificateCompressionAlgorithmZlib:
+		w = zlib.NewWriter(&compressed)
+	case extension.CertificateCompressionAlgorithmBrotli:
+		w = brotli.NewWriter(&compressed)
+	default:
+		return nil, errUnsupportedCertificateCompression
+	}
+
+	raw, err := m.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return &MessageCompressedCertificate{
+		Algorithm:             algorithm,
+		UncompressedLength:    uint32(len(raw)),
+		CompressedCertificate: compressed.Bytes(),
+	}, nil
+}
+
+// Decompress returns the Certificate message m was compressed from. No more
+// than maxLength bytes are ever decompressed, so a message that claims or
+// expands to a larger size is rejected without allocating it.
+func (m *MessageCompressedCertificate) Decompress(maxLength int) (*MessageCertificate, error) {
+	if m.Algorithm != extension.CertificateCompressionAlgorithmZlib && m.Algorithm != extension.CertificateCompressionAlgorithmBrotli {
+		return nil, errUnsupportedCertificateCompression
+	}
+	if int(m.UncompressedLength) > maxLength {
+		return nil, errCompressedCertificateTooLarge
+	}
+
+	var r io.Reader
+	if m.Algorithm == extension.CertificateCompressionAlgorithmZlib {
+		zr, err := zlib.NewReader(bytes.NewReader(m.CompressedCertificate))
+		if err != nil {
+			return nil, errInvalidCompressedCertificate
+		}
+		defer func() {
+			_ = zr.Close()
+		}()
+		r = zr
+	} else {
+		r = brotli.NewReader(bytes.NewReader(m.CompressedCertificate))
+	}
+
+	// Read one byte past the declared length to detect data beyond it.
+	raw, err := io.ReadAll(io.LimitReader(r, int64(m.UncompressedLength)+1))
+	if err != nil || len(raw) != int(m.UncompressedLength) {
+		return nil, errInvalidCompressedCertificate
+	}
+
+	certificate := &MessageCertificate{}
+	if err := certificate.Unmarshal(raw); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package handshake
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+)
+
+func TestHandshakeMessageCompressedCertificate(t *testing.T) {
+	rawCompressedCertificate := []byte{0x00, 0x01, 0x00, 0x00, 0x09, 0x00, 0x00, 0x02, 0x78, 0x9c}
+	parsedCompressedCertificate := &MessageCompressedCertificate{
+		Algorithm:             extension.CertificateCompressionAlgorithmZlib,
+		UncompressedLength:    9,
+		CompressedCertificate: []byte{0x78, 0x9c},
+	}
+
+	c := &MessageCompressedCertificate{}
+	if err := c.Unmarshal(rawCompressedCertificate); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedCompressedCertificate) {
+		t.Errorf("handshakeMessageCompressedCertificate unmarshal: got %#v, want %#v", c, parsedCompressedCertificate)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawCompressedCertificate) {
+		t.Errorf("handshakeMessageCompressedCertificate marshal: got %#v, want %#v", raw, rawCompressedCertificate)
+	}
+
+	for name, tt := range map[string]struct {
+		raw []byte
+		err error
+	}{
+		"BufferTooSmall": {[]byte{0x00, 0x01, 0x00, 0x00, 0x09, 0x00, 0x00}, errBufferTooSmall},
+		"LengthMismatch": {[]byte{0x00, 0x01, 0x00, 0x00, 0x09, 0x00, 0x00, 0x02, 0x78}, errLengthMismatch},
+		"Empty":          {[]byte{0x00, 0x01, 0x00, 0x00, 0x09, 0x00, 0x00, 0x00}, errInvalidCompressedCertificate},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			if err := (&MessageCompressedCertificate{}).Unmarshal(tt.raw); !errors.Is(err, tt.err) {
+				t.Errorf("handshakeMessageCompressedCertificate unmarshal: got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCompressCertificate(t *testing.T) {
+	certificate := &MessageCertificate{
+		Certificate: [][]byte{bytes.Repeat([]byte{0x30}, 1000), bytes.Repeat([]byte{0x30}, 1000)},
+	}
+
+	for _, algorithm := range []extension.CertificateCompressionAlgorithm{
+		extension.CertificateCompressionAlgorithmZlib,
+		extension.CertificateCompressionAlgorithmBrotli,
+	} {
+		compressed, err := CompressCertificate(certificate, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed.CompressedCertificate) >= int(compressed.UncompressedLength) {
+			t.Errorf("%s: Certificate did not shrink: %d >= %d", algorithm, len(compressed.CompressedCertificate), compressed.UncompressedLength)
+		}
+
+		decompressed, err := compressed.Decompress(1 << 16)
+		if err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(decompressed, certificate) {
+			t.Errorf("%s: Decompressed certificate does not match: got %#v, want %#v", algorithm, decompressed, certificate)
+		}
+	}
+
+	if _, err := CompressCertificate(certificate, extension.CertificateCompressionAlgorithmZstd); !errors.Is(err, errUnsupportedCertificateCompression) {
+		t.Errorf("Expected error %v, got %v", errUnsupportedCertificateCompression, err)
+	}
+}
+
+func TestDecompressCertificateLimits(t *testing.T) {
+	zlibCompress := func(data []byte) []byte {
+		var b bytes.Buffer
+		w := zlib.NewWriter(&b)
+		_, _ = w.Write(data)
+		_ = w.Close()
+		return b.Bytes()
+	}
+	brotliCompress := func(data []byte) []byte {
+		var b bytes.Buffer
+		w := brotli.NewWriter(&b)
+		_, _ = w.Write(data)
+		_ = w.Close()
+		return b.Bytes()
+	}
+	// A megabyte of zeros compresses to about a kilobyte, or a few bytes
+	// with brotli.
+	bomb := zlibCompress(make([]byte, 1<<20))
+	brotliBomb := brotliCompress(make([]byte, 1<<20))
+
+	for name, tt := range map[string]struct {
+		message *MessageCompressedCertificate
+		err     error
+	}{
+		"DeclaredTooLarge": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmZlib, 1 << 20, bomb},
+			errCompressedCertificateTooLarge,
+		},
+		"ExpandsBeyondDeclared": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmZlib, 100, bomb},
+			errInvalidCompressedCertificate,
+		},
+		"ShorterThanDeclared": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmZlib, 100, zlibCompress([]byte{0x00, 0x00, 0x00})},
+			errInvalidCompressedCertificate,
+		},
+		"NotZlib": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmZlib, 3, []byte{0x00, 0x00, 0x00}},
+			errInvalidCompressedCertificate,
+		},
+		"BrotliDeclaredTooLarge": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmBrotli, 1 << 20, brotliBomb},
+			errCompressedCertificateTooLarge,
+		},
+		"BrotliExpandsBeyondDeclared": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmBrotli, 100, brotliBomb},
+			errInvalidCompressedCertificate,
+		},
+		"BrotliShorterThanDeclared": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmBrotli, 100, brotliCompress([]byte{0x00, 0x00, 0x00})},
+			errInvalidCompressedCertificate,
+		},
+		"NotBrotli": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmBrotli, 3, []byte{0xff, 0xff, 0xff}},
+			errInvalidCompressedCertificate,
+		},
+		"UnsupportedAlgorithm": {
+			&MessageCompressedCertificate{extension.CertificateCompressionAlgorithmZstd, 3, []byte{0x00}},
+			errUnsupportedCertificateCompression,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			if _, err := tt.message.Decompress(1 << 16); !errors.Is(err, tt.err) {
+				t.Errorf("Expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -102,12 +102,10 @@ func (m *MessageServerHello) Unmarshal(data []byte) error {
 	if len(data) <= currOffset {
 		return errBufferTooSmall
 	}
-	if compressionMethod, ok := protocol.CompressionMethods()[protocol.CompressionMethodID(data[currOffset])]; ok {
-		m.CompressionMethod = compressionMethod
-		currOffset++
-	} else {
-		return errInvalidCompressionMethod
-	}
+	// The compression method is kept even if it is not supported, so that
+	// the client can report it before it aborts the handshake.
+	m.CompressionMethod = &protocol.CompressionMethod{ID: protocol.CompressionMethodID(data[currOffset])}
+	currOffset++
 
 	if len(data) <= currOffset {
 		m.Extensions = []extension.Extension{}
@@ -148,6 +146,12 @@ func (m *MessageServerHello) MakeLog() *tls.ServerHello {
 			ret.SecureRenegotiation = true
 		case *extension.UseExtendedMasterSecret:
 			ret.ExtendedMasterSecret = e.Supported
+		case *extension.SupportedVersions:
+			// Versions from DTLS 1.3 on are negotiated in the extension,
+			// and Version holds the legacy version.
+			selected := tls.TLSVersion((uint16(e.SelectedVersion.Major) << 8) | uint16(e.SelectedVersion.Minor))
+			ret.SupportedVersions = &tls.SupportedVersionsExt{SelectedVersion: selected}
+			ret.Version = selected
 
 		// unimplemented in zcrypto
 		case *extension.ConnectionID:
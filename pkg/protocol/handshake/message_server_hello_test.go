@@ -83,3 +83,53 @@ func TestHandshakeMessageServerHelloSessionID(t *testing.T) {
 		t.Errorf("handshakeMessageServerHello marshal: got %#v, want %#v", raw, rawServerHello)
 	}
 }
+
+func TestHandshakeMessageServerHelloSupportedVersions(t *testing.T) {
+	rawServerHello := []byte{
+		0xfe, 0xfd, 0x21, 0x63, 0x32, 0x21, 0x81, 0x0e, 0x98, 0x6c,
+		0x85, 0x3d, 0xa4, 0x39, 0xaf, 0x5f, 0xd6, 0x5c, 0xcc, 0x20,
+		0x7f, 0x7c, 0x78, 0xf1, 0x5f, 0x7e, 0x1c, 0xb7, 0xa1, 0x1e,
+		0xcf, 0x63, 0x84, 0x28, 0x00, 0x13, 0x01, 0x00, 0x00, 0x06,
+		0x00, 0x2b, 0x00, 0x02, 0xfe, 0xfc,
+	}
+
+	c := &MessageServerHello{}
+	if err := c.Unmarshal(rawServerHello); err != nil {
+		t.Fatal(err)
+	}
+	expected := []extension.Extension{&extension.SupportedVersions{SelectedVersion: protocol.Version1_3}}
+	if !reflect.DeepEqual(c.Extensions, expected) {
+		t.Errorf("handshakeMessageServerHello extensions: got %#v, want %#v", c.Extensions, expected)
+	}
+
+	// The negotiated version is reported from the extension.
+	log := c.MakeLog()
+	if log.Version != 0xfefc {
+		t.Errorf("handshakeMessageServerHello log version: got %#x, want %#x", uint16(log.Version), 0xfefc)
+	}
+	if log.SupportedVersions == nil || log.SupportedVersions.SelectedVersion != 0xfefc {
+		t.Errorf("handshakeMessageServerHello log supported versions: got %#v", log.SupportedVersions)
+	}
+}
+
+func TestHandshakeMessageServerHelloCompressionMethod(t *testing.T) {
+	// A ServerHello selecting DEFLATE, which is parsed so that the client
+	// can report it.
+	rawServerHello := []byte{
+		0xfe, 0xfd, 0x21, 0x63, 0x32, 0x21, 0x81, 0x0e, 0x98, 0x6c,
+		0x85, 0x3d, 0xa4, 0x39, 0xaf, 0x5f, 0xd6, 0x5c, 0xcc, 0x20,
+		0x7f, 0x7c, 0x78, 0xf1, 0x5f, 0x7e, 0x1c, 0xb7, 0xa1, 0x1e,
+		0xcf, 0x63, 0x84, 0x28, 0x00, 0xc0, 0x2b, 0x01, 0x00, 0x00,
+	}
+
+	c := &MessageServerHello{}
+	if err := c.Unmarshal(rawServerHello); err != nil {
+		t.Fatal(err)
+	}
+	if c.CompressionMethod == nil || c.CompressionMethod.ID != 1 {
+		t.Errorf("handshakeMessageServerHello compression method: got %#v, want 1", c.CompressionMethod)
+	}
+	if log := c.MakeLog(); log.CompressionMethod != 1 {
+		t.Errorf("handshakeMessageServerHello log compression method: got %d, want 1", log.CompressionMethod)
+	}
+}
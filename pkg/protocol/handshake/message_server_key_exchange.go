@@ -5,6 +5,7 @@ package handshake
 
 import (
 	"encoding/binary"
+	"math/big"
 
 	"github.com/censys-oss/dtls/v2/internal/ciphersuite/types"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
@@ -14,10 +15,16 @@ import (
 	"github.com/zmap/zcrypto/tls"
 )
 
-// MessageServerKeyExchange supports ECDH and PSK
+// MessageServerKeyExchange supports ECDH, DHE and PSK
 type MessageServerKeyExchange struct {
 	IdentityHint []byte
 
+	// DHPrime, DHGenerator and DHPublicKey are the ServerDHParams of the
+	// finite field Diffie-Hellman key exchange.
+	DHPrime     []byte
+	DHGenerator []byte
+	DHPublicKey []byte
+
 	EllipticCurveType  elliptic.CurveType
 	NamedCurve         elliptic.Curve
 	PublicKey          []byte
@@ -42,14 +49,23 @@ func (m *MessageServerKeyExchange) Marshal() ([]byte, error) {
 		binary.BigEndian.PutUint16(out, uint16(len(out)-2))
 	}
 
-	if m.EllipticCurveType == 0 || len(m.PublicKey) == 0 {
+	switch {
+	case len(m.DHPrime) > 0:
+		for _, v := range [][]byte{m.DHPrime, m.DHGenerator, m.DHPublicKey} {
+			out = append(out, 0x00, 0x00)
+			binary.BigEndian.PutUint16(out[len(out)-2:], uint16(len(v)))
+			out = append(out, v...)
+		}
+	case m.EllipticCurveType == 0 || len(m.PublicKey) == 0:
 		return out, nil
+	default:
+		out = append(out, byte(m.EllipticCurveType), 0x00, 0x00)
+		binary.BigEndian.PutUint16(out[len(out)-2:], uint16(m.NamedCurve))
+
+		out = append(out, byte(len(m.PublicKey)))
+		out = append(out, m.PublicKey...)
 	}
-	out = append(out, byte(m.EllipticCurveType), 0x00, 0x00)
-	binary.BigEndian.PutUint16(out[len(out)-2:], uint16(m.NamedCurve))
 
-	out = append(out, byte(len(m.PublicKey)))
-	out = append(out, m.PublicKey...)
 	switch {
 	case m.HashAlgorithm != hash.None && len(m.Signature) == 0:
 		return nil, errInvalidHashAlgorithm
@@ -89,6 +105,23 @@ func (m *MessageServerKeyExchange) Unmarshal(data []byte) error {
 		return errLengthMismatch
 	}
 
+	if m.KeyExchangeAlgorithm.Has(types.KeyExchangeAlgorithmDhe) {
+		offset := 0
+		for _, v := range []*[]byte{&m.DHPrime, &m.DHGenerator, &m.DHPublicKey} {
+			if len(data) < offset+2 {
+				return errBufferTooSmall
+			}
+			length := int(binary.BigEndian.Uint16(data[offset:]))
+			offset += 2
+			if length == 0 || len(data) < offset+length {
+				return errBufferTooSmall
+			}
+			*v = append([]byte{}, data[offset:offset+length]...)
+			offset += length
+		}
+		return m.unmarshalSignature(data[offset:])
+	}
+
 	if !m.KeyExchangeAlgorithm.Has(types.KeyExchangeAlgorithmEcdhe) {
 		return errLengthMismatch
 	}
@@ -117,13 +150,16 @@ func (m *MessageServerKeyExchange) Unmarshal(data []byte) error {
 	}
 	m.PublicKey = append([]byte{}, data[4:offset]...)
 
+	return m.unmarshalSignature(data[offset:])
+}
+
+func (m *MessageServerKeyExchange) unmarshalSignature(data []byte) error {
 	// Anon connection doesn't contains hashAlgorithm, signatureAlgorithm, signature
-	if len(data) == offset {
+	if len(data) == 0 {
 		return nil
-	} else if len(data) <= offset {
-		return errBufferTooSmall
 	}
 
+	offset := 0
 	m.HashAlgorithm = hash.Algorithm(data[offset])
 	if _, ok := hash.Algorithms()[m.HashAlgorithm]; !ok {
 		return errInvalidHashAlgorithm
@@ -152,9 +188,17 @@ func (m *MessageServerKeyExchange) Unmarshal(data []byte) error {
 func (m *MessageServerKeyExchange) MakeLog() *tls.ServerKeyExchange {
 	ret := &tls.ServerKeyExchange{}
 
-	ret.ECDHParams = new(zjson.ECDHParams)
-	ret.ECDHParams.TLSCurveID = zjson.TLSCurveID(m.NamedCurve)
-	ret.ECDHParams.ServerPublic = &zjson.ECPoint{}
+	if len(m.DHPrime) > 0 {
+		ret.DHParams = &zjson.DHParams{
+			Prime:        new(big.Int).SetBytes(m.DHPrime),
+			Generator:    new(big.Int).SetBytes(m.DHGenerator),
+			ServerPublic: new(big.Int).SetBytes(m.DHPublicKey),
+		}
+	} else {
+		ret.ECDHParams = new(zjson.ECDHParams)
+		ret.ECDHParams.TLSCurveID = zjson.TLSCurveID(m.NamedCurve)
+		ret.ECDHParams.ServerPublic = &zjson.ECPoint{}
+	}
 	ret.Signature = &tls.DigitalSignature{
 		Raw:   append([]byte{}, m.Signature...),
 		Type:  "",
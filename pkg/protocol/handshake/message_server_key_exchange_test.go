@@ -77,3 +77,41 @@ func TestHandshakeMessageServerKeyExchange(t *testing.T) {
 		test(rawServerKeyExchange, parsedServerKeyExchange)
 	})
 }
+
+func TestHandshakeMessageServerKeyExchangeDHE(t *testing.T) {
+	rawServerKeyExchange := []byte{
+		0x00, 0x01, 0x17, // p
+		0x00, 0x01, 0x05, // g
+		0x00, 0x02, 0x00, 0x13, // Ys
+		0x04, 0x01, 0x00, 0x03, 0xaa, 0xbb, 0xcc,
+	}
+	parsedServerKeyExchange := &MessageServerKeyExchange{
+		DHPrime:              []byte{0x17},
+		DHGenerator:          []byte{0x05},
+		DHPublicKey:          []byte{0x00, 0x13},
+		HashAlgorithm:        hash.SHA256,
+		SignatureAlgorithm:   signature.RSA,
+		Signature:            []byte{0xaa, 0xbb, 0xcc},
+		KeyExchangeAlgorithm: types.KeyExchangeAlgorithmDhe,
+	}
+
+	c := &MessageServerKeyExchange{
+		KeyExchangeAlgorithm: types.KeyExchangeAlgorithmDhe,
+	}
+	if err := c.Unmarshal(rawServerKeyExchange); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(c, parsedServerKeyExchange) {
+		t.Errorf("handshakeMessageServerKeyExchange unmarshal: got %#v, want %#v", c, parsedServerKeyExchange)
+	}
+
+	raw, err := c.Marshal()
+	if err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(raw, rawServerKeyExchange) {
+		t.Errorf("handshakeMessageServerKeyExchange marshal: got %#v, want %#v", raw, rawServerKeyExchange)
+	}
+
+	if err := (&MessageServerKeyExchange{KeyExchangeAlgorithm: types.KeyExchangeAlgorithmDhe}).Unmarshal(rawServerKeyExchange[:8]); err == nil {
+		t.Error("Expected an error for truncated ServerDHParams")
+	}
+}
@@ -6,6 +6,7 @@ package handshake
 import (
 	"crypto/rand"
 	"encoding/binary"
+	"io"
 	"time"
 )
 
@@ -42,10 +43,15 @@ func (r *Random) UnmarshalFixed(data [RandomLength]byte) {
 // Populate fills the handshakeRandom with random values
 // may be called multiple times
 func (r *Random) Populate() error {
+	return r.PopulateFrom(rand.Reader)
+}
+
+// PopulateFrom is like Populate but reads the random values from rand.
+func (r *Random) PopulateFrom(rand io.Reader) error {
 	r.GMTUnixTime = time.Now()
 
 	tmp := make([]byte, RandomBytesLength)
-	_, err := rand.Read(tmp)
+	_, err := io.ReadFull(rand, tmp)
 	copy(r.RandomBytes[:], tmp)
 
 	return err
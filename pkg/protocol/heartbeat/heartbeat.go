@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+// Package heartbeat implements the DTLS heartbeat protocol https://tools.ietf.org/html/rfc6520
+package heartbeat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+)
+
+const (
+	headerSize = 3
+
+	// MinPaddingLength is the minimum number of random padding bytes that
+	// must follow the payload of a heartbeat message.
+	MinPaddingLength = 16
+
+	// MaxPayloadLength is the largest payload that fits in a heartbeat
+	// message, which must not exceed 2^14 bytes including its header and
+	// padding.
+	MaxPayloadLength = 1<<14 - headerSize - MinPaddingLength
+)
+
+var (
+	errBufferTooSmall        = &protocol.TemporaryError{Err: errors.New("buffer is too small")}                                 //nolint:goerr113
+	errInvalidMessageType    = &protocol.TemporaryError{Err: errors.New("invalid heartbeat message type")}                      //nolint:goerr113
+	errPayloadLengthExceeded = &protocol.TemporaryError{Err: errors.New("heartbeat payload length exceeds the message length")} //nolint:goerr113
+	errPayloadTooLarge       = &protocol.TemporaryError{Err: errors.New("heartbeat payload is too large")}                      //nolint:goerr113
+	errPaddingTooSmall       = &protocol.TemporaryError{Err: errors.New("heartbeat padding is too small")}                      //nolint:goerr113
+)
+
+// MessageType is the type of a heartbeat message
+type MessageType byte
+
+// MessageType enums
+const (
+	Request  MessageType = 1
+	Response MessageType = 2
+)
+
+func (t MessageType) String() string {
+	switch t {
+	case Request:
+		return "HeartbeatRequest"
+	case Response:
+		return "HeartbeatResponse"
+	default:
+		return "Invalid heartbeat message type"
+	}
+}
+
+// Heartbeat is the content type used to check that the peer is still alive
+// and to keep middlebox bindings open without a full application payload.
+// A HeartbeatRequest must be answered with a HeartbeatResponse carrying an
+// exact copy of its payload.
+// https://tools.ietf.org/html/rfc6520#section-4
+type Heartbeat struct {
+	Type    MessageType
+	Payload []byte
+	// Padding is random data ignored by the receiver. It must be at least
+	// MinPaddingLength bytes long; the sender generates it.
+	Padding []byte
+}
+
+// ContentType returns the ContentType of this Content
+func (h Heartbeat) ContentType() protocol.ContentType {
+	return protocol.ContentTypeHeartbeat
+}
+
+// Marshal encodes the Heartbeat to binary
+func (h *Heartbeat) Marshal() ([]byte, error) {
+	if len(h.Payload) > MaxPayloadLength {
+		return nil, errPayloadTooLarge
+	}
+
+	if len(h.Padding) < MinPaddingLength {
+		return nil, errPaddingTooSmall
+	}
+
+	out := make([]byte, headerSize, headerSize+len(h.Payload)+len(h.Padding))
+	out[0] = byte(h.Type)
+	binary.BigEndian.PutUint16(out[1:], uint16(len(h.Payload)))
+	out = append(out, h.Payload...)
+	return append(out, h.Padding...), nil
+}
+
+// Unmarshal populates the Heartbeat from binary data. The declared payload
+// length is checked against the received data, so a message claiming more
+// payload than it carries is rejected instead of being echoed back with
+// memory beyond the record.
+func (h *Heartbeat) Unmarshal(data []byte) error {
+	if len(data) < headerSize {
+		return errBufferTooSmall
+	}
+
+	switch MessageType(data[0]) {
+	case Request, Response:
+	default:
+		return errInvalidMessageType
+	}
+
+	payloadLength := int(binary.BigEndian.Uint16(data[1:]))
+	if headerSize+payloadLength > len(data) {
+		return errPayloadLengthExceeded
+	}
+	if len(data)-headerSize-payloadLength < MinPaddingLength {
+		return errPaddingTooSmall
+	}
+
+	h.Type = MessageType(data[0])
+	h.Payload = append([]byte{}, data[headerSize:headerSize+payloadLength]...)
+	h.Padding = append([]byte{}, data[headerSize+payloadLength:]...)
+	return nil
+}
+
+func (h *Heartbeat) String() string {
+	return fmt.Sprintf("%s (payload: %d bytes)", h.Type, len(h.Payload))
+}
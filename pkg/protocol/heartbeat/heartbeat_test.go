@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package heartbeat
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHeartbeat(t *testing.T) {
+	padding := bytes.Repeat([]byte{0xff}, MinPaddingLength)
+
+	for _, test := range []struct {
+		Name               string
+		Data               []byte
+		Want               *Heartbeat
+		WantUnmarshalError error
+	}{
+		{
+			Name: "Valid HeartbeatRequest",
+			Data: append([]byte{0x01, 0x00, 0x03, 0x01, 0x02, 0x03}, padding...),
+			Want: &Heartbeat{
+				Type:    Request,
+				Payload: []byte{0x01, 0x02, 0x03},
+				Padding: padding,
+			},
+		},
+		{
+			Name: "Valid HeartbeatResponse",
+			Data: append([]byte{0x02, 0x00, 0x00}, padding...),
+			Want: &Heartbeat{
+				Type:    Response,
+				Payload: []byte{},
+				Padding: padding,
+			},
+		},
+		{
+			Name:               "Invalid length",
+			Data:               []byte{0x01, 0x00},
+			Want:               &Heartbeat{},
+			WantUnmarshalError: errBufferTooSmall,
+		},
+		{
+			Name:               "Invalid message type",
+			Data:               append([]byte{0x03, 0x00, 0x00}, padding...),
+			Want:               &Heartbeat{},
+			WantUnmarshalError: errInvalidMessageType,
+		},
+		{
+			Name:               "Payload length exceeds record",
+			Data:               append([]byte{0x01, 0x40, 0x00, 0x01}, padding...),
+			Want:               &Heartbeat{},
+			WantUnmarshalError: errPayloadLengthExceeded,
+		},
+		{
+			Name:               "Padding too small",
+			Data:               []byte{0x01, 0x00, 0x01, 0x01, 0xff},
+			Want:               &Heartbeat{},
+			WantUnmarshalError: errPaddingTooSmall,
+		},
+	} {
+		h := &Heartbeat{}
+		if err := h.Unmarshal(test.Data); !errors.Is(err, test.WantUnmarshalError) {
+			t.Errorf("Unexpected Error %v: exp: %v got: %v", test.Name, test.WantUnmarshalError, err)
+		} else if !reflect.DeepEqual(test.Want, h) {
+			t.Errorf("%q heartbeat.unmarshal: got %v, want %v", test.Name, h, test.Want)
+		}
+
+		if test.WantUnmarshalError != nil {
+			continue
+		}
+
+		data, marshalErr := h.Marshal()
+		if marshalErr != nil {
+			t.Errorf("Unexpected Error %v: got: %v", test.Name, marshalErr)
+		} else if !reflect.DeepEqual(test.Data, data) {
+			t.Errorf("%q heartbeat.marshal: got %#v, want %#v", test.Name, data, test.Data)
+		}
+	}
+}
+
+func TestHeartbeatMarshalPadding(t *testing.T) {
+	h := &Heartbeat{Type: Request, Payload: []byte{0x01}, Padding: make([]byte, MinPaddingLength-1)}
+	if _, err := h.Marshal(); !errors.Is(err, errPaddingTooSmall) {
+		t.Errorf("heartbeat.marshal short padding: got %v, want %v", err, errPaddingTooSmall)
+	}
+
+	h = &Heartbeat{Type: Request, Payload: make([]byte, MaxPayloadLength+1), Padding: make([]byte, MinPaddingLength)}
+	if _, err := h.Marshal(); !errors.Is(err, errPayloadTooLarge) {
+		t.Errorf("heartbeat.marshal oversized payload: got %v, want %v", err, errPayloadTooLarge)
+	}
+}
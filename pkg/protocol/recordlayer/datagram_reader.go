@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package recordlayer
+
+import (
+	"io"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+)
+
+// DatagramReader iterates over the records of a single datagram, parsing the
+// header of each one. Like ContentAwareUnpackDatagram it considers the
+// connection identifier of tls12_cid records, which is expected to be cidLen
+// bytes long. It does not need a Conn, so it can be used to inspect captured
+// traffic.
+type DatagramReader struct {
+	data   []byte
+	cidLen int
+	err    error
+}
+
+// NewDatagramReader returns a DatagramReader for the records in data.
+func NewDatagramReader(data []byte, cidLen int) *DatagramReader {
+	return &DatagramReader{data: data, cidLen: cidLen}
+}
+
+// Next returns the header and the content of the next record. The content
+// and the ConnectionID of the header alias data. Next returns io.EOF after
+// the last record; once it returned an error it keeps returning it.
+func (r *DatagramReader) Next() (*Header, []byte, error) {
+	if r.err != nil {
+		return nil, nil, r.err
+	}
+	if len(r.data) == 0 {
+		r.err = io.EOF
+		return nil, nil, r.err
+	}
+
+	headerSize, contentLen, err := recordSize(r.data, r.cidLen)
+	if err != nil {
+		r.err = err
+		return nil, nil, err
+	}
+
+	h := &Header{}
+	if protocol.ContentType(r.data[0]) == protocol.ContentTypeConnectionID {
+		h.ConnectionID = make([]byte, r.cidLen)
+	}
+	if err := h.Unmarshal(r.data[:headerSize]); err != nil {
+		r.err = err
+		return nil, nil, err
+	}
+	h.ContentLen = uint16(contentLen)
+
+	content := r.data[headerSize : headerSize+contentLen]
+	r.data = r.data[headerSize+contentLen:]
+	return h, content, nil
+}
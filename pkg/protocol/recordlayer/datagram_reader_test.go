@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package recordlayer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+)
+
+func TestDatagramReader(t *testing.T) {
+	records := []struct {
+		header  Header
+		content []byte
+	}{
+		{
+			header: Header{
+				ContentType:    protocol.ContentTypeHandshake,
+				Version:        protocol.Version1_2,
+				SequenceNumber: 7,
+			},
+			content: []byte{0x01, 0x02, 0x03},
+		},
+		{
+			header: Header{
+				ContentType:    protocol.ContentTypeConnectionID,
+				Version:        protocol.Version1_2,
+				Epoch:          1,
+				SequenceNumber: 8,
+				ConnectionID:   []byte{0xaa, 0xbb, 0xcc, 0xdd},
+			},
+			content: []byte{0x04, 0x05},
+		},
+		{
+			header: Header{
+				ContentType:    protocol.ContentTypeApplicationData,
+				Version:        protocol.Version1_2,
+				Epoch:          1,
+				SequenceNumber: 9,
+			},
+			content: []byte{0x06},
+		},
+	}
+
+	datagram := []byte{}
+	for _, r := range records {
+		h := r.header
+		h.ContentLen = uint16(len(r.content))
+		var err error
+		if datagram, err = h.AppendMarshal(datagram); err != nil {
+			t.Fatal(err)
+		}
+		datagram = append(datagram, r.content...)
+	}
+
+	reader := NewDatagramReader(datagram, 4)
+	for i, r := range records {
+		h, content, err := reader.Next()
+		if err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+		expected := r.header
+		expected.ContentLen = uint16(len(r.content))
+		if !reflect.DeepEqual(&expected, h) {
+			t.Errorf("Record %d: header mismatch\nwant: %+v\ngot: %+v", i, expected, *h)
+		}
+		if !bytes.Equal(r.content, content) {
+			t.Errorf("Record %d: content mismatch\nwant: %v\ngot: %v", i, r.content, content)
+		}
+	}
+	if _, _, err := reader.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF after the last record, got %v", err)
+	}
+
+	truncated := NewDatagramReader(datagram[:len(datagram)-1], 4)
+	for i := 0; i < 2; i++ {
+		if _, _, err := truncated.Next(); err != nil {
+			t.Fatalf("Record %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if _, _, err := truncated.Next(); !errors.Is(err, ErrInvalidPacketLength) {
+			t.Errorf("Expected %v for a truncated record, got %v", ErrInvalidPacketLength, err)
+		}
+	}
+}
@@ -32,13 +32,21 @@ const (
 
 // Marshal encodes a TLS RecordLayer Header to binary
 func (h *Header) Marshal() ([]byte, error) {
+	return h.AppendMarshal(make([]byte, 0, h.Size()))
+}
+
+// AppendMarshal appends the encoded Header to b and returns the extended
+// buffer, which only allocates if b lacks the capacity.
+func (h *Header) AppendMarshal(b []byte) ([]byte, error) {
 	if h.SequenceNumber > MaxSequenceNumber {
 		return nil, errSequenceNumberOverflow
 	}
 
 	hs := FixedHeaderSize + len(h.ConnectionID)
 
-	out := make([]byte, hs)
+	start := len(b)
+	b = append(b, make([]byte, hs)...)
+	out := b[start:]
 	out[0] = byte(h.ContentType)
 	out[1] = h.Version.Major
 	out[2] = h.Version.Minor
@@ -46,7 +54,7 @@ func (h *Header) Marshal() ([]byte, error) {
 	util.PutBigEndianUint48(out[5:], h.SequenceNumber)
 	copy(out[11:11+len(h.ConnectionID)], h.ConnectionID)
 	binary.BigEndian.PutUint16(out[hs-2:], h.ContentLen)
-	return out, nil
+	return b, nil
 }
 
 // Unmarshal populates a TLS RecordLayer Header from binary
@@ -9,6 +9,7 @@ import (
 	"github.com/censys-oss/dtls/v2/pkg/protocol"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/heartbeat"
 )
 
 // DTLS fixed size record layer header when Connection IDs are not in-use.
@@ -50,20 +51,35 @@ type RecordLayer struct {
 
 // Marshal encodes the RecordLayer to binary
 func (r *RecordLayer) Marshal() ([]byte, error) {
-	contentRaw, err := r.Content.Marshal()
-	if err != nil {
-		return nil, err
+	return r.AppendMarshal(nil)
+}
+
+// AppendMarshal appends the encoded RecordLayer to b and returns the extended
+// buffer. ApplicationData is copied straight from its Data, so no allocation
+// happens for it if b has enough capacity.
+func (r *RecordLayer) AppendMarshal(b []byte) ([]byte, error) {
+	var contentRaw []byte
+	if a, ok := r.Content.(*protocol.ApplicationData); ok {
+		contentRaw = a.Data
+	} else {
+		var err error
+		if contentRaw, err = r.Content.Marshal(); err != nil {
+			return nil, err
+		}
 	}
 
 	r.Header.ContentLen = uint16(len(contentRaw))
 	r.Header.ContentType = r.Content.ContentType()
 
-	headerRaw, err := r.Header.Marshal()
+	if b == nil {
+		b = make([]byte, 0, r.Header.Size()+len(contentRaw))
+	}
+	b, err := r.Header.AppendMarshal(b)
 	if err != nil {
 		return nil, err
 	}
 
-	return append(headerRaw, contentRaw...), nil
+	return append(b, contentRaw...), nil
 }
 
 // Unmarshal populates the RecordLayer from binary
@@ -81,11 +97,13 @@ func (r *RecordLayer) Unmarshal(data []byte) error {
 		r.Content = &handshake.Handshake{}
 	case protocol.ContentTypeApplicationData:
 		r.Content = &protocol.ApplicationData{}
+	case protocol.ContentTypeHeartbeat:
+		r.Content = &heartbeat.Heartbeat{}
 	default:
 		return errInvalidContentType
 	}
 
-	return r.Content.Unmarshal(data[r.Header.Size()+len(r.Header.ConnectionID):])
+	return r.Content.Unmarshal(data[r.Header.Size():])
 }
 
 // UnpackDatagram extracts all RecordLayer messages from a single datagram.
@@ -122,24 +140,34 @@ func ContentAwareUnpackDatagram(buf []byte, cidLength int) ([][]byte, error) {
 	out := [][]byte{}
 
 	for offset := 0; len(buf) != offset; {
-		headerSize := FixedHeaderSize
-		lenIdx := fixedHeaderLenIdx
-		if protocol.ContentType(buf[offset]) == protocol.ContentTypeConnectionID {
-			headerSize += cidLength
-			lenIdx += cidLength
-		}
-		if len(buf)-offset <= headerSize {
-			return nil, ErrInvalidPacketLength
-		}
-
-		pktLen := (headerSize + int(binary.BigEndian.Uint16(buf[offset+lenIdx:])))
-		if offset+pktLen > len(buf) {
-			return nil, ErrInvalidPacketLength
+		headerSize, pktLen, err := recordSize(buf[offset:], cidLength)
+		if err != nil {
+			return nil, err
 		}
 
-		out = append(out, buf[offset:offset+pktLen])
-		offset += pktLen
+		out = append(out, buf[offset:offset+headerSize+pktLen])
+		offset += headerSize + pktLen
 	}
 
 	return out, nil
 }
+
+// recordSize returns the header size and content length of the record at the
+// start of buf, considering the connection identifier of a tls12_cid record.
+func recordSize(buf []byte, cidLength int) (int, int, error) {
+	headerSize := FixedHeaderSize
+	lenIdx := fixedHeaderLenIdx
+	if protocol.ContentType(buf[0]) == protocol.ContentTypeConnectionID {
+		headerSize += cidLength
+		lenIdx += cidLength
+	}
+	if len(buf) <= headerSize {
+		return 0, 0, ErrInvalidPacketLength
+	}
+
+	contentLen := int(binary.BigEndian.Uint16(buf[lenIdx:]))
+	if headerSize+contentLen > len(buf) {
+		return 0, 0, ErrInvalidPacketLength
+	}
+	return headerSize, contentLen, nil
+}
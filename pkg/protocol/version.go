@@ -8,6 +8,7 @@ package protocol
 var (
 	Version1_0 = Version{Major: 0xfe, Minor: 0xff} //nolint:gochecknoglobals
 	Version1_2 = Version{Major: 0xfe, Minor: 0xfd} //nolint:gochecknoglobals
+	Version1_3 = Version{Major: 0xfe, Minor: 0xfc} //nolint:gochecknoglobals
 )
 
 // Version is the minor/major value in the RecordLayer
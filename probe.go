@@ -0,0 +1,158 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+)
+
+// ProbeCipherSuites enumerates the cipher suites accepted by the server at
+// addr. It performs one handshake per candidate suite, offering only that
+// suite, and returns the IDs of the suites for which the handshake completed
+// in the order they were probed. The candidates are config.CipherSuites, or
+// the default cipher suites if it is empty; suites that can not be offered
+// with config, for example PSK suites without a PSK, are skipped.
+//
+// All handshakes share a single UDP socket. Each one is bounded by the
+// context returned from config.ConnectContextMaker as well as by ctx; an
+// error is only returned if ctx is done or the socket can not be opened.
+func ProbeCipherSuites(ctx context.Context, addr *net.UDPAddr, config *Config) ([]uint16, error) {
+	if config == nil {
+		return nil, errNoConfigProvided
+	}
+
+	candidates, err := parseCipherSuites(config.CipherSuites, nil, config.includeCertificateSuites(), config.PSK != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// net.ListenUDP is used rather than net.DialUDP as the latter prevents the
+	// use of net.PacketConn.WriteTo, see DialWithContext.
+	pConn, err := net.ListenUDP(addr.Network(), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = pConn.Close()
+	}()
+	conn := &probePacketConn{pConn}
+
+	accepted := []uint16{}
+	for _, c := range candidates {
+		cfg := *config
+		cfg.CipherSuites = []CipherSuiteID{c.ID()}
+		cfg.CustomCipherSuites = nil
+		if validateConfig(&cfg) != nil {
+			continue
+		}
+
+		ok, err := probeCipherSuite(ctx, conn, addr, &cfg)
+		if err != nil {
+			return accepted, err
+		}
+		if ok {
+			accepted = append(accepted, uint16(c.ID()))
+		}
+	}
+
+	return accepted, nil
+}
+
+func probeCipherSuite(ctx context.Context, conn net.PacketConn, addr net.Addr, config *Config) (bool, error) {
+	connectCtx, cancelConnect := config.connectContextMaker()
+	defer cancelConnect()
+
+	probeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-connectCtx.Done():
+			cancel()
+		case <-probeCtx.Done():
+		}
+	}()
+
+	dconn, err := ClientWithContext(probeCtx, conn, addr, config)
+	if err != nil {
+		return false, ctx.Err()
+	}
+	_ = dconn.Close()
+	return true, nil
+}
+
+// ClientAuthRequirement classifies how a server treats client certificates,
+// as determined by ProbeClientAuth.
+type ClientAuthRequirement int
+
+// ClientAuthRequirement enums
+const (
+	// NoClientAuth indicates the server did not send a CertificateRequest.
+	NoClientAuth ClientAuthRequirement = iota
+	// RequestsButOptional indicates the server sent a CertificateRequest but
+	// completed the handshake without a client certificate.
+	RequestsButOptional
+	// RequiresClientAuth indicates the server sent a CertificateRequest and
+	// aborted the handshake when no client certificate was sent.
+	RequiresClientAuth
+)
+
+func (r ClientAuthRequirement) String() string {
+	switch r {
+	case NoClientAuth:
+		return "NoClientAuth"
+	case RequestsButOptional:
+		return "RequestsButOptional"
+	case RequiresClientAuth:
+		return "RequiresClientAuth"
+	default:
+		return "Invalid ClientAuthRequirement"
+	}
+}
+
+// ProbeClientAuth determines whether the server at addr requires client
+// authentication. It performs a handshake that answers any CertificateRequest
+// with an empty Certificate and classifies the outcome. Certificates and
+// GetClientCertificate in config are ignored. If the handshake fails before
+// the server requested a certificate or ctx is done, NoClientAuth is returned
+// together with the handshake error.
+func ProbeClientAuth(ctx context.Context, addr *net.UDPAddr, config *Config) (ClientAuthRequirement, error) {
+	if config == nil {
+		return NoClientAuth, errNoConfigProvided
+	}
+
+	var requested atomic.Bool
+	cfg := *config
+	cfg.Certificates = nil
+	cfg.GetClientCertificate = func(*CertificateRequestInfo) (*tls.Certificate, error) {
+		requested.Store(true)
+		return new(tls.Certificate), nil
+	}
+
+	dconn, err := DialWithContext(ctx, addr.Network(), addr, &cfg)
+	switch {
+	case err == nil:
+		_ = dconn.Close()
+		if requested.Load() {
+			return RequestsButOptional, nil
+		}
+		return NoClientAuth, nil
+	case requested.Load() && ctx.Err() == nil:
+		return RequiresClientAuth, nil
+	default:
+		return NoClientAuth, err
+	}
+}
+
+// probePacketConn shares one socket between the handshakes of
+// ProbeCipherSuites by ignoring Close from each Conn.
+type probePacketConn struct {
+	net.PacketConn
+}
+
+func (c *probePacketConn) Close() error {
+	return nil
+}
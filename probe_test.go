@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestProbeCipherSuites(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{
+		Certificates: []tls.Certificate{serverCert},
+		CipherSuites: []CipherSuiteID{
+			TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejected handshakes surface as Accept errors, so keep accepting until
+	// the listener is closed.
+	closing := make(chan struct{})
+	acceptDone := make(chan struct{})
+	go func() {
+		defer close(acceptDone)
+		for {
+			c, err := l.Accept()
+			if err == nil {
+				_ = c.Close()
+				continue
+			}
+			select {
+			case <-closing:
+				return
+			default:
+			}
+		}
+	}()
+	defer func() {
+		close(closing)
+		_ = l.Close()
+		<-acceptDone
+	}()
+
+	accepted, err := ProbeCipherSuites(ctx, l.Addr().(*net.UDPAddr), &Config{
+		InsecureSkipVerify: true,
+		CipherSuites: []CipherSuiteID{
+			TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+			TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		},
+		ConnectContextMaker: func() (context.Context, func()) {
+			return context.WithTimeout(context.Background(), 5*time.Second)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []uint16{
+		uint16(TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256),
+		uint16(TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA),
+	}
+	if !reflect.DeepEqual(expected, accepted) {
+		t.Errorf("Accepted cipher suites mismatch\nwant: %v\ngot: %v", expected, accepted)
+	}
+}
+
+func TestProbeClientAuth(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 30)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		clientAuth ClientAuthType
+		expected   ClientAuthRequirement
+	}{
+		"NoClientCert": {
+			clientAuth: NoClientCert,
+			expected:   NoClientAuth,
+		},
+		"RequestClientCert": {
+			clientAuth: RequestClientCert,
+			expected:   RequestsButOptional,
+		},
+		"RequireAnyClientCert": {
+			clientAuth: RequireAnyClientCert,
+			expected:   RequiresClientAuth,
+		},
+	} {
+		tt := tt
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			l, err := Listen("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, &Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tt.clientAuth,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			acceptDone := make(chan struct{})
+			go func() {
+				defer close(acceptDone)
+				if c, err := l.Accept(); err == nil {
+					_ = c.Close()
+				}
+			}()
+			defer func() {
+				_ = l.Close()
+				<-acceptDone
+			}()
+
+			actual, err := ProbeClientAuth(ctx, l.Addr().(*net.UDPAddr), &Config{
+				InsecureSkipVerify: true,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if actual != tt.expected {
+				t.Errorf("Client auth requirement mismatch\nwant: %s\ngot: %s", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+// RecordProtectionParams describes how the records of a connection are
+// protected, so that an external record layer can derive the same keys from
+// the key block and build the same nonces as the negotiated cipher suite.
+type RecordProtectionParams struct {
+	// CipherSuiteID is the negotiated cipher suite.
+	CipherSuiteID CipherSuiteID
+
+	// MACKeyLength is the length of each write MAC key. It is zero for
+	// AEAD cipher suites.
+	MACKeyLength int
+
+	// KeyLength is the length of each write key.
+	KeyLength int
+
+	// IVLength is the length of each write IV taken from the key block. For
+	// AEAD cipher suites it is the implicit part of the nonce.
+	IVLength int
+
+	// ExplicitNonceLength is the length of the nonce, or of the IV for CBC
+	// cipher suites, sent at the start of every record.
+	ExplicitNonceLength int
+
+	// TagLength is the length of the authentication tag or MAC appended to
+	// every record.
+	TagLength int
+
+	// SequenceNumberNonce is set if the explicit nonce of every record sent
+	// is its epoch and sequence number instead of random bytes, as enabled
+	// by Config.SequenceNumberNonces. The nonce is sent either way, so it
+	// does not change ExplicitNonceLength or the overhead of a record.
+	SequenceNumberNonce bool
+}
+
+// recordProtectionParams returns the record protection parameters of the
+// cipher suite id, and false if they are not known, as is the case for
+// custom cipher suites.
+func recordProtectionParams(id CipherSuiteID, truncatedHMAC bool) (RecordProtectionParams, bool) {
+	const (
+		aeadIVLength    = 4
+		aeadNonceLength = 8
+		cbcIVLength     = 16
+	)
+
+	p := RecordProtectionParams{CipherSuiteID: id}
+	switch id { //nolint:exhaustive
+	case TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_DHE_RSA_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_ECDSA_WITH_AES_128_CCM,
+		TLS_PSK_WITH_AES_128_CCM:
+		p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 16, aeadIVLength, aeadNonceLength, 16
+	case TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384:
+		p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 32, aeadIVLength, aeadNonceLength, 16
+	case TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8,
+		TLS_PSK_WITH_AES_128_CCM_8:
+		p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 16, aeadIVLength, aeadNonceLength, 8
+	case TLS_PSK_WITH_AES_256_CCM_8:
+		p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 32, aeadIVLength, aeadNonceLength, 8
+	case TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA:
+		p.MACKeyLength, p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 20, 32, cbcIVLength, cbcIVLength, 20
+	case TLS_PSK_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256:
+		p.MACKeyLength, p.KeyLength, p.IVLength, p.ExplicitNonceLength, p.TagLength = 32, 16, cbcIVLength, cbcIVLength, 32
+	default:
+		return RecordProtectionParams{}, false
+	}
+
+	// rfc6066#section-7
+	if p.MACKeyLength > 0 && truncatedHMAC {
+		p.TagLength = 10
+	}
+	return p, true
+}
+
+// RecordProtectionParams returns the record protection parameters of the
+// negotiated cipher suite. It returns the zero value if no cipher suite has
+// been negotiated yet or if the parameters of a custom cipher suite are not
+// known.
+func (c *Conn) RecordProtectionParams() RecordProtectionParams {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.state.cipherSuite == nil {
+		return RecordProtectionParams{}
+	}
+	p, ok := recordProtectionParams(c.state.cipherSuite.ID(), c.state.truncatedHMAC)
+	if ok {
+		p.SequenceNumberNonce = cipherSuiteSequenceNonce(c.state.cipherSuite)
+	}
+	return p
+}
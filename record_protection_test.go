@@ -0,0 +1,91 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestRecordProtectionParams(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	client, server, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	expected := RecordProtectionParams{
+		CipherSuiteID:       TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		KeyLength:           16,
+		IVLength:            4,
+		ExplicitNonceLength: 8,
+		TagLength:           16,
+	}
+	for name, c := range map[string]*Conn{"Client": client, "Server": server} {
+		if actual := c.RecordProtectionParams(); actual != expected {
+			t.Errorf("%s: expected %+v, got %+v", name, expected, actual)
+		}
+	}
+}
+
+func TestRecordProtectionParamsOverhead(t *testing.T) {
+	masterSecret := make([]byte, 48)
+	random := make([]byte, handshake.RandomLength)
+
+	for _, cipherSuite := range allCipherSuites() {
+		p, ok := recordProtectionParams(cipherSuite.ID(), false)
+		if !ok {
+			t.Errorf("%s: no record protection parameters", cipherSuite)
+			continue
+		}
+		if err := cipherSuite.Init(masterSecret, random, random, true); err != nil {
+			t.Fatal(err)
+		}
+
+		payload := make([]byte, 64)
+		pkt := &recordlayer.RecordLayer{
+			Header: recordlayer.Header{
+				Version: protocol.Version1_2,
+				Epoch:   1,
+			},
+			Content: &protocol.ApplicationData{Data: payload},
+		}
+		raw, err := pkt.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		encrypted, err := cipherSuite.Encrypt(pkt, raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// CBC cipher suites add between 1 and a block of padding.
+		overhead := len(encrypted) - len(raw)
+		minOverhead := p.ExplicitNonceLength + p.TagLength
+		maxOverhead := minOverhead
+		if p.MACKeyLength > 0 {
+			minOverhead++
+			maxOverhead += p.IVLength
+		}
+		if overhead < minOverhead || overhead > maxOverhead {
+			t.Errorf("%s: record overhead %d does not match %+v", cipherSuite, overhead, p)
+		}
+	}
+}
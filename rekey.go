@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol/alert"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
+)
+
+// rekey is a handshake that renegotiates the keys of an established
+// connection. It runs in the current epoch, protected by its keys, and its
+// keys protect the next one.
+type rekey struct {
+	fsm   *handshakeFSM
+	epoch uint16
+
+	done chan struct{} // Closed once the rekey has completed or failed
+	err  error
+	once sync.Once
+}
+
+// Rekey renegotiates the keys of the connection with a full handshake over
+// the established one, so that a long-lived connection can refresh its keys
+// and start its record sequence numbers over in a new epoch. Only a client
+// can start a rekey; a server follows when it receives the ClientHello if
+// its Config sets AcceptRekey, and refuses it otherwise.
+//
+// The peer must present the same certificates as in the previous handshake,
+// and both Finished messages of that handshake are bound to the new one with
+// the renegotiation_info extension [RFC5746]. Application data keeps flowing
+// while the rekey runs, and records of the previous epoch are still accepted
+// once it completes. As for the handshake, the rekey only progresses while
+// the connection reads from the underlying connection.
+//
+// The connection is closed if the rekey fails, is refused, or ctx is done
+// before it completes.
+func (c *Conn) Rekey(ctx context.Context) error {
+	if c.isConnectionClosed() {
+		return ErrConnClosed
+	}
+	if !c.isHandshakeCompletedSuccessfully() {
+		return errHandshakeInProgress
+	}
+	if !c.state.isClient {
+		return errRekeyNotClient
+	}
+
+	r, err := c.newRekey(flight1)
+	if err != nil {
+		return err
+	}
+	if err := c.startRekey(r); err != nil {
+		return err
+	}
+
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+		c.abortRekey(r, ctx.Err())
+		<-r.done
+	}
+	if r.err != nil {
+		return &HandshakeError{Err: r.err}
+	}
+	return nil
+}
+
+// newRekey returns a rekey of the connection that starts with initialFlight
+// in the current epoch.
+func (c *Conn) newRekey(initialFlight flightVal) (*rekey, error) {
+	epoch := c.state.getLocalEpoch()
+	if epoch != c.state.getRemoteEpoch() {
+		return nil, errRekeyInProgress
+	}
+	if epoch == math.MaxUint16 {
+		return nil, errRekeyEpochExhausted
+	}
+	clientVerifyData, serverVerifyData := c.finishedVerifyData(epoch)
+	if clientVerifyData == nil || serverVerifyData == nil {
+		// A resumed connection does not have the messages of its handshake.
+		return nil, errRekeyUnavailable
+	}
+
+	cfg, err := newHandshakeConfig(c.config, c.log, c.state.isClient)
+	if err != nil {
+		return nil, err
+	}
+	cfg.initialEpoch = epoch
+	cfg.clientVerifyData, cfg.serverVerifyData = clientVerifyData, serverVerifyData
+
+	c.lock.RLock()
+	peerCertificates := c.state.PeerCertificates
+	c.lock.RUnlock()
+	cfg.restrict = func(cfg *handshakeConfig) {
+		// The peer has already proven its address, and the keys of a rekey
+		// must be fresh, so there is neither a cookie exchange nor an
+		// abbreviated handshake. The connection IDs of the connection are
+		// kept.
+		cfg.insecureSkipHelloVerify = true
+		cfg.sessionStore = nil
+		cfg.connectionIDGenerator = nil
+
+		verifyConnection := cfg.verifyConnection
+		cfg.verifyConnection = func(s *State) error {
+			if len(s.PeerCertificates) != len(peerCertificates) {
+				return errRekeyPeerChanged
+			}
+			for i := range s.PeerCertificates {
+				if !bytes.Equal(s.PeerCertificates[i], peerCertificates[i]) {
+					return errRekeyPeerChanged
+				}
+			}
+			if verifyConnection != nil {
+				return verifyConnection(s)
+			}
+			return nil
+		}
+	}
+	cfg.restrict(cfg)
+
+	r := &rekey{epoch: epoch, done: make(chan struct{})}
+	cfg.onFlightState = func(_ flightVal, s handshakeState) {
+		if s == handshakeFinished {
+			c.completeRekey(r)
+		}
+	}
+	cfg.onRetransmit = func() {
+		atomic.AddUint64(&c.retransmittedFlights, 1)
+	}
+	// The handshake describes its state to callbacks such as
+	// VerifyConnection as of the epoch it runs in.
+	next := &State{isClient: c.state.isClient}
+	next.localEpoch.Store(epoch)
+	next.remoteEpoch.Store(epoch)
+	r.fsm = newHandshakeFSM(next, c.handshakeCache, cfg, initialFlight)
+	return r, nil
+}
+
+// finishedVerifyData returns the verify_data of the Finished messages that
+// started epoch, or nil for those that are not in the handshake cache.
+func (c *Conn) finishedVerifyData(epoch uint16) (client, server []byte) {
+	items := c.handshakeCache.pull(
+		handshakeCachePullRule{handshake.TypeFinished, epoch, true, false},
+		handshakeCachePullRule{handshake.TypeFinished, epoch, false, false},
+	)
+	verifyData := make([][]byte, len(items))
+	for i, item := range items {
+		if item == nil {
+			continue
+		}
+		h := &handshake.Handshake{}
+		if err := h.Unmarshal(item.data); err != nil {
+			continue
+		}
+		if finished, ok := h.Message.(*handshake.MessageFinished); ok {
+			verifyData[i] = finished.VerifyData
+		}
+	}
+	return verifyData[0], verifyData[1]
+}
+
+// startRekey stops the handshake FSM that currently runs and hands r to the
+// handshake routine.
+func (c *Conn) startRekey(r *rekey) error {
+	c.lock.Lock()
+	if c.rekeying != nil {
+		c.lock.Unlock()
+		return errRekeyInProgress
+	}
+	c.rekeying = r
+	cancelFSM := c.cancelFSM
+	c.lock.Unlock()
+
+	cancelFSM()
+	select {
+	case c.rekeys <- r:
+		return nil
+	case <-c.handshakerDone:
+		c.lock.Lock()
+		c.rekeying = nil
+		c.lock.Unlock()
+		return ErrConnClosed
+	}
+}
+
+// acceptRekey starts a rekey on a server that received a ClientHello in
+// epoch. Retransmissions of the ClientHello of a rekey are ignored. Unless
+// the Config accepts rekeys, it returns the no_renegotiation alert that
+// refuses the rekey instead.
+func (c *Conn) acceptRekey(epoch uint16) *alert.Alert {
+	if !c.isHandshakeCompletedSuccessfully() || epoch != c.state.getLocalEpoch() {
+		return nil
+	}
+	if !c.config.AcceptRekey {
+		c.log.Debugf("%s: refused rekey", srvCliStr(c.state.isClient))
+		return &alert.Alert{Level: alert.Warning, Description: alert.NoRenegotiation}
+	}
+	r, err := c.newRekey(flight0)
+	if err == nil {
+		err = c.startRekey(r)
+	}
+	if err != nil {
+		c.log.Debugf("%s: ignored rekey: %s", srvCliStr(c.state.isClient), err)
+	}
+	return nil
+}
+
+// refuseRekey fails the rekey a client is running, if any, when the server
+// refused it.
+func (c *Conn) refuseRekey() {
+	c.lock.RLock()
+	r := c.rekeying
+	c.lock.RUnlock()
+	if r != nil {
+		c.abortRekey(r, errRekeyRefused)
+	}
+}
+
+// completeRekey switches the connection to the keys negotiated by r, once
+// its handshake has finished, by replacing the handshakeResult of its state.
+// The epochs, sequence numbers, connection IDs and other extensions of the
+// connection are kept.
+func (c *Conn) completeRekey(r *rekey) {
+	r.once.Do(func() {
+		next := r.fsm.state
+
+		c.lock.Lock()
+		c.previousCipherSuite = c.state.cipherSuite
+		c.cipherSuiteEpoch = r.epoch + 1
+		c.state.handshakeResult = next.handshakeResult
+		c.rekeying = nil
+		c.lock.Unlock()
+
+		c.log.Tracef("[handshake:%s] rekey completed (epoch: %d)", srvCliStr(c.state.isClient), r.epoch+1)
+		close(r.done)
+	})
+}
+
+// abortRekey fails r unless it has already completed, and closes the
+// connection, whose handshake can no longer complete.
+func (c *Conn) abortRekey(r *rekey, err error) {
+	r.once.Do(func() {
+		c.log.Debugf("%s: rekey failed: %s", srvCliStr(c.state.isClient), err)
+		r.err = err
+		close(r.done)
+		_ = c.close(context.Background(), false) //nolint:contextcheck
+	})
+}
+
+// runHandshaker runs the handshake FSM until ctx is done or it fails. A rekey
+// stops the FSM that runs at the time, and the handshake routine carries on
+// with the FSM of the rekey.
+func (c *Conn) runHandshaker(ctx context.Context, initialState handshakeState) error {
+	fsm, state := c.fsm, initialState
+	var r *rekey
+	for {
+		ctxFSM, cancelFSM := context.WithCancel(ctx)
+		c.lock.Lock()
+		c.cancelFSM = cancelFSM
+		c.lock.Unlock()
+
+		err := fsm.Run(ctxFSM, c, state)
+		cancelFSM()
+		if errors.Is(err, context.Canceled) && ctx.Err() == nil {
+			select {
+			case r = <-c.rekeys:
+				fsm, state = r.fsm, handshakePreparing
+				continue
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		}
+		if r != nil {
+			c.abortRekey(r, err)
+		}
+		return err
+	}
+}
+
+// cipherSuiteLocked returns the cipher suite protecting the records of epoch.
+// The caller must hold lock.
+func (c *Conn) cipherSuiteLocked(epoch uint16) CipherSuite {
+	switch {
+	case c.rekeying != nil && epoch > c.rekeying.epoch:
+		return c.rekeying.fsm.state.cipherSuite
+	case epoch < c.cipherSuiteEpoch:
+		return c.previousCipherSuite
+	default:
+		return c.state.cipherSuite
+	}
+}
+
+// cipherSuite is like cipherSuiteLocked for callers that do not hold lock.
+func (c *Conn) cipherSuite(epoch uint16) CipherSuite {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.cipherSuiteLocked(epoch)
+}
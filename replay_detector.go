@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"sync/atomic"
+
+	"github.com/pion/transport/v3/replaydetector"
+)
+
+// countingReplayDetector wraps a replaydetector.ReplayDetector and counts
+// how many records were accepted or dropped, distinguishing duplicates inside
+// the window from records that fell behind it.
+type countingReplayDetector struct {
+	epoch      uint16
+	detector   replaydetector.ReplayDetector
+	windowSize uint
+	latestSeq  uint64
+
+	accepted         uint64 // atomic
+	droppedDuplicate uint64 // atomic
+	droppedTooOld    uint64 // atomic
+}
+
+func newCountingReplayDetector(epoch uint16, windowSize uint, maxSeq uint64) *countingReplayDetector {
+	return &countingReplayDetector{
+		epoch:      epoch,
+		detector:   replaydetector.New(windowSize, maxSeq),
+		windowSize: windowSize,
+	}
+}
+
+func (d *countingReplayDetector) Check(seq uint64) (func() bool, bool) {
+	accept, ok := d.detector.Check(seq)
+	if !ok {
+		if seq <= d.latestSeq && d.latestSeq >= uint64(d.windowSize)+seq {
+			atomic.AddUint64(&d.droppedTooOld, 1)
+		} else {
+			atomic.AddUint64(&d.droppedDuplicate, 1)
+		}
+		return accept, false
+	}
+
+	return func() bool {
+		if seq > d.latestSeq {
+			d.latestSeq = seq
+		}
+		atomic.AddUint64(&d.accepted, 1)
+		return accept()
+	}, true
+}
+
+func (d *countingReplayDetector) stats() ReplayStats {
+	return ReplayStats{
+		Epoch:            d.epoch,
+		Accepted:         atomic.LoadUint64(&d.accepted),
+		DroppedDuplicate: atomic.LoadUint64(&d.droppedDuplicate),
+		DroppedTooOld:    atomic.LoadUint64(&d.droppedTooOld),
+	}
+}
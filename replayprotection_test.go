@@ -12,6 +12,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/recordlayer"
 	"github.com/pion/transport/v3/dpipe"
 	"github.com/pion/transport/v3/test"
 )
@@ -140,3 +142,78 @@ func TestReplayProtection(t *testing.T) {
 		}
 	}
 }
+
+func TestReplayStats(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(5 * time.Second)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ca, cb, err := pipeMemory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ca.Close()
+		_ = cb.Close()
+	}()
+
+	record := func() []byte {
+		ca.lock.Lock()
+		defer ca.lock.Unlock()
+		raw, err := ca.processPacket(&packet{
+			record: &recordlayer.RecordLayer{
+				Header: recordlayer.Header{
+					Epoch:   ca.state.getLocalEpoch(),
+					Version: protocol.Version1_2,
+				},
+				Content: &protocol.ApplicationData{
+					Data: []byte("data"),
+				},
+			},
+			shouldEncrypt: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return raw
+	}
+
+	old := record()
+	epoch := ca.state.getLocalEpoch()
+	atomic.AddUint64(&ca.state.localSequenceNumber[epoch], defaultReplayProtectionWindow)
+	latest := record()
+
+	before := cb.Stats().Replay[epoch]
+
+	ctx := context.Background()
+	for _, raw := range [][]byte{latest, old, latest} {
+		if _, _, err := cb.handleIncomingPacket(ctx, raw, cb.RemoteAddr(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cb.Read(make([]byte, 16)); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := cb.Stats()
+	if len(stats.Replay) != int(epoch)+1 {
+		t.Fatalf("Expected replay stats for %d epochs, got %d", epoch+1, len(stats.Replay))
+	}
+	after := stats.Replay[epoch]
+	if after.Epoch != epoch {
+		t.Errorf("Expected epoch %d, got %d", epoch, after.Epoch)
+	}
+	if n := after.Accepted - before.Accepted; n != 1 {
+		t.Errorf("Expected 1 accepted record, got %d", n)
+	}
+	if n := after.DroppedTooOld - before.DroppedTooOld; n != 1 {
+		t.Errorf("Expected 1 too old record, got %d", n)
+	}
+	if n := after.DroppedDuplicate - before.DroppedDuplicate; n != 1 {
+		t.Errorf("Expected 1 duplicate record, got %d", n)
+	}
+}
@@ -10,7 +10,7 @@ import (
 
 // Resume imports an already established dtls connection using a specific dtls state
 func Resume(state *State, conn net.PacketConn, rAddr net.Addr, config *Config) (*Conn, error) {
-	if err := state.initCipherSuite(); err != nil {
+	if err := state.initCipherSuite(config.randReader(), config.SequenceNumberNonces); err != nil {
 		return nil, err
 	}
 	dconn, err := createConn(conn, rAddr, config, state.isClient)
@@ -5,29 +5,34 @@ package dtls
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/gob"
+	"io"
 	"sync/atomic"
 
-	"github.com/pion/transport/v3/replaydetector"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/elliptic"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/ffdhe"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/hash"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/prf"
+	"github.com/censys-oss/dtls/v2/pkg/crypto/signature"
 	"github.com/censys-oss/dtls/v2/pkg/crypto/signaturehash"
+	"github.com/censys-oss/dtls/v2/pkg/protocol"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
 	"github.com/censys-oss/dtls/v2/pkg/protocol/handshake"
 )
 
 // State holds the dtls connection state and implements both encoding.BinaryMarshaler and encoding.BinaryUnmarshaler
+//
+// The exported fields negotiated by the handshake, from CipherSuiteID and
+// PeerCertificates to SignatureScheme, are those of the embedded
+// handshakeResult.
 type State struct {
-	localEpoch, remoteEpoch   atomic.Value
-	localSequenceNumber       []uint64 // uint48
-	localRandom, remoteRandom handshake.Random
-	masterSecret              []byte
-	cipherSuite               CipherSuite // nil if a cipherSuite hasn't been chosen
-	CipherSuiteID             CipherSuiteID
+	handshakeResult
 
-	srtpProtectionProfile atomic.Value // Negotiated SRTPProtectionProfile
-	PeerCertificates      [][]byte
-	IdentityHint          []byte
-	SessionID             []byte
+	localEpoch, remoteEpoch atomic.Value
+	localSequenceNumber     [maxRetainedEpochs]uint64 // uint48, indexed by epoch modulo maxRetainedEpochs
+	srtpProtectionProfile   atomic.Value              // Negotiated SRTPProtectionProfile
+	SessionID               []byte
 
 	// Connection Identifiers must be negotiated afresh on session resumption.
 	// https://datatracker.ietf.org/doc/html/rfc9146#name-the-connection_id-extension
@@ -45,26 +50,107 @@ type State struct {
 
 	isClient bool
 
-	preMasterSecret      []byte
-	extendedMasterSecret bool
-
-	namedCurve                 elliptic.Curve
 	localKeypair               *elliptic.Keypair
+	dhGroup                    ffdhe.Group    // Group of a DHE key exchange, chosen by the server
+	localDHKeypair             *ffdhe.Keypair // Keypair of a DHE key exchange
 	cookie                     []byte
 	handshakeSendSequence      int
 	handshakeRecvSequence      int
 	serverName                 string
 	remoteCertRequestAlgs      []signaturehash.Algorithm
 	remoteRequestedCertificate bool   // Did we get a CertificateRequest
+	remoteRequestedOCSPStaple  bool   // Did the client send status_request
+	remoteRequestedSCTs        bool   // Did the client send signed_certificate_timestamp
 	localCertificatesVerify    []byte // cache CertificateVerify
-	localVerifyData            []byte // cached VerifyData
 	localKeySignature          []byte // cached keySignature
-	peerCertificatesVerified   bool
 
-	replayDetector []replaydetector.ReplayDetector
+	// localCertificate is the certificate a server sent in its Certificate
+	// message, whose key decrypts the premaster secret of a static RSA key
+	// exchange.
+	localCertificate *tls.Certificate
+
+	// clientVersion is the client_version of the ClientHello received by a
+	// server, which starts the premaster secret of a static RSA key exchange.
+	clientVersion protocol.Version
+
+	// certificateCompression is the algorithm the server compresses its
+	// Certificate with, as negotiated with compress_certificate.
+	certificateCompression CertificateCompressionAlgorithm
+
+	// replayDetector is indexed by epoch modulo maxRetainedEpochs.
+	replayDetector [maxRetainedEpochs]*countingReplayDetector
 
 	peerSupportedProtocols []string
 	NegotiatedProtocol     string
+
+	// clientHelloInfo describes the ClientHello received by a server. It is
+	// passed to the certificate and PSK identity hint callbacks.
+	clientHelloInfo *ClientHelloInfo
+
+	// remoteHeartbeatMode is the mode of the Heartbeat extension received
+	// from the remote endpoint, or zero if heartbeats were not negotiated.
+	remoteHeartbeatMode extension.HeartbeatMode
+}
+
+// handshakeResult is the part of State that a handshake negotiates. A rekey
+// replaces it as a whole once its handshake has finished.
+type handshakeResult struct {
+	localRandom, remoteRandom handshake.Random
+	masterSecret              []byte
+	cipherSuite               CipherSuite // nil if a cipherSuite hasn't been chosen
+
+	// CipherSuiteID is the negotiated cipher suite, which CipherSuiteName
+	// turns into its IANA name. It is zero until the server has chosen one.
+	CipherSuiteID CipherSuiteID
+
+	PeerCertificates [][]byte
+	IdentityHint     []byte
+
+	preMasterSecret      []byte
+	extendedMasterSecret bool
+
+	// encryptedPreMasterSecret is the premaster secret encrypted to the
+	// server's certificate when the static RSA key exchange is used.
+	encryptedPreMasterSecret []byte
+
+	namedCurve               elliptic.Curve
+	localVerifyData          []byte // cached VerifyData
+	peerCertificatesVerified bool
+
+	// truncatedHMAC is set if the truncated_hmac extension was negotiated
+	// for a CBC cipher suite.
+	truncatedHMAC bool
+
+	// CertificateValidityStatus classifies PeerCertificates. It is set even
+	// if verification was skipped or the certificates were not verified.
+	CertificateValidityStatus CertificateValidityStatus
+
+	// CompressionMethod is the compression method selected by the server in
+	// its ServerHello. A client aborts the handshake with a
+	// CompressionMethodError, which carries the method, unless it is null.
+	CompressionMethod protocol.CompressionMethodID
+
+	// SNIMatchesCertificate reports whether the leaf certificate of a server
+	// covers the server name sent by the client in the SNI extension. It is
+	// only set by clients, and is false if no server name was sent.
+	SNIMatchesCertificate bool
+
+	// OCSPResponse is the stapled OCSP response sent by the server in a
+	// CertificateStatus message, if any. It is only set by clients, which
+	// must request it with Config.RequestOCSPStaple.
+	OCSPResponse []byte
+
+	// SCTs are the Signed Certificate Timestamps sent by the server in the
+	// signed_certificate_timestamp extension, if any. It is only set by
+	// clients, which must request them with Config.RequestSCTs.
+	SCTs [][]byte
+
+	// SignatureScheme is the signature and hash algorithm the peer signed
+	// the handshake with: the ServerKeyExchange of the server on clients,
+	// and the CertificateVerify of a client certificate on servers. It is
+	// zero if the peer did not sign anything, as with PSK and static RSA
+	// key exchanges.
+	SignatureScheme signaturehash.Algorithm
 }
 
 type serializedState struct {
@@ -83,6 +169,15 @@ type serializedState struct {
 	RemoteConnectionID    []byte
 	IsClient              bool
 	NegotiatedProtocol    string
+	RemoteHeartbeatMode   byte
+	CertValidity          uint8
+	CompressionMethod     uint8
+	SNIMatch              bool
+	OCSPResponse          []byte
+	SCTs                  [][]byte
+	TruncatedHMAC         bool
+	SignatureHash         uint16
+	SignatureAlgorithm    uint16
 }
 
 func (s *State) clone() *State {
@@ -104,7 +199,7 @@ func (s *State) serialize() *serializedState {
 		RemoteEpoch:           s.getRemoteEpoch(),
 		CipherSuiteID:         uint16(s.cipherSuite.ID()),
 		MasterSecret:          s.masterSecret,
-		SequenceNumber:        atomic.LoadUint64(&s.localSequenceNumber[epoch]),
+		SequenceNumber:        atomic.LoadUint64(&s.localSequenceNumber[epoch%maxRetainedEpochs]),
 		LocalRandom:           localRnd,
 		RemoteRandom:          remoteRnd,
 		SRTPProtectionProfile: uint16(s.getSRTPProtectionProfile()),
@@ -115,6 +210,15 @@ func (s *State) serialize() *serializedState {
 		RemoteConnectionID:    s.remoteConnectionID,
 		IsClient:              s.isClient,
 		NegotiatedProtocol:    s.NegotiatedProtocol,
+		RemoteHeartbeatMode:   byte(s.remoteHeartbeatMode),
+		CertValidity:          uint8(s.CertificateValidityStatus),
+		CompressionMethod:     uint8(s.CompressionMethod),
+		SNIMatch:              s.SNIMatchesCertificate,
+		OCSPResponse:          s.OCSPResponse,
+		SCTs:                  s.SCTs,
+		TruncatedHMAC:         s.truncatedHMAC,
+		SignatureHash:         uint16(s.SignatureScheme.Hash),
+		SignatureAlgorithm:    uint16(s.SignatureScheme.Signature),
 	}
 }
 
@@ -124,10 +228,6 @@ func (s *State) deserialize(serialized serializedState) {
 	s.localEpoch.Store(serialized.LocalEpoch)
 	s.remoteEpoch.Store(serialized.RemoteEpoch)
 
-	for len(s.localSequenceNumber) <= int(epoch) {
-		s.localSequenceNumber = append(s.localSequenceNumber, uint64(0))
-	}
-
 	// Set random values
 	localRandom := &handshake.Random{}
 	localRandom.UnmarshalFixed(serialized.LocalRandom)
@@ -146,7 +246,7 @@ func (s *State) deserialize(serialized serializedState) {
 	s.CipherSuiteID = CipherSuiteID(serialized.CipherSuiteID)
 	s.cipherSuite = cipherSuiteForID(s.CipherSuiteID, nil)
 
-	atomic.StoreUint64(&s.localSequenceNumber[epoch], serialized.SequenceNumber)
+	atomic.StoreUint64(&s.localSequenceNumber[epoch%maxRetainedEpochs], serialized.SequenceNumber)
 	s.setSRTPProtectionProfile(SRTPProtectionProfile(serialized.SRTPProtectionProfile))
 
 	// Set remote certificate
@@ -161,12 +261,32 @@ func (s *State) deserialize(serialized serializedState) {
 	s.SessionID = serialized.SessionID
 
 	s.NegotiatedProtocol = serialized.NegotiatedProtocol
+
+	s.remoteHeartbeatMode = extension.HeartbeatMode(serialized.RemoteHeartbeatMode)
+
+	s.CertificateValidityStatus = CertificateValidityStatus(serialized.CertValidity)
+
+	s.CompressionMethod = protocol.CompressionMethodID(serialized.CompressionMethod)
+
+	s.SNIMatchesCertificate = serialized.SNIMatch
+	s.OCSPResponse = serialized.OCSPResponse
+	s.SCTs = serialized.SCTs
+	s.truncatedHMAC = serialized.TruncatedHMAC
+	s.SignatureScheme = signaturehash.Algorithm{
+		Hash:      hash.Algorithm(serialized.SignatureHash),
+		Signature: signature.Algorithm(serialized.SignatureAlgorithm),
+	}
 }
 
-func (s *State) initCipherSuite() error {
+func (s *State) initCipherSuite(rand io.Reader, sequenceNonce bool) error {
 	if s.cipherSuite.IsInitialized() {
 		return nil
 	}
+	if rand != nil {
+		setCipherSuiteRand(s.cipherSuite, rand)
+	}
+	setCipherSuiteTruncatedMAC(s.cipherSuite, s.truncatedHMAC)
+	setCipherSuiteSequenceNonce(s.cipherSuite, sequenceNonce)
 
 	localRandom := s.localRandom.MarshalFixed()
 	remoteRandom := s.remoteRandom.MarshalFixed()
@@ -205,7 +325,7 @@ func (s *State) UnmarshalBinary(data []byte) error {
 
 	s.deserialize(serialized)
 
-	return s.initCipherSuite()
+	return s.initCipherSuite(nil, false)
 }
 
 // ExportKeyingMaterial returns length bytes of exported key material in a new
@@ -224,13 +344,13 @@ func (s *State) ExportKeyingMaterial(label string, context []byte, length int) (
 	localRandom := s.localRandom.MarshalFixed()
 	remoteRandom := s.remoteRandom.MarshalFixed()
 
-	seed := []byte(label)
+	var seed []byte
 	if s.isClient {
 		seed = append(append(seed, localRandom[:]...), remoteRandom[:]...)
 	} else {
 		seed = append(append(seed, remoteRandom[:]...), localRandom[:]...)
 	}
-	return prf.PHash(s.masterSecret, seed, length, s.cipherSuite.HashFunc())
+	return prf.PRF(s.masterSecret, label, seed, length, s.cipherSuite.HashFunc())
 }
 
 func (s *State) getRemoteEpoch() uint16 {
@@ -247,6 +367,16 @@ func (s *State) getLocalEpoch() uint16 {
 	return 0
 }
 
+// localSequenceNumberOf returns the counter of the local sequence numbers of
+// epoch, or nil if epoch is ahead of the local epoch or has expired.
+func (s *State) localSequenceNumberOf(epoch uint16) *uint64 {
+	local := s.getLocalEpoch()
+	if epoch > local || local-epoch >= maxRetainedEpochs {
+		return nil
+	}
+	return &s.localSequenceNumber[epoch%maxRetainedEpochs]
+}
+
 func (s *State) setSRTPProtectionProfile(profile SRTPProtectionProfile) {
 	s.srtpProtectionProfile.Store(profile)
 }
@@ -259,6 +389,20 @@ func (s *State) getSRTPProtectionProfile() SRTPProtectionProfile {
 	return 0
 }
 
+// SendsConnectionID reports whether the records sent on the connection are
+// wrapped with a connection ID. This is the case if the peer asked for a
+// non-empty connection ID during the handshake.
+func (s *State) SendsConnectionID() bool {
+	return len(s.remoteConnectionID) > 0
+}
+
+// ReceivesConnectionID reports whether the records received on the
+// connection must carry a connection ID. This is the case if a non-empty
+// connection ID was sent to the peer during the handshake.
+func (s *State) ReceivesConnectionID() bool {
+	return len(s.localConnectionID) > 0
+}
+
 // RemoteRandomBytes returns the remote client hello random bytes
 func (s *State) RemoteRandomBytes() [handshake.RandomBytesLength]byte {
 	return s.remoteRandom.RandomBytes
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// Stats holds counters describing the traffic seen on a connection.
+type Stats struct {
+	// Replay holds the replay protection counters of the retained epochs the
+	// remote party has sent records in, ordered by epoch.
+	Replay []ReplayStats
+
+	// DroppedAfterClose is the number of records dropped because they
+	// arrived after the connection was closed.
+	DroppedAfterClose uint64
+
+	// BytesSent and BytesReceived count the bytes of the datagrams written to
+	// and read from the underlying connection, including record headers and
+	// encryption overhead.
+	BytesSent, BytesReceived uint64
+	// RecordsSent and RecordsReceived count the records in those datagrams.
+	// Received records are counted before they are validated.
+	RecordsSent, RecordsReceived uint64
+
+	// RetransmittedFlights is the number of times a handshake flight was
+	// sent again, either on timeout or in response to a retransmission of
+	// the peer.
+	RetransmittedFlights uint64
+
+	// DecryptFailures is the number of records dropped because they could
+	// not be decrypted or failed authentication.
+	DecryptFailures uint64
+
+	// MaxQueuedRecords is the largest number of records held at once while
+	// waiting for the keys to decrypt them, such as records of the next
+	// epoch that arrive before the handshake completes.
+	MaxQueuedRecords uint64
+	// DroppedQueueFull is the number of records dropped because that queue
+	// already held Config.MaxEarlyPacketQueue records.
+	DroppedQueueFull uint64
+	// DroppedBufferFull is the number of application data records dropped
+	// because Config.EarlyDataBuffer was full.
+	DroppedBufferFull uint64
+	// DroppedTooManyRecords is the number of datagrams dropped because they
+	// held more than Config.MaxRecordsPerDatagram records.
+	DroppedTooManyRecords uint64
+
+	// KeepAlivesSent is the number of records sent because of
+	// Config.KeepAlive.
+	KeepAlivesSent uint64
+}
+
+// ReplayStats holds the replay protection counters of a single epoch.
+// A high DroppedTooOld count under reordering indicates that the
+// ReplayProtectionWindow should be widened.
+type ReplayStats struct {
+	Epoch uint16
+
+	// Accepted is the number of records that passed replay protection.
+	Accepted uint64
+	// DroppedDuplicate is the number of records dropped because their
+	// sequence number had already been received within the window.
+	DroppedDuplicate uint64
+	// DroppedTooOld is the number of records dropped because their sequence
+	// number was behind the replay protection window.
+	DroppedTooOld uint64
+}
+
+// Stats returns a snapshot of the connection counters.
+func (c *Conn) Stats() Stats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	stats := Stats{
+		Replay:                make([]ReplayStats, 0, len(c.state.replayDetector)),
+		DroppedAfterClose:     atomic.LoadUint64(&c.droppedAfterClose),
+		BytesSent:             atomic.LoadUint64(&c.bytesSent),
+		BytesReceived:         atomic.LoadUint64(&c.bytesReceived),
+		RecordsSent:           atomic.LoadUint64(&c.recordsSent),
+		RecordsReceived:       atomic.LoadUint64(&c.recordsReceived),
+		RetransmittedFlights:  atomic.LoadUint64(&c.retransmittedFlights),
+		DecryptFailures:       atomic.LoadUint64(&c.decryptFailures),
+		MaxQueuedRecords:      atomic.LoadUint64(&c.maxQueuedRecords),
+		DroppedQueueFull:      atomic.LoadUint64(&c.droppedQueueFull),
+		DroppedBufferFull:     atomic.LoadUint64(&c.droppedBufferFull),
+		DroppedTooManyRecords: atomic.LoadUint64(&c.droppedTooManyRecords),
+		KeepAlivesSent:        atomic.LoadUint64(&c.keepAlivesSent),
+	}
+	for _, d := range c.state.replayDetector {
+		if d == nil {
+			continue
+		}
+		stats.Replay = append(stats.Replay, d.stats())
+	}
+	sort.Slice(stats.Replay, func(i, j int) bool {
+		return stats.Replay[i].Epoch < stats.Replay[j].Epoch
+	})
+	return stats
+}
+
+// ListenerStats holds counters describing the traffic seen by a listener.
+type ListenerStats struct {
+	// DroppedUnknownConnectionID is the number of datagrams dropped because
+	// they carried a connection ID that does not belong to any connection.
+	// These never reach a Conn, so they are not counted as decrypt failures.
+	DroppedUnknownConnectionID uint64
+}
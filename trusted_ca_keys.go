@@ -0,0 +1,52 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+)
+
+// trustedAuthorities returns the trusted_ca_keys entries identifying cas by
+// the SHA-1 hash of their keys.
+func trustedAuthorities(cas []*x509.Certificate) ([]extension.TrustedAuthority, error) {
+	authorities := make([]extension.TrustedAuthority, 0, len(cas))
+	for _, ca := range cas {
+		hash, err := keySHA1Hash(ca)
+		if err != nil {
+			return nil, err
+		}
+		authorities = append(authorities, extension.TrustedAuthority{
+			IdentifierType: extension.TrustedAuthorityKeySHA1Hash,
+			Identifier:     hash,
+		})
+	}
+	return authorities, nil
+}
+
+// keySHA1Hash returns the key_sha1_hash identifier of the key of cert: the
+// hash of the modulus, without leading zeros, for RSA keys and of the
+// subjectPublicKey for other keys.
+// https://datatracker.ietf.org/doc/html/rfc6066#section-6
+func keySHA1Hash(cert *x509.Certificate) ([]byte, error) {
+	if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+		hash := sha1.Sum(pub.N.Bytes()) //nolint:gosec
+		return hash[:], nil
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	hash := sha1.Sum(spki.PublicKey.RightAlign()) //nolint:gosec
+	return hash[:], nil
+}
@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: 2023 The Pion community <https://pion.ly>
+// SPDX-License-Identifier: MIT
+
+package dtls
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1" //nolint:gosec
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/censys-oss/dtls/v2/pkg/crypto/selfsign"
+	"github.com/censys-oss/dtls/v2/pkg/protocol/extension"
+	"github.com/pion/transport/v3/test"
+)
+
+func TestKeySHA1Hash(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048) //nolint:gosec
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct {
+		key      interface{}
+		expected [sha1.Size]byte
+	}{
+		"ECDSA": {
+			key:      ecdsaKey,
+			expected: sha1.Sum(elliptic.Marshal(elliptic.P256(), ecdsaKey.X, ecdsaKey.Y)), //nolint:gosec,staticcheck
+		},
+		"RSA": {
+			key:      rsaKey,
+			expected: sha1.Sum(rsaKey.N.Bytes()), //nolint:gosec
+		},
+	} {
+		cert, err := selfsign.SelfSign(tt.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		hash, err := keySHA1Hash(parsed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(hash, tt.expected[:]) {
+			t.Errorf("%s: expected key hash %x, got %x", name, tt.expected, hash)
+		}
+	}
+}
+
+func TestTrustedCAKeys(t *testing.T) {
+	// Limit runtime in case of deadlocks
+	lim := test.TimeOut(time.Second * 20)
+	defer lim.Stop()
+
+	// Check for leaking routines
+	report := test.CheckRoutines(t)
+	defer report()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	caCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caCert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serverCert, err := selfsign.GenerateSelfSigned()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hint []extension.TrustedAuthority
+	client, server := pipeConnPair(t, ctx, &Config{
+		TrustedCAKeys: []*x509.Certificate{ca},
+	}, &Config{
+		GetCertificate: func(info *ClientHelloInfo) (*tls.Certificate, error) {
+			hint = info.TrustedCAKeys
+			return &serverCert, nil
+		},
+	})
+	defer func() {
+		_ = client.Close()
+		_ = server.Close()
+	}()
+
+	expected, err := keySHA1Hash(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hint) != 1 {
+		t.Fatalf("Expected the client to advertise one trusted CA, got %+v", hint)
+	}
+	if a := hint[0]; a.IdentifierType != extension.TrustedAuthorityKeySHA1Hash || !bytes.Equal(a.Identifier, expected) {
+		t.Errorf("Expected key hash %x, got %+v", expected, a)
+	}
+}
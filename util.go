@@ -3,6 +3,8 @@
 
 package dtls
 
+import "github.com/censys-oss/dtls/v2/pkg/protocol"
+
 func findMatchingSRTPProfile(a, b []SRTPProtectionProfile) (SRTPProtectionProfile, bool) {
 	for _, aProfile := range a {
 		for _, bProfile := range b {
@@ -25,6 +27,30 @@ func findMatchingCipherSuite(a, b []CipherSuite) (CipherSuite, bool) {
 	return nil, false
 }
 
+// orderCipherSuites returns the suites of a that are also in order, in the
+// order of the latter. The suites returned are those of a.
+func orderCipherSuites(a, order []CipherSuite) []CipherSuite {
+	ordered := make([]CipherSuite, 0, len(a))
+	for _, orderSuite := range order {
+		for _, aSuite := range a {
+			if aSuite.ID() == orderSuite.ID() {
+				ordered = append(ordered, aSuite)
+				break
+			}
+		}
+	}
+	return ordered
+}
+
+func containsVersion(versions []protocol.Version, v protocol.Version) bool {
+	for _, version := range versions {
+		if version.Equal(v) {
+			return true
+		}
+	}
+	return false
+}
+
 func splitBytes(bytes []byte, splitLen int) [][]byte {
 	splitBytes := make([][]byte, 0)
 	numBytes := len(bytes)